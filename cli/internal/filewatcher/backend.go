@@ -4,14 +4,15 @@
 package filewatcher
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/hashicorp/go-hclog"
-	"github.com/karrick/godirwalk"
 	"github.com/pkg/errors"
 	"github.com/vercel/turbo/cli/internal/doublestar"
 	"github.com/vercel/turbo/cli/internal/fs"
@@ -27,15 +28,140 @@ const (
 	synthesizeEvents
 )
 
+// fsNotifyBackend watches via fsnotify, which on Linux, the BSDs, and Windows only
+// watches individual directories. We make that recursive ourselves: every directory
+// beneath a root is watched up front, and when a directory is created, we watch it and
+// walk it, synthesizing FileAdded events for anything that was created inside it before
+// its watch was installed. Between the walk and the live events, every level of a tree
+// created in one go is reported, however deep it is.
 type fsNotifyBackend struct {
+	// bufferSize is the size of the ReadDirectoryChangesW buffer that new watches get.
+	// It is accessed atomically, see growBuffers, so it comes first to be 64-bit aligned.
+	bufferSize int64
+
 	watcher *fsnotify.Watcher
 	events  chan Event
 	errors  chan error
 	logger  hclog.Logger
+	// debug is used for the debug lines we log per directory or per event
+	debug *sampledLogger
+	// addWatch installs a watch on a single path. It is addFSNotifyWatch, except in tests.
+	addWatch func(name string) error
 
 	mu          sync.Mutex
 	allExcludes []string
 	closed      bool
+	watched     map[turbopath.AbsoluteSystemPath]struct{}
+	listener    watchListener
+	walkWorkers int
+	dirFilter   func(dir turbopath.AbsoluteSystemPath) bool
+	ignore      func(path turbopath.AbsoluteSystemPath) bool
+	// attributor sets the PID of our events, if we came from GetFanotifyBackend. Events
+	// are handed to it via toAttribute, until attributorDone is closed.
+	attributor     processAttributor
+	toAttribute    chan Event
+	attributorDone chan struct{}
+	// closeWrites reports files closed after being written to, in place of each write, if
+	// WithCloseWrite asked for it
+	closeWrites closeWriteNotifier
+
+	// fsnotify reports the two halves of a rename as unrelated events, so we pair them up ourselves
+	renames *renameCorrelator
+
+	cfg     backendConfig
+	started bool
+	// raisedLimit is set once we have tried raising the limit on open files
+	raisedLimit bool
+	// poller polls the directories in polled, which we ran out of watches for
+	poller *pollingBackend
+	polled map[turbopath.AbsoluteSystemPath]struct{}
+
+	// walks tracks calls to watchRecursively that are in progress
+	walks activity
+
+	// queuedErrors are delivered by the watch loop, which errorsQueued wakes up. See queueError.
+	queuedErrors []error
+	errorsQueued chan struct{}
+
+	// scanFS is what rescans read, through throttle. It is the real filesystem, except in tests.
+	scanFS   scanFS
+	throttle *scanThrottle
+}
+
+func (f *fsNotifyBackend) setDirFilter(filter func(dir turbopath.AbsoluteSystemPath) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dirFilter = filter
+}
+
+func (f *fsNotifyBackend) setScanThrottle(throttle *scanThrottle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.throttle = throttle
+}
+
+func (f *fsNotifyBackend) setIgnoreFilter(ignore func(path turbopath.AbsoluteSystemPath) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ignore = ignore
+}
+
+// isIgnored returns true if we shouldn't watch the given path at all. Must be called while f.mu is held.
+func (f *fsNotifyBackend) isIgnored(path turbopath.AbsoluteSystemPath) bool {
+	return f.ignore != nil && f.ignore(path)
+}
+
+// wantsDir returns true if we should watch the given directory. Must be called while f.mu is held.
+func (f *fsNotifyBackend) wantsDir(dir turbopath.AbsoluteSystemPath) bool {
+	return f.dirFilter == nil || f.dirFilter(dir)
+}
+
+func (f *fsNotifyBackend) setWatchListener(l watchListener) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listener = l
+}
+
+// addDirWatch installs a watch on the given directory and records it.
+// Must be called while f.mu is held.
+func (f *fsNotifyBackend) addDirWatch(dir turbopath.AbsoluteSystemPath) error {
+	if err := f.addWatch(dir.ToString()); err != nil {
+		return watchError(err, dir)
+	}
+	if f.closeWrites != nil {
+		if err := f.closeWrites.add(dir); err != nil {
+			// The directory's writes are reported as they happen instead
+			f.debug.Debug("can't report writes when files are closed", _logOp, "watch", _logPath, dir, "error", err)
+		}
+	}
+	if _, ok := f.watched[dir]; !ok {
+		f.watched[dir] = struct{}{}
+		if f.listener != nil {
+			f.listener.onWatchAdded(dir)
+		}
+	}
+	return nil
+}
+
+// removeDirWatch removes the watch on a directory, which may already be gone. Must be
+// called while f.mu is held.
+func (f *fsNotifyBackend) removeDirWatch(dir turbopath.AbsoluteSystemPath) error {
+	if f.closeWrites != nil {
+		f.closeWrites.remove(dir)
+	}
+	return f.watcher.Remove(dir.ToString())
+}
+
+// forgetDirWatch drops our record of a directory watch. If the watch is still
+// installed, it is the caller's responsibility to remove it. Must be called while
+// f.mu is held.
+func (f *fsNotifyBackend) forgetDirWatch(dir turbopath.AbsoluteSystemPath) {
+	if _, ok := f.watched[dir]; ok {
+		delete(f.watched, dir)
+		if f.listener != nil {
+			f.listener.onWatchRemoved(dir)
+		}
+	}
 }
 
 func (f *fsNotifyBackend) Events() <-chan Event {
@@ -46,15 +172,73 @@ func (f *fsNotifyBackend) Errors() <-chan error {
 	return f.errors
 }
 
+// closeWriteNotifier reports the files within watched directories that are closed after
+// being written to, which fsnotify doesn't tell us
+type closeWriteNotifier interface {
+	// add starts watching the files directly inside dir
+	add(dir turbopath.AbsoluteSystemPath) error
+	remove(dir turbopath.AbsoluteSystemPath)
+	// watching returns true if the files directly inside dir are being watched
+	watching(dir turbopath.AbsoluteSystemPath) bool
+	Events() <-chan turbopath.AbsoluteSystemPath
+	Errors() <-chan error
+	close() error
+}
+
+// processAttributor finds the process responsible for an event, which fsnotify doesn't tell us
+type processAttributor interface {
+	// addRoot starts attributing changes within root
+	addRoot(root turbopath.AbsoluteSystemPath) error
+	// attribute sets the PID of the event, if it knows it
+	attribute(ev Event) Event
+	close() error
+}
+
+// _attributionBuffer is how many events can wait to be attributed to a process before
+// the watch loop waits for the attributor to catch up
+const _attributionBuffer = 256
+
+// setAttributor has a attribute our events to processes on a goroutine of its own, so
+// that the watch loop doesn't wait on it. Every event goes through that goroutine,
+// whether or not it can be attributed, so that events are still delivered in order.
+func (f *fsNotifyBackend) setAttributor(a processAttributor) {
+	f.attributor = a
+	f.toAttribute = make(chan Event, _attributionBuffer)
+	f.attributorDone = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case ev := <-f.toAttribute:
+				f.deliverEvent(a.attribute(ev))
+			case <-f.attributorDone:
+				return
+			}
+		}
+	}()
+}
+
 func (f *fsNotifyBackend) Close() error {
 	f.mu.Lock()
-	defer f.mu.Unlock()
 	if f.closed {
+		f.mu.Unlock()
 		return ErrFilewatchingClosed
 	}
 	f.closed = true
 	close(f.events)
 	close(f.errors)
+	poller := f.poller
+	f.mu.Unlock()
+	// The poller may be waiting to hand us an event, which needs f.mu
+	if poller != nil {
+		_ = poller.Close()
+	}
+	if f.attributor != nil {
+		close(f.attributorDone)
+		_ = f.attributor.close()
+	}
+	if f.closeWrites != nil {
+		_ = f.closeWrites.close()
+	}
 	if err := f.watcher.Close(); err != nil {
 		return err
 	}
@@ -76,80 +260,440 @@ func (f *fsNotifyBackend) onFileAdded(name turbopath.AbsoluteSystemPath) error {
 	}
 	if info.IsDir() {
 		// If a directory has been added, we need to synthesize events for everything it contains
-		if err := f.watchRecursively(name, []string{}, synthesizeEvents); err != nil {
+		if err := f.watchRecursively(osScanFS{}, name, []string{}, synthesizeEvents); err != nil {
 			return errors.Wrapf(err, "failed recursive watch of %v", name)
 		}
 	} else {
-		if err := f.watcher.Add(name.ToString()); err != nil {
-			return errors.Wrapf(err, "failed adding watch to %v", name)
+		f.mu.Lock()
+		f.unwatchReplacedDir(name)
+		ignored := f.isIgnored(name)
+		f.mu.Unlock()
+		if ignored {
+			return nil
+		}
+		f.renames.remember(name, info)
+		if err := f.addWatch(name.ToString()); err != nil {
+			// Creations and deletions are still reported by the directory's watch, but
+			// without one of its own, changes to the file's contents may not be
+			f.mu.Lock()
+			err = f.retryAfterLimit(name, err, func() error { return f.addWatch(name.ToString()) })
+			f.mu.Unlock()
+			if err != nil {
+				return watchError(err, name)
+			}
 		}
 	}
 	return nil
 }
 
-func (f *fsNotifyBackend) watchRecursively(root turbopath.AbsoluteSystemPath, excludePatterns []string, addMode watchAddMode) error {
+// unwatchReplacedDir removes our watches on a directory that has been replaced by a
+// file, and on everything beneath it. Usually they went away when the directory was
+// deleted, but if it moved somewhere we couldn't follow, the kernel's watches moved
+// with it, and would keep reporting its changes under its old path. Must be called
+// while f.mu is held.
+func (f *fsNotifyBackend) unwatchReplacedDir(name turbopath.AbsoluteSystemPath) {
+	for dir := range f.watched {
+		if dir.HasPrefix(name) {
+			f.forgetDirWatch(dir)
+			// The watch may already be gone
+			_ = f.removeDirWatch(dir)
+		}
+	}
+}
+
+// onWatchAutoRemoved updates our records after a deletion. When a watched directory is
+// deleted, the kernel removes its watch and reports IN_IGNORED. fsnotify handles that
+// itself rather than passing it on, so we treat the deletion as our cue to drop the
+// watch too, along with any we still hold beneath it, which would otherwise be stale
+// and count against the watch limit. If the directory is created again, onFileAdded
+// watches it afresh. If a directory is already back at the path, because another was
+// moved over it, the watches beneath it belong to the new one and are left alone.
+func (f *fsNotifyBackend) onWatchAutoRemoved(path turbopath.AbsoluteSystemPath) {
+	replaced := false
+	if info, err := path.Lstat(); err == nil && info.IsDir() {
+		replaced = true
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	err := fs.WalkMode(root.ToString(), func(name string, isDir bool, info os.FileMode) error {
-		for _, excludePattern := range excludePatterns {
-			excluded, err := doublestar.Match(excludePattern, filepath.ToSlash(name))
-			if err != nil {
-				return err
-			}
-			if excluded {
-				return godirwalk.SkipThis
+	f.forgetDirWatch(path)
+	if replaced {
+		return
+	}
+	for dir := range f.watched {
+		if dir.HasPrefix(path) {
+			f.logger.Debug("dropping watch beneath deleted directory", _logOp, "unwatch", _logPath, dir)
+			f.forgetDirWatch(dir)
+			// The kernel has usually removed it already
+			_ = f.removeDirWatch(dir)
+		}
+	}
+}
+
+// onMovedOut drops our watches on a directory that was moved somewhere we aren't
+// watching. Unlike a deleted directory's, the kernel's watches moved along with it, and
+// would go on reporting its changes under its old path. If something has been created
+// at the path since, its watches are left alone.
+func (f *fsNotifyBackend) onMovedOut(path turbopath.AbsoluteSystemPath) {
+	if _, err := path.Lstat(); err == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unwatchReplacedDir(path)
+}
+
+// onFileMoved updates our watches after a file or directory has moved within the
+// hierarchies we are watching. The kernel's watches on a moved directory and its
+// descendants follow it to its new location, so rather than treating the move as
+// the creation of a new tree, we re-home our records of them. Unlike onFileAdded,
+// this does not synthesize events for the contents of a moved directory, since the
+// single rename event describes what happened to all of them.
+func (f *fsNotifyBackend) onFileMoved(oldPath turbopath.AbsoluteSystemPath, newPath turbopath.AbsoluteSystemPath) error {
+	info, err := newPath.Lstat()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// It has already moved on again
+			return nil
+		}
+		return errors.Wrapf(err, "error checking lstat of moved file %v", newPath)
+	}
+	if !info.IsDir() {
+		return f.onFileAdded(newPath)
+	}
+	f.mu.Lock()
+	for dir := range f.watched {
+		if dir.HasPrefix(oldPath) {
+			f.forgetDirWatch(dir)
+		}
+	}
+	f.mu.Unlock()
+	if err := f.watchRecursively(osScanFS{}, newPath, []string{}, dontSynthesizeEvents); err != nil {
+		return errors.Wrapf(err, "failed recursive watch of moved directory %v", newPath)
+	}
+	return nil
+}
+
+// watchRecursively watches root and every directory beneath it, reading them from fsys
+func (f *fsNotifyBackend) watchRecursively(fsys scanFS, root turbopath.AbsoluteSystemPath, excludePatterns []string, addMode watchAddMode) error {
+	f.walks.begin()
+	defer f.walks.end()
+	unwatchable, alreadyPolled, err := f.walkAndWatch(fsys, root, excludePatterns, addMode)
+	if err != nil {
+		return err
+	}
+	return f.pollInstead(unwatchable, alreadyPolled, excludePatterns, addMode)
+}
+
+// walkAndWatch does the work of watchRecursively. Rather than walking directories that
+// we can't watch because we have run out of watches, it returns them, along with the
+// directories it came across that we are already polling. f.mu is taken around each
+// change to our bookkeeping, but not across the walk's reads, which may be throttled,
+// so that events keep flowing while a rescan is under way.
+//
+// Failing to watch a directory beneath root doesn't fail the walk. The directory, and
+// everything beneath it, is skipped, and the failure is reported as an error, so that
+// a single troublesome directory doesn't stop us from watching the rest of the tree.
+// Failing to watch root itself is returned.
+func (f *fsNotifyBackend) walkAndWatch(fsys scanFS, root turbopath.AbsoluteSystemPath, excludePatterns []string, addMode watchAddMode) ([]turbopath.AbsoluteSystemPath, []turbopath.AbsoluteSystemPath, error) {
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+	if closed {
+		return nil, nil, ErrFilewatchingClosed
+	}
+	followed := make(map[string]struct{})
+	var unwatchable, alreadyPolled []turbopath.AbsoluteSystemPath
+	cfg := walkConfig{
+		workers:    f.walkWorkers,
+		maxEntries: f.cfg.wideDirThreshold,
+		wide: func(dir string, entries int) {
+			f.logger.Debug("not enumerating the files of a wide directory", _logOp, "watch", _logPath, dir, "entries", entries)
+		},
+	}
+	err := walkTree(fsys, root.ToString(), cfg, func(name string, mode os.FileMode) (bool, error) {
+		excluded, err := isExcluded(name, excludePatterns)
+		if err != nil || excluded {
+			return false, err
+		}
+		path := fs.AbsoluteSystemPathFromUpstream(name)
+		f.mu.Lock()
+		ignored := f.isIgnored(path)
+		f.mu.Unlock()
+		if ignored {
+			return false, nil
+		}
+		if f.renames.hasRoom() {
+			if info, err := fsys.Lstat(name); err == nil {
+				f.renames.remember(path, info)
 			}
 		}
-		if info.IsDir() && (info&os.ModeSymlink == 0) {
-			if err := f.watcher.Add(name); err != nil {
-				return errors.Wrapf(err, "failed adding watch to %v", name)
+		isDir := mode.IsDir()
+		if !isDir && mode&(os.ModeSymlink|os.ModeIrregular) != 0 && isJunction(name) {
+			f.mu.Lock()
+			isDir = shouldFollowJunction(name, followed)
+			f.mu.Unlock()
+		}
+		if isDir {
+			f.mu.Lock()
+			if f.closed {
+				f.mu.Unlock()
+				return false, ErrFilewatchingClosed
+			}
+			if !f.wantsDir(path) {
+				f.mu.Unlock()
+				// We don't watch or descend into it, but it has still been created
+				if addMode == synthesizeEvents {
+					f.sendEvent(Event{
+						Path:      path,
+						EventType: FileAdded,
+						Inode:     f.renames.lastInode(path),
+					})
+				}
+				return false, nil
+			}
+			_, polled := f.polled[path]
+			var err error
+			if polled {
+				alreadyPolled = append(alreadyPolled, path)
+			} else if err = f.addDirWatch(path); errors.Is(err, ErrWatchLimitExceeded) {
+				retry := func() error { return f.addDirWatch(path) }
+				if err = f.retryAfterLimit(path, err, retry); errors.Is(err, ErrWatchLimitExceeded) {
+					f.polled[path] = struct{}{}
+					unwatchable = append(unwatchable, path)
+					polled, err = true, nil
+				}
+			}
+			if err != nil && path != root && path.DirExists() {
+				f.logger.Warn("skipping directory that can't be watched", _logOp, "watch", _logPath, path, "error", err)
+				f.queueError(err)
 			}
-			f.logger.Debug(fmt.Sprintf("watching directory %v", name))
+			f.mu.Unlock()
+			if err != nil && path != root {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			if polled {
+				// The poller takes care of everything beneath here
+				return false, nil
+			}
+			f.debug.Debug("watching directory", _logOp, "watch", _logPath, path)
 		}
 		if addMode == synthesizeEvents {
-			f.events <- Event{
-				Path:      fs.AbsoluteSystemPathFromUpstream(name),
+			f.sendEvent(Event{
+				Path:      path,
 				EventType: FileAdded,
-			}
+				Inode:     f.renames.lastInode(path),
+			})
 		}
-		return nil
+		return isDir, nil
 	})
+	return unwatchable, alreadyPolled, err
+}
+
+// shouldFollowJunction decides whether to descend into a junction. Unlike symlinks, we
+// follow junctions, since they are commonly used to stitch together directory trees
+// on Windows. We watch the contents via the junction's path, so events are reported
+// relative to the junction rather than its target. To avoid loops, we don't follow a
+// junction that points at one of its own ancestors, or at a target that we've already
+// followed during this walk.
+func shouldFollowJunction(junction string, followed map[string]struct{}) bool {
+	target, err := filepath.EvalSymlinks(junction)
 	if err != nil {
-		return err
+		return false
 	}
-	f.allExcludes = append(f.allExcludes, excludePatterns...)
+	if _, ok := followed[target]; ok {
+		return false
+	}
+	if turbopath.AbsoluteSystemPath(junction).HasPrefix(turbopath.AbsoluteSystemPath(target)) {
+		return false
+	}
+	followed[target] = struct{}{}
+	return true
+}
 
-	return nil
+// isExcluded returns true if the given path matches any of the exclude patterns
+func isExcluded(name string, excludePatterns []string) (bool, error) {
+	for _, excludePattern := range excludePatterns {
+		excluded, err := doublestar.Match(excludePattern, filepath.ToSlash(name))
+		if err != nil {
+			return false, err
+		}
+		if excluded {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func (f *fsNotifyBackend) watch() {
+	var closeWrites <-chan turbopath.AbsoluteSystemPath
+	var closeWriteErrors <-chan error
+	if f.closeWrites != nil {
+		closeWrites = f.closeWrites.Events()
+		closeWriteErrors = f.closeWrites.Errors()
+	}
 outer:
 	for {
+		// If we're holding onto half of a rename, wake up when it's due to be flushed
+		var renameExpiry <-chan time.Time
+		if deadline, ok := f.renames.nextDeadline(); ok {
+			renameExpiry = time.After(time.Until(deadline))
+		}
 		select {
 		case ev, ok := <-f.watcher.Events:
 			if !ok {
 				break outer
 			}
+			f.renames.busy(time.Now())
 			eventType := toFileEvent(ev.Op)
 			path := fs.AbsoluteSystemPathFromUpstream(ev.Name)
-			if eventType == FileAdded {
-				if err := f.onFileAdded(path); err != nil {
-					f.errors <- err
-				}
+			if f.closeWrites != nil && eventType == FileModified && ev.Op&fsnotify.Chmod == 0 && f.closeWrites.watching(path.Dir()) {
+				// We report the write once the file is closed
+				continue
 			}
-			f.events <- Event{
+			event := Event{
 				Path:      path,
 				EventType: eventType,
 			}
+			if eventType == FileAdded {
+				if f.renames.alreadyAdded(path, time.Now()) {
+					continue
+				}
+				var err error
+				if renamed, ok := f.renames.added(path, time.Now()); ok {
+					event = renamed
+					err = f.onFileMoved(renamed.OldPath, path)
+				} else {
+					err = f.onFileAdded(path)
+				}
+				if err != nil {
+					f.sendError(err)
+				}
+				event.Inode = f.renames.lastInode(path)
+			} else if eventType == FileModified {
+				event.Inode = f.renames.lastInode(path)
+			} else if eventType == FileDeleted || eventType == FileRenamed {
+				// The path is gone, so the inode we last saw there is all we have
+				event.Inode = f.renames.lastInode(path)
+				if eventType == FileDeleted {
+					f.onWatchAutoRemoved(path)
+				}
+				if renamed, ok := f.renames.caseOnly(event, time.Now()); ok {
+					event = renamed
+					if err := f.onFileMoved(renamed.OldPath, renamed.Path); err != nil {
+						f.sendError(err)
+					}
+				} else if held, unpaired := f.renames.removed(event, time.Now()); held {
+					if len(unpaired) > 0 {
+						f.logger.Warn("too many renames to pair up, reporting the oldest as deletions", _logOp, "rename", "count", len(unpaired))
+					}
+					for _, ev := range unpaired {
+						f.onMovedOut(ev.Path)
+						f.sendEvent(ev)
+					}
+					continue
+				}
+			}
+			f.sendEvent(event)
 		case err, ok := <-f.watcher.Errors:
 			if !ok {
 				break outer
 			}
-			f.errors <- err
+			if isOverflow(err) {
+				err = errors.Wrap(ErrEventsDropped, err.Error())
+				if runtime.GOOS == "windows" {
+					go f.growBuffers()
+				}
+			}
+			f.sendError(err)
+		case path, ok := <-closeWrites:
+			if !ok {
+				closeWrites = nil
+				continue
+			}
+			event := Event{
+				Path:      path,
+				EventType: FileModified,
+				Inode:     f.renames.lastInode(path),
+			}
+			f.sendEvent(event)
+		case err, ok := <-closeWriteErrors:
+			if !ok {
+				closeWriteErrors = nil
+				continue
+			}
+			f.sendError(err)
+		case <-f.errorsQueued:
+			f.sendQueuedErrors()
+		case now := <-renameExpiry:
+			for _, ev := range f.renames.expire(now) {
+				f.onMovedOut(ev.Path)
+				f.sendEvent(ev)
+			}
 		}
 	}
 }
 
+// sendEvent delivers an event unless we have been closed, once it has been attributed to
+// a process if we have an attributor. Must not be called while f.mu is held.
+func (f *fsNotifyBackend) sendEvent(ev Event) {
+	if f.toAttribute != nil {
+		select {
+		case f.toAttribute <- ev:
+		case <-f.attributorDone:
+		}
+		return
+	}
+	f.deliverEvent(ev)
+}
+
+// deliverEvent is sendEvent once the event is ready to go. Must not be called while f.mu is held.
+func (f *fsNotifyBackend) deliverEvent(ev Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.events <- ev
+	}
+}
+
+// sendError delivers an error unless we have been closed. Must not be called while f.mu is held.
+func (f *fsNotifyBackend) sendError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.errors <- err
+	}
+}
+
+// queueError has the watch loop deliver err. It is for errors that come up while f.mu
+// is held, or before anything is reading our errors, for instance during Start, where
+// blocking on delivering them would deadlock. Must be called while f.mu is held.
+func (f *fsNotifyBackend) queueError(err error) {
+	f.queuedErrors = append(f.queuedErrors, err)
+	select {
+	case f.errorsQueued <- struct{}{}:
+	default:
+	}
+}
+
+// sendQueuedErrors delivers the errors passed to queueError. Must not be called while f.mu is held.
+func (f *fsNotifyBackend) sendQueuedErrors() {
+	f.mu.Lock()
+	errs := f.queuedErrors
+	f.queuedErrors = nil
+	f.mu.Unlock()
+	for _, err := range errs {
+		f.sendError(err)
+	}
+}
+
+// isOverflow returns true if the error means that the OS dropped events because they
+// weren't read quickly enough.
+func isOverflow(err error) bool {
+	return errors.Is(err, fsnotify.ErrEventOverflow)
+}
+
 var _modifiedMask = fsnotify.Chmod | fsnotify.Write
 
 func toFileEvent(op fsnotify.Op) FileEvent {
@@ -172,16 +716,22 @@ func (f *fsNotifyBackend) Start() error {
 		return ErrFilewatchingClosed
 	}
 	for _, dir := range f.watcher.WatchList() {
-		for _, excludePattern := range f.allExcludes {
-			excluded, err := doublestar.Match(excludePattern, filepath.ToSlash(dir))
-			if err != nil {
+		excluded, err := isExcluded(dir, f.allExcludes)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			path := fs.AbsoluteSystemPathFromUpstream(dir)
+			if err := f.removeDirWatch(path); err != nil {
 				return err
 			}
-			if excluded {
-				if err := f.watcher.Remove(dir); err != nil {
-					return err
-				}
-			}
+			f.forgetDirWatch(path)
+		}
+	}
+	f.started = true
+	if f.poller != nil {
+		if err := f.poller.Start(); err != nil {
+			return err
 		}
 	}
 	go f.watch()
@@ -190,20 +740,147 @@ func (f *fsNotifyBackend) Start() error {
 
 func (f *fsNotifyBackend) AddRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
 	// We don't synthesize events for the initial watch
-	return f.watchRecursively(root, excludePatterns, dontSynthesizeEvents)
+	if err := f.watchRecursively(osScanFS{}, root, excludePatterns, dontSynthesizeEvents); err != nil {
+		return err
+	}
+	if f.attributor != nil {
+		if err := f.attributor.addRoot(root); err != nil {
+			// Events are still delivered, just without a PID
+			f.logger.Warn("can't attribute changes to processes", _logPath, root, "error", err)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allExcludes = append(f.allExcludes, excludePatterns...)
+	return nil
+}
+
+// addShallowRoot watches the given root directory without walking it. Subdirectories are
+// watched as they are created, via onFileAdded.
+func (f *fsNotifyBackend) addShallowRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFilewatchingClosed
+	}
+	if err := f.addDirWatch(root); err != nil {
+		return err
+	}
+	f.allExcludes = append(f.allExcludes, excludePatterns...)
+	return nil
+}
+
+// watchTree watches dir and everything beneath it, without synthesizing events
+func (f *fsNotifyBackend) watchTree(dir turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	return f.watchRecursively(osScanFS{}, dir, excludePatterns, dontSynthesizeEvents)
 }
 
-// GetPlatformSpecificBackend returns a filewatching backend appropriate for the OS we are
-// running on.
-func GetPlatformSpecificBackend(logger hclog.Logger) (Backend, error) {
+// unwatchDir removes the watch on dir, and on the files directly inside it, but not on
+// its subdirectories
+func (f *fsNotifyBackend) unwatchDir(dir turbopath.AbsoluteSystemPath) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFilewatchingClosed
+	}
+	f.forgetDirWatch(dir)
+	if err := f.removeDirWatch(dir); err != nil && dir.DirExists() {
+		return errors.Wrapf(err, "failed removing watch from %v", dir)
+	}
+	// Files are watched individually too, see onFileAdded
+	entries, err := os.ReadDir(dir.ToString())
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			// Most files won't have been watched, for instance if they already existed
+			_ = f.watcher.Remove(dir.UntypedJoin(entry.Name()).ToString())
+		}
+	}
+	return nil
+}
+
+// name is the OS's notification mechanism that fsnotify watches with
+func (f *fsNotifyBackend) name() string {
+	switch {
+	case runtime.GOOS == "linux":
+		return "inotify"
+	case runtime.GOOS == "windows":
+		return "ReadDirectoryChangesW"
+	case hasKqueueBackend():
+		return _kqueueBackend
+	}
+	return _fsnotifyBackendName
+}
+
+// capabilities reports on inotify, ReadDirectoryChangesW, or kqueue. Renames are paired
+// by inode, which Windows doesn't give us, so there only renames within a directory are
+// paired, and kqueue has no notion of an overflow.
+func (f *fsNotifyBackend) capabilities() Capabilities {
+	return Capabilities{
+		RenameCorrelation: runtime.GOOS != "windows",
+		AttributeEvents:   true,
+		OverflowDetection: runtime.GOOS == "linux" || runtime.GOOS == "windows",
+	}
+}
+
+// waitReady waits for every directory walk in progress to finish. inotify, kqueue, and
+// ReadDirectoryChangesW all install a watch before returning, so once a walk finishes,
+// the OS is watching everything it found.
+func (f *fsNotifyBackend) waitReady(ctx context.Context) error {
+	return f.walks.wait(ctx)
+}
+
+// rescan re-walks a root that was previously added, installing any watches that are missing
+// and synthesizing FileAdded events for everything it contains. The walk is throttled.
+func (f *fsNotifyBackend) rescan(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	f.mu.Lock()
+	fsys := throttledFS{fsys: f.scanFS, throttle: f.throttle}
+	f.mu.Unlock()
+	return f.watchRecursively(fsys, root, excludePatterns, synthesizeEvents)
+}
+
+// _fsnotifyBackendName identifies the fsnotify backend
+const _fsnotifyBackendName = "fsnotify"
+
+// newNativeBackend returns the filewatching backend built on the OS's own notification mechanism
+func newNativeBackend(logger hclog.Logger, cfg backendConfig) (Backend, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
+		if isWatchLimit(err) {
+			return nil, withKind(ErrWatchLimitExceeded, err, "failed to create watcher")
+		}
 		return nil, err
 	}
-	return &fsNotifyBackend{
-		watcher: watcher,
-		events:  make(chan Event),
-		errors:  make(chan error),
-		logger:  logger.Named("fsnotify"),
-	}, nil
+	logger = logger.Named(_fsnotifyBackendName).With(_logBackend, _fsnotifyBackendName)
+	var closeWrites closeWriteNotifier
+	if cfg.closeWrite {
+		if closeWrites, err = newCloseWriteNotifier(); err != nil {
+			logger.Warn("can't report writes when files are closed, reporting each write instead", "error", err)
+			closeWrites = nil
+		}
+	}
+	if cfg.bufferSize < _minBufferSize {
+		cfg.bufferSize = _minBufferSize
+	}
+	f := &fsNotifyBackend{
+		watcher:     watcher,
+		bufferSize:  int64(cfg.bufferSize),
+		events:      make(chan Event),
+		errors:      make(chan error),
+		logger:      logger,
+		debug:       newSampledLogger(logger, cfg.debugLogLimit),
+		watched:     make(map[turbopath.AbsoluteSystemPath]struct{}),
+		walkWorkers: cfg.walkWorkers,
+		renames:     newRenameCorrelator(cfg.renameWindow, cfg.maxTrackedPaths),
+		cfg:         cfg,
+		polled:      make(map[turbopath.AbsoluteSystemPath]struct{}),
+		scanFS:      osScanFS{},
+		closeWrites: closeWrites,
+		// Buffered, so that queueError never blocks
+		errorsQueued: make(chan struct{}, 1),
+	}
+	f.addWatch = f.addFSNotifyWatch
+	return f, nil
 }
@@ -4,7 +4,7 @@
 package filewatcher
 
 import (
-	"fmt"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -14,18 +14,35 @@ import (
 
 	"github.com/fsnotify/fsevents"
 	"github.com/hashicorp/go-hclog"
+	"github.com/karrick/godirwalk"
 	"github.com/vercel/turbo/cli/internal/doublestar"
 	"github.com/vercel/turbo/cli/internal/fs"
 	"github.com/vercel/turbo/cli/internal/turbopath"
 )
 
 type fseventsBackend struct {
-	events  chan Event
-	errors  chan error
-	logger  hclog.Logger
-	mu      sync.Mutex
-	streams []*fsevents.EventStream
-	closed  bool
+	latency  time.Duration
+	events   chan Event
+	errors   chan error
+	logger   hclog.Logger
+	mu       sync.Mutex
+	streams  []*fsevents.EventStream
+	roots    []turbopath.AbsoluteSystemPath
+	closed   bool
+	listener watchListener
+	throttle *scanThrottle
+}
+
+func (f *fseventsBackend) setWatchListener(l watchListener) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listener = l
+}
+
+func (f *fseventsBackend) setScanThrottle(throttle *scanThrottle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.throttle = throttle
 }
 
 func (f *fseventsBackend) Events() <-chan Event {
@@ -46,6 +63,11 @@ func (f *fseventsBackend) Close() error {
 	for _, stream := range f.streams {
 		stream.Stop()
 	}
+	if f.listener != nil {
+		for _, root := range f.roots {
+			f.listener.onWatchRemoved(root)
+		}
+	}
 	close(f.events)
 	close(f.errors)
 	return nil
@@ -55,10 +77,7 @@ func (f *fseventsBackend) Start() error {
 	return nil
 }
 
-var (
-	_eventLatency  = 10 * time.Millisecond
-	_cookieTimeout = 500 * time.Millisecond
-)
+var _cookieTimeout = 500 * time.Millisecond
 
 // AddRoot starts watching a new directory hierarchy. Events matching the provided excludePatterns
 // will not be forwarded.
@@ -74,10 +93,13 @@ func (f *fseventsBackend) AddRoot(someRoot turbopath.AbsoluteSystemPath, exclude
 		return err
 	}
 
-	// Optimistically set up and start a stream, assuming the watch is still valid.
+	// Optimistically set up and start a stream, assuming the watch is still valid. Without
+	// Resume, the stream starts from kFSEventStreamEventIdSinceNow, but FSEvents may still
+	// hand us history from before then, which we recognize by its ID.
+	startID := fsevents.LatestEventID()
 	s := &fsevents.EventStream{
 		Paths:   []string{root.ToString()},
-		Latency: _eventLatency,
+		Latency: f.latency,
 		Device:  dev,
 		Flags:   fsevents.FileEvents | fsevents.WatchRoot,
 	}
@@ -98,46 +120,86 @@ func (f *fseventsBackend) AddRoot(someRoot turbopath.AbsoluteSystemPath, exclude
 		return ErrFilewatchingClosed
 	}
 	f.streams = append(f.streams, s)
-	f.logger.Debug(fmt.Sprintf("watching root %v, excluding %v", root, excludePatterns))
+	// FSEvents streams are recursive, so the root is the only watch we install
+	f.roots = append(f.roots, someRoot)
+	if f.listener != nil {
+		f.listener.onWatchAdded(someRoot)
+	}
+	f.logger.Debug("watching root", _logOp, "watch", _logPath, root, "excludes", excludePatterns)
+
+	// translate maps a path reported by FSEvents back to the root we were given, and
+	// reports whether or not we are interested in it.
+	translate := func(rawPath string) (turbopath.AbsoluteSystemPath, bool) {
+		// 1. Ensure that we have a `/`-prefixed path from the event.
+		var eventPath string
+		if !strings.HasPrefix(rawPath, "/") {
+			eventPath = "/" + rawPath
+		} else {
+			eventPath = rawPath
+		}
+
+		// 2. We're getting events from the real path, but we need to translate
+		// back to the path we were provided since that's what the caller will
+		// expect in terms of event paths.
+		watchRootRelativePath := eventPath[len(realRoot):]
+		processedEventPath := someRoot.UntypedJoin(watchRootRelativePath)
+
+		// 3. Compare the event to all exclude patterns, short-circuit if we know
+		// we are not watching this file.
+		processedPathString := processedEventPath.ToString() // loop invariant
+		for _, pattern := range excludePatterns {
+			matches, err := doublestar.Match(pattern, processedPathString)
+			if err != nil {
+				f.sendError(err)
+			} else if matches {
+				return "", false
+			}
+		}
+		return processedEventPath, true
+	}
 
 	go func() {
 		for evs := range events {
-			for _, ev := range evs {
-				isExcluded := false
-
-				// 1. Ensure that we have a `/`-prefixed path from the event.
-				var eventPath string
-				if !strings.HasPrefix("/", ev.Path) {
-					eventPath = "/" + ev.Path
-				} else {
-					eventPath = ev.Path
+			for i := 0; i < len(evs); i++ {
+				ev := evs[i]
+				if ev.Flags&(fsevents.UserDropped|fsevents.KernelDropped) != 0 {
+					f.sendError(errors.Wrapf(ErrEventsDropped, "FSEvents dropped events for %v", root))
+					continue
 				}
-
-				// 2. We're getting events from the real path, but we need to translate
-				// back to the path we were provided since that's what the caller will
-				// expect in terms of event paths.
-				watchRootRelativePath := eventPath[len(realRoot):]
-				processedEventPath := someRoot.UntypedJoin(watchRootRelativePath)
-
-				// 3. Compare the event to all exclude patterns, short-circuit if we know
-				// we are not watching this file.
-				processedPathString := processedEventPath.ToString() // loop invariant
-				for _, pattern := range excludePatterns {
-					matches, err := doublestar.Match(pattern, processedPathString)
-					if err != nil {
-						f.errors <- err
-					} else if matches {
-						isExcluded = true
-						break
+				if isHistory(ev, startID) {
+					continue
+				}
+				// FSEvents reports both halves of a rename as separate ItemRenamed events
+				// with consecutive ids. If we have both halves, report a single rename.
+				if i+1 < len(evs) && isRenamePair(ev, evs[i+1]) {
+					from, fromOk := translate(ev.Path)
+					to, toOk := translate(evs[i+1].Path)
+					if fromOk && toOk {
+						i++
+						if isCaseOnlyChange(from, to) {
+							// Both exist as far as a case-insensitive filesystem is
+							// concerned, so go by the casing on disk
+							if current, ok := onDiskName(from); ok && current == from {
+								from, to = to, from
+							}
+						} else if from.Exists() && !to.Exists() {
+							from, to = to, from
+						}
+						f.sendEvent(Event{
+							Path:      to,
+							OldPath:   from,
+							EventType: FileRenamed,
+						})
+						continue
 					}
 				}
 
 				// 4. Report the file events we care about.
-				if !isExcluded {
-					f.events <- Event{
-						Path:      processedEventPath,
+				if path, ok := translate(ev.Path); ok {
+					f.sendEvent(Event{
+						Path:      path,
 						EventType: toFileEvent(ev.Flags),
-					}
+					})
 				}
 			}
 		}
@@ -146,6 +208,50 @@ func (f *fseventsBackend) AddRoot(someRoot turbopath.AbsoluteSystemPath, exclude
 	return nil
 }
 
+// sendEvent delivers an event unless we have been closed. Must not be called while f.mu is held.
+func (f *fseventsBackend) sendEvent(ev Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.events <- ev
+	}
+}
+
+// sendError delivers an error unless we have been closed. Must not be called while f.mu is held.
+func (f *fseventsBackend) sendError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.errors <- err
+	}
+}
+
+// rescan synthesizes FileAdded events for the current contents of a root that was previously added.
+// FSEvents streams are recursive, so there are no watches to re-install. The walk is
+// throttled, with each entry counting as a stat.
+func (f *fseventsBackend) rescan(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	f.mu.Lock()
+	throttle := f.throttle
+	f.mu.Unlock()
+	return fs.WalkMode(root.ToString(), func(name string, isDir bool, info os.FileMode) error {
+		release := throttle.acquire()
+		defer release()
+		for _, pattern := range excludePatterns {
+			matches, err := doublestar.Match(pattern, name)
+			if err != nil {
+				return err
+			} else if matches {
+				return godirwalk.SkipThis
+			}
+		}
+		f.sendEvent(Event{
+			Path:      fs.AbsoluteSystemPathFromUpstream(name),
+			EventType: FileAdded,
+		})
+		return nil
+	})
+}
+
 func waitForCookie(root turbopath.AbsoluteSystemPath, events <-chan []fsevents.Event, timeout time.Duration) error {
 	// This cookie needs to be in a location that we're watching, and at this point we can't guarantee
 	// what the root is, or if something like "node_modules/.cache/turbo" would make sense. As a compromise, ensure
@@ -190,17 +296,35 @@ func waitForEvent(events <-chan []fsevents.Event, path string, flag fsevents.Eve
 	}
 }
 
+// isHistory returns true if the event happened before the stream that reported it was
+// started, at startID. Events that FSEvents generates itself, such as RootChanged, have
+// an ID of 0, and are never history.
+func isHistory(ev fsevents.Event, startID uint64) bool {
+	return ev.ID != 0 && ev.ID <= startID
+}
+
+// isRenamePair returns true if the two events are the source and destination of
+// the same rename operation.
+func isRenamePair(first fsevents.Event, second fsevents.Event) bool {
+	return first.Flags&fsevents.ItemRenamed != 0 &&
+		second.Flags&fsevents.ItemRenamed != 0 &&
+		second.ID == first.ID+1
+}
+
 var _modifiedMask = fsevents.ItemModified | fsevents.ItemInodeMetaMod | fsevents.ItemFinderInfoMod | fsevents.ItemChangeOwner | fsevents.ItemXattrMod
 
 func toFileEvent(flags fsevents.EventFlags) FileEvent {
-	if flags&fsevents.ItemCreated != 0 {
+	// FSEvents coalesces flags for a path, so a file that is created by being
+	// renamed into place has both ItemCreated and ItemRenamed set. Report it
+	// once, as a rename, rather than as a create as well.
+	if flags&fsevents.ItemRenamed != 0 {
+		return FileRenamed
+	} else if flags&fsevents.ItemCreated != 0 {
 		return FileAdded
 	} else if flags&fsevents.ItemRemoved != 0 {
 		return FileDeleted
 	} else if flags&_modifiedMask != 0 {
 		return FileModified
-	} else if flags&fsevents.ItemRenamed != 0 {
-		return FileRenamed
 	} else if flags&fsevents.RootChanged != 0 {
 		// count this as a delete, something affected the path to the root
 		// of the stream
@@ -209,12 +333,28 @@ func toFileEvent(flags fsevents.EventFlags) FileEvent {
 	return FileOther
 }
 
-// GetPlatformSpecificBackend returns a filewatching backend appropriate for the OS we are
-// running on.
-func GetPlatformSpecificBackend(logger hclog.Logger) (Backend, error) {
+// _fseventsBackendName identifies the FSEvents backend
+const _fseventsBackendName = "fsevents"
+
+func (f *fseventsBackend) name() string {
+	return _fseventsBackendName
+}
+
+func (f *fseventsBackend) capabilities() Capabilities {
+	return Capabilities{
+		RenameCorrelation: true,
+		RecursiveWatching: true,
+		AttributeEvents:   true,
+		OverflowDetection: true,
+	}
+}
+
+// newNativeBackend returns the filewatching backend built on the OS's own notification mechanism
+func newNativeBackend(logger hclog.Logger, cfg backendConfig) (Backend, error) {
 	return &fseventsBackend{
-		events: make(chan Event),
-		errors: make(chan error),
-		logger: logger.Named("fsevents"),
+		latency: cfg.macOSLatency,
+		events:  make(chan Event),
+		errors:  make(chan error),
+		logger:  logger.Named(_fseventsBackendName).With(_logBackend, _fseventsBackendName),
 	}, nil
 }
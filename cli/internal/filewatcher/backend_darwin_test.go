@@ -0,0 +1,70 @@
+//go:build darwin
+// +build darwin
+
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestFileWatchingWithMacOSLatency(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger, WithMacOSLatency(200*time.Millisecond))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+
+	ch := make(chan Event, 1)
+	c := &testClient{
+		notify: ch,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	fooPath := repoRoot.UntypedJoin("foo")
+	err = fooPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		EventType: FileAdded,
+		Path:      fooPath,
+	})
+}
+
+func TestFSEventsHistoryIsDropped(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	existing := repoRoot.UntypedJoin("existing")
+	err := existing.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	// Buffering lets the client see everything reported from the moment we start
+	fw := New(logger, repoRoot, watcher, WithBufferUntilFirstClient())
+	// Modified just before we start, so FSEvents may still be flushing it
+	err = existing.WriteFile([]byte("world"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	// Only what happens after starting is reported
+	live := repoRoot.UntypedJoin("live")
+	err = live.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	for ev := nextEvents(t, ch, 1)[0]; ev.Path != live; ev = nextEvents(t, ch, 1)[0] {
+		assert.Assert(t, ev.Path != existing, "history was reported: %v", ev)
+	}
+}
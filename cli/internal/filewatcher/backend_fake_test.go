@@ -0,0 +1,67 @@
+package filewatcher
+
+import (
+	"sync"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// fakeBackend is a Backend that only produces the events and errors that a test gives it
+type fakeBackend struct {
+	events chan Event
+	errors chan error
+
+	// rescans, if set, receives the root of every rescan we are asked to do
+	rescans chan turbopath.AbsoluteSystemPath
+
+	mu     sync.Mutex
+	roots  []turbopath.AbsoluteSystemPath
+	closed bool
+}
+
+var _ Backend = &fakeBackend{}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		events: make(chan Event),
+		errors: make(chan error),
+	}
+}
+
+func (f *fakeBackend) AddRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.roots = append(f.roots, root)
+	return nil
+}
+
+func (f *fakeBackend) Events() <-chan Event {
+	return f.events
+}
+
+func (f *fakeBackend) Errors() <-chan error {
+	return f.errors
+}
+
+func (f *fakeBackend) Start() error {
+	return nil
+}
+
+func (f *fakeBackend) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFilewatchingClosed
+	}
+	f.closed = true
+	close(f.events)
+	close(f.errors)
+	return nil
+}
+
+func (f *fakeBackend) rescan(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	if f.rescans != nil {
+		f.rescans <- root
+	}
+	return nil
+}
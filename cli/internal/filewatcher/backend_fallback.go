@@ -0,0 +1,98 @@
+//go:build !darwin
+// +build !darwin
+
+package filewatcher
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _raiseFileLimit is replaced in tests that need to run out of file descriptors
+var _raiseFileLimit = raiseFileLimit
+
+// retryAfterLimit is called when installing a watch on path failed with err. kqueue uses
+// a file descriptor per watched directory and file, so if we have run out of those we
+// try raising our limit, once, and then call retry to install the watch again. It
+// returns the result of the retry, or err if there wasn't one. Must be called while
+// f.mu is held.
+func (f *fsNotifyBackend) retryAfterLimit(path turbopath.AbsoluteSystemPath, err error, retry func() error) error {
+	if f.raisedLimit || !(errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)) {
+		return err
+	}
+	f.raisedLimit = true
+	if !_raiseFileLimit() {
+		return err
+	}
+	f.logger.Info("raised the limit on open files to keep watching", _logOp, "watch", _logPath, path)
+	return retry()
+}
+
+// pollInstead starts polling the given unwatchable directories, which we failed to watch
+// because we ran out of watches, and reports that we did so. If addMode is
+// synthesizeEvents, FileAdded events are delivered for what they contain, and for what
+// the given already polled directories contain. Must not be called while f.mu is held.
+func (f *fsNotifyBackend) pollInstead(unwatchable []turbopath.AbsoluteSystemPath, alreadyPolled []turbopath.AbsoluteSystemPath, excludePatterns []string, addMode watchAddMode) error {
+	if len(unwatchable) == 0 && (addMode != synthesizeEvents || len(alreadyPolled) == 0) {
+		return nil
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrFilewatchingClosed
+	}
+	if f.poller == nil {
+		f.poller = newPollingBackend(f.logger, f.cfg)
+		f.poller.throttle = f.throttle
+		go f.forwardPolled(f.poller)
+		if f.started {
+			_ = f.poller.Start()
+		}
+	}
+	poller := f.poller
+	f.mu.Unlock()
+
+	for _, dir := range unwatchable {
+		if err := poller.AddRoot(dir, excludePatterns...); err != nil {
+			return errors.Wrapf(err, "failed to poll %v", dir)
+		}
+	}
+	if addMode == synthesizeEvents {
+		for _, dir := range append(unwatchable, alreadyPolled...) {
+			if err := poller.rescan(dir, excludePatterns...); err != nil {
+				return errors.Wrapf(err, "failed to scan %v", dir)
+			}
+		}
+	}
+	if len(unwatchable) > 0 {
+		f.logger.Warn("ran out of watches, polling directories instead", _logOp, "poll", _logPath, unwatchable[0], "polled_dirs", len(unwatchable))
+		f.mu.Lock()
+		f.queueError(errors.Wrapf(ErrWatchLimitExceeded, "polling %v directories instead of watching them, including %v", len(unwatchable), unwatchable[0]))
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+// forwardPolled delivers the events and errors of our fallback poller as our own
+func (f *fsNotifyBackend) forwardPolled(poller *pollingBackend) {
+	events := poller.Events()
+	errs := poller.Errors()
+	for events != nil || errs != nil {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			f.sendEvent(ev)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			f.sendError(err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//go:build freebsd || openbsd || netbsd || dragonfly
+// +build freebsd openbsd netbsd dragonfly
+
+package filewatcher
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestKqueueBackend(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	existing := repoRoot.UntypedJoin("parent", "existing")
+	err := existing.EnsureDir()
+	assert.NilError(t, err, "EnsureDir")
+	err = existing.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	t.Setenv(_backendEnvVar, "kqueue")
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	_, ok := watcher.(*fsNotifyBackend)
+	assert.Assert(t, ok, "expected the kqueue backend, got %T", watcher)
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	// Files that existed before we started
+	err = existing.WriteFile([]byte("world"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: existing, EventType: FileModified})
+
+	// And new ones, in new directories
+	created := repoRoot.UntypedJoin("parent", "child", "created")
+	err = created.EnsureDir()
+	assert.NilError(t, err, "EnsureDir")
+	err = created.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: created, EventType: FileAdded})
+	err = created.WriteFile([]byte("world"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: created, EventType: FileModified})
+	err = created.Remove()
+	assert.NilError(t, err, "Remove")
+	expectFilesystemEvent(t, ch, Event{Path: created, EventType: FileDeleted})
+}
+
+func TestKqueueFileLimitFallsBackToPolling(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	const dirCount = 100
+	var dirs []turbopath.AbsoluteSystemPath
+	for i := 0; i < dirCount; i++ {
+		dir := repoRoot.UntypedJoin(fmt.Sprintf("dir-%v", i))
+		err := dir.Mkdir(0775)
+		assert.NilError(t, err, "Mkdir")
+		dirs = append(dirs, dir)
+	}
+
+	// Leave only enough file descriptors to watch some of the directories, and don't
+	// let the backend raise the limit
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	assert.NilError(t, err, "Getrlimit")
+	original := limit
+	defer func() { _ = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &original) }()
+	prevRaise := _raiseFileLimit
+	_raiseFileLimit = func() bool { return false }
+	defer func() { _raiseFileLimit = prevRaise }()
+
+	watcher, err := GetPlatformSpecificBackend(logger, WithPollInterval(50*time.Millisecond))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	ch := make(chan Event, dirCount*2)
+
+	limit.Cur = 64
+	err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+	assert.NilError(t, err, "Setrlimit")
+	err = fw.Start()
+	// Polling needs a few descriptors of its own while it scans
+	_ = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &original)
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	expectError(t, fw.Errors(), ErrWatchLimitExceeded)
+
+	// Changes are noticed everywhere, whether a directory is watched or polled
+	for _, dir := range dirs {
+		err := dir.UntypedJoin("foo").WriteFile([]byte("hello"), 0644)
+		assert.NilError(t, err, "WriteFile")
+	}
+	remaining := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for _, dir := range dirs {
+		remaining[dir.UntypedJoin("foo")] = struct{}{}
+	}
+	timeout := time.After(5 * time.Second)
+	for len(remaining) > 0 {
+		select {
+		case ev := <-ch:
+			delete(remaining, ev.Path)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events for %v files", len(remaining))
+		}
+	}
+}
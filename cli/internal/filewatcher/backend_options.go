@@ -0,0 +1,168 @@
+package filewatcher
+
+import (
+	"runtime"
+	"time"
+)
+
+// _defaultMacOSLatency is the FSEvents stream latency used when none is configured.
+var _defaultMacOSLatency = 10 * time.Millisecond
+
+// _defaultPollInterval is how often the polling backend rescans when none is configured.
+var _defaultPollInterval = 500 * time.Millisecond
+
+// _defaultBufferSize is the size of each directory's ReadDirectoryChangesW buffer when
+// none is configured, which is fsnotify's default.
+var _defaultBufferSize = 64 * 1024
+
+// _defaultMaxBufferSize is the most that ReadDirectoryChangesW buffers grow to when
+// none is configured.
+var _defaultMaxBufferSize = 256 * 1024
+
+// backendConfig holds the tunables for the platform-specific backends.
+// Backends ignore the settings that don't apply to them.
+type backendConfig struct {
+	macOSLatency time.Duration
+	walkWorkers  int
+	pollInterval time.Duration
+	// debugLogLimit is the maximum number of high-frequency debug lines logged per second, or 0 for no limit
+	debugLogLimit int
+	renameWindow  time.Duration
+	closeWrite    bool
+	// maxTrackedPaths is the most paths whose identity we remember to pair renames by
+	maxTrackedPaths int
+	// wideDirThreshold is the most entries a directory can have for its files to be
+	// enumerated when it is watched, or 0 for no limit
+	wideDirThreshold int
+	// bufferSize and maxBufferSize bound the size in bytes of each directory's
+	// ReadDirectoryChangesW buffer
+	bufferSize    int
+	maxBufferSize int
+}
+
+func newBackendConfig(opts []BackendOption) backendConfig {
+	cfg := backendConfig{
+		macOSLatency:    _defaultMacOSLatency,
+		walkWorkers:     runtime.GOMAXPROCS(0),
+		pollInterval:    _defaultPollInterval,
+		renameWindow:    _renameWindow,
+		maxTrackedPaths: _maxTrackedPaths,
+		bufferSize:      _defaultBufferSize,
+		maxBufferSize:   _defaultMaxBufferSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// BackendOption configures the Backend returned by GetPlatformSpecificBackend
+type BackendOption func(cfg *backendConfig)
+
+// WithMacOSLatency sets the latency of the FSEvents stream on macOS. Higher values let
+// FSEvents batch more changes together, reducing CPU usage during large operations, at
+// the cost of events being delivered later. It has no effect on other platforms.
+func WithMacOSLatency(d time.Duration) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.macOSLatency = d
+	}
+}
+
+// WithPollInterval sets how often the polling backend rescans the watched roots. Shorter
+// intervals notice changes sooner, at the cost of more I/O. It has no effect on other backends.
+func WithPollInterval(d time.Duration) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.pollInterval = d
+	}
+}
+
+// WithWalkConcurrency sets the number of goroutines used to walk directory hierarchies
+// when installing watches, for backends that watch each directory individually. The default
+// is GOMAXPROCS. A value of 1 walks hierarchies serially.
+func WithWalkConcurrency(workers int) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.walkWorkers = workers
+	}
+}
+
+// WithDebugLogLimit caps how many high-frequency debug lines, such as one per directory
+// watched, the backend logs per second. Operations that touch many directories at once,
+// like a large `git checkout`, otherwise flood the log. Lines over the limit are counted
+// and summarized once the next second begins. Errors and warnings are never limited.
+// The default of 0 logs every line.
+func WithDebugLogLimit(perSecond int) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.debugLogLimit = perSecond
+	}
+}
+
+// WithRenameWindow sets how long we wait for the second half of a rename, for backends
+// that report the two halves separately, such as inotify. A file moved out of the
+// watched tree has no second half, and is reported as FileDeleted once the window has
+// passed. Shorter windows report those sooner, but may fail to pair up a rename within
+// the tree when the system is busy, reporting it as a deletion and a creation instead.
+// The default is 100ms. It has no effect on other backends.
+func WithRenameWindow(d time.Duration) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.renameWindow = d
+	}
+}
+
+// WithRenameTrackingLimit sets how many paths we remember the identity of, to pair up
+// the two halves of a rename by, for backends that report them separately, such as
+// inotify. Remembering a path that already exists when its directory is watched costs
+// an Lstat, and each path costs memory for as long as it exists. Renames of paths beyond
+// the limit are reported as a deletion and a creation. The default is 131072, and 0
+// turns off pairing renames by identity. It has no effect on other backends.
+func WithRenameTrackingLimit(paths int) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.maxTrackedPaths = paths
+	}
+}
+
+// WithCloseWrite reports a change to a file's contents once, when the process writing
+// it closes the file, rather than on every write. A large file being written otherwise
+// produces a stream of FileModified events, of which only the last describes the
+// finished file. Changes made by a process that keeps the file open indefinitely, or
+// that writes through a memory mapping, are missed until it closes the file, if it ever
+// does. Changes to a file's permissions are still reported as they happen. It is only
+// available on Linux, with the native backend, and has no effect elsewhere.
+//
+// Close-writes are watched by an inotify instance of their own, so every directory uses
+// two watches, and half as many directories fit within fs.inotify.max_user_watches. A
+// directory that can't be given its second watch reports every write, as it would
+// without WithCloseWrite.
+func WithCloseWrite(enabled bool) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.closeWrite = enabled
+	}
+}
+
+// WithWideDirThreshold stops us from enumerating the files of a directory with more than
+// the given number of entries when we watch it, for trees with generated directories of
+// hundreds of thousands of files, which would otherwise stall the watch for seconds at a
+// time. Such a directory is still watched, as are its subdirectories, and changes within
+// it are reported as they happen. But the files that were already in it are neither
+// reported, when the directory was created or is rescanned, nor remembered, so renaming
+// one of them may be reported as a deletion and a creation. It applies to
+// backends that watch each directory individually. The default of 0 enumerates every
+// directory, however wide.
+func WithWideDirThreshold(entries int) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.wideDirThreshold = entries
+	}
+}
+
+// WithWindowsBufferSize sets the size in bytes of the buffer that ReadDirectoryChangesW
+// reports each watched directory's changes in, starting at size. When a burst of changes
+// overflows the buffers, they are grown by doubling, up to maxSize, and ErrEventsDropped
+// is reported, followed by a rescan. Each watched directory has a buffer of its own, so
+// the memory used grows with the number of directories. The defaults are 64K and 256K.
+// Sizes below 4K are raised to 4K, and a maxSize below size disables growing. It only
+// applies on Windows.
+func WithWindowsBufferSize(size int, maxSize int) BackendOption {
+	return func(cfg *backendConfig) {
+		cfg.bufferSize = size
+		cfg.maxBufferSize = maxSize
+	}
+}
@@ -0,0 +1,371 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/karrick/godirwalk"
+	"github.com/vercel/turbo/cli/internal/doublestar"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// fileState is what the polling backend compares between scans to detect changes
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+	// id is the file's identity, if the platform provides one. It lets us tell a
+	// rename apart from a deletion and an unrelated creation.
+	id    fileID
+	hasID bool
+}
+
+// inode returns the inode of the file, or 0 if the platform doesn't provide one
+func (s fileState) inode() uint64 {
+	return s.id.ino
+}
+
+// changedFrom returns true if the file's contents or metadata appear to have changed.
+// Directories change whenever their contents do, which is reported separately.
+func (s fileState) changedFrom(prev fileState) bool {
+	if s.mode.IsDir() && prev.mode.IsDir() {
+		return false
+	}
+	return s.size != prev.size || !s.modTime.Equal(prev.modTime) || s.mode != prev.mode
+}
+
+// pollingBackend detects changes by periodically rescanning every root and comparing
+// what it finds against the previous scan. It doesn't depend on any OS facilities, so
+// it works anywhere, but changes are only noticed once per poll interval, and a file
+// that changes without its size or modification time changing goes unnoticed.
+type pollingBackend struct {
+	interval time.Duration
+	events   chan Event
+	errors   chan error
+	logger   hclog.Logger
+
+	mu      sync.Mutex
+	roots   []watchRoot
+	state   map[turbopath.AbsoluteSystemPath]fileState
+	closed  bool
+	started bool
+	stop    chan struct{}
+	// throttle bounds the I/O of rescans, but not of polling
+	throttle *scanThrottle
+}
+
+func newPollingBackend(logger hclog.Logger, cfg backendConfig) *pollingBackend {
+	return &pollingBackend{
+		interval: cfg.pollInterval,
+		events:   make(chan Event),
+		errors:   make(chan error),
+		logger:   logger.Named(_pollingBackend).With(_logBackend, _pollingBackend),
+		state:    make(map[turbopath.AbsoluteSystemPath]fileState),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (p *pollingBackend) setScanThrottle(throttle *scanThrottle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.throttle = throttle
+}
+
+func (p *pollingBackend) name() string {
+	return _pollingBackend
+}
+
+// capabilities reports that each scan covers whole roots, and compares only sizes,
+// modification times, and modes. Renames are paired by file identity, which Windows
+// doesn't give us.
+func (p *pollingBackend) capabilities() Capabilities {
+	return Capabilities{
+		RenameCorrelation: runtime.GOOS != "windows",
+		RecursiveWatching: true,
+	}
+}
+
+func (p *pollingBackend) Events() <-chan Event {
+	return p.events
+}
+
+func (p *pollingBackend) Errors() <-chan error {
+	return p.errors
+}
+
+func (p *pollingBackend) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrFilewatchingClosed
+	}
+	p.closed = true
+	close(p.stop)
+	close(p.events)
+	close(p.errors)
+	return nil
+}
+
+func (p *pollingBackend) AddRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	r := watchRoot{
+		path:            root,
+		excludePatterns: excludePatterns,
+	}
+	// We don't report events for what already exists
+	state, err := scanRoot(r, osScanFS{})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrFilewatchingClosed
+	}
+	p.roots = append(p.roots, r)
+	// A scan may be reading the current state without holding p.mu, so replace it rather
+	// than modifying it
+	merged := make(map[turbopath.AbsoluteSystemPath]fileState, len(p.state)+len(state))
+	for path, s := range p.state {
+		merged[path] = s
+	}
+	for path, s := range state {
+		merged[path] = s
+	}
+	p.state = merged
+	return nil
+}
+
+func (p *pollingBackend) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrFilewatchingClosed
+	}
+	if !p.started {
+		p.started = true
+		go p.poll()
+	}
+	return nil
+}
+
+// rescan synthesizes FileAdded events for the current contents of a root that was previously added
+func (p *pollingBackend) rescan(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	p.mu.Lock()
+	fsys := throttledFS{fsys: osScanFS{}, throttle: p.throttle}
+	p.mu.Unlock()
+	state, err := scanRoot(watchRoot{
+		path:            root,
+		excludePatterns: excludePatterns,
+	}, fsys)
+	if err != nil {
+		return err
+	}
+	for _, path := range sortedPaths(state) {
+		p.sendEvent(Event{
+			Path:      path,
+			EventType: FileAdded,
+			Inode:     state[path].inode(),
+		})
+	}
+	return nil
+}
+
+func (p *pollingBackend) poll() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.scan()
+		}
+	}
+}
+
+// scan rescans every root, and reports the differences from the previous scan
+func (p *pollingBackend) scan() {
+	p.mu.Lock()
+	roots := make([]watchRoot, len(p.roots))
+	copy(roots, p.roots)
+	p.mu.Unlock()
+
+	current := make(map[turbopath.AbsoluteSystemPath]fileState)
+	for _, root := range roots {
+		state, err := scanRoot(root, osScanFS{})
+		if err != nil {
+			p.sendError(err)
+			return
+		}
+		for path, s := range state {
+			current[path] = s
+		}
+	}
+
+	p.mu.Lock()
+	previous := p.state
+	p.state = current
+	p.mu.Unlock()
+
+	// Report additions parents-first, and deletions children-first, as a native backend would
+	deleted := sortedPaths(previous)
+	reported := make(map[turbopath.AbsoluteSystemPath]struct{})
+	renamed := pairRenames(previous, current, reported)
+	for _, path := range sortedPaths(current) {
+		if _, ok := reported[path]; ok {
+			continue
+		} else if oldPath, ok := renamed[path]; ok {
+			p.sendEvent(Event{Path: path, OldPath: oldPath, EventType: FileRenamed, Inode: current[path].inode()})
+		} else if prev, ok := previous[path]; !ok {
+			p.sendEvent(Event{Path: path, EventType: FileAdded, Inode: current[path].inode()})
+		} else if current[path].mode.IsDir() != prev.mode.IsDir() {
+			// A file replaced by a directory, or the other way around, is a deletion of
+			// the old one, and of anything that was beneath it, and an addition of the new
+			for i := len(deleted) - 1; i >= 0; i-- {
+				child := deleted[i]
+				if _, ok := current[child]; !ok && child != path && child.HasPrefix(path) {
+					p.sendEvent(Event{Path: child, EventType: FileDeleted, Inode: previous[child].inode()})
+					reported[child] = struct{}{}
+				}
+			}
+			p.sendEvent(Event{Path: path, EventType: FileDeleted, Inode: prev.inode()})
+			p.sendEvent(Event{Path: path, EventType: FileAdded, Inode: current[path].inode()})
+		} else if current[path].changedFrom(prev) {
+			p.sendEvent(Event{Path: path, EventType: FileModified, Inode: current[path].inode()})
+		}
+	}
+	renamedFrom := make(map[turbopath.AbsoluteSystemPath]struct{}, len(renamed))
+	for _, oldPath := range renamed {
+		renamedFrom[oldPath] = struct{}{}
+	}
+	for i := len(deleted) - 1; i >= 0; i-- {
+		_, stillExists := current[deleted[i]]
+		_, alreadyReported := reported[deleted[i]]
+		_, wasRenamed := renamedFrom[deleted[i]]
+		if !stillExists && !alreadyReported && !wasRenamed {
+			p.sendEvent(Event{Path: deleted[i], EventType: FileDeleted, Inode: previous[deleted[i]].inode()})
+		}
+	}
+}
+
+// sendEvent delivers an event unless we have been closed. Must not be called while p.mu is held.
+func (p *pollingBackend) sendEvent(ev Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.events <- ev
+	}
+}
+
+// sendError delivers an error unless we have been closed. Must not be called while p.mu is held.
+func (p *pollingBackend) sendError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.errors <- err
+	}
+}
+
+// scanRoot records the state of everything in the given root that isn't excluded, as
+// stat'ed through fsys
+func scanRoot(root watchRoot, fsys scanFS) (map[turbopath.AbsoluteSystemPath]fileState, error) {
+	state := make(map[turbopath.AbsoluteSystemPath]fileState)
+	err := fs.WalkMode(root.path.ToString(), func(name string, isDir bool, mode os.FileMode) error {
+		for _, pattern := range root.excludePatterns {
+			matches, err := doublestar.Match(pattern, filepath.ToSlash(name))
+			if err != nil {
+				return err
+			} else if matches {
+				return godirwalk.SkipThis
+			}
+		}
+		info, err := fsys.Lstat(name)
+		if err != nil {
+			// It went away while we were scanning, we'll notice on the next scan
+			return nil
+		}
+		id, hasID := fileIDOf(info)
+		state[fs.AbsoluteSystemPathFromUpstream(name)] = fileState{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			mode:    info.Mode(),
+			id:      id,
+			hasID:   hasID,
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		// The root itself is gone, which we report as everything having been deleted
+		return state, nil
+	}
+	return state, err
+}
+
+// pairRenames finds the paths that disappeared between two scans while a file of the same
+// identity appeared, and returns them keyed by where they are now. Like a native backend,
+// we report a directory's move once, rather than the moves of everything beneath it, and
+// those are added to reported, under both their old and new paths.
+func pairRenames(previous map[turbopath.AbsoluteSystemPath]fileState, current map[turbopath.AbsoluteSystemPath]fileState, reported map[turbopath.AbsoluteSystemPath]struct{}) map[turbopath.AbsoluteSystemPath]turbopath.AbsoluteSystemPath {
+	gone := make(map[fileID]turbopath.AbsoluteSystemPath)
+	for path, s := range previous {
+		if _, ok := current[path]; !ok && s.hasID {
+			gone[s.id] = path
+		}
+	}
+	renamed := make(map[turbopath.AbsoluteSystemPath]turbopath.AbsoluteSystemPath)
+	if len(gone) == 0 {
+		return renamed
+	}
+	var movedDirs []turbopath.AbsoluteSystemPath
+outer:
+	for _, path := range sortedPaths(current) {
+		if _, ok := previous[path]; ok {
+			continue
+		}
+		// Parents come first, so a moved directory is paired before its contents
+		for _, dir := range movedDirs {
+			if !path.HasPrefix(dir) {
+				continue
+			}
+			oldChild := renamed[dir].UntypedJoin(path.ToString()[len(dir):])
+			if _, ok := previous[oldChild]; ok {
+				if _, ok := current[oldChild]; !ok {
+					reported[path] = struct{}{}
+					reported[oldChild] = struct{}{}
+					continue outer
+				}
+			}
+		}
+		s := current[path]
+		if !s.hasID {
+			continue
+		}
+		oldPath, ok := gone[s.id]
+		if !ok || previous[oldPath].mode.IsDir() != s.mode.IsDir() {
+			continue
+		}
+		delete(gone, s.id)
+		renamed[path] = oldPath
+		if s.mode.IsDir() {
+			movedDirs = append(movedDirs, path)
+		}
+	}
+	return renamed
+}
+
+func sortedPaths(state map[turbopath.AbsoluteSystemPath]fileState) []turbopath.AbsoluteSystemPath {
+	paths := make([]turbopath.AbsoluteSystemPath, 0, len(state))
+	for path := range state {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i] < paths[j]
+	})
+	return paths
+}
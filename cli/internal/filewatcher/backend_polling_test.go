@@ -0,0 +1,89 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestBackendSelectedByEnv(t *testing.T) {
+	logger := hclog.Default()
+	t.Setenv(_backendEnvVar, "polling")
+	backend, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	defer func(backend Backend) { _ = backend.Close() }(backend)
+	_, ok := backend.(*pollingBackend)
+	assert.Assert(t, ok, "expected the polling backend, got %T", backend)
+
+	t.Setenv(_backendEnvVar, "native")
+	backend, err = GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	defer func(backend Backend) { _ = backend.Close() }(backend)
+	_, ok = backend.(*pollingBackend)
+	assert.Assert(t, !ok, "expected the native backend, got %T", backend)
+
+	// Asking for kqueue gets us the native backend, whether or not that is kqueue
+	t.Setenv(_backendEnvVar, "kqueue")
+	backend, err = GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	defer func(backend Backend) { _ = backend.Close() }(backend)
+	_, ok = backend.(*pollingBackend)
+	assert.Assert(t, !ok, "expected the native backend, got %T", backend)
+}
+
+func TestPollingBackend(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("node_modules", "dep").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	existingPath := repoRoot.UntypedJoin("existing")
+	err = existingPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	cfg := newBackendConfig([]BackendOption{WithPollInterval(10 * time.Millisecond)})
+	fw := New(logger, repoRoot, newPollingBackend(logger, cfg))
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	newPath := repoRoot.UntypedJoin("parent", "new")
+	err = newPath.EnsureDir()
+	assert.NilError(t, err, "EnsureDir")
+	err = newPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      newPath.Dir(),
+		EventType: FileAdded,
+	})
+	expectFilesystemEvent(t, ch, Event{
+		Path:      newPath,
+		EventType: FileAdded,
+	})
+
+	err = existingPath.WriteFile([]byte("hello, world"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      existingPath,
+		EventType: FileModified,
+	})
+
+	err = existingPath.Remove()
+	assert.NilError(t, err, "Remove")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      existingPath,
+		EventType: FileDeleted,
+	})
+
+	// Excluded paths are not polled
+	err = repoRoot.UntypedJoin("node_modules", "dep", "index.js").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+}
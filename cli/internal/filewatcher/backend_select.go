@@ -0,0 +1,53 @@
+package filewatcher
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// _backendEnvVar can be set to force a particular backend, regardless of the OS
+const _backendEnvVar = "TURBO_FILEWATCH_BACKEND"
+
+const (
+	// _nativeBackend uses the OS's own notification mechanism: inotify, FSEvents, etc.
+	_nativeBackend = "native"
+	// _pollingBackend periodically rescans the watched roots
+	_pollingBackend = "polling"
+	// _kqueueBackend is the native backend on the BSDs, where fsnotify watches via kqueue
+	_kqueueBackend = "kqueue"
+)
+
+// hasKqueueBackend returns true if the native backend watches via kqueue. macOS has
+// kqueue too, but we watch via FSEvents there.
+func hasKqueueBackend() bool {
+	switch runtime.GOOS {
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+		return true
+	}
+	return false
+}
+
+// GetPlatformSpecificBackend returns a filewatching backend appropriate for the OS we are
+// running on. On the BSDs, that is kqueue, which needs a file descriptor for every
+// directory and file it watches: we raise our limit on open files if we run out, and
+// poll whatever we still can't watch. Setting TURBO_FILEWATCH_BACKEND to "polling"
+// selects the polling backend instead, which works everywhere but is slower to notice
+// changes. This is mostly useful for debugging, or for reproducing platform-specific
+// behavior. "kqueue" can be requested explicitly, but only exists on the BSDs.
+func GetPlatformSpecificBackend(logger hclog.Logger, opts ...BackendOption) (Backend, error) {
+	cfg := newBackendConfig(opts)
+	switch kind := os.Getenv(_backendEnvVar); kind {
+	case _pollingBackend:
+		return newPollingBackend(logger, cfg), nil
+	case "", _nativeBackend:
+	case _kqueueBackend:
+		if !hasKqueueBackend() {
+			logger.Warn("kqueue is only available on the BSDs, using the native backend", "env", _backendEnvVar, "os", runtime.GOOS)
+		}
+	default:
+		logger.Warn("unknown backend requested, using the native backend", "env", _backendEnvVar, _logBackend, kind)
+	}
+	return newNativeBackend(logger, cfg)
+}
@@ -0,0 +1,47 @@
+package filewatcher
+
+import (
+	"sync/atomic"
+)
+
+// WithChannelBuffer places a buffer of up to n events between the backend and the loop
+// that delivers events to clients. By default there is no buffer: the backend waits for
+// each event to be delivered before producing the next one, so nothing is lost inside the
+// watcher, but a slow client stalls the backend, and the operating system may then drop
+// events on its end during a burst. A buffer absorbs bursts at the cost of memory for n
+// pending events. If it fills up anyway, further events are dropped until there is room,
+// and clients receive ErrEventsDropped via OnFileWatchError.
+func WithChannelBuffer(n int) Option {
+	return func(fw *FileWatcher) {
+		fw.bufferSize = n
+	}
+}
+
+// bufferEvents forwards events from the backend into a channel with room for
+// fw.bufferSize events. It never blocks the backend: if the buffer is full, the
+// event is dropped and counted, and the watch loop is notified via fw.overflowed.
+func (fw *FileWatcher) bufferEvents(in <-chan Event) <-chan Event {
+	out := make(chan Event, fw.bufferSize)
+	go func() {
+		defer close(out)
+		for ev := range in {
+			select {
+			case out <- ev:
+			default:
+				atomic.AddUint64(&fw.counters().dropped, 1)
+				if atomic.AddUint64(&fw.dropped, 1) == 1 {
+					select {
+					case fw.overflowed <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// takeDropped returns the number of events dropped since the last call
+func (fw *FileWatcher) takeDropped() uint64 {
+	return atomic.SwapUint64(&fw.dropped, 0)
+}
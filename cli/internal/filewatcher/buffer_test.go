@@ -0,0 +1,84 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestChannelBufferOverflow(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithChannelBuffer(2))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	// An unbuffered notify channel stalls the watch loop until we read from it,
+	// so the burst below has to fit in the event buffer.
+	ch := make(chan Event)
+	errs := make(chan error, 1)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+		errs:   errs,
+	}), "AddClient")
+
+	const burst = 10
+	for i := 0; i < burst; i++ {
+		backend.events <- Event{
+			Path:      repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)),
+			EventType: FileAdded,
+		}
+	}
+
+	received := 0
+	var dropErr error
+	for {
+		select {
+		case <-ch:
+			received++
+			continue
+		case err := <-errs:
+			dropErr = err
+			continue
+		case <-time.After(200 * time.Millisecond):
+		}
+		break
+	}
+	assert.ErrorIs(t, dropErr, ErrEventsDropped)
+	assert.Assert(t, received < burst, "expected some events to be dropped, got all %v", received)
+}
+
+func TestEventsDroppedTriggersRescan(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	backend.rescans = make(chan turbopath.AbsoluteSystemPath, 1)
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	errs := make(chan error, 1)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: make(chan Event, 16),
+		errs:   errs,
+	}), "AddClient")
+
+	// The backend reports that the OS overflowed
+	backend.errors <- errors.Wrap(ErrEventsDropped, "queue overflow")
+	assert.ErrorIs(t, <-errs, ErrEventsDropped)
+	select {
+	case root := <-backend.rescans:
+		assert.Equal(t, root, repoRoot)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for rescan")
+	}
+}
@@ -0,0 +1,112 @@
+package filewatcher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// _burst is enough files created at once to overflow the default 64K buffer, at around
+// 100 bytes per change
+const _burst = 2000
+
+func createBurst(t *testing.T, dir turbopath.AbsoluteSystemPath) map[turbopath.AbsoluteSystemPath]struct{} {
+	t.Helper()
+	created := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for i := 0; i < _burst; i++ {
+		path := dir.UntypedJoin(fmt.Sprintf("file-with-a-long-name-to-fill-buffers-%v", i))
+		err := path.WriteFile([]byte("hello"), 0644)
+		assert.NilError(t, err, "WriteFile")
+		created[path] = struct{}{}
+	}
+	return created
+}
+
+func TestBurstLosesNoFiles(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger, WithWindowsBufferSize(4*1024*1024, 4*1024*1024))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 1024)
+	errs := make(chan error, 16)
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+		errs:   errs,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	// With the larger buffer, every file is reported as it is created, without an overflow
+	expected := createBurst(t, repoRoot)
+	timeout := time.After(10 * time.Second)
+	for len(expected) > 0 {
+		select {
+		case ev := <-ch:
+			if ev.EventType == FileAdded {
+				delete(expected, ev.Path)
+			}
+		case err := <-errs:
+			t.Fatalf("unexpected error: %v", err)
+		case <-timeout:
+			t.Fatalf("timed out with %v files unreported", len(expected))
+		}
+	}
+}
+
+func TestBuffersGrowAfterOverflow(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger, WithWindowsBufferSize(4096, 16384))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	f, ok := watcher.(*fsNotifyBackend)
+	assert.Assert(t, ok, "backend is %T", watcher)
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 1024)
+	errs := make(chan error, 16)
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+		errs:   errs,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	// Every file is still reported, either directly, or by the rescan that follows the overflow
+	expected := createBurst(t, repoRoot)
+	overflowed := false
+	timeout := time.After(10 * time.Second)
+	for len(expected) > 0 || !overflowed {
+		select {
+		case ev := <-ch:
+			if ev.EventType == FileAdded {
+				delete(expected, ev.Path)
+			}
+		case err := <-errs:
+			assert.Assert(t, errors.Is(err, ErrEventsDropped), "unexpected error: %v", err)
+			overflowed = true
+		case <-timeout:
+			t.Fatalf("timed out with %v files unreported, overflowed: %v", len(expected), overflowed)
+		}
+	}
+	for atomic.LoadInt64(&f.bufferSize) == 4096 {
+		select {
+		case <-timeout:
+			t.Fatal("timed out waiting for the buffers to grow")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	assert.Assert(t, atomic.LoadInt64(&f.bufferSize) <= 16384, "buffers grew past the limit")
+}
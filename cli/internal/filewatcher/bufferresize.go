@@ -0,0 +1,57 @@
+//go:build !darwin
+// +build !darwin
+
+package filewatcher
+
+import (
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// _minBufferSize is the smallest ReadDirectoryChangesW buffer that fsnotify accepts
+const _minBufferSize = 4096
+
+// addFSNotifyWatch watches a single path, with a ReadDirectoryChangesW buffer of the
+// current size on Windows
+func (f *fsNotifyBackend) addFSNotifyWatch(name string) error {
+	size := int(atomic.LoadInt64(&f.bufferSize))
+	return f.watcher.AddWith(name, fsnotify.WithBufferSize(size))
+}
+
+// growBuffers doubles the size of our ReadDirectoryChangesW buffers, up to the maximum,
+// after they have overflowed. fsnotify doesn't tell us which directory's buffer it was,
+// so every directory is watched again with the larger buffer. Whatever changes while a
+// directory's watch is replaced is picked up by the rescan that follows the overflow.
+// Must not be called while f.mu is held, nor from the watch loop, since removing and
+// adding watches waits on fsnotify, which may be waiting for the watch loop to take an
+// event.
+func (f *fsNotifyBackend) growBuffers() {
+	current := atomic.LoadInt64(&f.bufferSize)
+	grown := current * 2
+	if limit := int64(f.cfg.maxBufferSize); grown > limit {
+		grown = limit
+	}
+	if grown <= current || !atomic.CompareAndSwapInt64(&f.bufferSize, current, grown) {
+		// Already as large as it may be, or someone else is growing it
+		return
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	dirs := make([]string, 0, len(f.watched))
+	for dir := range f.watched {
+		dirs = append(dirs, dir.ToString())
+	}
+	f.mu.Unlock()
+	f.logger.Info("event buffers overflowed, growing them", _logOp, "watch", "buffer_size", grown, "dirs", len(dirs))
+	for _, dir := range dirs {
+		// A watch only takes a new buffer size when it is first added
+		_ = f.watcher.Remove(dir)
+		if err := f.addWatch(dir); err != nil {
+			f.logger.Warn("failed to watch directory with a larger buffer", _logOp, "watch", _logPath, dir, "error", err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package filewatcher
+
+import "time"
+
+// WithBulkSuppression detects bulk operations, like a `git checkout`, `npm install`, or
+// `rm -rf`, that create and delete many files in quick succession. Once more than
+// threshold events arrive within settleWindow, events are held back until none have
+// arrived for settleWindow. Then the held events are reduced to their net effect per
+// path, as they are while paused, and delivered. Files that were created and deleted
+// again during the operation produce no events at all. Events that arrived before the
+// operation was detected have already been delivered. Errors are not held back.
+func WithBulkSuppression(threshold int, settleWindow time.Duration) Option {
+	return func(fw *FileWatcher) {
+		fw.bulk = &bulkDetector{
+			threshold: threshold,
+			window:    settleWindow,
+		}
+	}
+}
+
+// bulkDetector tracks the rate of events to detect bulk operations. It is only used by
+// the watch loop.
+type bulkDetector struct {
+	threshold int
+	window    time.Duration
+
+	// windowStart and count are the start of the current window and how many events
+	// have arrived in it
+	windowStart time.Time
+	count       int
+
+	// active is set during a bulk operation, while we hold events in pending
+	active  bool
+	last    time.Time
+	pending *eventCoalescer
+}
+
+// hold returns true if the event is part of a bulk operation, and has been held
+func (b *bulkDetector) hold(ev Event, now time.Time) bool {
+	if !b.active {
+		if now.Sub(b.windowStart) > b.window {
+			b.windowStart = now
+			b.count = 0
+		}
+		b.count++
+		if b.count <= b.threshold {
+			return false
+		}
+		b.active = true
+	}
+	b.pending.add(ev)
+	b.last = now
+	return true
+}
+
+// settleDeadline returns when the current bulk operation will be considered settled, if
+// there is one
+func (b *bulkDetector) settleDeadline() (time.Time, bool) {
+	if !b.active {
+		return time.Time{}, false
+	}
+	return b.last.Add(b.window), true
+}
+
+// settle ends the current bulk operation if it has settled, or if force is set, and
+// returns the net effect of the events held during it
+func (b *bulkDetector) settle(now time.Time, force bool) []Event {
+	if !b.active || (!force && now.Before(b.last.Add(b.window))) {
+		return nil
+	}
+	b.active = false
+	b.windowStart = now
+	b.count = 0
+	return b.pending.flush()
+}
+
+// holdIfBulk returns true if the event has been held as part of a bulk operation
+func (fw *FileWatcher) holdIfBulk(ev Event) bool {
+	if fw.bulk == nil {
+		return false
+	}
+	wasActive := fw.bulk.active
+	held := fw.bulk.hold(ev, time.Now())
+	if held && !wasActive {
+		fw.logger.Debug("bulk operation detected, holding events until they settle", _logOp, "hold", "threshold", fw.bulk.threshold, "window", fw.bulk.window)
+	}
+	return held
+}
+
+// settleBulk delivers the net effect of a bulk operation once it has settled
+func (fw *FileWatcher) settleBulk(now time.Time, force bool) {
+	if fw.bulk == nil {
+		return
+	}
+	evs := fw.bulk.settle(now, force)
+	if len(evs) > 0 {
+		fw.logger.Debug("bulk operation settled, delivering net changes", _logOp, "dispatch", "events", len(evs))
+	}
+	for _, ev := range evs {
+		if !fw.holdIfPaused(ev) && !fw.holdForOrdering(ev) {
+			fw.dispatch(ev)
+		}
+	}
+}
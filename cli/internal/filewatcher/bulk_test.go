@@ -0,0 +1,69 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestBulkSuppression(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	const threshold = 10
+	fw := New(logger, repoRoot, backend, WithBulkSuppression(threshold, 100*time.Millisecond))
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 1000)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Lots of files come and go, like an install's temporary files, and a few stay
+	var survivors []turbopath.AbsoluteSystemPath
+	for i := 0; i < 100; i++ {
+		churn := repoRoot.UntypedJoin(fmt.Sprintf("churn-%v", i))
+		backend.events <- Event{Path: churn, EventType: FileAdded}
+		backend.events <- Event{Path: churn, EventType: FileDeleted}
+		if i%20 == 19 {
+			survivor := repoRoot.UntypedJoin(fmt.Sprintf("survivor-%v", i))
+			backend.events <- Event{Path: survivor, EventType: FileAdded}
+			backend.events <- Event{Path: survivor, EventType: FileModified}
+			survivors = append(survivors, survivor)
+		}
+	}
+
+	// Until the operation is detected, events are delivered as usual
+	for i := 0; i < threshold; i++ {
+		<-ch
+	}
+	var got []turbopath.AbsoluteSystemPath
+	quiet := time.After(time.Second)
+	for len(got) < len(survivors) {
+		select {
+		case ev := <-ch:
+			assert.Equal(t, ev.EventType, FileAdded, "unexpected event %v", ev)
+			got = append(got, ev.Path)
+		case <-quiet:
+			t.Fatalf("timed out waiting for survivors, got %v", got)
+		}
+	}
+	assert.DeepEqual(t, got, survivors)
+	expectNoFilesystemEvent(t, ch)
+
+	// Once settled, events are delivered right away again
+	foo := repoRoot.UntypedJoin("foo")
+	backend.events <- Event{Path: foo, EventType: FileModified}
+	select {
+	case ev := <-ch:
+		assert.Assert(t, ev.Equal(Event{Path: foo, EventType: FileModified}), "unexpected event %v", ev)
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("event was held after the bulk operation settled")
+	}
+}
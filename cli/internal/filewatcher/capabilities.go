@@ -0,0 +1,41 @@
+package filewatcher
+
+// Capabilities describes what the active backend is able to report, so that consumers can
+// decide whether they need to compensate for what it can't, for instance by inferring
+// renames from inodes themselves.
+type Capabilities struct {
+	// RenameCorrelation is true if both halves of a rename are reported as a single
+	// FileRenamed event carrying OldPath. Otherwise a rename may arrive as a deletion and an
+	// unrelated creation.
+	RenameCorrelation bool
+	// RecursiveWatching is true if the backend watches a whole tree at once. Otherwise we
+	// watch each directory individually, and so are subject to the OS's limit on watches.
+	RecursiveWatching bool
+	// AttributeEvents is true if changes to a file's permissions or ownership are reported
+	// as FileModified, even when its contents haven't changed.
+	AttributeEvents bool
+	// OverflowDetection is true if ErrEventsDropped is reported when the OS discards
+	// events that weren't read quickly enough. Otherwise lost events go unnoticed.
+	OverflowDetection bool
+}
+
+// capableBackend is implemented by backends that can describe their capabilities
+type capableBackend interface {
+	capabilities() Capabilities
+}
+
+// Capabilities reports what the active backend supports. A backend that doesn't describe
+// itself is assumed to support nothing.
+func (fw *FileWatcher) Capabilities() Capabilities {
+	if capable, ok := fw.backend.(capableBackend); ok {
+		return capable.capabilities()
+	}
+	return Capabilities{}
+}
+
+// BackendName returns the name of the active backend: "inotify", "fsevents", "kqueue",
+// "ReadDirectoryChangesW", or "polling". A backend that doesn't name itself is described
+// by its type.
+func (fw *FileWatcher) BackendName() string {
+	return backendName(fw.backend)
+}
@@ -0,0 +1,84 @@
+package filewatcher
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	defer func() { _ = watcher.Close() }()
+	fw := New(logger, repoRoot, watcher)
+
+	var expected Capabilities
+	switch {
+	case os.Getenv(_backendEnvVar) == _pollingBackend:
+		expected = Capabilities{RecursiveWatching: true}
+	case runtime.GOOS == "darwin":
+		expected = Capabilities{
+			RenameCorrelation: true,
+			RecursiveWatching: true,
+			AttributeEvents:   true,
+			OverflowDetection: true,
+		}
+	case runtime.GOOS == "linux":
+		expected = Capabilities{
+			RenameCorrelation: true,
+			AttributeEvents:   true,
+			OverflowDetection: true,
+		}
+	case runtime.GOOS == "windows":
+		expected = Capabilities{
+			AttributeEvents:   true,
+			OverflowDetection: true,
+		}
+	default:
+		expected = Capabilities{
+			RenameCorrelation: true,
+			AttributeEvents:   true,
+		}
+	}
+	assert.Equal(t, fw.Capabilities(), expected)
+
+	// A backend that doesn't describe itself supports nothing
+	fw = New(logger, repoRoot, newFakeBackend())
+	assert.Equal(t, fw.Capabilities(), Capabilities{})
+}
+
+func TestBackendName(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	defer func() { _ = watcher.Close() }()
+	fw := New(logger, repoRoot, watcher)
+
+	var expected string
+	switch {
+	case os.Getenv(_backendEnvVar) == _pollingBackend:
+		expected = "polling"
+	case runtime.GOOS == "darwin":
+		expected = "fsevents"
+	case runtime.GOOS == "linux":
+		expected = "inotify"
+	case runtime.GOOS == "windows":
+		expected = "ReadDirectoryChangesW"
+	default:
+		expected = "kqueue"
+	}
+	assert.Equal(t, fw.BackendName(), expected)
+
+	// A backend that doesn't name itself is described by its type
+	fw = New(logger, repoRoot, newFakeBackend())
+	assert.Equal(t, fw.BackendName(), "*filewatcher.fakeBackend")
+}
@@ -0,0 +1,40 @@
+package filewatcher
+
+import (
+	"os"
+	"strings"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// isCaseOnlyChange returns true if the two paths differ, but only in case, as when a
+// file is renamed from README.md to Readme.md
+func isCaseOnlyChange(a turbopath.AbsoluteSystemPath, b turbopath.AbsoluteSystemPath) bool {
+	return a != b && strings.EqualFold(a.ToString(), b.ToString())
+}
+
+// onDiskName returns p with its final component spelled the way its directory has it. On
+// a case-insensitive filesystem, p resolves to the same file however it is spelled, and
+// only the directory knows which spelling is current. It returns false if p doesn't exist.
+func onDiskName(p turbopath.AbsoluteSystemPath) (turbopath.AbsoluteSystemPath, bool) {
+	dir, err := os.Open(p.Dir().ToString())
+	if err != nil {
+		return "", false
+	}
+	defer func() { _ = dir.Close() }()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return "", false
+	}
+	base := p.Base()
+	var folded turbopath.AbsoluteSystemPath
+	for _, name := range names {
+		if name == base {
+			return p, true
+		}
+		if folded == "" && strings.EqualFold(name, base) {
+			folded = p.Dir().UntypedJoin(name)
+		}
+	}
+	return folded, folded != ""
+}
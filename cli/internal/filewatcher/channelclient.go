@@ -0,0 +1,133 @@
+package filewatcher
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _clientChannelBuffer is how many events the channel returned by AddClientChannel holds
+// for a reader that has fallen behind
+var _clientChannelBuffer = 256
+
+// AddClientChannel registers a client that delivers events to a channel of its own, for
+// consumers that would rather receive from a channel than implement FileWatchClient.
+// Each channel is independent of every other client. The returned function removes the
+// client and closes the channel, as does filewatching being closed. Errors aren't
+// delivered to the channel; read them from Errors instead.
+//
+// The channel holds a few hundred events. If the reader falls that far behind, the
+// oldest event is dropped to make room for a TreeDirty event for the repository root,
+// which stands in for every change until the reader catches up: anything cached from
+// earlier events should be treated as stale. Its Err wraps ErrEventsDropped, and the
+// events it stands for are counted as dropped by Clients. It returns an error under the
+// same conditions as AddClient.
+func (fw *FileWatcher) AddClientChannel(opts ...ClientOption) (<-chan Event, func(), error) {
+	client := &channelClient{
+		ch:   make(chan Event, _clientChannelBuffer),
+		root: fw.reportedRoot(),
+	}
+	entry, err := fw.addClient(client, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	unsubscribe := func() {
+		// Removing the client waits out any delivery in progress
+		fw.removeClient(entry)
+		client.close()
+	}
+	return client.ch, unsubscribe, nil
+}
+
+// channelClient is the FileWatchClient behind AddClientChannel
+type channelClient struct {
+	ch   chan Event
+	root turbopath.AbsoluteSystemPath
+
+	mu     sync.Mutex
+	closed bool
+	// dirty is set while the most recent event sent to ch is a TreeDirty standing in
+	// for events that didn't fit
+	dirty bool
+	// dropped is the counter of the client's entry, see droppingClient
+	dropped *uint64
+}
+
+// droppingClient is implemented by clients that drop events themselves, rather than
+// by panicking on them, so that Clients counts what they drop as well
+type droppingClient interface {
+	// countDropsIn is called once, as the client is registered, with the counter to
+	// add to atomically
+	countDropsIn(dropped *uint64)
+}
+
+func (c *channelClient) countDropsIn(dropped *uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped = dropped
+}
+
+func (c *channelClient) OnFileWatchEvent(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.ch <- ev:
+		c.dirty = false
+		return
+	default:
+	}
+	if c.dirty {
+		// The channel is still full, so our TreeDirty is still the last thing in it,
+		// and it covers this event too
+		c.countDropped(1)
+		return
+	}
+	// Only the reader takes from the channel, so once we have dropped the oldest event
+	// there is room for the TreeDirty
+	select {
+	case <-c.ch:
+		c.countDropped(1)
+	default:
+	}
+	c.ch <- Event{
+		Path:      c.root,
+		EventType: TreeDirty,
+		Err:       errors.Wrap(ErrEventsDropped, "the reader of a client channel fell behind"),
+	}
+	c.dirty = true
+	// The TreeDirty stands in for this event too
+	c.countDropped(1)
+}
+
+func (c *channelClient) queueDepth() int {
+	return len(c.ch)
+}
+
+// countDropped adds n to the dropped events of the client's entry. Must be called while
+// c.mu is held.
+func (c *channelClient) countDropped(n uint64) {
+	if c.dropped != nil {
+		atomic.AddUint64(c.dropped, n)
+	}
+}
+
+func (c *channelClient) OnFileWatchError(err error) {}
+
+func (c *channelClient) OnFileWatchClosed(err error) {
+	c.close()
+}
+
+// close closes the channel, once
+func (c *channelClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.ch)
+	}
+}
@@ -0,0 +1,67 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestAddClientChannel(t *testing.T) {
+	prevBuffer := _clientChannelBuffer
+	_clientChannelBuffer = 4
+	defer func() { _clientChannelBuffer = prevBuffer }()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	behind, unsubscribeBehind, err := fw.AddClientChannel()
+	assert.NilError(t, err, "AddClientChannel")
+	keepingUp, unsubscribeKeepingUp, err := fw.AddClientChannel()
+	assert.NilError(t, err, "AddClientChannel")
+	defer unsubscribeKeepingUp()
+
+	var evs []Event
+	for i := 0; i < 10; i++ {
+		ev := Event{Path: repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)), EventType: FileAdded}
+		evs = append(evs, ev)
+		backend.events <- ev
+		// One reader keeping up is unaffected by the other falling behind
+		assertEvents(t, nextEvents(t, keepingUp, 1), ev)
+	}
+
+	// The reader that fell behind gets the newest events that fit, with the oldest
+	// dropped to make room for a TreeDirty that covers everything it missed. Both the
+	// oldest and the six that arrived after the channel filled up count as dropped.
+	assert.Equal(t, fw.Clients()[0].Dropped, uint64(7))
+	assert.Equal(t, fw.Clients()[0].QueueDepth, 4)
+	dirty := Event{Path: repoRoot, EventType: TreeDirty}
+	got := nextEvents(t, behind, 4)
+	assertEvents(t, got, evs[1], evs[2], evs[3], dirty)
+	assert.ErrorIs(t, got[3].Err, ErrEventsDropped)
+	// Once it has caught up, events are delivered as usual again
+	modified := Event{Path: evs[0].Path, EventType: FileModified}
+	backend.events <- modified
+	assertEvents(t, nextEvents(t, keepingUp, 1), modified)
+	assertEvents(t, nextEvents(t, behind, 1), modified)
+
+	// Unsubscribing closes only that channel
+	unsubscribeBehind()
+	_, ok := <-behind
+	assert.Assert(t, !ok, "expected the channel to be closed")
+	deleted := Event{Path: evs[0].Path, EventType: FileDeleted}
+	backend.events <- deleted
+	assertEvents(t, nextEvents(t, keepingUp, 1), deleted)
+	assert.Equal(t, len(fw.Clients()), 1)
+
+	// As does closing filewatching
+	err = fw.Close()
+	assert.NilError(t, err, "Close")
+	for range keepingUp {
+	}
+}
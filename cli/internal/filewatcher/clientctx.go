@@ -0,0 +1,33 @@
+package filewatcher
+
+import "context"
+
+// AddClientWithContext registers a client for filesystem events until ctx is done. Then
+// the client is removed, and told so via OnFileWatchClosed with ctx's error. Once
+// OnFileWatchClosed has been called, no more events or errors are delivered to the client.
+// If filewatching is closed first, the client is told so as usual, and only once. It
+// returns an error under the same conditions as AddClient.
+func (fw *FileWatcher) AddClientWithContext(ctx context.Context, client FileWatchClient, opts ...ClientOption) error {
+	entry, err := fw.addClient(client, opts...)
+	if err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-fw.done:
+			return
+		}
+		fw.clientsMu.Lock()
+		// Taking clientsMu for writing waits out any delivery in progress. If we have
+		// been closed, or the client was evicted, it has already been told.
+		removed := !fw.closed && fw.removeClientLocked(entry)
+		fw.clientsMu.Unlock()
+		if removed {
+			_ = callClient(client, func(client FileWatchClient) {
+				client.OnFileWatchClosed(ctx.Err())
+			})
+		}
+	}()
+	return nil
+}
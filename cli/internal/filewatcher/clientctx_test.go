@@ -0,0 +1,71 @@
+package filewatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestAddClientWithContext(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scoped := &closeRecordingClient{
+		allEventsClient: allEventsClient{notify: make(chan Event, 16)},
+		closed:          make(chan error, 2),
+	}
+	assert.NilError(t, fw.AddClientWithContext(ctx, scoped), "AddClientWithContext")
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: ch}), "AddClient")
+
+	before := Event{Path: repoRoot.UntypedJoin("before"), EventType: FileAdded}
+	backend.events <- before
+	expectFilesystemEvent(t, scoped.notify, before)
+
+	cancel()
+	assert.ErrorIs(t, <-scoped.closed, context.Canceled)
+	after := Event{Path: repoRoot.UntypedJoin("after"), EventType: FileAdded}
+	backend.events <- after
+	expectFilesystemEvent(t, ch, before)
+	expectFilesystemEvent(t, ch, after)
+	assert.Equal(t, len(scoped.notify), 0, "expected no events after cancellation")
+	assert.Equal(t, len(fw.Clients()), 1)
+
+	// The client isn't told about the close it has already been told about
+	assert.NilError(t, fw.Close(), "fw.Close")
+	<-fw.done
+	assert.Equal(t, len(scoped.closed), 0)
+}
+
+func TestAddClientWithContextAfterClose(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scoped := &closeRecordingClient{
+		allEventsClient: allEventsClient{notify: make(chan Event, 16)},
+		closed:          make(chan error, 2),
+	}
+	assert.NilError(t, fw.AddClientWithContext(ctx, scoped), "AddClientWithContext")
+	assert.NilError(t, fw.Close(), "fw.Close")
+	assert.NilError(t, <-scoped.closed)
+	<-fw.done
+
+	// Cancelling afterwards doesn't close the client again
+	cancel()
+	expectNoFilesystemEvent(t, scoped.notify)
+	assert.Equal(t, len(scoped.closed), 0)
+}
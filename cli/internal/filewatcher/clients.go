@@ -0,0 +1,163 @@
+package filewatcher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FilteringClient can optionally be implemented by a FileWatchClient that only acts on
+// some of the events delivered to it, to describe which ones for Clients.
+type FilteringClient interface {
+	FilterDescription() string
+}
+
+// ClientInfo describes a registered client, for diagnosing which client is slow or
+// missing events
+type ClientInfo struct {
+	// ID identifies the client. IDs are assigned in the order that clients are added,
+	// and are never reused.
+	ID uint64
+	// Name is the name the client was added with via AddNamedClient. Otherwise it is
+	// generated from Type and ID.
+	Name string
+	// Type is the client's Go type
+	Type string
+	// Filter describes which events the client acts on, if it is a FilteringClient
+	Filter string
+	// Throttle is the interval of a client added via AddClientThrottled, the window for
+	// which events are held for a GroupClient, and 0 otherwise
+	Throttle time.Duration
+	// QueueDepth is the number of events awaiting the client: those held for a throttled
+	// client or a GroupClient, one that a dispatch worker is delivering or about to, and
+	// those waiting to be read from a channel returned by AddClientChannel
+	QueueDepth int
+	// Delivered counts the events delivered to the client
+	Delivered uint64
+	// Dropped counts the events that the client didn't receive: those it panicked on,
+	// every event in a group that a GroupClient panicked on, and those that a channel
+	// returned by AddClientChannel had no room for
+	Dropped uint64
+}
+
+// queueingClient is implemented by clients that hold events of their own for a reader,
+// so that Clients counts them in QueueDepth
+type queueingClient interface {
+	queueDepth() int
+}
+
+// Clients returns a description of every registered client, in the order they were added
+func (fw *FileWatcher) Clients() []ClientInfo {
+	fw.clientsMu.RLock()
+	defer fw.clientsMu.RUnlock()
+	infos := make([]ClientInfo, 0, len(fw.clients))
+	for _, entry := range fw.clients {
+		info := ClientInfo{
+			ID:         entry.id,
+			Name:       entry.name,
+			Type:       fmt.Sprintf("%T", entry.client),
+			QueueDepth: int(atomic.LoadInt64(&entry.queued) + atomic.LoadInt64(&entry.handedOff)),
+			Delivered:  atomic.LoadUint64(&entry.delivered),
+			Dropped:    atomic.LoadUint64(&entry.dropped),
+		}
+		if queueing, ok := entry.client.(queueingClient); ok {
+			info.QueueDepth += queueing.queueDepth()
+		}
+		if filtering, ok := entry.client.(FilteringClient); ok {
+			info.Filter = filtering.FilterDescription()
+		}
+		if entry.throttle != nil {
+			info.Throttle = entry.throttle.interval
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// named is the ClientOption used by AddNamedClient
+func named(name string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.name = name
+	}
+}
+
+// AddNamedClient registers a client, as AddClient does, under a name that identifies it
+// in Clients, in the errors we log about it, and to a ClientMetricsSink. Clients added
+// without a name are given one made up of their type and ID, which is stable for as long
+// as the client is registered, but not from one run to the next.
+func (fw *FileWatcher) AddNamedClient(name string, client FileWatchClient, opts ...ClientOption) error {
+	return fw.AddClient(client, append(opts, named(name))...)
+}
+
+// clientName is the name of a client added without one
+func clientName(client FileWatchClient, id uint64) string {
+	return fmt.Sprintf("%T#%v", client, id)
+}
+
+// ErrClientRemoved is passed to OnFileWatchClosed for a client that SetClients removed
+var ErrClientRemoved = errors.New("filewatching client was removed by SetClients")
+
+// SetClients replaces the registered clients with the given ones in a single step, so
+// that there is no moment when events reach neither the old set nor the new one. Clients
+// already registered, compared with ==, are kept as they are, and keep receiving every
+// event. Clients that aren't in the new set are removed, and told so via
+// OnFileWatchClosed with ErrClientRemoved. Newly registered clients are configured by
+// opts, for instance WithExistingFiles to learn about the current state of the watched
+// roots. It returns an error, and changes nothing, under the same conditions as AddClient.
+func (fw *FileWatcher) SetClients(clients []FileWatchClient, opts ...ClientOption) error {
+	var removed, evicted []*clientEntry
+	// Runs after clientsMu is released, since evicting takes it again
+	defer func() {
+		for _, entry := range removed {
+			_ = callClient(entry.client, func(client FileWatchClient) {
+				client.OnFileWatchClosed(ErrClientRemoved)
+			})
+		}
+		for _, entry := range evicted {
+			fw.evictClient(entry)
+		}
+	}()
+	fw.clientsMu.Lock()
+	defer fw.clientsMu.Unlock()
+	if err := fw.checkRunning(); err != nil {
+		return err
+	}
+	kept := make([]*clientEntry, 0, len(clients))
+	for _, entry := range fw.clients {
+		if containsClient(clients, entry.client) {
+			kept = append(kept, entry)
+		} else {
+			removed = append(removed, entry)
+		}
+	}
+	fw.clients = kept
+	var added []*clientEntry
+	for _, client := range clients {
+		if containsEntryFor(fw.clients, client) {
+			continue
+		}
+		added = append(added, fw.addClientLocked(client, opts...))
+	}
+	evicted = fw.replayUntilFirstClient(added)
+	return nil
+}
+
+func containsClient(clients []FileWatchClient, client FileWatchClient) bool {
+	for _, other := range clients {
+		if other == client {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEntryFor(entries []*clientEntry, client FileWatchClient) bool {
+	for _, entry := range entries {
+		if entry.client == client {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package filewatcher
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+// clientPanicsSink is a ClientMetricsSink that counts the panics of each client
+type clientPanicsSink struct {
+	nopMetricsSink
+	mu     sync.Mutex
+	panics map[string]int
+}
+
+func (s *clientPanicsSink) IncClientPanics(client string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panics[client]++
+}
+
+func TestClients(t *testing.T) {
+	var out syncBuffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &out})
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	sink := &clientPanicsSink{panics: make(map[string]int)}
+	fw := New(logger, repoRoot, backend, WithMetricsSink(sink))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	jar, err := NewCookieJar(repoRoot.UntypedJoin(".cookies"), time.Second)
+	assert.NilError(t, err, "NewCookieJar")
+	assert.NilError(t, fw.AddClient(jar), "AddClient")
+	panicky := &panickingClient{
+		errs:   make(chan error, _maxClientPanics),
+		closed: make(chan error, 1),
+	}
+	err = fw.AddNamedClient("panicky", panicky)
+	assert.NilError(t, err, "AddNamedClient")
+	assert.NilError(t, fw.AddClientThrottled(&allEventsClient{notify: make(chan Event, 16)}, time.Hour), "AddClientThrottled")
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: ch}), "AddClient")
+
+	for _, name := range []string{"a", "b"} {
+		ev := Event{
+			Path:      repoRoot.UntypedJoin(name),
+			EventType: FileModified,
+		}
+		backend.events <- ev
+		expectFilesystemEvent(t, ch, ev)
+	}
+
+	expected := []ClientInfo{
+		{
+			ID:        1,
+			Name:      "*filewatcher.CookieJar#1",
+			Type:      "*filewatcher.CookieJar",
+			Filter:    fmt.Sprintf("cookie files added in %v", repoRoot.UntypedJoin(".cookies")),
+			Delivered: 2,
+		},
+		{
+			// Short of being evicted
+			ID:      2,
+			Name:    "panicky",
+			Type:    "*filewatcher.panickingClient",
+			Dropped: 2,
+		},
+		{
+			ID:       3,
+			Name:     "*filewatcher.allEventsClient#3",
+			Type:     "*filewatcher.allEventsClient",
+			Throttle: time.Hour,
+			// The first event is delivered right away, and the second held for the interval
+			QueueDepth: 1,
+			Delivered:  1,
+		},
+		{
+			ID:        4,
+			Name:      "*filewatcher.allEventsClient#4",
+			Type:      "*filewatcher.allEventsClient",
+			Delivered: 2,
+		},
+	}
+	// Throttled clients are delivered to after the others
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && fw.Clients()[2].QueueDepth < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.DeepEqual(t, fw.Clients(), expected)
+
+	// The name identifies the client in what we log and measure about it
+	assert.Assert(t, strings.Contains(out.String(), "op=dispatch client=panicky panics=1"), out.String())
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.DeepEqual(t, sink.panics, map[string]int{"panicky": 2})
+}
+
+func TestSetClients(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	const count = 200
+	surviving := &allEventsClient{notify: make(chan Event, count)}
+	removed := &closeRecordingClient{
+		allEventsClient: allEventsClient{notify: make(chan Event, count)},
+		closed:          make(chan error, 1),
+	}
+	added := &allEventsClient{notify: make(chan Event, count)}
+	assert.NilError(t, fw.AddClient(surviving), "AddClient")
+	assert.NilError(t, fw.AddClient(removed), "AddClient")
+
+	go func() {
+		for i := 0; i < count; i++ {
+			backend.events <- Event{
+				Path:      repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)),
+				EventType: FileModified,
+			}
+		}
+	}()
+	// Swap clients while events are still arriving
+	all := nextEvents(t, surviving.notify, count/4)
+	assert.NilError(t, fw.SetClients([]FileWatchClient{surviving, added}), "SetClients")
+	all = append(all, nextEvents(t, surviving.notify, count-count/4)...)
+
+	select {
+	case err := <-removed.closed:
+		assert.ErrorIs(t, err, ErrClientRemoved)
+	case <-time.After(time.Second):
+		t.Fatal("removed client was not closed")
+	}
+	// Each event reached either the removed client or the new one, in order. The removed
+	// client has had all of its events by the time it is closed.
+	var split []Event
+	for len(removed.notify) > 0 {
+		split = append(split, <-removed.notify)
+	}
+	split = append(split, nextEvents(t, added.notify, count-len(split))...)
+	assert.DeepEqual(t, split, all)
+
+	infos := fw.Clients()
+	assert.Equal(t, len(infos), 2)
+	assert.Equal(t, infos[0].ID, uint64(1))
+	assert.Equal(t, infos[1].ID, uint64(3))
+}
@@ -0,0 +1,177 @@
+package filewatcher
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"golang.org/x/sys/unix"
+)
+
+// inotifyCloseWrites watches directories for files within them being closed after they
+// were written to. fsnotify only watches for IN_MODIFY, which fires on every write, so
+// this is an inotify instance of its own that watches for IN_CLOSE_WRITE.
+type inotifyCloseWrites struct {
+	fd   int
+	file *os.File
+	// done is closed when we are closed, so that read doesn't block on delivering to a
+	// backend that has stopped listening
+	done   chan struct{}
+	events chan turbopath.AbsoluteSystemPath
+	errors chan error
+
+	mu     sync.Mutex
+	closed bool
+	wds    map[int]turbopath.AbsoluteSystemPath
+	dirs   map[turbopath.AbsoluteSystemPath]int
+}
+
+func newCloseWriteNotifier() (closeWriteNotifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize inotify")
+	}
+	c := &inotifyCloseWrites{
+		fd: fd,
+		// A non-blocking descriptor is read through the runtime's poller, so closing the
+		// file wakes up a read in progress
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		done:   make(chan struct{}),
+		events: make(chan turbopath.AbsoluteSystemPath),
+		errors: make(chan error),
+		wds:    make(map[int]turbopath.AbsoluteSystemPath),
+		dirs:   make(map[turbopath.AbsoluteSystemPath]int),
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *inotifyCloseWrites) add(dir turbopath.AbsoluteSystemPath) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrFilewatchingClosed
+	}
+	wd, err := unix.InotifyAddWatch(c.fd, dir.ToString(), unix.IN_CLOSE_WRITE|unix.IN_ONLYDIR)
+	if err != nil {
+		return err
+	}
+	// A directory that has moved keeps its watch, and is given the same descriptor again
+	if old, ok := c.wds[wd]; ok && old != dir {
+		delete(c.dirs, old)
+	}
+	c.wds[wd] = dir
+	c.dirs[dir] = wd
+	return nil
+}
+
+func (c *inotifyCloseWrites) remove(dir turbopath.AbsoluteSystemPath) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wd, ok := c.dirs[dir]
+	if !ok || c.closed {
+		return
+	}
+	delete(c.dirs, dir)
+	delete(c.wds, wd)
+	// The kernel has already removed it if the directory was deleted
+	_, _ = unix.InotifyRmWatch(c.fd, uint32(wd))
+}
+
+func (c *inotifyCloseWrites) watching(dir turbopath.AbsoluteSystemPath) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.dirs[dir]
+	return ok
+}
+
+func (c *inotifyCloseWrites) Events() <-chan turbopath.AbsoluteSystemPath {
+	return c.events
+}
+
+func (c *inotifyCloseWrites) Errors() <-chan error {
+	return c.errors
+}
+
+// read delivers the paths of files that were closed after being written to, until we
+// are closed
+func (c *inotifyCloseWrites) read() {
+	defer close(c.events)
+	defer close(c.errors)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := c.file.Read(buf)
+		if err != nil {
+			// We have been closed
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(raw.Len)
+			if offset > n {
+				break
+			}
+			if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+				if !c.sendError(errors.Wrap(ErrEventsDropped, "inotify dropped close-write events")) {
+					return
+				}
+				continue
+			}
+			path, ok := c.pathOf(int(raw.Wd), raw.Mask, bytes.TrimRight(buf[nameStart:offset], "\x00"))
+			if !ok {
+				continue
+			}
+			select {
+			case c.events <- path:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// pathOf returns the path of the file that an event is about, if it is a file closed
+// after being written to
+func (c *inotifyCloseWrites) pathOf(wd int, mask uint32, name []byte) (turbopath.AbsoluteSystemPath, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dir, ok := c.wds[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		// The directory was deleted, or we removed its watch
+		if ok {
+			delete(c.wds, wd)
+			if c.dirs[dir] == wd {
+				delete(c.dirs, dir)
+			}
+		}
+		return "", false
+	}
+	if !ok || mask&unix.IN_CLOSE_WRITE == 0 || mask&unix.IN_ISDIR != 0 || len(name) == 0 {
+		return "", false
+	}
+	return dir.UntypedJoin(string(name)), true
+}
+
+func (c *inotifyCloseWrites) sendError(err error) bool {
+	select {
+	case c.errors <- err:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *inotifyCloseWrites) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	return c.file.Close()
+}
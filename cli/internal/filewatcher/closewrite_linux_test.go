@@ -0,0 +1,114 @@
+package filewatcher
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithCloseWrite(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("dir").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	large := repoRoot.UntypedJoin("dir", "large")
+	err = large.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger, WithCloseWrite(true))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 1024)
+	err = fw.AddClient(&allEventsClient{notify: ch})
+	assert.NilError(t, err, "AddClient")
+
+	f, err := os.OpenFile(large.ToString(), os.O_WRONLY|os.O_TRUNC, 0644)
+	assert.NilError(t, err, "OpenFile")
+	chunk := bytes.Repeat([]byte("x"), 64*1024)
+	for i := 0; i < 256; i++ {
+		_, err := f.Write(chunk)
+		assert.NilError(t, err, "Write")
+	}
+	// Nothing is reported while the file is still being written
+	expectNoFilesystemEvent(t, ch)
+
+	err = f.Close()
+	assert.NilError(t, err, "Close")
+	expectFilesystemEvent(t, ch, Event{
+		EventType: FileModified,
+		Path:      large,
+	})
+	expectNoFilesystemEvent(t, ch)
+}
+
+// failingCloseWrites fails to watch close-writes in a single directory, as if we had run
+// out of watches
+type failingCloseWrites struct {
+	closeWriteNotifier
+	dir turbopath.AbsoluteSystemPath
+}
+
+func (c *failingCloseWrites) add(dir turbopath.AbsoluteSystemPath) error {
+	if dir == c.dir {
+		return syscall.ENOSPC
+	}
+	return c.closeWriteNotifier.add(dir)
+}
+
+func TestWithCloseWriteFallsBackToWrites(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	full := repoRoot.UntypedJoin("full")
+	err := full.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger, WithCloseWrite(true))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	f := watcher.(*fsNotifyBackend)
+	f.closeWrites = &failingCloseWrites{closeWriteNotifier: f.closeWrites, dir: full}
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 1024)
+	errs := make(chan error, 1)
+	err = fw.AddClient(&allEventsClient{notify: ch, errs: errs})
+	assert.NilError(t, err, "AddClient")
+	// The directory is still watched, rather than polled
+	watches := fw.currentWatches()
+	sort.Slice(watches, func(i, j int) bool { return watches[i] < watches[j] })
+	assert.DeepEqual(t, watches, []turbopath.AbsoluteSystemPath{repoRoot, full})
+
+	filePath := full.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		EventType: FileAdded,
+		Path:      filePath,
+	})
+	f2, err := os.OpenFile(filePath.ToString(), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NilError(t, err, "OpenFile")
+	defer func() { _ = f2.Close() }()
+	_, err = f2.Write([]byte("world"))
+	assert.NilError(t, err, "Write")
+	// The write is reported as it happens, since the directory has no close-write watch
+	expectFilesystemEvent(t, ch, Event{
+		EventType: FileModified,
+		Path:      filePath,
+	})
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package filewatcher
+
+import "github.com/pkg/errors"
+
+// newCloseWriteNotifier is only available on Linux, since IN_CLOSE_WRITE is specific to
+// inotify
+func newCloseWriteNotifier() (closeWriteNotifier, error) {
+	return nil, errors.New("close-write events are only available on Linux")
+}
@@ -0,0 +1,110 @@
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// eventCoalescer accumulates events and reduces them to their net effect per path.
+// For instance, a file that is created and then deleted produces no events at all,
+// and a file that is deleted and then recreated produces a single FileModified.
+// Events are reported in the order that their paths were first seen. How far events
+// are merged depends on the CoalescePolicy, see WithCoalescePolicy.
+type eventCoalescer struct {
+	policy CoalescePolicy
+	// raw holds the events as they arrived, for CoalesceNone
+	raw    []Event
+	order  []turbopath.AbsoluteSystemPath
+	events map[turbopath.AbsoluteSystemPath]Event
+	// canceled holds paths whose events canceled out, so that a duplicate deletion
+	// reported afterwards, for instance by a watch on the file itself, is dropped too.
+	canceled map[turbopath.AbsoluteSystemPath]struct{}
+}
+
+func newEventCoalescer(policy CoalescePolicy) *eventCoalescer {
+	return &eventCoalescer{
+		policy:   policy,
+		events:   make(map[turbopath.AbsoluteSystemPath]Event),
+		canceled: make(map[turbopath.AbsoluteSystemPath]struct{}),
+	}
+}
+
+func (c *eventCoalescer) add(ev Event) {
+	if c.policy == CoalesceNone {
+		c.raw = append(c.raw, ev)
+		return
+	}
+	prev, ok := c.events[ev.Path]
+	if !ok {
+		if _, wasCanceled := c.canceled[ev.Path]; wasCanceled && ev.EventType == FileDeleted {
+			return
+		}
+		delete(c.canceled, ev.Path)
+		c.order = append(c.order, ev.Path)
+		c.events[ev.Path] = ev
+		return
+	}
+	merged, keep := coalesceEvents(prev, ev)
+	if keep {
+		c.events[ev.Path] = merged
+	} else {
+		delete(c.events, ev.Path)
+		c.canceled[ev.Path] = struct{}{}
+	}
+}
+
+func (c *eventCoalescer) len() int {
+	if c.policy == CoalesceNone {
+		return len(c.raw)
+	}
+	return len(c.events)
+}
+
+// flush returns the net events accumulated so far and resets the coalescer
+func (c *eventCoalescer) flush() []Event {
+	if c.policy == CoalesceNone {
+		evs := c.raw
+		c.raw = nil
+		return evs
+	}
+	evs := make([]Event, 0, len(c.events))
+	for _, path := range c.order {
+		// A path can appear more than once in c.order if it canceled out and then came back
+		if ev, ok := c.events[path]; ok {
+			evs = append(evs, ev)
+			delete(c.events, path)
+		}
+	}
+	c.order = nil
+	c.canceled = make(map[turbopath.AbsoluteSystemPath]struct{})
+	switch c.policy {
+	case CoalesceByDirectory:
+		return coalesceByDirectory(evs)
+	case CoalesceTree:
+		return coalesceTree(evs)
+	}
+	return evs
+}
+
+// coalesceEvents merges two consecutive events for the same path. It returns false if
+// the two events cancel each other out.
+func coalesceEvents(prev Event, next Event) (Event, bool) {
+	switch prev.EventType {
+	case FileAdded:
+		switch next.EventType {
+		case FileDeleted:
+			// The file didn't exist before and doesn't exist now
+			return Event{}, false
+		case FileModified, FileOther, FileAdded:
+			return prev, true
+		}
+	case FileDeleted:
+		if next.EventType == FileAdded {
+			// The file existed before and exists now, but its contents may have changed
+			next.EventType = FileModified
+			return next, true
+		}
+	case FileModified:
+		if next.EventType == FileAdded || next.EventType == FileOther {
+			return prev, true
+		}
+	}
+	return next, true
+}
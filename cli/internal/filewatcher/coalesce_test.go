@@ -0,0 +1,137 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestEventCoalescer(t *testing.T) {
+	root := turbopath.AbsoluteSystemPath("/repo")
+	created := root.UntypedJoin("created")
+	transient := root.UntypedJoin("transient")
+	replaced := root.UntypedJoin("replaced")
+	deleted := root.UntypedJoin("deleted")
+
+	c := newEventCoalescer(CoalesceByPath)
+	c.add(Event{Path: created, EventType: FileAdded})
+	c.add(Event{Path: transient, EventType: FileAdded})
+	c.add(Event{Path: replaced, EventType: FileDeleted})
+	c.add(Event{Path: created, EventType: FileModified})
+	c.add(Event{Path: transient, EventType: FileModified})
+	c.add(Event{Path: deleted, EventType: FileModified})
+	c.add(Event{Path: transient, EventType: FileDeleted})
+	// A second report of the same deletion doesn't bring the transient file back
+	c.add(Event{Path: transient, EventType: FileDeleted})
+	c.add(Event{Path: replaced, EventType: FileAdded})
+	c.add(Event{Path: deleted, EventType: FileDeleted})
+
+	assert.DeepEqual(t, c.flush(), []Event{
+		{Path: created, EventType: FileAdded},
+		{Path: replaced, EventType: FileModified},
+		{Path: deleted, EventType: FileDeleted},
+	})
+	assert.Equal(t, c.len(), 0)
+	assert.Equal(t, len(c.flush()), 0)
+}
+
+func TestCoalescePolicies(t *testing.T) {
+	root := turbopath.AbsoluteSystemPath("/repo")
+	pkg := root.UntypedJoin("pkg")
+	src := root.UntypedJoin("src")
+	readme := root.UntypedJoin("docs", "README.md")
+	// A package is generated, two sources are edited, the docs are touched, and a
+	// temporary file comes and goes
+	burst := []Event{
+		{Path: pkg, EventType: FileAdded},
+		{Path: pkg.UntypedJoin("a.ts"), EventType: FileAdded},
+		{Path: src.UntypedJoin("x.ts"), EventType: FileModified},
+		{Path: pkg.UntypedJoin("b.ts"), EventType: FileAdded},
+		{Path: root.UntypedJoin("tmp"), EventType: FileAdded},
+		{Path: src.UntypedJoin("y.ts"), EventType: FileModified},
+		{Path: pkg.UntypedJoin("a.ts"), EventType: FileModified},
+		{Path: readme, EventType: FileModified},
+		{Path: root.UntypedJoin("tmp"), EventType: FileDeleted},
+	}
+	testCases := []struct {
+		policy   CoalescePolicy
+		expected []Event
+	}{
+		{
+			policy:   CoalesceNone,
+			expected: burst,
+		},
+		{
+			policy: CoalesceByPath,
+			expected: []Event{
+				{Path: pkg, EventType: FileAdded},
+				{Path: pkg.UntypedJoin("a.ts"), EventType: FileAdded},
+				{Path: src.UntypedJoin("x.ts"), EventType: FileModified},
+				{Path: pkg.UntypedJoin("b.ts"), EventType: FileAdded},
+				{Path: src.UntypedJoin("y.ts"), EventType: FileModified},
+				{Path: readme, EventType: FileModified},
+			},
+		},
+		{
+			policy: CoalesceByDirectory,
+			expected: []Event{
+				{Path: pkg, EventType: FileAdded},
+				{Path: src, EventType: FileModified},
+				{Path: readme, EventType: FileModified},
+			},
+		},
+		{
+			policy: CoalesceTree,
+			expected: []Event{
+				{Path: pkg, EventType: FileAdded},
+				{Path: src.UntypedJoin("x.ts"), EventType: FileModified},
+				{Path: src.UntypedJoin("y.ts"), EventType: FileModified},
+				{Path: readme, EventType: FileModified},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		c := newEventCoalescer(tc.policy)
+		for _, ev := range burst {
+			c.add(ev)
+		}
+		assert.DeepEqual(t, c.flush(), tc.expected)
+		assert.Equal(t, c.len(), 0)
+	}
+}
+
+func TestWithCoalescePolicy(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithCoalescePolicy(CoalesceTree))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	fw.Pause()
+	dir := Event{Path: repoRoot.UntypedJoin("dist"), EventType: FileAdded}
+	backend.events <- dir
+	backend.events <- Event{Path: repoRoot.UntypedJoin("dist", "index.js"), EventType: FileAdded}
+	for pausedEvents(fw) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	fw.Resume()
+	assertEvents(t, nextEvents(t, ch, 1), dir)
+	expectNoFilesystemEvent(t, ch)
+}
+
+func pausedEvents(fw *FileWatcher) int {
+	fw.pauseMu.Lock()
+	defer fw.pauseMu.Unlock()
+	return fw.pending.len()
+}
@@ -0,0 +1,87 @@
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// CoalescePolicy says how events that are held back are merged before they are
+// delivered. Events are held while paused, during a bulk operation, during
+// WithTemporaryDebounce, and for clients added with AddClientThrottled.
+type CoalescePolicy int
+
+const (
+	// CoalesceNone delivers held events as they arrived, without merging any of them
+	CoalesceNone CoalescePolicy = iota
+	// CoalesceByPath reduces held events to their net effect per path. A file that is
+	// created and deleted again produces no events at all, and one that is deleted and
+	// created again produces a FileModified. This is the default.
+	CoalesceByPath
+	// CoalesceByDirectory merges as CoalesceByPath does, then reports two or more
+	// changes to entries of the same directory as a single FileModified for the
+	// directory
+	CoalesceByDirectory
+	// CoalesceTree merges as CoalesceByPath does, then drops the changes beneath a
+	// directory that was itself added, deleted, or renamed, since the event for the
+	// directory describes what happened to everything in it
+	CoalesceTree
+)
+
+// WithCoalescePolicy sets how held events are merged before they are delivered. See
+// CoalescePolicy for what each policy does. The default is CoalesceByPath.
+func WithCoalescePolicy(policy CoalescePolicy) Option {
+	return func(fw *FileWatcher) {
+		fw.coalescePolicy = policy
+	}
+}
+
+// coalesceByDirectory replaces the events for entries of a directory that had more than
+// one of them with a single FileModified for the directory
+func coalesceByDirectory(evs []Event) []Event {
+	counts := make(map[turbopath.AbsoluteSystemPath]int)
+	for _, ev := range evs {
+		counts[ev.Path.Dir()]++
+	}
+	// The directory may have an event of its own, which is merged with ours
+	merged := newEventCoalescer(CoalesceByPath)
+	for _, ev := range evs {
+		if dir := ev.Path.Dir(); counts[dir] > 1 {
+			merged.add(Event{Path: dir, EventType: FileModified})
+		} else {
+			merged.add(ev)
+		}
+	}
+	return merged.flush()
+}
+
+// coalesceTree drops the events beneath paths that were added, deleted, or renamed.
+// Since the events have already been merged per path, a path with events beneath it
+// must be a directory.
+func coalesceTree(evs []Event) []Event {
+	trees := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for _, ev := range evs {
+		switch ev.EventType {
+		case FileAdded, FileDeleted:
+			trees[ev.Path] = struct{}{}
+		case FileRenamed:
+			trees[ev.Path] = struct{}{}
+			if ev.OldPath != "" {
+				trees[ev.OldPath] = struct{}{}
+			}
+		}
+	}
+	kept := make([]Event, 0, len(evs))
+	for _, ev := range evs {
+		if !hasAncestorIn(ev.Path, trees) {
+			kept = append(kept, ev)
+		}
+	}
+	return kept
+}
+
+// hasAncestorIn returns true if one of the parent directories of path is in dirs
+func hasAncestorIn(path turbopath.AbsoluteSystemPath, dirs map[turbopath.AbsoluteSystemPath]struct{}) bool {
+	for dir := path.Dir(); dir != path; path, dir = dir, dir.Dir() {
+		if _, ok := dirs[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package filewatcher
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"github.com/vercel/turbo/cli/internal/xxhash"
+)
+
+// _contentHashMaxSize is the largest file that WithContentHashOnModify reads. Files are
+// hashed on the watch loop, so this is kept small enough not to hold up other events.
+// Larger files get no ContentHash, and their modifications are always delivered.
+const _contentHashMaxSize = 256 << 10
+
+// _contentHashMaxPaths is how many files WithContentHashOnModify remembers the contents
+// of. Files beyond that aren't remembered, so their modifications are always delivered.
+const _contentHashMaxPaths = 128 * 1024
+
+// WithContentHashOnModify reads files as their FileModified events are processed, and
+// sets the ContentHash of those events to a hash of their contents. A FileModified event
+// is suppressed entirely if the file's contents hash the same as they did at its previous
+// FileModified event, for instance because it was touched without being edited. Only
+// modifications are read, so the first modification of a file since Start, or since it
+// was added or renamed, is always delivered. Files larger than 256KiB are neither hashed
+// nor suppressed, and neither are files beyond the first 128Ki that we remember, until
+// others are deleted.
+func WithContentHashOnModify(enabled bool) Option {
+	return func(fw *FileWatcher) {
+		if enabled {
+			fw.contentHashes = newContentHashes(_contentHashMaxPaths)
+		} else {
+			fw.contentHashes = nil
+		}
+	}
+}
+
+// contentHashes holds the last hash of each file's contents, and an index of the paths
+// beneath each directory, so that everything beneath a deleted directory can be forgotten
+// along with it. It is only used by the watch loop.
+type contentHashes struct {
+	maxPaths int
+	hashes   map[turbopath.AbsoluteSystemPath]string
+	// children holds the hashed files and the directories leading to them, by parent
+	children map[turbopath.AbsoluteSystemPath]map[turbopath.AbsoluteSystemPath]struct{}
+}
+
+func newContentHashes(maxPaths int) *contentHashes {
+	return &contentHashes{
+		maxPaths: maxPaths,
+		hashes:   make(map[turbopath.AbsoluteSystemPath]string),
+		children: make(map[turbopath.AbsoluteSystemPath]map[turbopath.AbsoluteSystemPath]struct{}),
+	}
+}
+
+// set records the hash of the file at path, unless we already remember as many as we may
+func (c *contentHashes) set(path turbopath.AbsoluteSystemPath, hash string) {
+	if _, ok := c.hashes[path]; !ok {
+		if len(c.hashes) >= c.maxPaths {
+			return
+		}
+		for p := path; ; p = p.Dir() {
+			parent := p.Dir()
+			if parent == p {
+				break
+			}
+			siblings, ok := c.children[parent]
+			if !ok {
+				siblings = make(map[turbopath.AbsoluteSystemPath]struct{})
+				c.children[parent] = siblings
+			}
+			if _, ok := siblings[p]; ok {
+				break
+			}
+			siblings[p] = struct{}{}
+		}
+	}
+	c.hashes[path] = hash
+}
+
+// forget drops the hash of path, and of anything beneath it
+func (c *contentHashes) forget(path turbopath.AbsoluteSystemPath) {
+	dirs := []turbopath.AbsoluteSystemPath{path}
+	for len(dirs) > 0 {
+		next := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		delete(c.hashes, next)
+		for child := range c.children[next] {
+			dirs = append(dirs, child)
+		}
+		delete(c.children, next)
+	}
+	// Prune the directories that led only to what we forgot
+	for p := path; ; p = p.Dir() {
+		parent := p.Dir()
+		if parent == p {
+			break
+		}
+		delete(c.children[parent], p)
+		if len(c.children[parent]) > 0 {
+			break
+		}
+		delete(c.children, parent)
+	}
+}
+
+// hashContent sets the ContentHash of a FileModified event, and returns true if the
+// contents are unchanged since its previous FileModified event. Other events only make us
+// forget what we remembered about their paths, so that only modifications are read. It
+// must only be called from the watch loop.
+func (fw *FileWatcher) hashContent(ev Event) (Event, bool) {
+	if fw.contentHashes == nil {
+		return ev, false
+	}
+	switch ev.EventType {
+	case FileAdded, FileRenamed:
+		if ev.OldPath != "" {
+			fw.contentHashes.forget(ev.OldPath)
+		}
+		fw.contentHashes.forget(ev.Path)
+	case FileModified:
+		hash, ok := contentHash(ev.Path)
+		if !ok {
+			fw.contentHashes.forget(ev.Path)
+			return ev, false
+		}
+		previous, seen := fw.contentHashes.hashes[ev.Path]
+		fw.contentHashes.set(ev.Path, hash)
+		if seen && previous == hash {
+			return ev, true
+		}
+		ev.ContentHash = hash
+	case FileDeleted:
+		fw.contentHashes.forget(ev.Path)
+	}
+	return ev, false
+}
+
+// contentHash returns the xxhash of the regular file at path, as hex. ok is false if it
+// isn't a regular file, is larger than _contentHashMaxSize, or can't be read.
+func contentHash(path turbopath.AbsoluteSystemPath) (string, bool) {
+	info, err := path.Lstat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() > _contentHashMaxSize {
+		return "", false
+	}
+	f, err := path.Open()
+	if err != nil {
+		return "", false
+	}
+	defer func() { _ = f.Close() }()
+	digest := xxhash.New()
+	// The file may have grown since we looked at its size
+	n, err := io.Copy(digest, io.LimitReader(f, _contentHashMaxSize+1))
+	if err != nil || n > _contentHashMaxSize {
+		return "", false
+	}
+	return fmt.Sprintf("%016x", digest.Sum64()), true
+}
@@ -0,0 +1,95 @@
+package filewatcher
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestContentHashOnModify(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	sourcePath := repoRoot.UntypedJoin("index.ts")
+	otherPath := repoRoot.UntypedJoin("other.ts")
+	assert.NilError(t, sourcePath.WriteFile([]byte("export {}\n"), 0644), "WriteFile")
+	assert.NilError(t, otherPath.WriteFile([]byte("export {}\n"), 0644), "WriteFile")
+
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithContentHashOnModify(true))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	touch := func() {
+		t.Helper()
+		now := time.Now()
+		assert.NilError(t, os.Chtimes(sourcePath.ToString(), now, now), "Chtimes")
+	}
+	added := Event{Path: sourcePath, EventType: FileAdded}
+	backend.events <- added
+	assert.Assert(t, (<-ch).Equal(added))
+
+	// The first modification after an add is always delivered
+	modified := Event{Path: sourcePath, EventType: FileModified}
+	touch()
+	backend.events <- modified
+	ev := <-ch
+	assert.Assert(t, ev.Equal(modified), "expected the first modification, got %v", ev)
+
+	touch()
+	backend.events <- modified
+	assert.NilError(t, sourcePath.WriteFile([]byte("export const a = 1\n"), 0644), "WriteFile")
+	backend.events <- modified
+	ev = <-ch
+	assert.Assert(t, ev.Equal(modified), "expected the edit, got %v", ev)
+	expected, ok := contentHash(sourcePath)
+	assert.Assert(t, ok, "contentHash")
+	assert.Equal(t, ev.ContentHash, expected)
+
+	touch()
+	backend.events <- modified
+	// A file we haven't seen before is always delivered
+	otherModified := Event{Path: otherPath, EventType: FileModified}
+	backend.events <- otherModified
+	ev = <-ch
+	assert.Assert(t, ev.Equal(otherModified), "expected the touch to be suppressed, got %v", ev)
+}
+
+func TestContentHashesForgetDeletedDirectories(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	dir := repoRoot.UntypedJoin("dir")
+	nested := dir.UntypedJoin("nested", "file")
+	sibling := dir.UntypedJoin("sibling")
+	other := repoRoot.UntypedJoin("other")
+	c := newContentHashes(3)
+	c.set(nested, "a")
+	c.set(sibling, "b")
+	c.set(other, "c")
+	// Beyond the limit, nothing more is remembered
+	c.set(repoRoot.UntypedJoin("extra"), "d")
+	assert.Equal(t, len(c.hashes), 3)
+
+	c.forget(dir)
+	assert.DeepEqual(t, c.hashes, map[turbopath.AbsoluteSystemPath]string{other: "c"})
+	// Nothing is left indexed beneath the deleted directory, and there is room again
+	for parent := range c.children {
+		assert.Assert(t, !parent.HasPrefix(dir), "%v is still indexed", parent)
+	}
+	c.set(repoRoot.UntypedJoin("extra"), "d")
+	assert.Equal(t, len(c.hashes), 2)
+
+	c.forget(other)
+	c.forget(repoRoot.UntypedJoin("extra"))
+	assert.Equal(t, len(c.hashes), 0)
+	assert.Equal(t, len(c.children), 0)
+}
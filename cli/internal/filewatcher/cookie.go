@@ -66,7 +66,7 @@ func (cj *CookieJar) removeAllCookiesWithError(err error) {
 
 // OnFileWatchClosed handles the case where filewatching had to close for some reason
 // We send an error to all of our cookies and stop accepting new ones.
-func (cj *CookieJar) OnFileWatchClosed() {
+func (cj *CookieJar) OnFileWatchClosed(err error) {
 	cj.mu.Lock()
 	defer cj.mu.Unlock()
 	cj.closed = true
@@ -86,6 +86,11 @@ func (cj *CookieJar) OnFileWatchError(err error) {
 	cj.cookies = make(map[turbopath.AbsoluteSystemPath]chan error)
 }
 
+// FilterDescription reports that we only act on cookie files being added
+func (cj *CookieJar) FilterDescription() string {
+	return fmt.Sprintf("cookie files added in %v", cj.dir)
+}
+
 // OnFileWatchEvent determines if the specified event is relevant
 // for cookie watching and notifies the appropriate cookie if so.
 func (cj *CookieJar) OnFileWatchEvent(ev Event) {
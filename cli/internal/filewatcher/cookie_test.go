@@ -23,7 +23,7 @@ func TestWaitForCookie(t *testing.T) {
 	fw := New(logger, repoRoot, watcher)
 	err = fw.Start()
 	assert.NilError(t, err, "Start")
-	fw.AddClient(jar)
+	assert.NilError(t, fw.AddClient(jar), "AddClient")
 	err = fw.AddRoot(cookieDir)
 	assert.NilError(t, err, "Add")
 
@@ -44,7 +44,7 @@ func TestWaitForCookieAfterClose(t *testing.T) {
 	fw := New(logger, repoRoot, watcher)
 	err = fw.Start()
 	assert.NilError(t, err, "Start")
-	fw.AddClient(jar)
+	assert.NilError(t, fw.AddClient(jar), "AddClient")
 	err = fw.AddRoot(cookieDir)
 	assert.NilError(t, err, "Add")
 
@@ -67,7 +67,7 @@ func TestWaitForCookieTimeout(t *testing.T) {
 	fw := New(logger, repoRoot, watcher)
 	err = fw.Start()
 	assert.NilError(t, err, "Start")
-	fw.AddClient(jar)
+	assert.NilError(t, fw.AddClient(jar), "AddClient")
 
 	// NOTE: don't call fw.Add here so that no file event gets delivered
 
@@ -88,7 +88,7 @@ func TestWaitForCookieWithError(t *testing.T) {
 	fw := New(logger, repoRoot, watcher)
 	err = fw.Start()
 	assert.NilError(t, err, "Start")
-	fw.AddClient(jar)
+	assert.NilError(t, fw.AddClient(jar), "AddClient")
 
 	// NOTE: don't call fw.Add here so that no file event gets delivered
 	myErr := errors.New("an error")
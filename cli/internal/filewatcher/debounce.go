@@ -0,0 +1,132 @@
+package filewatcher
+
+import (
+	"sync"
+	"time"
+)
+
+// WithTemporaryDebounce holds back events while fn runs, for instance while turbo runs
+// an install or code generation that it knows will be noisy. Events are held until none
+// have arrived for d, then reduced to their net effect per path, as they are while
+// paused, and delivered. Calls may be nested or overlap, and the longest d of those in
+// progress is in effect. Once fn returns or panics, anything still held is delivered
+// once it has been quiet for the longest d of the calls still in progress, or straight
+// away if there are none. fn's error is returned as is.
+func (fw *FileWatcher) WithTemporaryDebounce(d time.Duration, fn func() error) error {
+	fw.debounce.raise(d)
+	fw.logger.Debug("debouncing events", _logOp, "hold", "interval", d)
+	fw.debounceChanged()
+	defer func() {
+		restored := fw.debounce.restore(d)
+		fw.logger.Debug("restored debounce", _logOp, "hold", "interval", restored)
+		fw.debounceChanged()
+	}()
+	return fn()
+}
+
+// debouncer holds back events until none have arrived for its interval. With an
+// interval of 0, which is the default, events aren't held.
+type debouncer struct {
+	mu sync.Mutex
+	// interval is the longest of raised, or 0 if it is empty
+	interval time.Duration
+	// raised counts the calls to raise in progress with each interval
+	raised map[time.Duration]int
+	// last is when the most recent event was held
+	last    time.Time
+	pending *eventCoalescer
+}
+
+func newDebouncer(policy CoalescePolicy) *debouncer {
+	return &debouncer{
+		raised:  make(map[time.Duration]int),
+		pending: newEventCoalescer(policy),
+	}
+}
+
+// raise holds events for at least interval, until a matching call to restore
+func (d *debouncer) raise(interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.raised[interval]++
+	if interval > d.interval {
+		d.interval = interval
+	}
+}
+
+// restore undoes a call to raise with the same interval, and returns the interval now in
+// effect, which is the longest of those still raised
+func (d *debouncer) restore(interval time.Duration) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.raised[interval]--; d.raised[interval] <= 0 {
+		delete(d.raised, interval)
+	}
+	d.interval = 0
+	for raised := range d.raised {
+		if raised > d.interval {
+			d.interval = raised
+		}
+	}
+	return d.interval
+}
+
+// hold returns true if the event has been held
+func (d *debouncer) hold(ev Event, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.interval == 0 && d.pending.len() == 0 {
+		return false
+	}
+	// Once we are holding events, later ones are held too, so that they stay in order
+	d.pending.add(ev)
+	d.last = now
+	return true
+}
+
+// deadline returns when the held events are due, if there are any
+func (d *debouncer) deadline() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending.len() == 0 {
+		return time.Time{}, false
+	}
+	return d.last.Add(d.interval), true
+}
+
+// release returns the net effect of the held events if they are due, or if force is set
+func (d *debouncer) release(now time.Time, force bool) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending.len() == 0 || (!force && now.Before(d.last.Add(d.interval))) {
+		return nil
+	}
+	return d.pending.flush()
+}
+
+// debounceChanged wakes the watch loop to reconsider when held events are due
+func (fw *FileWatcher) debounceChanged() {
+	select {
+	case fw.debounceUpdated <- struct{}{}:
+	default:
+		// a wakeup is already pending
+	}
+}
+
+// holdIfDebouncing returns true if the event has been held by WithTemporaryDebounce
+func (fw *FileWatcher) holdIfDebouncing(ev Event) bool {
+	return fw.debounce.hold(ev, time.Now())
+}
+
+// releaseDebounced delivers the net effect of the held events once they are due
+func (fw *FileWatcher) releaseDebounced(now time.Time, force bool) {
+	evs := fw.debounce.release(now, force)
+	if len(evs) > 0 {
+		fw.logger.Debug("debounced events settled, delivering net changes", _logOp, "dispatch", "events", len(evs))
+	}
+	for _, ev := range evs {
+		if !fw.holdIfPaused(ev) && !fw.holdForOrdering(ev) {
+			fw.dispatch(ev)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithTemporaryDebounce(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	lockfile := Event{Path: repoRoot.UntypedJoin("package-lock.json"), EventType: FileModified}
+	other := Event{Path: repoRoot.UntypedJoin("node_modules", "foo"), EventType: FileAdded}
+
+	// Outside of it, every modification is delivered
+	backend.events <- lockfile
+	backend.events <- lockfile
+	assertEvents(t, nextEvents(t, ch, 2), lockfile, lockfile)
+
+	errInstall := errors.New("install failed")
+	err = fw.WithTemporaryDebounce(time.Hour, func() error {
+		backend.events <- lockfile
+		backend.events <- lockfile
+		backend.events <- lockfile
+		backend.events <- other
+		// Wait until the watch loop has seen everything we sent
+		for heldEvents(fw) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		expectNoFilesystemEvent(t, ch)
+		return errInstall
+	})
+	assert.ErrorIs(t, err, errInstall)
+	// Restoring the debounce delivers what was held, reduced to one event per path
+	assertEvents(t, nextEvents(t, ch, 2), lockfile, other)
+	expectNoFilesystemEvent(t, ch)
+
+	func() {
+		defer func() {
+			assert.Equal(t, recover(), "codegen panicked")
+		}()
+		_ = fw.WithTemporaryDebounce(time.Hour, func() error {
+			panic("codegen panicked")
+		})
+	}()
+	backend.events <- lockfile
+	backend.events <- lockfile
+	assertEvents(t, nextEvents(t, ch, 2), lockfile, lockfile)
+}
+
+func heldEvents(fw *FileWatcher) int {
+	fw.debounce.mu.Lock()
+	defer fw.debounce.mu.Unlock()
+	return fw.debounce.pending.len()
+}
+
+func TestOverlappingTemporaryDebounces(t *testing.T) {
+	d := newDebouncer(CoalesceByPath)
+	// The first call finishes while the second is still running
+	d.raise(time.Second)
+	d.raise(time.Hour)
+	assert.Equal(t, d.restore(time.Second), time.Hour)
+	assert.Equal(t, d.restore(time.Hour), time.Duration(0))
+
+	// The longer call finishes first
+	d.raise(time.Hour)
+	d.raise(time.Second)
+	assert.Equal(t, d.restore(time.Hour), time.Second)
+	assert.Equal(t, d.restore(time.Second), time.Duration(0))
+
+	// Two calls with the same interval
+	d.raise(time.Minute)
+	d.raise(time.Minute)
+	assert.Equal(t, d.restore(time.Minute), time.Minute)
+	assert.Equal(t, d.restore(time.Minute), time.Duration(0))
+}
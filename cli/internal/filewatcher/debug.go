@@ -0,0 +1,73 @@
+package filewatcher
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DebugDump writes a human-readable report of the state of filewatching to w, for
+// inclusion in diagnostics when filewatching misbehaves.
+func (fw *FileWatcher) DebugDump(w io.Writer) {
+	fmt.Fprintf(w, "backend: %v\n", backendName(fw.backend))
+	fmt.Fprintf(w, "os: %v/%v\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "repo root: %v\n", fw.repoRoot)
+	if fw.linkedRoot != "" {
+		fmt.Fprintf(w, "reported as: %v\n", fw.linkedRoot)
+	}
+	if atomic.LoadInt32(&fw.started) == 1 {
+		fmt.Fprintf(w, "uptime: %v\n", time.Since(fw.startedAt).Round(time.Second))
+	} else {
+		fmt.Fprintf(w, "uptime: not started\n")
+	}
+	fmt.Fprintf(w, "events dispatched: %v\n", atomic.LoadUint64(&fw.seq))
+	fmt.Fprintf(w, "errors reported: %v\n", atomic.LoadUint64(&fw.errorCount))
+
+	fw.rootsMu.Lock()
+	roots := make([]watchRoot, len(fw.roots))
+	copy(roots, fw.roots)
+	fw.rootsMu.Unlock()
+	fmt.Fprintf(w, "roots:\n")
+	for _, root := range roots {
+		fmt.Fprintf(w, "  %v\n", root.path)
+		for _, pattern := range root.excludePatterns {
+			fmt.Fprintf(w, "    excluding %v\n", pattern)
+		}
+	}
+
+	fw.ignoreMu.RLock()
+	ignoreGlobs := fw.ignoreGlobs
+	fw.ignoreMu.RUnlock()
+	fmt.Fprintf(w, "ignore patterns:\n")
+	for _, glob := range ignoreGlobs {
+		fmt.Fprintf(w, "  %v\n", glob)
+	}
+
+	if _, ok := fw.backend.(watchTrackingBackend); !ok {
+		fmt.Fprintf(w, "watched directories: not tracked by this backend\n")
+		return
+	}
+	watches := fw.currentWatches()
+	sort.Slice(watches, func(i, j int) bool {
+		return watches[i] < watches[j]
+	})
+	if limit, ok := watchLimit(); ok {
+		fmt.Fprintf(w, "watched directories: %v of a limit of %v\n", len(watches), limit)
+	} else {
+		fmt.Fprintf(w, "watched directories: %v\n", len(watches))
+	}
+	for _, dir := range watches {
+		fmt.Fprintf(w, "  %v\n", dir)
+	}
+}
+
+// backendName describes a backend, by name if it has one and by type otherwise
+func backendName(backend Backend) string {
+	if named, ok := backend.(namedBackend); ok {
+		return named.name()
+	}
+	return fmt.Sprintf("%T", backend)
+}
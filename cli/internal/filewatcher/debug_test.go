@@ -0,0 +1,41 @@
+package filewatcher
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestDebugDump(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	child := repoRoot.UntypedJoin("parent", "child")
+	err := child.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	var before bytes.Buffer
+	fw.DebugDump(&before)
+	assert.Assert(t, strings.Contains(before.String(), "uptime: not started"), before.String())
+
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	fw.SetIgnorePatterns([]string{"dist/**"})
+
+	var out bytes.Buffer
+	fw.DebugDump(&out)
+	dump := out.String()
+	assert.Assert(t, strings.Contains(dump, "backend: "+backendName(watcher)+"\n"), dump)
+	assert.Assert(t, strings.Contains(dump, "  "+repoRoot.ToString()+"\n"), dump)
+	assert.Assert(t, strings.Contains(dump, "  dist/**\n"), dump)
+	if _, ok := watcher.(watchTrackingBackend); ok {
+		assert.Assert(t, strings.Contains(dump, "  "+child.ToString()+"\n"), dump)
+	}
+}
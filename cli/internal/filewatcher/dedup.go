@@ -0,0 +1,71 @@
+package filewatcher
+
+import (
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _duplicateAddWindow is how long after reporting that a path was added we drop further
+// reports of the same thing
+var _duplicateAddWindow = 500 * time.Millisecond
+
+// addedFilter drops duplicate FileAdded events. When a directory is created, we walk it
+// to watch what is already inside, and synthesize events for what we find. The kernel
+// may also report some of the same paths, for instance the directory itself, or a file
+// created inside it after the watch was installed but before the walk reached it.
+//
+// Only FileAdded is deduplicated, since any other event can legitimately repeat. A path
+// that is deleted or renamed away can be added again straight away. It is only used by
+// the watch loop.
+type addedFilter struct {
+	window    time.Duration
+	added     map[turbopath.AbsoluteSystemPath]time.Time
+	lastPrune time.Time
+}
+
+func newAddedFilter(window time.Duration) *addedFilter {
+	return &addedFilter{
+		window: window,
+		added:  make(map[turbopath.AbsoluteSystemPath]time.Time),
+	}
+}
+
+// duplicate returns true if the event repeats a recent FileAdded
+func (a *addedFilter) duplicate(ev Event, now time.Time) bool {
+	if now.Sub(a.lastPrune) >= a.window {
+		for path, at := range a.added {
+			if now.Sub(at) >= a.window {
+				delete(a.added, path)
+			}
+		}
+		a.lastPrune = now
+	}
+	switch ev.EventType {
+	case FileAdded:
+		if at, ok := a.added[ev.Path]; ok && now.Sub(at) < a.window {
+			return true
+		}
+		a.added[ev.Path] = now
+	case FileDeleted:
+		// Deleting a directory's contents produces an event for each of them
+		delete(a.added, ev.Path)
+	case FileRenamed:
+		// Renaming a directory only produces an event for the directory itself. It may be
+		// reported under either path, or both.
+		a.forgetTree(ev.Path)
+		if ev.OldPath != "" {
+			a.forgetTree(ev.OldPath)
+		}
+	}
+	return false
+}
+
+// forgetTree drops what we know about the given path and everything beneath it
+func (a *addedFilter) forgetTree(removed turbopath.AbsoluteSystemPath) {
+	for path := range a.added {
+		if path.HasPrefix(removed) {
+			delete(a.added, path)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestCreatedTreeIsAddedOnce(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 1000)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	for i := 0; i < 5; i++ {
+		dir := repoRoot.UntypedJoin("parent", fmt.Sprintf("child-%v", i), "deep", "deeper")
+		err := dir.MkdirAll(0775)
+		assert.NilError(t, err, "MkdirAll")
+		err = dir.UntypedJoin("foo").WriteFile([]byte("hello"), 0644)
+		assert.NilError(t, err, "WriteFile")
+	}
+	last := repoRoot.UntypedJoin("parent", "child-4", "deep", "deeper", "foo")
+	added := make(map[string]int)
+	timeout := time.After(2 * time.Second)
+	for added[last.ToString()] == 0 {
+		select {
+		case ev := <-ch:
+			if ev.EventType == FileAdded {
+				added[ev.Path.ToString()]++
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", last)
+		}
+	}
+	// Give any duplicates time to show up
+	time.Sleep(200 * time.Millisecond)
+	for len(ch) > 0 {
+		if ev := <-ch; ev.EventType == FileAdded {
+			added[ev.Path.ToString()]++
+		}
+	}
+	assert.Equal(t, len(added), 21)
+	for path, count := range added {
+		assert.Equal(t, count, 1, "%v was added %v times", path, count)
+	}
+}
+
+func TestAddedFilter(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	dir := repoRoot.UntypedJoin("dir")
+	file := dir.UntypedJoin("file")
+	filter := newAddedFilter(time.Second)
+	now := time.Now()
+
+	assert.Assert(t, !filter.duplicate(Event{Path: file, EventType: FileAdded}, now))
+	assert.Assert(t, filter.duplicate(Event{Path: file, EventType: FileAdded}, now))
+	// Repeated modifications are never duplicates
+	assert.Assert(t, !filter.duplicate(Event{Path: file, EventType: FileModified}, now))
+	assert.Assert(t, !filter.duplicate(Event{Path: file, EventType: FileModified}, now))
+	// Once the window has passed, an add is reported again
+	assert.Assert(t, !filter.duplicate(Event{Path: file, EventType: FileAdded}, now.Add(time.Second)))
+
+	// A deleted path can be added again
+	assert.Assert(t, !filter.duplicate(Event{Path: file, EventType: FileDeleted}, now.Add(time.Second)))
+	assert.Assert(t, !filter.duplicate(Event{Path: file, EventType: FileAdded}, now.Add(time.Second)))
+
+	// As can the contents of a directory that was renamed away
+	assert.Assert(t, !filter.duplicate(Event{Path: dir, EventType: FileAdded}, now.Add(time.Second)))
+	assert.Assert(t, !filter.duplicate(Event{Path: dir, EventType: FileRenamed}, now.Add(time.Second)))
+	assert.Assert(t, !filter.duplicate(Event{Path: dir, EventType: FileAdded}, now.Add(time.Second)))
+	assert.Assert(t, !filter.duplicate(Event{Path: file, EventType: FileAdded}, now.Add(time.Second)))
+}
@@ -0,0 +1,33 @@
+package filewatcher
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithMaxDepth stops watching directories more than n levels below the repo root, for
+// repos with pathologically deep generated trees. Changes to the entries of a directory
+// n levels down are still reported, but nothing beneath its subdirectories is, neither
+// from the initial walk nor when directories are created later. Backends that watch the
+// whole tree natively, such as FSEvents, are unaffected. Zero, the default, means no limit.
+func WithMaxDepth(n int) Option {
+	return func(fw *FileWatcher) {
+		fw.maxDepth = n
+	}
+}
+
+// withinMaxDepth returns true if dir is no more than maxDepth levels below the repo root,
+// or is not within the repo root at all
+func (fw *FileWatcher) withinMaxDepth(dir turbopath.AbsoluteSystemPath) bool {
+	if fw.maxDepth <= 0 {
+		return true
+	}
+	rel, err := (Event{Path: dir}).RelativeTo(fw.repoRoot)
+	if err != nil || rel == "." {
+		return true
+	}
+	depth := strings.Count(filepath.ToSlash(rel.ToString()), "/") + 1
+	return depth <= fw.maxDepth
+}
@@ -0,0 +1,90 @@
+//go:build !darwin
+// +build !darwin
+
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestMaxDepth(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("a", "b", "c", "d").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithMaxDepth(2))
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	c := &allEventsClient{
+		notify: ch,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	// Nothing deeper than a/b is watched after the initial walk
+	expectWatchedDirs(t, fw,
+		repoRoot,
+		repoRoot.UntypedJoin("a"),
+		repoRoot.UntypedJoin("a", "b"),
+	)
+
+	// Entries of the deepest watched directory are still reported, but nothing below them
+	err = repoRoot.UntypedJoin("a", "b", "c", "deep-file").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+	shallowFile := repoRoot.UntypedJoin("a", "b", "file")
+	err = shallowFile.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      shallowFile,
+		EventType: FileAdded,
+	})
+
+	// Directories created later stop at the same depth
+	newDir := repoRoot.UntypedJoin("x", "y", "z")
+	err = newDir.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      repoRoot.UntypedJoin("x"),
+		EventType: FileAdded,
+	})
+	expectFilesystemEvent(t, ch, Event{
+		Path:      repoRoot.UntypedJoin("x", "y"),
+		EventType: FileAdded,
+	})
+	expectFilesystemEvent(t, ch, Event{
+		Path:      newDir,
+		EventType: FileAdded,
+	})
+	expectWatchedDirs(t, fw,
+		repoRoot,
+		repoRoot.UntypedJoin("a"),
+		repoRoot.UntypedJoin("a", "b"),
+		repoRoot.UntypedJoin("x"),
+		repoRoot.UntypedJoin("x", "y"),
+	)
+}
+
+// expectWatchedDirs asserts that exactly the given directories are being watched
+func expectWatchedDirs(t *testing.T, fw *FileWatcher, dirs ...turbopath.AbsoluteSystemPath) {
+	t.Helper()
+	watched := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for _, dir := range fw.currentWatches() {
+		watched[dir] = struct{}{}
+	}
+	expected := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for _, dir := range dirs {
+		expected[dir] = struct{}{}
+	}
+	assert.DeepEqual(t, watched, expected)
+}
@@ -0,0 +1,71 @@
+package filewatcher
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestDirectoryMoveRehomesWatches(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("moves are reported as a deletion and a creation on Windows")
+	}
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("parent", "child", "deep").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = repoRoot.UntypedJoin("sibling").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	oldPath := repoRoot.UntypedJoin("parent", "child")
+	newPath := repoRoot.UntypedJoin("sibling", "child")
+	err = oldPath.Rename(newPath)
+	assert.NilError(t, err, "Rename")
+
+	// The move is reported once, not as a new tree
+	ev := <-ch
+	assert.Assert(t, ev.Equal(Event{Path: newPath, OldPath: oldPath, EventType: FileRenamed}), "unexpected event %v", ev)
+	expectNoFilesystemEvent(t, ch)
+
+	// Changes beneath the moved directory are reported at its new location
+	filePath := newPath.UntypedJoin("deep", "foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	ev = <-ch
+	assert.Assert(t, ev.Equal(Event{Path: filePath, EventType: FileAdded}), "unexpected event %v", ev)
+
+	// We no longer consider the old location watched
+	deadline := time.Now().Add(time.Second)
+	for {
+		watches := fw.currentWatches()
+		stale := false
+		for _, dir := range watches {
+			if dir.HasPrefix(oldPath) {
+				stale = true
+			}
+		}
+		if !stale {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still watching the old location: %v", watches)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,77 @@
+package filewatcher
+
+import "time"
+
+// _dirtyWindow is how long after the first change we wait before sending a TreeDirty
+// event, gathering any further changes into it
+const _dirtyWindow = 100 * time.Millisecond
+
+// WithDirtySignalOnly replaces granular events with a single TreeDirty event for the
+// repository root, for consumers that only need to know that something changed, for
+// instance to invalidate everything they have cached. The first change starts a window,
+// and once it has passed, one TreeDirty event is delivered for every change within it,
+// so there is at most one per window however busy the tree is. Errors are delivered as
+// usual, as are events replayed by WithExistingFiles.
+func WithDirtySignalOnly(enabled bool) Option {
+	return func(fw *FileWatcher) {
+		if enabled {
+			fw.dirty = &dirtySignal{window: _dirtyWindow}
+		} else {
+			fw.dirty = nil
+		}
+	}
+}
+
+// dirtySignal tracks whether anything has changed since the last TreeDirty event. It is
+// only used by the watch loop.
+type dirtySignal struct {
+	window time.Duration
+	// pending is set once something has changed, at since
+	pending bool
+	since   time.Time
+}
+
+// mark records a change
+func (d *dirtySignal) mark(now time.Time) {
+	if !d.pending {
+		d.pending = true
+		d.since = now
+	}
+}
+
+// deadline returns when the next TreeDirty event is due, if one is
+func (d *dirtySignal) deadline() (time.Time, bool) {
+	if !d.pending {
+		return time.Time{}, false
+	}
+	return d.since.Add(d.window), true
+}
+
+// release returns true if a TreeDirty event is due, or if one is pending and force is set
+func (d *dirtySignal) release(now time.Time, force bool) bool {
+	if !d.pending || (!force && now.Before(d.since.Add(d.window))) {
+		return false
+	}
+	d.pending = false
+	return true
+}
+
+// holdAsDirty returns true if the event has been folded into the next TreeDirty event
+func (fw *FileWatcher) holdAsDirty(ev Event) bool {
+	if fw.dirty == nil || ev.EventType == TreeDirty {
+		return false
+	}
+	fw.dirty.mark(time.Now())
+	return true
+}
+
+// releaseDirty delivers a TreeDirty event once one is due
+func (fw *FileWatcher) releaseDirty(now time.Time, force bool) {
+	if fw.dirty == nil || !fw.dirty.release(now, force) {
+		return
+	}
+	fw.dispatch(Event{
+		Path:      fw.repoRoot,
+		EventType: TreeDirty,
+	})
+}
@@ -0,0 +1,36 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithDirtySignalOnly(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), repoRoot, backend, WithDirtySignalOnly(true))
+	fw.dirty.window = 50 * time.Millisecond
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	err = fw.AddClient(&allEventsClient{notify: ch})
+	assert.NilError(t, err, "AddClient")
+
+	dirty := Event{Path: repoRoot, EventType: TreeDirty}
+	for round := 0; round < 2; round++ {
+		start := time.Now()
+		backend.events <- Event{Path: repoRoot.UntypedJoin("a"), EventType: FileAdded}
+		backend.events <- Event{Path: repoRoot.UntypedJoin("b"), EventType: FileModified}
+		backend.events <- Event{Path: repoRoot.UntypedJoin("dir", "c"), EventType: FileDeleted}
+		backend.events <- Event{Path: repoRoot.UntypedJoin("a"), EventType: FileModified}
+		assertEvents(t, nextEvents(t, ch, 1), dirty)
+		assert.Assert(t, time.Since(start) >= fw.dirty.window, "signalled before the window passed")
+		// Every change in the window was covered by that one
+		expectNoFilesystemEvent(t, ch)
+	}
+}
@@ -0,0 +1,83 @@
+package filewatcher
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// kindError is a failure of one of the kinds described by our sentinel errors, for
+// instance ErrWatchLimitExceeded, caused by an error from somewhere else. errors.Is
+// matches both the kind and anything in the chain of the cause.
+type kindError struct {
+	kind  error
+	msg   string
+	cause error
+}
+
+// withKind annotates cause as a failure of the given kind
+func withKind(kind error, cause error, format string, args ...interface{}) error {
+	return &kindError{
+		kind:  kind,
+		msg:   fmt.Sprintf(format, args...),
+		cause: cause,
+	}
+}
+
+func (e *kindError) Error() string {
+	return fmt.Sprintf("%v: %v: %v", e.kind, e.msg, e.cause)
+}
+
+// Is implements errors.Is
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap implements errors.Unwrap
+func (e *kindError) Unwrap() error {
+	return e.cause
+}
+
+// Cause implements github.com/pkg/errors.Cause
+func (e *kindError) Cause() error {
+	return e.cause
+}
+
+// watchError describes a failure to install a watch on the given path, classifying it
+// as ErrWatchLimitExceeded if the OS has run out of watches.
+func watchError(err error, path turbopath.AbsoluteSystemPath) error {
+	if isWatchLimit(err) {
+		return withKind(ErrWatchLimitExceeded, err, "failed adding watch to %v", path)
+	}
+	return errors.Wrapf(err, "failed adding watch to %v", path)
+}
+
+// isWatchLimit returns true if err is how the OS reports that we can't have any more
+// watches. inotify reports ENOSPC when max_user_watches is reached, and EMFILE when
+// max_user_instances is. kqueue needs a file descriptor per watch, so it reports EMFILE
+// or ENFILE when we or the system run out of those.
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// isRootRemoval returns true if the event means that one of the roots we are watching
+// has been deleted or moved away
+func (fw *FileWatcher) isRootRemoval(ev Event) bool {
+	if ev.EventType != FileDeleted && ev.EventType != FileRenamed {
+		return false
+	}
+	removed := ev.Path
+	if ev.EventType == FileRenamed && ev.OldPath != "" {
+		removed = ev.OldPath
+	}
+	fw.rootsMu.Lock()
+	defer fw.rootsMu.Unlock()
+	for _, root := range fw.roots {
+		if root.path == removed {
+			return true
+		}
+	}
+	return false
+}
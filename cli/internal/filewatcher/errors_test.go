@@ -0,0 +1,134 @@
+package filewatcher
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// closeRecordingClient records the reason passed to OnFileWatchClosed
+type closeRecordingClient struct {
+	allEventsClient
+	closed chan error
+}
+
+func (c *closeRecordingClient) OnFileWatchClosed(err error) {
+	c.closed <- err
+}
+
+// failingBackend is a fakeBackend whose AddRoot fails
+type failingBackend struct {
+	*fakeBackend
+	err error
+}
+
+func (f *failingBackend) AddRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	return f.err
+}
+
+func startErrorsTest(t *testing.T) (*FileWatcher, *fakeBackend, *closeRecordingClient) {
+	t.Helper()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), repoRoot, backend)
+	client := &closeRecordingClient{
+		allEventsClient: allEventsClient{
+			notify: make(chan Event, 16),
+			errs:   make(chan error, 16),
+		},
+		closed: make(chan error, 1),
+	}
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	err = fw.AddClient(client)
+	assert.NilError(t, err, "AddClient")
+	return fw, backend, client
+}
+
+func expectError(t *testing.T, errs <-chan error, target error) {
+	t.Helper()
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, target)
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %v", target)
+	}
+}
+
+func TestErrWatchLimitExceeded(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	cause := watchError(syscall.ENOSPC, repoRoot.UntypedJoin("foo"))
+	assert.ErrorIs(t, cause, ErrWatchLimitExceeded)
+	// The underlying cause is still available
+	assert.ErrorIs(t, cause, syscall.ENOSPC)
+	assert.ErrorContains(t, cause, "foo")
+	assert.Assert(t, !errors.Is(watchError(syscall.EACCES, repoRoot), ErrWatchLimitExceeded))
+
+	backend := &failingBackend{
+		fakeBackend: newFakeBackend(),
+		err:         cause,
+	}
+	fw := New(hclog.Default(), repoRoot, backend)
+	err := fw.Start()
+	assert.ErrorIs(t, err, ErrWatchLimitExceeded)
+}
+
+func TestErrEventsDroppedFromBackend(t *testing.T) {
+	fw, backend, client := startErrorsTest(t)
+	defer func() { _ = fw.Close() }()
+
+	backend.errors <- errors.Wrap(ErrEventsDropped, "queue overflow")
+	expectError(t, client.errs, ErrEventsDropped)
+}
+
+func TestErrBackendClosed(t *testing.T) {
+	_, backend, client := startErrorsTest(t)
+
+	_ = backend.Close()
+	expectError(t, client.closed, ErrBackendClosed)
+}
+
+func TestErrRootDisappeared(t *testing.T) {
+	fw, backend, client := startErrorsTest(t)
+	defer func() { _ = fw.Close() }()
+
+	backend.events <- Event{
+		Path:      fw.repoRoot,
+		EventType: FileDeleted,
+	}
+	expectError(t, client.errs, ErrRootDisappeared)
+	// The event itself is still delivered
+	expectFilesystemEvent(t, client.notify, Event{
+		Path:      fw.repoRoot,
+		EventType: FileDeleted,
+	})
+
+	// Removing something within a root is not an error
+	backend.events <- Event{
+		Path:      fw.repoRoot.UntypedJoin("foo"),
+		EventType: FileDeleted,
+	}
+	expectFilesystemEvent(t, client.notify, Event{
+		Path:      fw.repoRoot.UntypedJoin("foo"),
+		EventType: FileDeleted,
+	})
+	select {
+	case err := <-client.errs:
+		t.Errorf("unexpected error %v", err)
+	default:
+	}
+}
+
+func TestErrNotStarted(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(hclog.Default(), repoRoot, newFakeBackend())
+	_, err := fw.WaitForChange(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrNotStarted)
+}
@@ -0,0 +1,23 @@
+package filewatcher
+
+// _errorStreamBuffer is how many errors Errors() holds for a reader that has fallen behind
+const _errorStreamBuffer = 64
+
+// Errors returns a channel that receives every error delivered to clients via
+// OnFileWatchError, whether or not any clients are registered. It is meant for embedders
+// that forward errors to their own telemetry. The channel is closed once filewatching
+// has stopped. If the reader falls behind by more than a few dozen errors, further errors
+// are dropped from the channel, but are still delivered to clients.
+func (fw *FileWatcher) Errors() <-chan error {
+	return fw.errStream
+}
+
+// publishError offers an error to the Errors() channel without blocking.
+// It must only be called from the watch loop.
+func (fw *FileWatcher) publishError(err error) {
+	select {
+	case fw.errStream <- err:
+	default:
+		fw.logger.Debug("Errors() reader is behind, dropping error", "error", err)
+	}
+}
@@ -0,0 +1,38 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestErrorsStream(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+
+	// No clients are needed to receive errors
+	backendErr := errors.New("something went wrong")
+	backend.errors <- backendErr
+	select {
+	case err := <-fw.Errors():
+		assert.Equal(t, err, backendErr)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+
+	assert.NilError(t, fw.Close(), "Close")
+	select {
+	case _, ok := <-fw.Errors():
+		assert.Assert(t, !ok, "expected Errors() to be closed")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for Errors() to close")
+	}
+}
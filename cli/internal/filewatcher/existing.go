@@ -0,0 +1,106 @@
+package filewatcher
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ClientOption configures how AddClient registers a client
+type ClientOption func(cfg *clientConfig)
+
+type clientConfig struct {
+	existingFiles bool
+	throttle      time.Duration
+	name          string
+}
+
+// WithExistingFiles delivers a FileAdded event to the new client for everything that
+// currently exists in the watched roots, before or interleaved with live events. This is
+// useful for a client added long after Start, which would otherwise never learn about
+// files created in the meantime. Other clients are not notified again.
+func WithExistingFiles() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.existingFiles = true
+	}
+}
+
+// replay is a batch of events destined for a single client
+type replay struct {
+	entry  *clientEntry
+	events []Event
+	err    error
+}
+
+// replayExisting scans the watched roots and hands the result to the watch loop for
+// delivery to the given client.
+func (fw *FileWatcher) replayExisting(entry *clientEntry) {
+	events, err := fw.scanRoots()
+	select {
+	case fw.replays <- replay{entry: entry, events: events, err: err}:
+	case <-fw.done:
+	}
+}
+
+// scanRoots returns a FileAdded event for everything in the roots we are watching,
+// honoring each root's exclusions.
+func (fw *FileWatcher) scanRoots() ([]Event, error) {
+	fw.rootsMu.Lock()
+	roots := make([]watchRoot, len(fw.roots))
+	copy(roots, fw.roots)
+	fw.rootsMu.Unlock()
+	var events []Event
+	for _, root := range roots {
+		state, err := scanRoot(root, osScanFS{})
+		for _, path := range sortedPaths(state) {
+			events = append(events, Event{
+				Path:      path,
+				EventType: FileAdded,
+			})
+		}
+		if err != nil {
+			return events, errors.Wrapf(err, "failed to scan %v", root.path)
+		}
+	}
+	return events, nil
+}
+
+// deliverReplay delivers a replay to its client, if the client is still registered.
+// It must only be called from the watch loop.
+func (fw *FileWatcher) deliverReplay(r replay) {
+	fw.clientsMu.RLock()
+	registered := false
+	for _, entry := range fw.clients {
+		if entry == r.entry {
+			registered = true
+			break
+		}
+	}
+	var evicted []*clientEntry
+	if registered {
+		target := []*clientEntry{r.entry}
+		for _, ev := range r.events {
+			ev = normalizeEvent(ev)
+			// Git's state files are reported when they change, not as existing files
+			if _, inGitDir := fw.isGitStateEvent(ev); inGitDir || !fw.accept(ev) {
+				continue
+			}
+			ev.Root = fw.rootOf(ev.Path)
+			ev = fw.fromRealEvent(ev)
+			ev.Package = fw.packageOf(ev.Path)
+			evicted = fw.deliverEventTo(target, ev)
+			if len(evicted) > 0 {
+				break
+			}
+		}
+		if r.err != nil && len(evicted) == 0 {
+			evicted = fw.deliverTo(target, func(client FileWatchClient) {
+				client.OnFileWatchError(r.err)
+			})
+		}
+	}
+	fw.clientsMu.RUnlock()
+	for _, entry := range evicted {
+		fw.evictClient(entry)
+	}
+}
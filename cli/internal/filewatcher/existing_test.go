@@ -0,0 +1,76 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestAddClientWithExistingFiles(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("node_modules", "dep").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	existingCh := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: existingCh,
+	}), "AddClient")
+
+	// Create some files after starting, and let the existing client hear about them
+	dirPath := repoRoot.UntypedJoin("parent")
+	filePath := dirPath.UntypedJoin("foo")
+	err = dirPath.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, existingCh, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+	drain(existingCh)
+
+	newCh := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: newCh,
+	}, WithExistingFiles()), "AddClient")
+	got := make(map[string]FileEvent)
+	timeout := time.After(time.Second)
+	for len(got) < 3 {
+		select {
+		case ev := <-newCh:
+			got[ev.Path.ToString()] = ev.EventType
+		case <-timeout:
+			t.Fatalf("timed out waiting for existing files, got %v", got)
+		}
+	}
+	assert.DeepEqual(t, got, map[string]FileEvent{
+		repoRoot.ToString(): FileAdded,
+		dirPath.ToString():  FileAdded,
+		filePath.ToString(): FileAdded,
+	})
+	// Excluded paths are not replayed, and existing clients don't hear about any of it
+	expectNoFilesystemEvent(t, newCh)
+	expectNoFilesystemEvent(t, existingCh)
+}
+
+// drain discards events until none have arrived for a little while
+func drain(ch <-chan Event) {
+	for {
+		select {
+		case <-ch:
+		case <-time.After(200 * time.Millisecond):
+			return
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package filewatcher
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"golang.org/x/sys/unix"
+)
+
+// _pidAttributionWindow is how long after a process writes to a file that events for the
+// file are attributed to it
+const _pidAttributionWindow = time.Second
+
+// _pidAttributionWait is how long we wait for fanotify to report the write behind a
+// FileModified event, when it hasn't by the time we see the event
+const _pidAttributionWait = 10 * time.Millisecond
+
+// _maxAttributions is how many recent writers we remember before forgetting stale ones
+const _maxAttributions = 4096
+
+// GetFanotifyBackend returns the backend that GetPlatformSpecificBackend would, with
+// events attributed to the process that caused them, for debugging or for telling turbo's
+// own writes from the user's. The PID of FileModified events, and of FileAdded events for
+// files that had already been written to, is found with fanotify, which needs
+// CAP_SYS_ADMIN. fanotify can only watch a whole mount at a time, so every write anywhere
+// on the mount that each root is on is reported to us, and costs us a file descriptor
+// and a lookup of its path before we can tell it is outside our roots. On a busy mount,
+// such as the one holding the system's temporary files, that adds up. The reports are
+// read, and events attributed, on a goroutine of their own, so they don't hold up the
+// watch loop. Attribution is best-effort: fanotify and inotify don't report a write in
+// any particular order relative to each other, so if fanotify hasn't reported it shortly
+// after we see its event, the event is delivered with a PID of 0. If fanotify isn't
+// available, or TURBO_FILEWATCH_BACKEND selects a backend other than the native one, the
+// backend is returned as is, and events have a PID of 0.
+func GetFanotifyBackend(logger hclog.Logger, opts ...BackendOption) (Backend, error) {
+	backend, err := GetPlatformSpecificBackend(logger, opts...)
+	if err != nil {
+		return nil, err
+	}
+	native, ok := backend.(*fsNotifyBackend)
+	if !ok {
+		logger.Info("fanotify only works with the native backend, events won't be attributed to processes", _logBackend, backendName(backend))
+		return backend, nil
+	}
+	attributor, err := newFanotifyAttributor()
+	if err != nil {
+		logger.Info("fanotify is unavailable, events won't be attributed to processes", "error", err)
+		return backend, nil
+	}
+	native.setAttributor(attributor)
+	return backend, nil
+}
+
+// fanotifyAttributor remembers which process most recently wrote to each file within
+// its roots, as reported by fanotify
+type fanotifyAttributor struct {
+	mu      sync.Mutex
+	fd      int
+	closed  bool
+	roots   []turbopath.AbsoluteSystemPath
+	writers map[turbopath.AbsoluteSystemPath]writer
+	buf     []byte
+}
+
+// writer is a process that wrote to a file, and when we heard about it
+type writer struct {
+	pid int
+	at  time.Time
+}
+
+func newFanotifyAttributor() (*fanotifyAttributor, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize fanotify")
+	}
+	return &fanotifyAttributor{
+		fd:      fd,
+		writers: make(map[turbopath.AbsoluteSystemPath]writer),
+		buf:     make([]byte, 4096),
+	}, nil
+}
+
+func (a *fanotifyAttributor) addRoot(root turbopath.AbsoluteSystemPath) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrFilewatchingClosed
+	}
+	// This marks the whole mount, not just root, see GetFanotifyBackend
+	if err := unix.FanotifyMark(a.fd, unix.FAN_MARK_ADD|unix.FAN_MARK_MOUNT, unix.FAN_MODIFY|unix.FAN_CLOSE_WRITE, unix.AT_FDCWD, root.ToString()); err != nil {
+		return errors.Wrapf(err, "failed to mark the mount containing %v", root)
+	}
+	a.roots = append(a.roots, root)
+	return nil
+}
+
+// attribute sets the PID of the event to that of the process that last wrote to its
+// path. fanotify may report a write after inotify does, so if we haven't heard of a
+// writer for a FileModified event, we wait up to _pidAttributionWait for one. It is
+// called from the backend's attribution goroutine, not its watch loop.
+func (a *fanotifyAttributor) attribute(ev Event) Event {
+	if ev.EventType != FileAdded && ev.EventType != FileModified {
+		return ev
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ev
+	}
+	start := time.Now()
+	now := start
+	for {
+		a.readWriters(now)
+		if w, ok := a.writers[ev.Path]; ok && now.Sub(w.at) <= _pidAttributionWindow {
+			ev.PID = w.pid
+			return ev
+		}
+		remaining := _pidAttributionWait - now.Sub(start)
+		if ev.EventType != FileModified || remaining <= 0 {
+			return ev
+		}
+		// Wait for fanotify to have something more to read. An error, such as EINTR,
+		// just means we look again.
+		fds := []unix.PollFd{{Fd: int32(a.fd), Events: unix.POLLIN}}
+		_, _ = unix.Poll(fds, int(remaining/time.Millisecond)+1)
+		now = time.Now()
+	}
+}
+
+// readWriters records the writes that fanotify has reported since we last asked, without
+// blocking. Must be called while a.mu is held.
+func (a *fanotifyAttributor) readWriters(now time.Time) {
+	const metadataSize = int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	for {
+		n, err := unix.Read(a.fd, a.buf)
+		if err != nil || n < metadataSize {
+			// EAGAIN once we have read everything
+			return
+		}
+		for offset := 0; offset+metadataSize <= n; {
+			metadata := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&a.buf[offset]))
+			if metadata.Event_len < uint32(metadataSize) {
+				break
+			}
+			offset += int(metadata.Event_len)
+			if metadata.Fd == unix.FAN_NOFD {
+				// The queue overflowed, and we'll have to do without
+				continue
+			}
+			target, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", metadata.Fd))
+			_ = unix.Close(int(metadata.Fd))
+			if err != nil || metadata.Vers != unix.FANOTIFY_METADATA_VERSION {
+				continue
+			}
+			a.recordWriter(normalizePath(fs.AbsoluteSystemPathFromUpstream(target)), int(metadata.Pid), now)
+		}
+	}
+}
+
+// recordWriter remembers that pid wrote to path, if it is within one of our roots. Must
+// be called while a.mu is held.
+func (a *fanotifyAttributor) recordWriter(path turbopath.AbsoluteSystemPath, pid int, now time.Time) {
+	within := false
+	for _, root := range a.roots {
+		if path == root || path.HasPrefix(root) {
+			within = true
+			break
+		}
+	}
+	if !within {
+		return
+	}
+	if len(a.writers) >= _maxAttributions {
+		for p, w := range a.writers {
+			if now.Sub(w.at) > _pidAttributionWindow {
+				delete(a.writers, p)
+			}
+		}
+	}
+	a.writers[path] = writer{pid: pid, at: now}
+}
+
+func (a *fanotifyAttributor) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	return unix.Close(a.fd)
+}
@@ -0,0 +1,56 @@
+package filewatcher
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestFanotifyBackendPID(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	path := repoRoot.UntypedJoin("foo")
+	err := path.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetFanotifyBackend(logger)
+	assert.NilError(t, err, "GetFanotifyBackend")
+	if watcher.(*fsNotifyBackend).attributor == nil {
+		t.Skip("fanotify needs CAP_SYS_ADMIN")
+	}
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Attribution is best-effort, so a write may occasionally go unattributed under load
+	for attempt := 0; ; attempt++ {
+		err = path.WriteFile([]byte("goodbye"), 0644)
+		assert.NilError(t, err, "WriteFile")
+		ev := nextEvents(t, ch, 1)[0]
+		assert.Assert(t, ev.Equal(Event{Path: path, EventType: FileModified}), "got %v", ev)
+		if ev.PID == 0 && attempt < 3 {
+			continue
+		}
+		assert.Equal(t, ev.PID, os.Getpid())
+		break
+	}
+}
+
+func TestFanotifyBackendSelectedByEnv(t *testing.T) {
+	logger := hclog.Default()
+	t.Setenv(_backendEnvVar, "polling")
+	backend, err := GetFanotifyBackend(logger)
+	assert.NilError(t, err, "GetFanotifyBackend")
+	defer func() { _ = backend.Close() }()
+	_, ok := backend.(*pollingBackend)
+	assert.Assert(t, ok, "expected the polling backend, got %T", backend)
+}
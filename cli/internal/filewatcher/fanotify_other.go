@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package filewatcher
+
+import "github.com/hashicorp/go-hclog"
+
+// GetFanotifyBackend attributes events to processes on Linux. fanotify is specific to
+// Linux, so elsewhere this is GetPlatformSpecificBackend, and events have a PID of 0.
+func GetFanotifyBackend(logger hclog.Logger, opts ...BackendOption) (Backend, error) {
+	logger.Info("fanotify is only available on Linux, events won't be attributed to processes")
+	return GetPlatformSpecificBackend(logger, opts...)
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package filewatcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIDOf returns the device and inode of the given file
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{
+		dev: uint64(stat.Dev),
+		ino: uint64(stat.Ino),
+	}, true
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package filewatcher
+
+import "os"
+
+// fileIDOf is not supported on Windows. The file index is only available via an open
+// handle, which we can't get for a path that has already been renamed away.
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -2,9 +2,13 @@
 package filewatcher
 
 import (
+	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/pkg/errors"
@@ -15,13 +19,43 @@ import (
 var _ignores = []string{".git", "node_modules"}
 
 // FileWatchClient defines the callbacks used by the file watching loop.
-// All methods are called from the same goroutine so they:
-// 1) do not need synchronization
+// A client's methods are never called concurrently with each other, so they:
+// 1) do not need synchronization among themselves
 // 2) should minimize the work they are doing when called, if possible
+// They are usually called from the watch loop's goroutine, but with WithDispatchWorkers
+// they are called from a pool of workers, concurrently with other clients' methods.
+//
+// Events are delivered in the order the backend reports them, which is usually, but not
+// always, parent directories before their contents for additions, and contents before
+// their parent directories for deletions. Use WithTreeOrdering to guarantee that.
 type FileWatchClient interface {
 	OnFileWatchEvent(ev Event)
 	OnFileWatchError(err error)
-	OnFileWatchClosed()
+	// OnFileWatchClosed is called once filewatching has stopped. err is nil if
+	// filewatching was shut down via Close, otherwise it describes why it stopped.
+	OnFileWatchClosed(err error)
+}
+
+// WatchObserver can optionally be implemented by a FileWatchClient to be notified
+// when the watcher installs or removes a watch on a directory. These are not
+// filesystem events: they describe what is being watched, not what changed.
+// Unlike the FileWatchClient methods, they may be called from backend goroutines.
+// When an observer is added, OnWatchAdded is called for every existing watch.
+type WatchObserver interface {
+	OnWatchAdded(dir turbopath.AbsoluteSystemPath)
+	OnWatchRemoved(dir turbopath.AbsoluteSystemPath)
+}
+
+// watchListener is notified by a Backend when it installs or removes a watch
+type watchListener interface {
+	onWatchAdded(dir turbopath.AbsoluteSystemPath)
+	onWatchRemoved(dir turbopath.AbsoluteSystemPath)
+}
+
+// watchTrackingBackend is implemented by backends that can report changes to the
+// set of watches they have installed.
+type watchTrackingBackend interface {
+	setWatchListener(l watchListener)
 }
 
 // FileEvent is an enum covering the kinds of things that can happen
@@ -35,23 +69,162 @@ const (
 	FileDeleted
 	// FileModified - this file has been changed in some way
 	FileModified
-	// FileRenamed - a file's name has changed
+	// FileRenamed - a file's name has changed. A file or directory renamed within the
+	// watched tree is reported as a single FileRenamed, with OldPath set, on every
+	// platform and backend, whether the OS reports the two names together or they are
+	// paired up by file identity. The exception is a move between directories on
+	// Windows, which has no file identities to pair by, and is reported as a FileDeleted
+	// and a FileAdded. A directory's move is reported once, not for everything beneath
+	// it. Something moved out of the tree is reported as FileDeleted, and something moved
+	// into it as FileAdded. A FileRenamed without OldPath means the backend could only
+	// tell that the path was renamed away.
 	FileRenamed
 	// FileOther - some other backend-specific event has happened
 	FileOther
+	// GitStateChanged - one of the files git uses to track the checked-out branch
+	// or the repository's refs has changed, for instance .git/HEAD
+	GitStateChanged
+	// TreeDirty - something beneath the repository root has changed. It replaces every
+	// other kind of event when WithDirtySignalOnly is used, stands in for the events that
+	// didn't fit in the channel of a client added via AddClientChannel, and summarizes the
+	// changes held for a client added via AddClientThrottled.
+	TreeDirty
 )
 
+// String returns a lowercase description of the kind of event, for instance "added"
+func (fe FileEvent) String() string {
+	switch fe {
+	case FileAdded:
+		return "added"
+	case FileDeleted:
+		return "deleted"
+	case FileModified:
+		return "modified"
+	case FileRenamed:
+		return "renamed"
+	case FileOther:
+		return "other"
+	case GitStateChanged:
+		return "git state changed"
+	case TreeDirty:
+		return "tree dirty"
+	}
+	return fmt.Sprintf("unknown(%d)", int(fe))
+}
+
 var (
 	// ErrFilewatchingClosed is returned when filewatching has been closed
 	ErrFilewatchingClosed = errors.New("Close() has already been called for filewatching")
 	// ErrFailedToStart is returned when filewatching fails to start up
 	ErrFailedToStart = errors.New("filewatching failed to start")
+	// ErrBackendClosed is delivered to OnFileWatchClosed when the backend stopped
+	// producing events without Close having been called
+	ErrBackendClosed = errors.New("filewatching backend closed unexpectedly")
+	// ErrPathOutsideRoot is returned when an event path is not contained in the requested root
+	ErrPathOutsideRoot = errors.New("path is not contained in the root")
+	// ErrEventsDropped is delivered to clients via OnFileWatchError when the watcher or the
+	// OS had to discard events, so clients may have missed changes. It is followed by
+	// FileAdded events for the current contents of every watched root.
+	ErrEventsDropped = errors.New("filewatching dropped events")
+	// ErrWatchLimitExceeded is returned or delivered when the OS refuses to install another
+	// watch because a per-user limit has been reached, for instance inotify's
+	// max_user_watches. Anything beneath the directory that failed is not being watched.
+	ErrWatchLimitExceeded = errors.New("filewatching exceeded the OS limit on watches")
+	// ErrRootDisappeared is delivered to clients via OnFileWatchError when a watched root
+	// is deleted or moved away. Nothing more will be reported from beneath it.
+	ErrRootDisappeared = errors.New("a watched root disappeared")
+	// ErrWatchUnstable is delivered to clients via OnFileWatchError when a directory keeps
+	// disappearing and reappearing, and we have given up on watching it. Nothing more is
+	// reported from beneath it until it is passed to AddWatch.
+	ErrWatchUnstable = errors.New("a directory is disappearing and reappearing too often to watch")
+	// ErrNotStarted is returned by operations that need filewatching to be running when
+	// Start has not yet been called
+	ErrNotStarted = errors.New("filewatching has not been started")
+	// ErrMountChanged is delivered to clients via OnFileWatchError, for information, when
+	// a filesystem is mounted or unmounted within a watched root. Watching continues
+	// beneath the mount point, and FileAdded events are synthesized for whatever is there now.
+	ErrMountChanged = errors.New("a filesystem was mounted or unmounted within a watched root")
+	// ErrUnsupported is returned by operations that the backend can't perform
+	ErrUnsupported = errors.New("not supported by this filewatching backend")
+	// ErrReplayOverflow is returned by EventsSince when some of the events asked for are
+	// no longer kept
+	ErrReplayOverflow = errors.New("events are no longer kept for replay")
 )
 
 // Event is the backend-independent information about a file change
 type Event struct {
 	Path      turbopath.AbsoluteSystemPath
 	EventType FileEvent
+	// OldPath is the previous location of the file for FileRenamed events, if
+	// the backend was able to correlate both halves of the rename, see FileRenamed.
+	OldPath turbopath.AbsoluteSystemPath
+	// Seq is assigned from a counter as each event is dispatched, before it is fanned out
+	// to clients, so every client sees the same Seq for the same event and a gap means
+	// the client missed something. It starts at 1. Events replayed to a single client by
+	// WithExistingFiles, and the TreeDirty that WithBufferUntilFirstClient delivers in
+	// place of what it couldn't hold, have a Seq of 0.
+	Seq uint64
+	// Root is the watched root that the event happened within: the repo root, or one
+	// added via AddRoot. If roots are nested, it is the outermost one that doesn't exclude Path.
+	Root turbopath.AbsoluteSystemPath
+	// Inode is the inode number of the file at Path, for FileAdded, FileModified, and
+	// FileRenamed events, if the platform has them and the backend tracks the tree it
+	// watches, which FSEvents doesn't unless WithInodeLookup is given. For FileDeleted
+	// events, and FileRenamed events without OldPath, it is the inode last seen at Path,
+	// if the backend tracks the tree. That lets consumers pair a rename that could only
+	// be reported as a deletion with the FileAdded that follows. Otherwise it is 0.
+	// Creating a hard link to an existing file is reported as FileAdded for the new name,
+	// carrying the same Inode as the existing name, so consumers that care about contents
+	// rather than names can dedupe.
+	Inode uint64
+	// Package is the name of the workspace package that Path belongs to, if a resolver
+	// was given via WithPackageResolver and it knew. Otherwise it is empty.
+	Package string
+	// ContentHash is a hash of the file's contents, for FileModified events delivered
+	// with WithContentHashOnModify, if the file could be read. Otherwise it is empty.
+	ContentHash string
+	// PID is the ID of the process that wrote to the file, for FileAdded and FileModified
+	// events from a backend returned by GetFanotifyBackend, if it could tell. Otherwise it is 0.
+	PID int
+	// Changes are the events that a TreeDirty event delivered to a client added via
+	// AddClientThrottled stands for. Otherwise it is empty.
+	Changes []Event
+	// Err is set on the TreeDirty event that a channel returned by AddClientChannel
+	// receives in place of events that didn't fit, and wraps ErrEventsDropped. Otherwise
+	// it is nil.
+	Err error
+}
+
+// String returns a human-readable description of the event, for instance
+// "added /repo/parent/child/foo", or "renamed /repo/old -> /repo/new" for
+// a rename whose previous location is known.
+func (e Event) String() string {
+	if e.OldPath != "" {
+		return fmt.Sprintf("%v %v -> %v", e.EventType, e.OldPath, e.Path)
+	}
+	return fmt.Sprintf("%v %v", e.EventType, e.Path)
+}
+
+// Equal returns true if both events have the same type, path, and previous path. Seq,
+// Root, Inode, Package, ContentHash, and PID, which are filled in as the event is
+// processed, are not compared.
+func (e Event) Equal(other Event) bool {
+	return e.EventType == other.EventType && e.Path == other.Path && e.OldPath == other.OldPath
+}
+
+// RelativeTo returns the path of this event anchored at the given root,
+// for instance the repo root. It returns ErrPathOutsideRoot if the event
+// did not happen within root.
+func (e Event) RelativeTo(root turbopath.AbsoluteSystemPath) (turbopath.AnchoredSystemPath, error) {
+	// filepath.Rel cleans both paths, so trailing separators don't matter here.
+	rel, err := e.Path.RelativeTo(root)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel.ToString(), ".."+string(filepath.Separator)) {
+		return "", errors.Wrapf(ErrPathOutsideRoot, "%v is not within %v", e.Path, root)
+	}
+	return rel, nil
 }
 
 // Backend is the interface that describes what an underlying filesystem watching backend
@@ -68,100 +241,845 @@ type Backend interface {
 // We currently ignore .git and top-level node_modules. We can revisit
 // if necessary.
 type FileWatcher struct {
+	// seq is the Seq of the most recently dispatched event, probeSerial numbers
+	// Healthcheck's probe files, and errorCount counts the errors delivered to clients.
+	// They are accessed atomically, so they come first to be 64-bit aligned on 32-bit
+	// platforms.
+	seq         uint64
+	probeSerial uint64
+	errorCount  uint64
+
 	backend Backend
 
 	logger         hclog.Logger
 	repoRoot       turbopath.AbsoluteSystemPath
 	excludePattern string
+	// linkedRoot is the repo root as we were given it, if it is a symlink to repoRoot.
+	// Paths we report are beneath it, while we watch repoRoot.
+	linkedRoot turbopath.AbsoluteSystemPath
+
+	clientsMu   sync.RWMutex
+	clients     []*clientEntry
+	lastID      uint64
+	closed      bool
+	closeReason error
+
+	// closing is set once Close has been called, so that we can tell a clean
+	// shutdown from the backend failing.
+	closingMu sync.Mutex
+	closing   bool
+
+	// watchMu protects watchedDirs. It must never be held while acquiring clientsMu.
+	watchMu     sync.Mutex
+	watchedDirs map[turbopath.AbsoluteSystemPath]struct{}
+
+	pauseMu sync.Mutex
+	paused  bool
+	pending *eventCoalescer
+	resumed chan struct{}
+	// coalescePolicy is how events held back by any of our features are merged
+	coalescePolicy CoalescePolicy
+
+	// debounce holds events during WithTemporaryDebounce. debounceUpdated wakes the
+	// watch loop when its interval changes.
+	debounce        *debouncer
+	debounceUpdated chan struct{}
+
+	rootsMu sync.Mutex
+	roots   []watchRoot
+
+	sleep     sleepDetector
+	include   *includeFilter
+	tracked   *trackedFilter
+	listed    *listedFilter
+	dirs      *directorySet
+	rewatch   *rewatchGuard
+	gitignore *ignoreScopes
+	maxDepth  int
+
+	// manualMu protects manualWatches, the directories passed to AddWatch, and
+	// stoppedDirs, the directories passed to StopWatching
+	manualMu      sync.RWMutex
+	manualWatches map[turbopath.AbsoluteSystemPath]struct{}
+	stoppedDirs   map[turbopath.AbsoluteSystemPath]struct{}
+
+	// ignoreMu protects ignoreGlobs, which are set by SetIgnorePatterns
+	ignoreMu    sync.RWMutex
+	ignoreGlobs []string
+	bulk        *bulkDetector
+	dirty       *dirtySignal
+	ordering    *treeOrderer
+	overwrites  *overwriteDetector
+	finalState  *finalStateTracker
+	added       *addedFilter
+	// ignoreFold makes matching against ignoreGlobs case-insensitive
+	ignoreFold bool
+	// errs carries errors from our own background work to the watch loop
+	errs chan error
+	// errStream is the channel returned by Errors()
+	errStream chan error
+	// done is closed when the watch loop exits
+	done chan struct{}
+	// ready is closed once Start has finished
+	ready chan struct{}
+
+	bufferSize int
+	// dropped counts events discarded because the buffer was full. overflowed is
+	// signalled when it becomes non-zero.
+	dropped    uint64
+	overflowed chan struct{}
+
+	// stats holds the *statCounters behind Stats
+	stats atomic.Value
+
+	// replays carries existing files to be delivered to a single client
+	replays chan replay
+
+	// started is set to 1 once Start has launched the watch loop, and startedAt is when
+	// that happened
+	started   int32
+	startedAt time.Time
+
+	// settleWindow is how long after Start events are dropped, until liveAt
+	settleWindow time.Duration
+	liveAt       time.Time
+
+	startTimeout    time.Duration
+	skipInitialScan bool
+	// reconciling is 1 while a rescan of every root is in progress
+	reconciling int32
+	// scanThrottle bounds the I/O of rescans, or is nil for no limit
+	scanThrottle *scanThrottle
+	// resolvePackage maps paths to workspace packages, if WithPackageResolver was used
+	resolvePackage func(path turbopath.AbsoluteSystemPath) (string, bool)
+	// groupKey groups events for GroupClients, if WithGroupKey was used
+	groupKey func(ev Event) string
+	// history keeps recent events for EventsSince
+	history *eventHistory
+	// contentHashes holds the last hash of each file's contents seen by the watch loop,
+	// if WithContentHashOnModify is enabled
+	contentHashes *contentHashes
+
+	tempFilePatterns []string
+
+	metrics MetricsSink
+
+	dispatchWorkers int
+	// deliveries feeds the pool of dispatch workers, if we have them
+	deliveries chan delivery
+
+	// bufferUntilFirstClient is set until the first client is added, if
+	// WithBufferUntilFirstClient was given, and untilFirstClient holds the events and
+	// errors dispatched in the meantime, with droppedUntilFirstClient counting those that
+	// didn't fit. All three are protected by clientsMu.
+	bufferUntilFirstClient  bool
+	untilFirstClient        []heldDelivery
+	droppedUntilFirstClient int
+
+	healthcheckDir turbopath.AbsoluteSystemPath
+	// probesMu protects probes, which maps each outstanding probe file to a channel
+	// that is closed when its event arrives, and markerDir
+	probesMu sync.Mutex
+	probes   map[turbopath.AbsoluteSystemPath]chan struct{}
+	// markerDir is the directory outside the repo that SuppressDuring writes its probe
+	// files to, once it has been created. markerMu serializes creating and removing it.
+	markerMu  sync.Mutex
+	markerDir turbopath.AbsoluteSystemPath
+
+	// selfWritesMu protects selfWrites, which maps the paths passed to IgnorePath to when
+	// we stop ignoring them
+	selfWritesMu sync.Mutex
+	selfWrites   map[turbopath.AbsoluteSystemPath]time.Time
+
+	maxFileSize  int64
+	ignoreBinary bool
+
+	lookupInodes bool
+
+	watchLinkedGitDir bool
+	// gitDirs are the git directories whose state files we report: the repo root's .git,
+	// or if the repo root is a worktree, its linked git directories.
+	gitDirs []turbopath.AbsoluteSystemPath
+}
+
+// watchRoot is a hierarchy that we have asked the backend to watch
+type watchRoot struct {
+	path            turbopath.AbsoluteSystemPath
+	excludePatterns []string
+	// shallow is set if only the root itself was watched up front, rather than the
+	// whole hierarchy
+	shallow bool
+}
 
-	clientsMu sync.RWMutex
-	clients   []FileWatchClient
-	closed    bool
+// rescanningBackend is implemented by backends that can re-walk a root, installing any
+// watches that are missing and synthesizing FileAdded events for its current contents.
+type rescanningBackend interface {
+	rescan(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error
 }
 
-// New returns a new FileWatcher instance
-func New(logger hclog.Logger, repoRoot turbopath.AbsoluteSystemPath, backend Backend) *FileWatcher {
+// New returns a new FileWatcher instance. If repoRoot is, or is within, a symlink, the
+// directory it points to is watched, and events are still reported beneath repoRoot.
+func New(logger hclog.Logger, repoRoot turbopath.AbsoluteSystemPath, backend Backend, opts ...Option) *FileWatcher {
+	// Use the same form for the repo root as for event paths, so that they can be compared
+	repoRoot = normalizePath(repoRoot)
+	var linkedRoot turbopath.AbsoluteSystemPath
+	if real := resolveRoot(repoRoot); real != repoRoot {
+		linkedRoot, repoRoot = repoRoot, real
+	}
 	excludes := make([]string, len(_ignores))
 	for i, ignore := range _ignores {
 		excludes[i] = filepath.ToSlash(repoRoot.UntypedJoin(ignore).ToString() + "/**")
 	}
 	excludePattern := "{" + strings.Join(excludes, ",") + "}"
-	return &FileWatcher{
+	fw := &FileWatcher{
 		backend:        backend,
-		logger:         logger,
+		logger:         logger.With(_logBackend, backendName(backend)),
 		repoRoot:       repoRoot,
+		linkedRoot:     linkedRoot,
 		excludePattern: excludePattern,
+		watchedDirs:    make(map[turbopath.AbsoluteSystemPath]struct{}),
+		manualWatches:  make(map[turbopath.AbsoluteSystemPath]struct{}),
+		stoppedDirs:    make(map[turbopath.AbsoluteSystemPath]struct{}),
+		added:          newAddedFilter(_duplicateAddWindow),
+		resumed:        make(chan struct{}, 1),
+		sleep: sleepDetector{
+			clock:         systemClock{},
+			threshold:     _defaultSleepThreshold,
+			checkInterval: _sleepCheckInterval,
+		},
+		errs:       make(chan error),
+		done:       make(chan struct{}),
+		ready:      make(chan struct{}),
+		overflowed: make(chan struct{}, 1),
+		replays:    make(chan replay),
+		errStream:  make(chan error, _errorStreamBuffer),
+
+		tempFilePatterns: _defaultTempFilePatterns,
+		metrics:          nopMetricsSink{},
+		healthcheckDir:   repoRoot,
+		probes:           make(map[turbopath.AbsoluteSystemPath]chan struct{}),
+		selfWrites:       make(map[turbopath.AbsoluteSystemPath]time.Time),
+		history:          newEventHistory(_defaultReplayBuffer),
+		coalescePolicy:   CoalesceByPath,
+		debounceUpdated:  make(chan struct{}, 1),
+		ignoreFold:       isCaseInsensitiveFS(repoRoot),
+	}
+	fw.stats.Store(&statCounters{})
+	for _, opt := range opts {
+		opt(fw)
+	}
+	// Held events are merged according to the policy the options chose
+	fw.pending = newEventCoalescer(fw.coalescePolicy)
+	fw.debounce = newDebouncer(fw.coalescePolicy)
+	if fw.bulk != nil {
+		fw.bulk.pending = newEventCoalescer(fw.coalescePolicy)
+	}
+	if _, ok := backend.(rewatchingBackend); ok {
+		fw.rewatch = newRewatchGuard()
 	}
+	if tracker, ok := backend.(watchTrackingBackend); ok {
+		tracker.setWatchListener(fw)
+	}
+	if filtering, ok := backend.(dirFilteringBackend); ok {
+		filtering.setDirFilter(fw.admitDir)
+	}
+	if ignoring, ok := backend.(ignoringBackend); ok && len(fw.tempFilePatterns) > 0 {
+		ignoring.setIgnoreFilter(fw.isTempFile)
+	}
+	if throttling, ok := backend.(throttlingBackend); ok && fw.scanThrottle != nil {
+		throttling.setScanThrottle(fw.scanThrottle)
+	}
+	return fw
 }
 
 // Close shuts down filewatching
 func (fw *FileWatcher) Close() error {
-	return fw.backend.Close()
+	fw.closingMu.Lock()
+	fw.closing = true
+	fw.closingMu.Unlock()
+	err := fw.backend.Close()
+	fw.removeMarkerDir()
+	return err
+}
+
+// shutdownReason returns why the watch loop is exiting, given a description of what
+// caused it to exit. It is nil if we were asked to shut down.
+func (fw *FileWatcher) shutdownReason(cause string) error {
+	fw.closingMu.Lock()
+	defer fw.closingMu.Unlock()
+	if fw.closing {
+		return nil
+	}
+	return errors.Wrap(ErrBackendClosed, cause)
 }
 
 // Start recursively adds all directories from the repo root, redacts the excluded ones,
-// then fires off a goroutine to respond to filesystem events
+// then fires off a goroutine to respond to filesystem events. See WithoutInitialScan
+// for skipping the recursive part.
 func (fw *FileWatcher) Start() error {
-	if err := fw.backend.AddRoot(fw.repoRoot, fw.excludePattern); err != nil {
+	if err := fw.resolveGitDirs(); err != nil {
+		return err
+	}
+	if fw.tracked != nil {
+		if _, err := fw.tracked.refresh(); err != nil {
+			return errors.Wrap(err, "failed to list git-tracked files")
+		}
+	}
+	if err := fw.addRepoRoot(); err != nil {
 		return err
 	}
 	if err := fw.backend.Start(); err != nil {
 		return err
 	}
+	// Git directories are added once the backend has started, because starting prunes
+	// watches matching any root's exclusions: the repo root excludes its .git, and a
+	// worktree's git directory lives within the excluded part of the shared one.
+	if err := fw.addGitDirs(); err != nil {
+		return err
+	}
+	fw.sleep.reset()
+	fw.startedAt = time.Now()
+	fw.liveAt = fw.startedAt.Add(fw.settleWindow)
+	fw.startDispatchWorkers()
+	if fw.tracked != nil {
+		go fw.refreshTrackedFiles()
+	}
+	atomic.StoreInt32(&fw.started, 1)
 	go fw.watch()
+	fw.watchMounts()
+	close(fw.ready)
 	return nil
 }
 
+// RepoRelativePath returns the path of the given event anchored at the repo root
+// this FileWatcher was created with.
+func (fw *FileWatcher) RepoRelativePath(ev Event) (turbopath.AnchoredSystemPath, error) {
+	return ev.RelativeTo(fw.reportedRoot())
+}
+
 // AddRoot registers the root a filesystem hierarchy to be watched for changes. Events are *not*
 // fired for existing files when AddRoot is called, only for subsequent changes.
 // NOTE: if it appears helpful, we could change this behavior so that we provide a stream of initial
 // events.
+//
+// A root within one that is already being watched, and that isn't excluded from it, is
+// left to the outer root, so that each change is reported once, attributed to the outer
+// root. The reverse doesn't hold: adding a root that contains an existing one doesn't
+// stop the inner one being watched, and backends that watch each root separately, such as
+// FSEvents, then report changes within it twice. Add outer roots first.
 func (fw *FileWatcher) AddRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
-	return fw.backend.AddRoot(root, excludePatterns...)
+	root = fw.toRealPath(root)
+	if outer, ok := fw.coveringRoot(root); ok {
+		fw.logger.Debug("root is already watched as part of another root", _logOp, "watch", _logPath, root, "within", outer.path)
+		return nil
+	}
+	if err := fw.backend.AddRoot(root, excludePatterns...); err != nil {
+		return err
+	}
+	fw.rootsMu.Lock()
+	defer fw.rootsMu.Unlock()
+	fw.roots = append(fw.roots, watchRoot{
+		path:            root,
+		excludePatterns: excludePatterns,
+	})
+	return nil
+}
+
+// rootOf returns the outermost watched root containing the given path, skipping roots
+// that exclude it, or "" if there isn't one
+func (fw *FileWatcher) rootOf(p turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	fw.rootsMu.Lock()
+	defer fw.rootsMu.Unlock()
+	var containing []watchRoot
+	for _, root := range fw.roots {
+		if p.HasPrefix(normalizePath(root.path)) {
+			containing = append(containing, root)
+		}
+	}
+	if len(containing) == 0 {
+		return ""
+	}
+	// Only bother matching exclusions if the roots overlap
+	if len(containing) > 1 {
+		sort.Slice(containing, func(i, j int) bool {
+			return len(containing[i].path) < len(containing[j].path)
+		})
+		for _, root := range containing {
+			if !root.excludes(p) {
+				return normalizePath(root.path)
+			}
+		}
+	}
+	return normalizePath(containing[len(containing)-1].path)
 }
 
 // watch is the main file-watching loop. Watching is not recursive,
 // so when new directories are added, they are manually recursively watched.
 func (fw *FileWatcher) watch() {
+	defer close(fw.done)
+	var sleepCheck <-chan time.Time
+	if fw.sleep.enabled() {
+		ticker := time.NewTicker(fw.sleep.checkInterval)
+		defer ticker.Stop()
+		sleepCheck = ticker.C
+	}
+	events := fw.backend.Events()
+	if fw.bufferSize > 0 {
+		events = fw.bufferEvents(events)
+	}
+	var reason error
 outer:
 	for {
+		// If we're holding events for a throttled client, wake up when they're due
+		var throttleExpiry <-chan time.Time
+		if deadline, ok := fw.nextThrottleDeadline(); ok {
+			throttleExpiry = time.After(time.Until(deadline))
+		}
+		var bulkSettled <-chan time.Time
+		if fw.bulk != nil {
+			if deadline, ok := fw.bulk.settleDeadline(); ok {
+				bulkSettled = time.After(time.Until(deadline))
+			}
+		}
+		var debounceDue <-chan time.Time
+		if deadline, ok := fw.debounce.deadline(); ok {
+			debounceDue = time.After(time.Until(deadline))
+		}
+		var orderingDue <-chan time.Time
+		if fw.ordering != nil {
+			if deadline, ok := fw.ordering.releaseDeadline(); ok {
+				orderingDue = time.After(time.Until(deadline))
+			}
+		}
+		var overwriteDue <-chan time.Time
+		if fw.overwrites != nil {
+			if deadline, ok := fw.overwrites.nextDeadline(); ok {
+				overwriteDue = time.After(time.Until(deadline))
+			}
+		}
+		var finalStateDue <-chan time.Time
+		if fw.finalState != nil {
+			if deadline, ok := fw.finalState.nextDeadline(); ok {
+				finalStateDue = time.After(time.Until(deadline))
+			}
+		}
+		var dirtyDue <-chan time.Time
+		if fw.dirty != nil {
+			if deadline, ok := fw.dirty.deadline(); ok {
+				dirtyDue = time.After(time.Until(deadline))
+			}
+		}
 		select {
-		case ev, ok := <-fw.backend.Events():
+		case ev, ok := <-events:
 			if !ok {
 				fw.logger.Info("Events channel closed. Exiting watch loop")
+				reason = fw.shutdownReason("events channel closed")
 				break outer
 			}
-			fw.clientsMu.RLock()
-			for _, client := range fw.clients {
-				client.OnFileWatchEvent(ev)
+			ev = normalizeEvent(ev)
+			if fw.settling(ev, time.Now()) {
+				continue
+			}
+			fw.reloadIgnores(ev)
+			if fw.isProbe(ev) || fw.isSelfWrite(ev, time.Now()) || !fw.accept(ev) {
+				continue
+			}
+			ev = fw.classifyGitState(ev)
+			if fw.added.duplicate(ev, time.Now()) {
+				continue
+			}
+			ev = fw.tagInode(ev)
+			if fw.tracked != nil && fw.isIndexChange(ev) {
+				fw.requestTrackedRefresh()
 			}
-			fw.clientsMu.RUnlock()
+			if fw.isRootRemoval(ev) {
+				fw.dispatchError(errors.Wrapf(ErrRootDisappeared, "%v", ev))
+			}
+			ev, held := fw.holdForOverwrite(ev)
+			if held {
+				continue
+			}
+			fw.forwardFinalState(ev)
+		case <-fw.resumed:
+			fw.flushPaused()
+		case <-fw.debounceUpdated:
+			fw.releaseDebounced(time.Now(), false)
+		case r := <-fw.replays:
+			fw.deliverReplay(r)
 		case err, ok := <-fw.backend.Errors():
 			if !ok {
 				fw.logger.Info("Errors channel closed. Exiting watch loop")
+				reason = fw.shutdownReason("errors channel closed")
 				break outer
 			}
-			fw.clientsMu.RLock()
-			for _, client := range fw.clients {
-				client.OnFileWatchError(err)
+			fw.dispatchError(err)
+			if errors.Is(err, ErrEventsDropped) {
+				go fw.reconcile()
+			}
+		case err := <-fw.errs:
+			fw.dispatchError(err)
+		case <-fw.overflowed:
+			dropped := fw.takeDropped()
+			fw.logger.Warn("event buffer overflowed", "dropped", dropped)
+			fw.dispatchError(errors.Wrapf(ErrEventsDropped, "event buffer full, dropped %v events", dropped))
+			go fw.reconcile()
+		case now := <-throttleExpiry:
+			fw.flushThrottled(now, false)
+		case now := <-bulkSettled:
+			fw.settleBulk(now, false)
+		case now := <-debounceDue:
+			fw.releaseDebounced(now, false)
+		case now := <-orderingDue:
+			fw.releaseOrdered(now, false)
+		case now := <-overwriteDue:
+			fw.releaseOverwrites(now, false)
+		case now := <-finalStateDue:
+			fw.releaseFinalState(now, false)
+		case now := <-dirtyDue:
+			fw.releaseDirty(now, false)
+		case <-sleepCheck:
+			if asleep, slept := fw.sleep.check(); slept {
+				fw.logger.Warn("detected sleep, reconciling watched roots", _logOp, "rescan", "asleep", asleep)
+				fw.dispatchError(errors.Wrapf(ErrPossiblyStaleAfterSleep, "asleep for %v", asleep))
+				go fw.reconcile()
 			}
-			fw.clientsMu.RUnlock()
 		}
 	}
+	// Don't leave clients without the last changes before we stopped
+	fw.releaseOverwrites(time.Now(), true)
+	fw.releaseFinalState(time.Now(), true)
+	fw.settleBulk(time.Now(), true)
+	fw.releaseDebounced(time.Now(), true)
+	fw.releaseOrdered(time.Now(), true)
+	fw.releaseDirty(time.Now(), true)
+	fw.flushThrottled(time.Now(), true)
+	close(fw.errStream)
 	fw.clientsMu.Lock()
 	fw.closed = true
-	for _, client := range fw.clients {
-		client.OnFileWatchClosed()
+	fw.closeReason = reason
+	for _, entry := range fw.clients {
+		_ = callClient(entry.client, func(client FileWatchClient) {
+			client.OnFileWatchClosed(reason)
+		})
 	}
 	fw.clientsMu.Unlock()
 }
 
-// AddClient registers a client for filesystem events
-func (fw *FileWatcher) AddClient(client FileWatchClient) {
+// forward dispatches an event that has made it through our filters, unless its contents
+// are unchanged, or it is held while a bulk operation settles, while we are paused, or to
+// be put in tree order
+func (fw *FileWatcher) forward(ev Event) {
+	ev, unchanged := fw.hashContent(ev)
+	if unchanged {
+		return
+	}
+	if fw.holdIfBulk(ev) || fw.holdIfDebouncing(ev) || fw.holdIfPaused(ev) || fw.holdForOrdering(ev) {
+		return
+	}
+	fw.dispatch(ev)
+}
+
+// dispatch delivers an event to every client, or holds it for throttled clients that
+// have had a delivery too recently
+func (fw *FileWatcher) dispatch(ev Event) {
+	if fw.holdAsDirty(ev) {
+		return
+	}
+	ev.Seq = atomic.AddUint64(&fw.seq, 1)
+	ev.Root = fw.rootOf(ev.Path)
+	ev = fw.fromRealEvent(ev)
+	ev.Package = fw.packageOf(ev.Path)
+	if fw.logger.IsTrace() {
+		fw.logger.Trace("dispatching event", _logOp, "dispatch", _logPath, ev.Path, _logEventType, ev.EventType, "seq", ev.Seq)
+	}
+	fw.history.add(ev)
+	fw.metrics.IncEvents(ev.EventType)
+	atomic.AddUint64(&fw.counters().delivered, 1)
+	start := time.Now()
+	fw.clientsMu.RLock()
+	var evicted []*clientEntry
+	if !fw.holdUntilFirstClient(ev) {
+		evicted = fw.deliverEvent(fw.clients, ev)
+	}
+	fw.clientsMu.RUnlock()
+	fw.metrics.ObserveDispatchLatency(time.Since(start))
+	for _, entry := range evicted {
+		fw.evictClient(entry)
+	}
+}
+
+// deliverEvent delivers an event to the given clients, holding it for those that are
+// throttled, and returns the clients to evict. Must be called while clientsMu is held.
+func (fw *FileWatcher) deliverEvent(entries []*clientEntry, ev Event) []*clientEntry {
+	var unthrottled, throttled []*clientEntry
+	for _, entry := range entries {
+		if entry.throttle != nil {
+			throttled = append(throttled, entry)
+		} else {
+			unthrottled = append(unthrottled, entry)
+		}
+	}
+	evicted := fw.deliverEventTo(unthrottled, ev)
+	if len(throttled) > 0 {
+		evicted = append(evicted, fw.dispatchThrottled(throttled, ev, time.Now())...)
+	}
+	return evicted
+}
+
+// dispatchError delivers an error to every client
+func (fw *FileWatcher) dispatchError(err error) {
+	atomic.AddUint64(&fw.errorCount, 1)
+	atomic.AddUint64(&fw.counters().errors, 1)
+	fw.metrics.IncErrors()
+	fw.publishError(err)
+	fw.clientsMu.RLock()
+	held := fw.holdErrorUntilFirstClient(err)
+	fw.clientsMu.RUnlock()
+	if held {
+		return
+	}
+	fw.deliver(func(client FileWatchClient) {
+		client.OnFileWatchError(err)
+	})
+}
+
+// reportError hands an error from background work to the watch loop for delivery.
+func (fw *FileWatcher) reportError(err error) {
+	select {
+	case fw.errs <- err:
+	case <-fw.done:
+		fw.logger.Debug("dropping error after filewatching closed", "error", err)
+	}
+}
+
+// reconcile asks the backend to rescan every root we are watching, which re-installs any
+// missing watches and synthesizes events for the current contents. It blocks on event
+// delivery, so it must not be called from the watch loop.
+func (fw *FileWatcher) reconcile() {
+	rescanner, ok := fw.backend.(rescanningBackend)
+	if !ok {
+		return
+	}
+	// One rescan at a time is enough, since each one covers every root
+	if !atomic.CompareAndSwapInt32(&fw.reconciling, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&fw.reconciling, 0)
+	fw.rootsMu.Lock()
+	roots := make([]watchRoot, len(fw.roots))
+	copy(roots, fw.roots)
+	fw.rootsMu.Unlock()
+	for _, root := range roots {
+		if err := rescanner.rescan(root.path, root.excludePatterns...); err != nil {
+			fw.reportError(errors.Wrapf(err, "failed to rescan %v", root.path))
+		}
+	}
+}
+
+// Pause stops delivering events to clients until Resume is called. Events that happen
+// while paused are accumulated and reduced to their net effect, so a file that is created
+// and deleted while paused produces no events at all. WithCoalescePolicy changes how far
+// they are merged. Errors are still delivered.
+func (fw *FileWatcher) Pause() {
+	fw.pauseMu.Lock()
+	defer fw.pauseMu.Unlock()
+	fw.paused = true
+}
+
+// Resume restarts event delivery after a call to Pause, first delivering the net changes
+// that occurred while paused.
+func (fw *FileWatcher) Resume() {
+	fw.pauseMu.Lock()
+	defer fw.pauseMu.Unlock()
+	fw.paused = false
+	select {
+	case fw.resumed <- struct{}{}:
+	default:
+		// a flush is already pending
+	}
+}
+
+// holdIfPaused accumulates the event if we are paused, returning true if the event
+// should not be dispatched now. If we have resumed, any accumulated events are flushed first.
+func (fw *FileWatcher) holdIfPaused(ev Event) bool {
+	fw.pauseMu.Lock()
+	if fw.paused {
+		fw.pending.add(ev)
+		fw.pauseMu.Unlock()
+		return true
+	}
+	fw.pauseMu.Unlock()
+	fw.flushPaused()
+	return false
+}
+
+// flushPaused delivers any events accumulated while paused, unless we have been paused again.
+func (fw *FileWatcher) flushPaused() {
+	fw.pauseMu.Lock()
+	if fw.paused || fw.pending.len() == 0 {
+		fw.pauseMu.Unlock()
+		return
+	}
+	evs := fw.pending.flush()
+	fw.pauseMu.Unlock()
+	for _, ev := range evs {
+		if !fw.holdForOrdering(ev) {
+			fw.dispatch(ev)
+		}
+	}
+}
+
+// AddClient registers a client for filesystem events. Clients are added once Start has
+// returned, and receive the events dispatched from then on, until Close is called. It
+// returns ErrNotStarted before Start, and ErrFilewatchingClosed once Close has been
+// called, or if the backend stopped on its own, the error wrapping ErrBackendClosed that
+// filewatching stopped with. To see the changes made between Start and the first client
+// being added, use WithBufferUntilFirstClient, and for errors, Errors.
+func (fw *FileWatcher) AddClient(client FileWatchClient, opts ...ClientOption) error {
+	_, err := fw.addClient(client, opts...)
+	return err
+}
+
+// addClient registers a client and returns our record of it, which can be passed to removeClient
+func (fw *FileWatcher) addClient(client FileWatchClient, opts ...ClientOption) (*clientEntry, error) {
+	var evicted []*clientEntry
+	// Runs after clientsMu is released, since evicting takes it again
+	defer func() {
+		for _, entry := range evicted {
+			fw.evictClient(entry)
+		}
+	}()
 	fw.clientsMu.Lock()
 	defer fw.clientsMu.Unlock()
-	fw.clients = append(fw.clients, client)
-	if fw.closed {
-		client.OnFileWatchClosed()
+	if err := fw.checkRunning(); err != nil {
+		return nil, err
+	}
+	entry := fw.addClientLocked(client, opts...)
+	evicted = fw.replayUntilFirstClient([]*clientEntry{entry})
+	return entry, nil
+}
+
+// checkRunning returns an error describing why clients can't be added, unless we have
+// started and haven't closed. Must be called while clientsMu is held.
+func (fw *FileWatcher) checkRunning() error {
+	if atomic.LoadInt32(&fw.started) == 0 {
+		return ErrNotStarted
+	}
+	if fw.closed && fw.closeReason != nil {
+		return fw.closeReason
+	}
+	fw.closingMu.Lock()
+	closing := fw.closing
+	fw.closingMu.Unlock()
+	if closing || fw.closed {
+		return ErrFilewatchingClosed
+	}
+	return nil
+}
+
+// addClientLocked registers a client. The caller must then replay anything buffered by
+// WithBufferUntilFirstClient to it. Must be called while clientsMu is held for writing,
+// once checkRunning has passed.
+func (fw *FileWatcher) addClientLocked(client FileWatchClient, opts ...ClientOption) *clientEntry {
+	var cfg clientConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	entry := &clientEntry{client: client}
+	fw.lastID++
+	entry.id = fw.lastID
+	entry.name = cfg.name
+	if entry.name == "" {
+		entry.name = clientName(client, entry.id)
+	}
+	if grouped := fw.isGroupClient(client); cfg.throttle > 0 || grouped {
+		entry.throttle = &clientThrottle{
+			interval: cfg.throttle,
+			pending:  newEventCoalescer(fw.coalescePolicy),
+			grouped:  grouped,
+		}
+		if entry.throttle.interval == 0 {
+			entry.throttle.interval = _groupWindow
+		}
+	}
+	if dropping, ok := client.(droppingClient); ok {
+		dropping.countDropsIn(&entry.dropped)
+	}
+	fw.clients = append(fw.clients, entry)
+	if cfg.existingFiles {
+		go fw.replayExisting(entry)
+	}
+	if observer, ok := client.(WatchObserver); ok {
+		for _, dir := range fw.currentWatches() {
+			observer.OnWatchAdded(dir)
+		}
+	}
+	return entry
+}
+
+// removeClient stops delivering to a client. It returns false if the client was not registered.
+func (fw *FileWatcher) removeClient(entry *clientEntry) bool {
+	fw.clientsMu.Lock()
+	defer fw.clientsMu.Unlock()
+	return fw.removeClientLocked(entry)
+}
+
+// removeClientLocked is removeClient for when clientsMu is already held for writing
+func (fw *FileWatcher) removeClientLocked(entry *clientEntry) bool {
+	for i, other := range fw.clients {
+		if other == entry {
+			fw.clients = append(fw.clients[:i:i], fw.clients[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (fw *FileWatcher) currentWatches() []turbopath.AbsoluteSystemPath {
+	fw.watchMu.Lock()
+	defer fw.watchMu.Unlock()
+	dirs := make([]turbopath.AbsoluteSystemPath, 0, len(fw.watchedDirs))
+	for dir := range fw.watchedDirs {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func (fw *FileWatcher) onWatchAdded(dir turbopath.AbsoluteSystemPath) {
+	fw.watchMu.Lock()
+	fw.watchedDirs[dir] = struct{}{}
+	count := len(fw.watchedDirs)
+	fw.watchMu.Unlock()
+	if fw.dirs != nil {
+		fw.dirs.add(dir)
+	}
+	fw.metrics.SetWatchedDirs(count)
+	fw.logger.Trace("watch added", _logOp, "watch", _logPath, dir, _logWatchedDirs, count)
+	fw.clientsMu.RLock()
+	defer fw.clientsMu.RUnlock()
+	for _, entry := range fw.clients {
+		if observer, ok := entry.client.(WatchObserver); ok {
+			observer.OnWatchAdded(dir)
+		}
+	}
+}
+
+func (fw *FileWatcher) onWatchRemoved(dir turbopath.AbsoluteSystemPath) {
+	fw.watchMu.Lock()
+	delete(fw.watchedDirs, dir)
+	count := len(fw.watchedDirs)
+	fw.watchMu.Unlock()
+	if fw.rewatch != nil && !dir.DirExists() {
+		// It was deleted, rather than our having unwatched it
+		fw.rewatch.lost(dir, time.Now())
+	}
+	fw.metrics.SetWatchedDirs(count)
+	fw.logger.Trace("watch removed", _logOp, "unwatch", _logPath, dir, _logWatchedDirs, count)
+	fw.clientsMu.RLock()
+	defer fw.clientsMu.RUnlock()
+	for _, entry := range fw.clients {
+		if observer, ok := entry.client.(WatchObserver); ok {
+			observer.OnWatchRemoved(dir)
+		}
 	}
 }
@@ -1,7 +1,10 @@
 package filewatcher
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -29,7 +32,25 @@ func (c *testClient) OnFileWatchEvent(ev Event) {
 
 func (c *testClient) OnFileWatchError(err error) {}
 
-func (c *testClient) OnFileWatchClosed() {}
+func (c *testClient) OnFileWatchClosed(err error) {}
+
+// allEventsClient forwards every event it receives, and errors if errs is set
+type allEventsClient struct {
+	notify chan Event
+	errs   chan error
+}
+
+func (c *allEventsClient) OnFileWatchEvent(ev Event) {
+	c.notify <- ev
+}
+
+func (c *allEventsClient) OnFileWatchError(err error) {
+	if c.errs != nil {
+		c.errs <- err
+	}
+}
+
+func (c *allEventsClient) OnFileWatchClosed(err error) {}
 
 func expectFilesystemEvent(t *testing.T, ch <-chan Event, expected Event) {
 	// mark this method as a helper
@@ -39,7 +60,7 @@ func expectFilesystemEvent(t *testing.T, ch <-chan Event, expected Event) {
 		select {
 		case ev := <-ch:
 			t.Logf("got event %v", ev)
-			if ev.Path == expected.Path && ev.EventType == expected.EventType {
+			if ev.Equal(expected) {
 				return
 			}
 		case <-timeout:
@@ -112,7 +133,7 @@ func TestFileWatching(t *testing.T) {
 	c := &testClient{
 		notify: ch,
 	}
-	fw.AddClient(c)
+	assert.NilError(t, fw.AddClient(c), "AddClient")
 	expectedWatching := []turbopath.AbsoluteSystemPath{
 		repoRoot,
 		repoRoot.UntypedJoin("parent"),
@@ -132,8 +153,8 @@ func TestFileWatching(t *testing.T) {
 	deepPath := repoRoot.UntypedJoin("parent", "sibling", "deep", "path")
 	err = deepPath.MkdirAll(0775)
 	assert.NilError(t, err, "MkdirAll")
-	// We'll catch an event for "deep", but not "deep/path" since
-	// we don't have a recursive watch
+	// "deep/path" may be created before "deep" is watched, in which case
+	// its event is synthesized when we walk "deep"
 	expectFilesystemEvent(t, ch, Event{
 		Path:      repoRoot.UntypedJoin("parent", "sibling", "deep"),
 		EventType: FileAdded,
@@ -150,3 +171,317 @@ func TestFileWatching(t *testing.T) {
 	assert.NilError(t, err, "WriteFile")
 	expectNoFilesystemEvent(t, ch)
 }
+
+func TestEventRelativeTo(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	ev := Event{
+		Path:      repoRoot.UntypedJoin("parent", "child", "foo"),
+		EventType: FileAdded,
+	}
+
+	rel, err := ev.RelativeTo(repoRoot)
+	assert.NilError(t, err, "RelativeTo")
+	assert.Equal(t, rel, turbopath.AnchoredSystemPath(filepath.Join("parent", "child", "foo")))
+
+	// A trailing separator on the root should not change the result
+	rel, err = ev.RelativeTo(turbopath.AbsoluteSystemPath(repoRoot.ToString() + string(filepath.Separator)))
+	assert.NilError(t, err, "RelativeTo")
+	assert.Equal(t, rel, turbopath.AnchoredSystemPath(filepath.Join("parent", "child", "foo")))
+
+	_, err = ev.RelativeTo(repoRoot.UntypedJoin("other"))
+	assert.ErrorIs(t, err, ErrPathOutsideRoot)
+}
+
+func TestEventString(t *testing.T) {
+	path := turbopath.AbsoluteSystemPath(filepath.Join("repo", "parent", "child", "foo"))
+	oldPath := turbopath.AbsoluteSystemPath(filepath.Join("repo", "parent", "child", "bar"))
+	testCases := []struct {
+		ev       Event
+		expected string
+	}{
+		{Event{EventType: FileAdded, Path: path}, "added " + path.ToString()},
+		{Event{EventType: FileDeleted, Path: path}, "deleted " + path.ToString()},
+		{Event{EventType: FileModified, Path: path}, "modified " + path.ToString()},
+		{Event{EventType: FileRenamed, Path: path}, "renamed " + path.ToString()},
+		{Event{EventType: FileRenamed, Path: path, OldPath: oldPath}, "renamed " + oldPath.ToString() + " -> " + path.ToString()},
+		{Event{EventType: FileOther, Path: path}, "other " + path.ToString()},
+		{Event{EventType: GitStateChanged, Path: path}, "git state changed " + path.ToString()},
+		{Event{EventType: TreeDirty, Path: path}, "tree dirty " + path.ToString()},
+		{Event{EventType: FileEvent(42), Path: path}, "unknown(42) " + path.ToString()},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.ev.String(), tc.expected)
+	}
+}
+
+func TestEventEqual(t *testing.T) {
+	path := turbopath.AbsoluteSystemPath(filepath.Join("repo", "foo"))
+	oldPath := turbopath.AbsoluteSystemPath(filepath.Join("repo", "bar"))
+	added := Event{EventType: FileAdded, Path: path}
+	assert.Assert(t, added.Equal(Event{EventType: FileAdded, Path: path}))
+	assert.Assert(t, !added.Equal(Event{EventType: FileModified, Path: path}))
+	assert.Assert(t, !added.Equal(Event{EventType: FileAdded, Path: oldPath}))
+
+	renamed := Event{EventType: FileRenamed, Path: path, OldPath: oldPath}
+	assert.Assert(t, renamed.Equal(Event{EventType: FileRenamed, Path: path, OldPath: oldPath}))
+	// A rename with an unknown previous location is a different event
+	assert.Assert(t, !renamed.Equal(Event{EventType: FileRenamed, Path: path}))
+	assert.Assert(t, !renamed.Equal(Event{EventType: FileRenamed, Path: oldPath, OldPath: path}))
+}
+
+func TestFileWatchingSubfolderRename(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("parent", "child").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+
+	ch := make(chan Event, 16)
+	c := &allEventsClient{
+		notify: ch,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	oldPath := repoRoot.UntypedJoin("parent", "child")
+	newPath := repoRoot.UntypedJoin("parent", "renamed")
+	err = oldPath.Rename(newPath)
+	assert.NilError(t, err, "Rename")
+
+	if runtime.GOOS != "windows" {
+		// FSEvents gives us both halves of the rename, and elsewhere we pair them up
+		// by inode, so we report a single event
+		expectFilesystemEvent(t, ch, Event{
+			EventType: FileRenamed,
+			Path:      newPath,
+			OldPath:   oldPath,
+		})
+	} else {
+		// Windows reports the new location as a create
+		expectFilesystemEvent(t, ch, Event{
+			EventType: FileAdded,
+			Path:      newPath,
+		})
+	}
+}
+
+type watchObserverClient struct {
+	testClient
+	watchesAdded chan turbopath.AbsoluteSystemPath
+}
+
+func (c *watchObserverClient) OnWatchAdded(dir turbopath.AbsoluteSystemPath) {
+	c.watchesAdded <- dir
+}
+
+func (c *watchObserverClient) OnWatchRemoved(dir turbopath.AbsoluteSystemPath) {}
+
+func expectWatchAdded(t *testing.T, ch <-chan turbopath.AbsoluteSystemPath, expected ...turbopath.AbsoluteSystemPath) {
+	t.Helper()
+	remaining := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for _, dir := range expected {
+		remaining[dir] = struct{}{}
+	}
+	timeout := time.After(1 * time.Second)
+	for len(remaining) > 0 {
+		select {
+		case dir := <-ch:
+			delete(remaining, dir)
+		case <-timeout:
+			t.Errorf("Timed out waiting for watches on %v", remaining)
+			return
+		}
+	}
+}
+
+func TestWatchObserver(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("parent", "child").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	watchesAdded := make(chan turbopath.AbsoluteSystemPath, 16)
+	c := &watchObserverClient{
+		testClient:   testClient{notify: ch},
+		watchesAdded: watchesAdded,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	if runtime.GOOS == "darwin" {
+		// FSEvents watches are recursive, we only have a watch on the root
+		expectWatchAdded(t, watchesAdded, repoRoot)
+		return
+	}
+	expectWatchAdded(t, watchesAdded, repoRoot, repoRoot.UntypedJoin("parent"), repoRoot.UntypedJoin("parent", "child"))
+
+	newDir := repoRoot.UntypedJoin("parent", "new-dir")
+	err = newDir.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	expectWatchAdded(t, watchesAdded, newDir)
+}
+
+func TestPauseResume(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	c := &allEventsClient{
+		notify: ch,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	fw.Pause()
+	transientPath := repoRoot.UntypedJoin("transient")
+	err = transientPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = transientPath.Remove()
+	assert.NilError(t, err, "Remove")
+	markerPath := repoRoot.UntypedJoin("marker")
+	err = markerPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	// Wait for the marker to be held, at which point the transient file's events have been as well
+	deadline := time.Now().Add(time.Second)
+	for {
+		fw.pauseMu.Lock()
+		_, held := fw.pending.events[markerPath]
+		fw.pauseMu.Unlock()
+		if held {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for events to be held")
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+	expectNoFilesystemEvent(t, ch)
+
+	fw.Resume()
+	ev := <-ch
+	assert.Equal(t, ev.Path, markerPath)
+	assert.Equal(t, ev.EventType, FileAdded)
+	for {
+		select {
+		case ev := <-ch:
+			assert.Assert(t, ev.Path != transientPath, "got event for transient file %v", ev)
+		case <-time.After(200 * time.Millisecond):
+			return
+		}
+	}
+}
+
+type closeReasonClient struct {
+	testClient
+	closed chan error
+}
+
+func (c *closeReasonClient) OnFileWatchClosed(err error) {
+	c.closed <- err
+}
+
+func expectClosed(t *testing.T, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for filewatching to close")
+		return nil
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	// Calling Close is a clean shutdown
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	c := &closeReasonClient{closed: make(chan error, 1)}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+	err = fw.Close()
+	assert.NilError(t, err, "Close")
+	assert.NilError(t, expectClosed(t, c.closed))
+
+	// The backend going away on its own is not
+	backend = newFakeBackend()
+	fw = New(hclog.Default(), repoRoot, backend)
+	err = fw.Start()
+	assert.NilError(t, err, "Start")
+	c = &closeReasonClient{closed: make(chan error, 1)}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+	err = backend.Close()
+	assert.NilError(t, err, "Close")
+	assert.ErrorIs(t, expectClosed(t, c.closed), ErrBackendClosed)
+
+	// Adding a client afterwards fails with the same reason
+	late := &closeReasonClient{closed: make(chan error, 1)}
+	err = fw.AddClient(late)
+	assert.ErrorIs(t, err, ErrBackendClosed)
+	select {
+	case err := <-late.closed:
+		t.Fatalf("client that was never added was closed with %v", err)
+	default:
+	}
+}
+
+func TestAddClientBeforeStart(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), repoRoot, backend)
+	ch := make(chan Event, 16)
+	c := &allEventsClient{notify: ch}
+	err := fw.AddClient(c)
+	assert.ErrorIs(t, err, ErrNotStarted)
+	err = fw.AddClientThrottled(c, time.Second)
+	assert.ErrorIs(t, err, ErrNotStarted)
+	err = fw.SetClients([]FileWatchClient{c})
+	assert.ErrorIs(t, err, ErrNotStarted)
+
+	err = fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(c)
+	assert.NilError(t, err, "AddClient")
+	ev := Event{Path: repoRoot.UntypedJoin("foo"), EventType: FileAdded}
+	backend.events <- ev
+	expectFilesystemEvent(t, ch, ev)
+	// Only the client added after starting is registered
+	assert.Equal(t, len(fw.Clients()), 1)
+}
+
+func TestAddClientAfterClose(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(hclog.Default(), repoRoot, newFakeBackend())
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	err = fw.Close()
+	assert.NilError(t, err, "Close")
+
+	c := &closeReasonClient{closed: make(chan error, 1)}
+	err = fw.AddClient(c)
+	assert.ErrorIs(t, err, ErrFilewatchingClosed)
+	err = fw.AddClientWithContext(context.Background(), c)
+	assert.ErrorIs(t, err, ErrFilewatchingClosed)
+	assert.Equal(t, len(fw.Clients()), 0)
+}
@@ -0,0 +1,67 @@
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// accept returns true if the event should be delivered to clients
+func (fw *FileWatcher) accept(ev Event) bool {
+	if isState, ok := fw.isGitStateEvent(ev); ok {
+		return isState
+	}
+	if fw.isTempFile(ev.Path) {
+		return false
+	}
+	if fw.dirs != nil && !fw.dirs.isDirectoryEvent(ev) {
+		return false
+	}
+	// Directories passed to AddWatch are watched regardless of our filters
+	if !fw.isInManualWatch(ev.Path) && fw.isFilteredOut(ev) {
+		return false
+	}
+	if fw.isSuppressedModification(ev) {
+		return false
+	}
+	return true
+}
+
+// isFilteredOut returns true if the event is for a path that our filters exclude
+func (fw *FileWatcher) isFilteredOut(ev Event) bool {
+	if fw.include != nil && !fw.include.matchesEvent(ev) {
+		return true
+	}
+	if fw.tracked != nil && !fw.tracked.matchesEvent(ev) {
+		return true
+	}
+	if fw.listed != nil && !fw.listed.matchesEvent(ev) {
+		return true
+	}
+	if fw.gitignore != nil && fw.gitignore.ignoresEvent(ev) {
+		return true
+	}
+	if fw.isStopped(ev.Path) && (ev.OldPath == "" || fw.isStopped(ev.OldPath)) {
+		return true
+	}
+	return fw.isIgnoredEvent(ev)
+}
+
+// shouldWatchDir returns true if the directory passes every filter we have been configured with
+func (fw *FileWatcher) shouldWatchDir(dir turbopath.AbsoluteSystemPath) bool {
+	if watch, ok := fw.shouldWatchGitDir(dir); ok {
+		return watch
+	}
+	if !fw.withinMaxDepth(dir) {
+		return false
+	}
+	if fw.include != nil && !fw.include.shouldWatchDir(dir) {
+		return false
+	}
+	if fw.tracked != nil && !fw.tracked.shouldWatchDir(dir) {
+		return false
+	}
+	if fw.listed != nil && !fw.listed.shouldWatchDir(dir) {
+		return false
+	}
+	if fw.gitignore != nil && fw.gitignore.ignores(dir, true) {
+		return false
+	}
+	return !fw.isStopped(dir) && !fw.isIgnored(dir)
+}
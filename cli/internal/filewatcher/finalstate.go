@@ -0,0 +1,165 @@
+package filewatcher
+
+import (
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithFinalState reports each path's final state rather than every step it took to get
+// there, for tools that create and delete the same file over and over within
+// milliseconds. The first event for a path starts a window, and the events for it that
+// follow within the window are held along with it. Once the window has passed, a single
+// event is delivered that matches what is on disk: FileAdded if the path exists and
+// didn't before, FileDeleted if it existed and doesn't any more, FileModified if it
+// existed before and still does, and nothing at all if it didn't exist before and still
+// doesn't. Unlike WithBulkSuppression, this applies to every path, however quiet the
+// rest of the tree is. Renames that carry both paths are delivered as they are, after
+// anything held for either path. Every event is delivered up to window later.
+func WithFinalState(window time.Duration) Option {
+	return func(fw *FileWatcher) {
+		fw.finalState = newFinalStateTracker(window)
+	}
+}
+
+// heldPath is what we know about a path whose events are being held
+type heldPath struct {
+	// existed is set if the path existed before its first held event
+	existed  bool
+	deadline time.Time
+}
+
+// finalStateTracker holds the events for each path over a window, remembering only
+// whether it existed beforehand. It is only used by the watch loop.
+type finalStateTracker struct {
+	window time.Duration
+	held   map[turbopath.AbsoluteSystemPath]heldPath
+	// order is the held paths in the order they were first seen, which may include paths
+	// that are no longer held
+	order []turbopath.AbsoluteSystemPath
+}
+
+func newFinalStateTracker(window time.Duration) *finalStateTracker {
+	return &finalStateTracker{
+		window: window,
+		held:   make(map[turbopath.AbsoluteSystemPath]heldPath),
+	}
+}
+
+// hold holds the event, and returns true, unless it is one that we deliver as is
+func (f *finalStateTracker) hold(ev Event, now time.Time) bool {
+	if ev.OldPath != "" || (ev.EventType != FileAdded && ev.EventType != FileDeleted && ev.EventType != FileModified && ev.EventType != FileRenamed) {
+		return false
+	}
+	if _, ok := f.held[ev.Path]; ok {
+		return true
+	}
+	f.held[ev.Path] = heldPath{
+		// A path that was modified, deleted, or renamed away must have been there
+		existed:  ev.EventType != FileAdded,
+		deadline: now.Add(f.window),
+	}
+	f.order = append(f.order, ev.Path)
+	return true
+}
+
+// take stops holding path, returning whether it was held, and whether it existed
+// beforehand
+func (f *finalStateTracker) take(path turbopath.AbsoluteSystemPath) (existed bool, ok bool) {
+	h, ok := f.held[path]
+	if !ok {
+		return false, false
+	}
+	delete(f.held, path)
+	return h.existed, true
+}
+
+// nextDeadline returns when the oldest held path is due, if there is one
+func (f *finalStateTracker) nextDeadline() (time.Time, bool) {
+	var next time.Time
+	for _, h := range f.held {
+		if next.IsZero() || h.deadline.Before(next) {
+			next = h.deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// expire returns the held paths that are due, in the order they were first seen, or all
+// of them if force is set
+func (f *finalStateTracker) expire(now time.Time, force bool) []turbopath.AbsoluteSystemPath {
+	var expired []turbopath.AbsoluteSystemPath
+	remaining := f.order[:0]
+	for _, path := range f.order {
+		h, ok := f.held[path]
+		if !ok {
+			continue
+		}
+		if force || !now.Before(h.deadline) {
+			expired = append(expired, path)
+		} else {
+			remaining = append(remaining, path)
+		}
+	}
+	f.order = remaining
+	return expired
+}
+
+// finalEvent returns the event that takes a path that existed, or didn't, to its current
+// state on disk, or false if there was no net change
+func finalEvent(path turbopath.AbsoluteSystemPath, existed bool) (Event, bool) {
+	_, err := path.Lstat()
+	exists := err == nil
+	ev := Event{Path: path}
+	switch {
+	case existed && exists:
+		ev.EventType = FileModified
+	case existed:
+		ev.EventType = FileDeleted
+	case exists:
+		ev.EventType = FileAdded
+	default:
+		return Event{}, false
+	}
+	return ev, true
+}
+
+// forwardFinalState forwards the event, unless it has been held by WithFinalState. A
+// rename first delivers whatever is held for its paths.
+func (fw *FileWatcher) forwardFinalState(ev Event) {
+	if fw.finalState == nil {
+		fw.forward(ev)
+		return
+	}
+	if fw.finalState.hold(ev, time.Now()) {
+		return
+	}
+	for _, path := range []turbopath.AbsoluteSystemPath{ev.OldPath, ev.Path} {
+		if path != "" {
+			fw.releaseFinalStateOf(path)
+		}
+	}
+	fw.forward(ev)
+}
+
+// releaseFinalStateOf delivers the final state of a held path
+func (fw *FileWatcher) releaseFinalStateOf(path turbopath.AbsoluteSystemPath) {
+	existed, ok := fw.finalState.take(path)
+	if !ok {
+		return
+	}
+	if ev, changed := finalEvent(path, existed); changed {
+		fw.forward(ev)
+	}
+}
+
+// releaseFinalState delivers the final state of the held paths whose window has passed,
+// or of all of them if force is set
+func (fw *FileWatcher) releaseFinalState(now time.Time, force bool) {
+	if fw.finalState == nil {
+		return
+	}
+	for _, path := range fw.finalState.expire(now, force) {
+		fw.releaseFinalStateOf(path)
+	}
+}
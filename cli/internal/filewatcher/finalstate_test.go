@@ -0,0 +1,66 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithFinalState(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	window := 50 * time.Millisecond
+	fw := New(hclog.Default(), repoRoot, backend, WithFinalState(window))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	err = fw.AddClient(&allEventsClient{notify: ch})
+	assert.NilError(t, err, "AddClient")
+
+	// toggle creates and deletes path the given number of times, starting with a creation
+	toggle := func(path turbopath.AbsoluteSystemPath, times int) {
+		for i := 0; i < times; i++ {
+			if i%2 == 0 {
+				assert.NilError(t, path.WriteFile([]byte("hello"), 0644), "WriteFile")
+				backend.events <- Event{Path: path, EventType: FileAdded}
+			} else {
+				assert.NilError(t, path.Remove(), "Remove")
+				backend.events <- Event{Path: path, EventType: FileDeleted}
+			}
+		}
+	}
+
+	// A new file that keeps appearing and disappearing, and ends up present
+	created := Event{Path: repoRoot.UntypedJoin("created"), EventType: FileAdded}
+	start := time.Now()
+	toggle(created.Path, 7)
+	assertEvents(t, nextEvents(t, ch, 1), created)
+	assert.Assert(t, time.Since(start) >= window, "delivered before the window passed")
+	expectNoFilesystemEvent(t, ch)
+
+	// An existing file that ends up gone
+	deleted := Event{Path: created.Path, EventType: FileDeleted}
+	assert.NilError(t, deleted.Path.Remove(), "Remove")
+	backend.events <- deleted
+	toggle(deleted.Path, 3)
+	assert.NilError(t, deleted.Path.Remove(), "Remove")
+	backend.events <- deleted
+	assertEvents(t, nextEvents(t, ch, 1), deleted)
+
+	// A new file that ends up gone was never there as far as anyone can tell, while an
+	// existing one that ends up present was modified
+	transient := Event{Path: repoRoot.UntypedJoin("transient"), EventType: FileAdded}
+	toggle(transient.Path, 4)
+	existing := repoRoot.UntypedJoin("existing")
+	assert.NilError(t, existing.WriteFile([]byte("hello"), 0644), "WriteFile")
+	backend.events <- Event{Path: existing, EventType: FileModified}
+	backend.events <- Event{Path: existing, EventType: FileDeleted}
+	backend.events <- Event{Path: existing, EventType: FileAdded}
+	assertEvents(t, nextEvents(t, ch, 1), Event{Path: existing, EventType: FileModified})
+	expectNoFilesystemEvent(t, ch)
+}
@@ -0,0 +1,104 @@
+package filewatcher
+
+import "github.com/pkg/errors"
+
+// _maxHeldUntilFirstClient is how many events and errors WithBufferUntilFirstClient holds
+var _maxHeldUntilFirstClient = 4096
+
+// WithBufferUntilFirstClient holds every event and error dispatched between Start and
+// the first call to AddClient or SetClients, and delivers them to the clients that call
+// adds as soon as they are added, before anything live. This closes the gap in which
+// changes made just after Start would otherwise be seen by nobody, without requiring
+// callers to add a client before starting. Later clients only see live events.
+//
+// A few thousand events and errors are held. Beyond that, the rest are dropped, and the
+// held ones are followed by an error wrapping ErrEventsDropped and a TreeDirty event for
+// the repository root, which stands in for everything dropped. The replay happens within
+// the call to AddClient or SetClients, before it returns, so the clients' methods must
+// not call back into the watcher while handling it, for instance to add or remove clients.
+func WithBufferUntilFirstClient() Option {
+	return func(fw *FileWatcher) {
+		fw.bufferUntilFirstClient = true
+	}
+}
+
+// heldDelivery is an event, or if err is set, an error, held until the first client is added
+type heldDelivery struct {
+	ev  Event
+	err error
+}
+
+// holdUntilFirstClient buffers the event and returns true if no client has been added yet.
+// It must only be called from the watch loop, while clientsMu is held for reading.
+func (fw *FileWatcher) holdUntilFirstClient(ev Event) bool {
+	return fw.holdDeliveryUntilFirstClient(heldDelivery{ev: ev})
+}
+
+// holdErrorUntilFirstClient is holdUntilFirstClient for an error
+func (fw *FileWatcher) holdErrorUntilFirstClient(err error) bool {
+	return fw.holdDeliveryUntilFirstClient(heldDelivery{err: err})
+}
+
+// holdDeliveryUntilFirstClient buffers the delivery, or counts it as dropped if the
+// buffer is full, and returns true if no client has been added yet
+func (fw *FileWatcher) holdDeliveryUntilFirstClient(h heldDelivery) bool {
+	if !fw.bufferUntilFirstClient {
+		return false
+	}
+	if len(fw.untilFirstClient) >= _maxHeldUntilFirstClient {
+		fw.droppedUntilFirstClient++
+		return true
+	}
+	fw.untilFirstClient = append(fw.untilFirstClient, h)
+	return true
+}
+
+// replayUntilFirstClient delivers what was buffered to the first clients added, and
+// stops buffering. It returns the clients that should be evicted. Must be called while
+// clientsMu is held for writing, which keeps live events from overtaking these.
+func (fw *FileWatcher) replayUntilFirstClient(entries []*clientEntry) []*clientEntry {
+	if !fw.bufferUntilFirstClient || len(entries) == 0 {
+		return nil
+	}
+	held := fw.untilFirstClient
+	if fw.droppedUntilFirstClient > 0 {
+		root := fw.reportedRoot()
+		held = append(held, heldDelivery{
+			err: errors.Wrapf(ErrEventsDropped, "dropped %v events and errors held until the first client was added", fw.droppedUntilFirstClient),
+		}, heldDelivery{
+			ev: Event{Path: root, EventType: TreeDirty, Root: root},
+		})
+	}
+	fw.bufferUntilFirstClient = false
+	fw.untilFirstClient = nil
+	fw.droppedUntilFirstClient = 0
+	var evicted []*clientEntry
+	targets := entries
+	for _, h := range held {
+		var failed []*clientEntry
+		if h.err != nil {
+			err := h.err
+			failed = fw.deliverTo(targets, func(client FileWatchClient) {
+				client.OnFileWatchError(err)
+			})
+		} else {
+			failed = fw.deliverEvent(targets, h.ev)
+		}
+		if len(failed) > 0 {
+			evicted = append(evicted, failed...)
+			targets = withoutEntries(targets, failed)
+		}
+	}
+	return evicted
+}
+
+// withoutEntries returns the entries that aren't in removed
+func withoutEntries(entries []*clientEntry, removed []*clientEntry) []*clientEntry {
+	var kept []*clientEntry
+	for _, entry := range entries {
+		if !containsEntryFor(removed, entry.client) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,131 @@
+package filewatcher
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestBufferUntilFirstClient(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithBufferUntilFirstClient())
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	early := repoRoot.UntypedJoin("early")
+	err = early.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	// Give the event time to be dispatched to nobody
+	time.Sleep(100 * time.Millisecond)
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      early,
+		EventType: FileAdded,
+	})
+
+	// Only the first client gets what was buffered
+	late := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: late,
+	}), "AddClient")
+	expectNoFilesystemEvent(t, late)
+
+	live := repoRoot.UntypedJoin("live")
+	err = live.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      live,
+		EventType: FileAdded,
+	})
+	expectFilesystemEvent(t, late, Event{
+		Path:      live,
+		EventType: FileAdded,
+	})
+}
+
+func TestBufferUntilFirstClientsSet(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithBufferUntilFirstClient())
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	// Errors are held along with events
+	early := Event{Path: repoRoot.UntypedJoin("early"), EventType: FileAdded}
+	backend.events <- early
+	backend.errors <- errors.New("early error")
+	// Give them time to be dispatched to nobody
+	time.Sleep(100 * time.Millisecond)
+
+	// Every client registered by the first SetClients gets what was held
+	var clients []*allEventsClient
+	for i := 0; i < 2; i++ {
+		clients = append(clients, &allEventsClient{
+			notify: make(chan Event, 16),
+			errs:   make(chan error, 16),
+		})
+	}
+	err = fw.SetClients([]FileWatchClient{clients[0], clients[1]})
+	assert.NilError(t, err, "SetClients")
+	for _, c := range clients {
+		expectFilesystemEvent(t, c.notify, early)
+		select {
+		case err := <-c.errs:
+			assert.ErrorContains(t, err, "early error")
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the held error")
+		}
+	}
+}
+
+func TestBufferUntilFirstClientOverflow(t *testing.T) {
+	prevMax := _maxHeldUntilFirstClient
+	_maxHeldUntilFirstClient = 2
+	defer func() { _maxHeldUntilFirstClient = prevMax }()
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithBufferUntilFirstClient())
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	var evs []Event
+	for i := 0; i < 4; i++ {
+		ev := Event{Path: repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)), EventType: FileAdded}
+		evs = append(evs, ev)
+		backend.events <- ev
+	}
+	// Give them time to be dispatched to nobody
+	time.Sleep(100 * time.Millisecond)
+
+	// What fit is delivered, followed by an error and a TreeDirty for the rest
+	client := &allEventsClient{
+		notify: make(chan Event, 16),
+		errs:   make(chan error, 16),
+	}
+	assert.NilError(t, fw.AddClient(client), "AddClient")
+	assertEvents(t, nextEvents(t, client.notify, 3), evs[0], evs[1], Event{Path: repoRoot, EventType: TreeDirty})
+	select {
+	case err := <-client.errs:
+		assert.ErrorIs(t, err, ErrEventsDropped)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the error")
+	}
+}
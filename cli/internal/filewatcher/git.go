@@ -0,0 +1,145 @@
+package filewatcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _gitStateFiles are the files within a git directory that change when the checked-out
+// branch or the set of refs changes.
+var _gitStateFiles = map[string]struct{}{
+	"HEAD":        {},
+	"index":       {},
+	"packed-refs": {},
+}
+
+// WithLinkedGitDir makes the watcher follow a git worktree's link to the repository it
+// belongs to. In a worktree, `.git` is a file pointing at a directory inside the main
+// repository's `.git`, so branch switches don't happen anywhere that we watch. With this
+// option, if the repo root is a worktree, the worktree's git directory and the shared
+// git directory are also watched, and changes to their HEAD and packed-refs files, and to
+// the `.git` file itself, are delivered as GitStateChanged events. Without this option,
+// changes to the `.git` file are not delivered at all. It has no effect if the repo root
+// is not a worktree.
+func WithLinkedGitDir() Option {
+	return func(fw *FileWatcher) {
+		fw.watchLinkedGitDir = true
+	}
+}
+
+// worktreeGitDirs returns the git directory of the worktree at root, and the common git
+// directory it shares with the main repository. ok is false if root is not a worktree.
+func worktreeGitDirs(root turbopath.AbsoluteSystemPath) (gitDir turbopath.AbsoluteSystemPath, commonDir turbopath.AbsoluteSystemPath, ok bool, err error) {
+	dotGit := root.UntypedJoin(".git")
+	if !dotGit.FileExists() {
+		return "", "", false, nil
+	}
+	contents, err := dotGit.ReadFile()
+	if err != nil {
+		return "", "", false, err
+	}
+	line := strings.TrimSpace(string(contents))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", "", false, fmt.Errorf("%v does not point to a git directory", dotGit)
+	}
+	gitDir = resolveGitPath(root, strings.TrimSpace(strings.TrimPrefix(line, "gitdir:")))
+	commonDir = gitDir
+	if contents, err := gitDir.UntypedJoin("commondir").ReadFile(); err == nil {
+		commonDir = resolveGitPath(gitDir, strings.TrimSpace(string(contents)))
+	}
+	return gitDir, commonDir, true, nil
+}
+
+// resolveGitPath resolves a path found in a git metadata file, which may be relative to base
+func resolveGitPath(base turbopath.AbsoluteSystemPath, p string) turbopath.AbsoluteSystemPath {
+	p = filepath.FromSlash(p)
+	if filepath.IsAbs(p) {
+		return turbopath.AbsoluteSystemPath(filepath.Clean(p))
+	}
+	return base.UntypedJoin(p)
+}
+
+// resolveGitDirs finds the git directories whose state files we report: the repo root's
+// .git, and if the repo root is a worktree and WithLinkedGitDir is set, its linked git
+// directories. They are resolved before the backend starts, so that they don't change
+// while it is consulting them.
+func (fw *FileWatcher) resolveGitDirs() error {
+	if dotGit := fw.repoRoot.UntypedJoin(".git"); dotGit.DirExists() {
+		fw.gitDirs = append(fw.gitDirs, dotGit)
+	}
+	if !fw.watchLinkedGitDir {
+		return nil
+	}
+	gitDir, commonDir, ok, err := worktreeGitDirs(fw.repoRoot)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve linked git directory")
+	}
+	if !ok {
+		return nil
+	}
+	fw.gitDirs = append(fw.gitDirs, gitDir)
+	if commonDir != gitDir {
+		fw.gitDirs = append(fw.gitDirs, commonDir)
+	}
+	return nil
+}
+
+// addGitDirs watches the top level of each of the git directories. Nothing beneath it is
+// watched: objects, refs and lock files churn constantly, and none of it is reported.
+func (fw *FileWatcher) addGitDirs() error {
+	for _, dir := range fw.gitDirs {
+		fw.logger.Debug("watching git directory", _logOp, "watch", _logPath, dir)
+		if err := fw.AddRoot(dir, filepath.ToSlash(dir.UntypedJoin("*").ToString()+"/**")); err != nil {
+			return errors.Wrapf(err, "failed to watch git directory %v", dir)
+		}
+	}
+	return nil
+}
+
+// shouldWatchGitDir returns true if dir is one of the git directories, whose top level we
+// watch regardless of any other filter, and false if it is beneath one. ok is false if
+// dir is not within a git directory at all.
+func (fw *FileWatcher) shouldWatchGitDir(dir turbopath.AbsoluteSystemPath) (watch bool, ok bool) {
+	for _, gitDir := range fw.gitDirs {
+		if dir == gitDir {
+			return true, true
+		}
+		if dir.HasPrefix(gitDir) {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// isGitStateEvent returns true if the event is for one of _gitStateFiles within a git
+// directory, and false if it is for something else in that directory. ok is false if
+// the event is not within a git directory at all.
+func (fw *FileWatcher) isGitStateEvent(ev Event) (isState bool, ok bool) {
+	for _, dir := range fw.gitDirs {
+		if ev.Path.Dir() == dir {
+			_, isState := _gitStateFiles[ev.Path.Base()]
+			return isState, true
+		}
+		if ev.Path.HasPrefix(dir) {
+			return false, true
+		}
+	}
+	// In a worktree, .git is a file pointing at the git directory. Rewriting it only
+	// tells us anything if we follow where it points.
+	if ev.Path == fw.repoRoot.UntypedJoin(".git") {
+		return fw.watchLinkedGitDir, true
+	}
+	return false, false
+}
+
+// classifyGitState reports events for git's state files as GitStateChanged
+func (fw *FileWatcher) classifyGitState(ev Event) Event {
+	if isState, _ := fw.isGitStateEvent(ev); isState {
+		ev.EventType = GitStateChanged
+	}
+	return ev
+}
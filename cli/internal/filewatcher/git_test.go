@@ -0,0 +1,146 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestLinkedGitDir(t *testing.T) {
+	logger := hclog.Default()
+	tmp := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	commonDir := tmp.UntypedJoin("main", ".git")
+	gitDir := commonDir.UntypedJoin("worktrees", "wt")
+	err := gitDir.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	assert.NilError(t, gitDir.UntypedJoin("HEAD").WriteFile([]byte("ref: refs/heads/main\n"), 0644), "WriteFile")
+	assert.NilError(t, gitDir.UntypedJoin("commondir").WriteFile([]byte("../..\n"), 0644), "WriteFile")
+	assert.NilError(t, commonDir.UntypedJoin("packed-refs").WriteFile([]byte(""), 0644), "WriteFile")
+
+	// Simulate the worktree itself, whose .git is a file pointing at its git directory
+	repoRoot := tmp.UntypedJoin("wt")
+	err = repoRoot.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = repoRoot.UntypedJoin(".git").WriteFile([]byte("gitdir: "+gitDir.ToString()+"\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithLinkedGitDir())
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Unrelated git metadata stays quiet
+	err = gitDir.UntypedJoin("ORIG_HEAD").WriteFile([]byte("abc"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	head := gitDir.UntypedJoin("HEAD")
+	err = head.WriteFile([]byte("ref: refs/heads/feature\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      head,
+		EventType: GitStateChanged,
+	})
+
+	packedRefs := commonDir.UntypedJoin("packed-refs")
+	err = packedRefs.WriteFile([]byte("abc refs/heads/feature\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      packedRefs,
+		EventType: GitStateChanged,
+	})
+
+	// Repointing the worktree is a change of state too
+	dotGit := repoRoot.UntypedJoin(".git")
+	err = dotGit.WriteFile([]byte("gitdir: "+gitDir.ToString()+"\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      dotGit,
+		EventType: GitStateChanged,
+	})
+}
+
+func TestWorktreeGitFileIgnored(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	dotGit := repoRoot.UntypedJoin(".git")
+	err := dotGit.WriteFile([]byte("gitdir: /elsewhere/.git/worktrees/wt\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	err = dotGit.WriteFile([]byte("gitdir: /elsewhere/.git/worktrees/other\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	// Only the .git file itself is ignored
+	gitignore := repoRoot.UntypedJoin(".gitignore")
+	err = gitignore.WriteFile([]byte("dist\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      gitignore,
+		EventType: FileAdded,
+	})
+}
+
+func TestGitStateChanged(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin(".git", "objects").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// The rest of .git stays quiet
+	err = repoRoot.UntypedJoin(".git", "config").WriteFile([]byte("[core]"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = repoRoot.UntypedJoin(".git", "objects", "abc").WriteFile([]byte("blob"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	head := repoRoot.UntypedJoin(".git", "HEAD")
+	err = head.WriteFile([]byte("ref: refs/heads/feature\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      head,
+		EventType: GitStateChanged,
+	})
+}
+
+func TestWorktreeGitDirsNotAWorktree(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin(".git").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	_, _, ok, err := worktreeGitDirs(repoRoot)
+	assert.NilError(t, err, "worktreeGitDirs")
+	assert.Assert(t, !ok, "a .git directory is not a worktree")
+}
@@ -0,0 +1,102 @@
+package filewatcher
+
+import (
+	"path/filepath"
+	"sync"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithGitIgnore skips paths within the repo root that are ignored by a .gitignore file.
+// Each .gitignore applies only beneath the directory containing it, so that
+// apps/web/.gitignore affects apps/web and nothing else, and its patterns are relative
+// to that directory. Ignored directories aren't watched, and ignored paths produce no
+// events. A .gitignore is read again when it changes, but a directory that stops being
+// ignored is only watched once it is next created.
+func WithGitIgnore() Option {
+	return func(fw *FileWatcher) {
+		fw.gitignore = &ignoreScopes{
+			root:   fw.repoRoot,
+			scopes: make(map[turbopath.AbsoluteSystemPath]*gitignore.GitIgnore),
+		}
+	}
+}
+
+// ignoreScopes lazily loads and caches the .gitignore of each directory beneath root
+type ignoreScopes struct {
+	root turbopath.AbsoluteSystemPath
+
+	mu sync.Mutex
+	// scopes holds the compiled .gitignore of each directory we have looked at, or nil
+	// if it doesn't have one
+	scopes map[turbopath.AbsoluteSystemPath]*gitignore.GitIgnore
+}
+
+// scope returns the compiled .gitignore for dir, or nil if there isn't one
+func (s *ignoreScopes) scope(dir turbopath.AbsoluteSystemPath) *gitignore.GitIgnore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ignore, ok := s.scopes[dir]; ok {
+		return ignore
+	}
+	var ignore *gitignore.GitIgnore
+	if file := dir.UntypedJoin(".gitignore"); file.FileExists() {
+		// A .gitignore we can't read is treated as empty until it changes again
+		ignore, _ = gitignore.CompileIgnoreFile(file.ToString())
+	}
+	s.scopes[dir] = ignore
+	return ignore
+}
+
+// ignores returns true if a .gitignore in one of the path's ancestors ignores it
+func (s *ignoreScopes) ignores(p turbopath.AbsoluteSystemPath, isDir bool) bool {
+	if p == s.root || !p.HasPrefix(s.root) {
+		return false
+	}
+	for dir := p.Dir(); dir.HasPrefix(s.root); dir = dir.Dir() {
+		if ignore := s.scope(dir); ignore != nil {
+			rel, err := p.RelativeTo(dir)
+			if err != nil {
+				return false
+			}
+			name := filepath.ToSlash(rel.ToString())
+			if isDir {
+				name += "/"
+			}
+			if ignore.MatchesPath(name) {
+				return true
+			}
+		}
+		if dir == s.root {
+			break
+		}
+	}
+	return false
+}
+
+// ignoresEvent returns true if both the current and any previous path of the event are ignored
+func (s *ignoreScopes) ignoresEvent(ev Event) bool {
+	return s.ignores(ev.Path, false) && (ev.OldPath == "" || s.ignores(ev.OldPath, false))
+}
+
+// onEvent forgets what we know about the .gitignore the event is for, if it is for one
+func (s *ignoreScopes) onEvent(ev Event) bool {
+	if ev.Path.Base() != ".gitignore" && (ev.OldPath == "" || ev.OldPath.Base() != ".gitignore") {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scopes, ev.Path.Dir())
+	if ev.OldPath != "" {
+		delete(s.scopes, ev.OldPath.Dir())
+	}
+	return true
+}
+
+// reloadIgnores forgets a changed .gitignore so that it is read again when next needed
+func (fw *FileWatcher) reloadIgnores(ev Event) {
+	if fw.gitignore != nil && fw.gitignore.onEvent(ev) {
+		fw.logger.Debug("reloading ignore rules", _logPath, ev.Path, _logEventType, ev.EventType)
+	}
+}
@@ -0,0 +1,73 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithGitIgnoreScopes(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	web := repoRoot.UntypedJoin("apps", "web")
+	docs := repoRoot.UntypedJoin("apps", "docs")
+	for _, pkg := range []string{"web", "docs"} {
+		err := repoRoot.UntypedJoin("apps", pkg, "dist").MkdirAll(0775)
+		assert.NilError(t, err, "MkdirAll")
+	}
+	// Each package ignores something different
+	err := web.UntypedJoin(".gitignore").WriteFile([]byte("dist/\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = docs.UntypedJoin(".gitignore").WriteFile([]byte("*.log\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithGitIgnore())
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	err = web.UntypedJoin("dist", "index.js").WriteFile([]byte("ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = docs.UntypedJoin("debug.log").WriteFile([]byte("ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	// Each package's rules don't apply to the other
+	docsOutput := docs.UntypedJoin("dist", "index.js")
+	err = docsOutput.WriteFile([]byte("not ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      docsOutput,
+		EventType: FileAdded,
+	})
+	webLog := web.UntypedJoin("debug.log")
+	err = webLog.WriteFile([]byte("not ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      webLog,
+		EventType: FileAdded,
+	})
+
+	// Rules are read again when they change
+	err = docs.UntypedJoin(".gitignore").WriteFile([]byte("\n"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      docs.UntypedJoin(".gitignore"),
+		EventType: FileModified,
+	})
+	docsLog := docs.UntypedJoin("debug.log")
+	err = docsLog.WriteFile([]byte("no longer ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      docsLog,
+		EventType: FileModified,
+	})
+}
@@ -0,0 +1,172 @@
+package filewatcher
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithGitTrackedOnly restricts watching the repo root to the files that git tracks,
+// meaning the files in its index. Only directories containing tracked files are watched,
+// and changes to untracked files produce no events. The set of tracked files is listed
+// with `git ls-files` in Start, and listed again whenever the index changes. Additional
+// roots, such as a cookie directory, are unaffected.
+func WithGitTrackedOnly() Option {
+	return func(fw *FileWatcher) {
+		fw.tracked = &trackedFilter{
+			root:      fw.repoRoot,
+			refreshes: make(chan struct{}, 1),
+		}
+	}
+}
+
+// trackedFilter is an allowlist of the files git tracks beneath root
+type trackedFilter struct {
+	root turbopath.AbsoluteSystemPath
+
+	// refreshes holds a pending request to list the tracked files again. Requests made
+	// while one is pending are merged into it.
+	refreshes chan struct{}
+
+	mu    sync.RWMutex
+	files map[turbopath.AbsoluteSystemPath]struct{}
+	// dirs holds every directory that contains a tracked file, however deeply
+	dirs map[turbopath.AbsoluteSystemPath]struct{}
+}
+
+// listTrackedFiles returns the absolute paths of the files in the index of the repository at root
+func listTrackedFiles(root turbopath.AbsoluteSystemPath) ([]turbopath.AbsoluteSystemPath, error) {
+	cmd := exec.Command("git", "ls-files", "-z")
+	cmd.Dir = root.ToString()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to run git ls-files in %v", root)
+	}
+	var files []turbopath.AbsoluteSystemPath
+	for _, name := range bytes.Split(out, []byte{0}) {
+		if len(name) > 0 {
+			files = append(files, root.UntypedJoin(filepath.FromSlash(string(name))))
+		}
+	}
+	return files, nil
+}
+
+// refresh lists the tracked files again, and returns the directories that contain
+// tracked files now but didn't before.
+func (t *trackedFilter) refresh() ([]turbopath.AbsoluteSystemPath, error) {
+	tracked, err := listTrackedFiles(t.root)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[turbopath.AbsoluteSystemPath]struct{}, len(tracked))
+	dirs := map[turbopath.AbsoluteSystemPath]struct{}{t.root: {}}
+	for _, file := range tracked {
+		files[file] = struct{}{}
+		for dir := file.Dir(); dir != t.root && dir.HasPrefix(t.root); dir = dir.Dir() {
+			if _, ok := dirs[dir]; ok {
+				break
+			}
+			dirs[dir] = struct{}{}
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var added []turbopath.AbsoluteSystemPath
+	if t.dirs != nil {
+		for dir := range dirs {
+			if _, ok := t.dirs[dir]; !ok {
+				added = append(added, dir)
+			}
+		}
+	}
+	t.files = files
+	t.dirs = dirs
+	return added, nil
+}
+
+// matches returns true if the path is tracked, is a directory containing tracked files,
+// or is not within the root at all.
+func (t *trackedFilter) matches(p turbopath.AbsoluteSystemPath) bool {
+	if p == t.root || !p.HasPrefix(t.root) {
+		return true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if _, ok := t.files[p]; ok {
+		return true
+	}
+	_, ok := t.dirs[p]
+	return ok
+}
+
+// matchesEvent returns true if either the current or the previous path of the event is tracked
+func (t *trackedFilter) matchesEvent(ev Event) bool {
+	return t.matches(ev.Path) || (ev.OldPath != "" && t.matches(ev.OldPath))
+}
+
+// shouldWatchDir returns true if the directory contains tracked files, or is the
+// repository's own .git directory, which we need for GitStateChanged events.
+func (t *trackedFilter) shouldWatchDir(dir turbopath.AbsoluteSystemPath) bool {
+	if dir == t.root.UntypedJoin(".git") {
+		return true
+	}
+	return t.matches(dir)
+}
+
+// requestTrackedRefresh asks the refresher to list the tracked files again, without
+// blocking. Requests made while one is already pending are dropped, so however long a
+// burst of index changes is, at most one listing follows the one in progress.
+func (fw *FileWatcher) requestTrackedRefresh() {
+	select {
+	case fw.tracked.refreshes <- struct{}{}:
+	default:
+	}
+}
+
+// refreshTrackedFiles lists the tracked files again whenever it is asked to, until the
+// watch loop exits. It runs on a goroutine of its own, started in Start, because listing
+// runs git and watching blocks on the backend.
+func (fw *FileWatcher) refreshTrackedFiles() {
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-fw.tracked.refreshes:
+			fw.refreshTracked()
+		}
+	}
+}
+
+// refreshTracked lists the tracked files again after the index changed, and watches any
+// directories that now contain tracked files. It blocks on the backend, so it must not
+// be called from the watch loop.
+func (fw *FileWatcher) refreshTracked() {
+	added, err := fw.tracked.refresh()
+	if err != nil {
+		fw.reportError(errors.Wrap(err, "failed to refresh git-tracked files"))
+		return
+	}
+	shallow, ok := fw.backend.(shallowWatchingBackend)
+	if !ok {
+		return
+	}
+	for _, dir := range added {
+		// The index can list files that have since been deleted
+		if !dir.DirExists() {
+			continue
+		}
+		fw.logger.Debug("watching newly tracked directory", _logOp, "watch", _logPath, dir)
+		if err := shallow.addShallowRoot(dir); err != nil {
+			fw.reportError(errors.Wrapf(err, "failed to watch newly tracked directory %v", dir))
+		}
+	}
+}
+
+// isIndexChange returns true if the event is a change to the repository's index
+func (fw *FileWatcher) isIndexChange(ev Event) bool {
+	return ev.EventType == GitStateChanged && ev.Path.Base() == "index"
+}
@@ -0,0 +1,97 @@
+package filewatcher
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func runGit(t *testing.T, dir turbopath.AbsoluteSystemPath, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir.ToString()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v %v", args, err, string(out))
+	}
+}
+
+func TestWithGitTrackedOnly(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	runGit(t, repoRoot, "init", "--quiet")
+	tracked := repoRoot.UntypedJoin("src", "tracked.txt")
+	err := tracked.EnsureDir()
+	assert.NilError(t, err, "EnsureDir")
+	err = tracked.WriteFile([]byte("tracked"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	runGit(t, repoRoot, "add", "src/tracked.txt")
+	generated := repoRoot.UntypedJoin("generated")
+	err = generated.Mkdir(0775)
+	assert.NilError(t, err, "Mkdir")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithGitTrackedOnly())
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Untracked files, whether alongside tracked ones or not, produce no events
+	untracked := repoRoot.UntypedJoin("src", "untracked.txt")
+	err = untracked.WriteFile([]byte("untracked"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = generated.UntypedJoin("output.js").WriteFile([]byte("generated"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	err = tracked.WriteFile([]byte("changed"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      tracked,
+		EventType: FileModified,
+	})
+
+	// Once a file is added to the index, it is watched too
+	runGit(t, repoRoot, "add", "src/untracked.txt")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err = untracked.WriteFile([]byte("now tracked"), 0644)
+		assert.NilError(t, err, "WriteFile")
+		select {
+		case ev := <-ch:
+			if ev.Path == untracked {
+				return
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for an event for a newly tracked file")
+		}
+	}
+}
+
+func TestTrackedRefreshesCoalesce(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(logger, repoRoot, newFakeBackend(), WithGitTrackedOnly())
+
+	// However many index changes arrive before the refresher gets to them, one listing is pending
+	for i := 0; i < 10; i++ {
+		fw.requestTrackedRefresh()
+	}
+	assert.Equal(t, len(fw.tracked.refreshes), 1)
+}
@@ -0,0 +1,77 @@
+package filewatcher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// _groupWindow is how long events are held for a GroupClient after the first one, before
+// being delivered in groups
+const _groupWindow = 100 * time.Millisecond
+
+// GroupClient can optionally be implemented by a FileWatchClient to be delivered events
+// in groups, as decided by WithGroupKey, rather than one at a time
+type GroupClient interface {
+	OnFileWatchGroup(key string, evs []Event)
+}
+
+// WithGroupKey groups the events delivered to each GroupClient by the key that key
+// returns for them, for instance by file extension, so that a consumer interested in
+// every change to .proto files hears about them together wherever they are. Events for
+// a GroupClient are held for a window after the first one, or for the interval of a
+// client added via AddClientThrottled, reduced to their net effect per path, and then
+// delivered with one call to OnFileWatchGroup per key, in the order that each key was
+// first seen. Events replayed by WithExistingFiles are still delivered one at a time.
+// key is called from the same goroutine as every client's callbacks, so it should be
+// quick. Clients that don't implement GroupClient are unaffected.
+func WithGroupKey(key func(ev Event) string) Option {
+	return func(fw *FileWatcher) {
+		fw.groupKey = key
+	}
+}
+
+// isGroupClient returns true if the client is to be delivered events in groups
+func (fw *FileWatcher) isGroupClient(client FileWatchClient) bool {
+	if fw.groupKey == nil {
+		return false
+	}
+	_, ok := client.(GroupClient)
+	return ok
+}
+
+// groupEvents splits evs by their group key, returning the keys in the order they were
+// first seen
+func groupEvents(evs []Event, key func(ev Event) string) ([]string, map[string][]Event) {
+	var keys []string
+	groups := make(map[string][]Event)
+	for _, ev := range evs {
+		k := key(ev)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], ev)
+	}
+	return keys, groups
+}
+
+// deliverGroupsTo delivers evs to a GroupClient in groups, and returns true if the client
+// should be evicted. Must be called while clientsMu is held for reading.
+func (fw *FileWatcher) deliverGroupsTo(entry *clientEntry, evs []Event) bool {
+	keys, groups := groupEvents(evs, fw.groupKey)
+	for _, key := range keys {
+		group := groups[key]
+		panics := entry.panics
+		evict := fw.deliverToEntry(entry, func(client FileWatchClient) {
+			client.(GroupClient).OnFileWatchGroup(key, group)
+		})
+		if entry.panics == panics {
+			atomic.AddUint64(&entry.delivered, uint64(len(group)))
+		} else {
+			atomic.AddUint64(&entry.dropped, uint64(len(group)))
+		}
+		if evict {
+			return true
+		}
+	}
+	return false
+}
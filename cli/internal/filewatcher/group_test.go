@@ -0,0 +1,84 @@
+package filewatcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+type eventGroup struct {
+	key string
+	evs []Event
+}
+
+// groupingClient is a GroupClient that sends each group it is delivered to groups
+type groupingClient struct {
+	allEventsClient
+	groups chan eventGroup
+}
+
+func (c *groupingClient) OnFileWatchGroup(key string, evs []Event) {
+	c.groups <- eventGroup{key: key, evs: evs}
+}
+
+func TestWithGroupKey(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	byExtension := func(ev Event) string {
+		return filepath.Ext(ev.Path.ToString())
+	}
+	fw := New(logger, repoRoot, backend, WithGroupKey(byExtension))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	grouping := &groupingClient{
+		allEventsClient: allEventsClient{notify: make(chan Event, 16)},
+		groups:          make(chan eventGroup, 16),
+	}
+	assert.NilError(t, fw.AddClient(grouping), "AddClient")
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: ch}), "AddClient")
+
+	evs := []Event{
+		{Path: repoRoot.UntypedJoin("api", "user.proto"), EventType: FileModified},
+		{Path: repoRoot.UntypedJoin("web", "index.ts"), EventType: FileModified},
+		{Path: repoRoot.UntypedJoin("web", "gen", "user.proto"), EventType: FileAdded},
+		{Path: repoRoot.UntypedJoin("api", "server.ts"), EventType: FileModified},
+		{Path: repoRoot.UntypedJoin("api", "order.proto"), EventType: FileModified},
+	}
+	// Pausing makes sure that they all land in the same window
+	fw.Pause()
+	for _, ev := range evs {
+		backend.events <- ev
+	}
+	fw.Resume()
+
+	// Other clients get them one at a time
+	assertEvents(t, nextEvents(t, ch, len(evs)), evs...)
+
+	var groups []eventGroup
+	timeout := time.After(time.Second)
+	for len(groups) < 2 {
+		select {
+		case group := <-grouping.groups:
+			groups = append(groups, group)
+		case <-timeout:
+			t.Fatalf("got %v groups, expected 2", len(groups))
+		}
+	}
+	assert.Equal(t, groups[0].key, ".proto")
+	assertEvents(t, groups[0].evs, evs[0], evs[2], evs[4])
+	assert.Equal(t, groups[1].key, ".ts")
+	assertEvents(t, groups[1].evs, evs[1], evs[3])
+	select {
+	case ev := <-grouping.notify:
+		t.Fatalf("group client was delivered %v on its own", ev)
+	default:
+	}
+}
@@ -0,0 +1,95 @@
+package filewatcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// ErrUnhealthy is returned by Healthcheck when filewatching is not delivering events
+var ErrUnhealthy = errors.New("filewatching is not delivering events")
+
+// _defaultHealthcheckTimeout bounds a Healthcheck whose context has no deadline
+var _defaultHealthcheckTimeout = 5 * time.Second
+
+// _probePrefix starts the name of every file written by Healthcheck
+const _probePrefix = ".turbo-healthcheck-"
+
+// WithHealthcheckDir makes Healthcheck write its probe files in dir rather than in the
+// repo root. dir must be within a watched root, for instance a cookie directory.
+func WithHealthcheckDir(dir turbopath.AbsoluteSystemPath) Option {
+	return func(fw *FileWatcher) {
+		fw.healthcheckDir = fw.toRealPath(normalizePath(dir))
+	}
+}
+
+// Healthcheck checks that filewatching is still delivering events, by writing a probe
+// file and waiting for the event for it. It returns nil if the event arrives before ctx
+// is done, or within a default of 5 seconds if ctx has no deadline. Otherwise it returns
+// an error wrapping ErrUnhealthy. The probe file is removed afterwards, and events for
+// probe files are not delivered to clients.
+func (fw *FileWatcher) Healthcheck(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, _defaultHealthcheckTimeout)
+		defer cancel()
+	}
+	return fw.awaitProbe(ctx, fw.healthcheckDir)
+}
+
+// awaitProbe writes a probe file in dir, which must be watched, and waits for its event
+// to reach the watch loop
+func (fw *FileWatcher) awaitProbe(ctx context.Context, dir turbopath.AbsoluteSystemPath) error {
+	if atomic.LoadInt32(&fw.started) == 0 {
+		return ErrNotStarted
+	}
+	select {
+	case <-fw.done:
+		return errors.Wrap(ErrUnhealthy, "filewatching has stopped")
+	default:
+	}
+	probe := dir.UntypedJoin(fmt.Sprintf("%v%v", _probePrefix, atomic.AddUint64(&fw.probeSerial, 1)))
+	seen := make(chan struct{})
+	fw.probesMu.Lock()
+	fw.probes[probe] = seen
+	fw.probesMu.Unlock()
+	defer func() {
+		fw.probesMu.Lock()
+		delete(fw.probes, probe)
+		fw.probesMu.Unlock()
+	}()
+	if err := probe.WriteFile([]byte("probe"), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write healthcheck probe %v", probe)
+	}
+	defer func() { _ = probe.Remove() }()
+	select {
+	case <-seen:
+		return nil
+	case <-fw.done:
+		return errors.Wrap(ErrUnhealthy, "filewatching has stopped")
+	case <-ctx.Done():
+		return errors.Wrapf(ErrUnhealthy, "no event for %v: %v", probe, ctx.Err())
+	}
+}
+
+// isProbe returns true if the event is for a file written by Healthcheck, or for
+// anything in SuppressDuring's marker directory, and lets the Healthcheck or
+// SuppressDuring waiting for it know that it arrived
+func (fw *FileWatcher) isProbe(ev Event) bool {
+	fw.probesMu.Lock()
+	defer fw.probesMu.Unlock()
+	ours := fw.markerDir != "" && (ev.Path == fw.markerDir || ev.Path.HasPrefix(fw.markerDir))
+	if !ours && (ev.Path.Dir() != fw.healthcheckDir || !strings.HasPrefix(ev.Path.Base(), _probePrefix)) {
+		return false
+	}
+	if seen, ok := fw.probes[ev.Path]; ok {
+		close(seen)
+		delete(fw.probes, ev.Path)
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestHealthcheck(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+
+	err = fw.Healthcheck(context.Background())
+	assert.ErrorIs(t, err, ErrNotStarted)
+
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	err = fw.Healthcheck(context.Background())
+	assert.NilError(t, err, "Healthcheck")
+	// The probe is invisible to clients, and cleaned up
+	expectNoFilesystemEvent(t, ch)
+	entries, err := os.ReadDir(repoRoot.ToString())
+	assert.NilError(t, err, "ReadDir")
+	assert.Equal(t, len(entries), 0, "probe files were left behind")
+
+	err = fw.Close()
+	assert.NilError(t, err, "Close")
+	err = fw.Healthcheck(context.Background())
+	assert.ErrorIs(t, err, ErrUnhealthy)
+}
+
+func TestHealthcheckNoEvents(t *testing.T) {
+	// The fake backend never produces events of its own, like a backend whose OS handle
+	// has silently died
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(logger, repoRoot, newFakeBackend())
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = fw.Healthcheck(ctx)
+	assert.ErrorIs(t, err, ErrUnhealthy)
+}
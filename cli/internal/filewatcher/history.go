@@ -0,0 +1,88 @@
+package filewatcher
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// _defaultReplayBuffer is how many recent events are kept for EventsSince when
+// WithReplayBuffer isn't used
+const _defaultReplayBuffer = 1024
+
+// WithReplayBuffer sets how many of the most recently dispatched events are kept for
+// EventsSince, trading memory for how far behind a reconnecting client may fall. The
+// default is 1024. With 0, no events are kept, and EventsSince always reports ErrReplayOverflow.
+func WithReplayBuffer(n int) Option {
+	return func(fw *FileWatcher) {
+		fw.history = newEventHistory(n)
+	}
+}
+
+// EventsSince returns the events dispatched after the one with the given Seq, in order,
+// so that a client that was disconnected can catch up on what it missed. Passing 0
+// asks for every event since Start. If any of the events asked for are no longer kept,
+// it returns ErrReplayOverflow, and the client should start over, for instance from a
+// Snapshot.
+func (fw *FileWatcher) EventsSince(seq uint64) ([]Event, error) {
+	return fw.history.since(seq)
+}
+
+// eventHistory is a ring buffer of the most recently dispatched events
+type eventHistory struct {
+	mu     sync.Mutex
+	events []Event
+	// start is the index of the oldest event, and count the number of events kept
+	start int
+	count int
+	// latest is the Seq of the most recent event recorded
+	latest uint64
+}
+
+func newEventHistory(size int) *eventHistory {
+	if size < 0 {
+		size = 0
+	}
+	return &eventHistory{
+		events: make([]Event, size),
+	}
+}
+
+// add records an event, displacing the oldest one if the buffer is full
+func (h *eventHistory) add(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest = ev.Seq
+	if len(h.events) == 0 {
+		return
+	}
+	if h.count < len(h.events) {
+		h.events[(h.start+h.count)%len(h.events)] = ev
+		h.count++
+		return
+	}
+	h.events[h.start] = ev
+	h.start = (h.start + 1) % len(h.events)
+}
+
+func (h *eventHistory) since(seq uint64) ([]Event, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.events) == 0 {
+		return nil, errors.Wrap(ErrReplayOverflow, "no events are kept")
+	}
+	if seq >= h.latest {
+		return nil, nil
+	}
+	if h.count == 0 || h.events[h.start].Seq > seq+1 {
+		return nil, errors.Wrapf(ErrReplayOverflow, "events after %v are no longer kept", seq)
+	}
+	var events []Event
+	for i := 0; i < h.count; i++ {
+		ev := h.events[(h.start+i)%len(h.events)]
+		if ev.Seq > seq {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,68 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestEventsSince(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithReplayBuffer(3))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	events, err := fw.EventsSince(0)
+	assert.NilError(t, err, "EventsSince")
+	assert.Equal(t, len(events), 0)
+
+	var sent []Event
+	for i := 0; i < 5; i++ {
+		ev := Event{
+			Path:      repoRoot.UntypedJoin(fmt.Sprintf("file%v", i)),
+			EventType: FileAdded,
+		}
+		backend.events <- ev
+		sent = append(sent, <-ch)
+	}
+
+	events, err = fw.EventsSince(2)
+	assert.NilError(t, err, "EventsSince")
+	assert.DeepEqual(t, events, sent[2:])
+	events, err = fw.EventsSince(4)
+	assert.NilError(t, err, "EventsSince")
+	assert.DeepEqual(t, events, sent[4:])
+	events, err = fw.EventsSince(5)
+	assert.NilError(t, err, "EventsSince")
+	assert.Equal(t, len(events), 0)
+
+	// The first two events have been displaced
+	_, err = fw.EventsSince(1)
+	assert.ErrorIs(t, err, ErrReplayOverflow)
+	_, err = fw.EventsSince(0)
+	assert.ErrorIs(t, err, ErrReplayOverflow)
+}
+
+func TestEventsSinceWithoutBuffer(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithReplayBuffer(0))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	_, err = fw.EventsSince(0)
+	assert.ErrorIs(t, err, ErrReplayOverflow)
+}
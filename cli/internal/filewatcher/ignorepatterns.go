@@ -0,0 +1,149 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/doublestar"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// rewatchingBackend is implemented by backends that install a watch per directory, and
+// can add or remove watches for part of a root after it has been added.
+type rewatchingBackend interface {
+	// watchTree watches dir and everything beneath it, without synthesizing events
+	watchTree(dir turbopath.AbsoluteSystemPath, excludePatterns ...string) error
+	// unwatchDir removes the watch on dir, and on the files directly inside it, but not
+	// on its subdirectories
+	unwatchDir(dir turbopath.AbsoluteSystemPath) error
+}
+
+// WithIgnoreCaseInsensitive sets whether the globs passed to SetIgnorePatterns match
+// paths without regard to case, so that on a case-insensitive filesystem `Dist/**`
+// ignores `dist/` as well. By default this is decided by whether the filesystem holding
+// the repo root is case-insensitive.
+func WithIgnoreCaseInsensitive(enabled bool) Option {
+	return func(fw *FileWatcher) {
+		fw.ignoreFold = enabled
+	}
+}
+
+// SetIgnorePatterns replaces the set of globs for paths within the repo root that we
+// ignore. Globs are relative to the repo root and use `/` as the separator, as with
+// WithIncludeGlobs, and a directory matching one is ignored along with everything in
+// it. Events are filtered by the new globs from the moment this is called. If the
+// watcher has started, watches are removed from newly ignored directories and installed
+// on newly un-ignored ones, which doesn't produce events for what they contain. Errors
+// doing so are delivered to clients via OnFileWatchError. Watches added via AddWatch are
+// left alone.
+func (fw *FileWatcher) SetIgnorePatterns(globs []string) {
+	fw.ignoreMu.Lock()
+	previous := fw.ignoreGlobs
+	fw.ignoreGlobs = append([]string{}, globs...)
+	fw.ignoreMu.Unlock()
+
+	rewatching, ok := fw.backend.(rewatchingBackend)
+	if !ok || atomic.LoadInt32(&fw.started) == 0 {
+		return
+	}
+	watched := fw.currentWatches()
+	isWatched := make(map[turbopath.AbsoluteSystemPath]struct{}, len(watched))
+	for _, dir := range watched {
+		isWatched[dir] = struct{}{}
+	}
+	for _, dir := range watched {
+		if fw.isIgnored(dir) && !fw.isManualWatch(dir) {
+			fw.logger.Debug("no longer watching ignored directory", _logOp, "unwatch", _logPath, dir)
+			if err := rewatching.unwatchDir(dir); err != nil {
+				fw.reportError(errors.Wrapf(err, "failed to stop watching %v", dir))
+			}
+			continue
+		}
+		// Directories that were ignored are found beneath directories that weren't
+		entries, err := os.ReadDir(dir.ToString())
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			child := dir.UntypedJoin(entry.Name())
+			if _, ok := isWatched[child]; ok || !entry.IsDir() {
+				continue
+			}
+			if !matchesIgnoreGlobs(fw.repoRoot, previous, child, fw.ignoreFold) || !fw.shouldWatchDir(child) {
+				continue
+			}
+			fw.logger.Debug("watching un-ignored directory", _logOp, "watch", _logPath, child)
+			if err := rewatching.watchTree(child, fw.excludePattern); err != nil {
+				fw.reportError(errors.Wrapf(err, "failed to watch %v", child))
+			}
+		}
+	}
+}
+
+// isIgnored returns true if the path, or one of its parents, matches one of the globs
+// passed to SetIgnorePatterns
+func (fw *FileWatcher) isIgnored(p turbopath.AbsoluteSystemPath) bool {
+	fw.ignoreMu.RLock()
+	defer fw.ignoreMu.RUnlock()
+	return matchesIgnoreGlobs(fw.repoRoot, fw.ignoreGlobs, p, fw.ignoreFold)
+}
+
+// matchesIgnoreGlobs returns true if the path, or one of its parents within root,
+// matches one of the given root-relative globs. If fold is true, case is ignored.
+func matchesIgnoreGlobs(root turbopath.AbsoluteSystemPath, globs []string, p turbopath.AbsoluteSystemPath, fold bool) bool {
+	if len(globs) == 0 || p == root || !p.HasPrefix(root) {
+		return false
+	}
+	rel, err := p.RelativeTo(root)
+	if err != nil {
+		return false
+	}
+	relName := filepath.ToSlash(rel.ToString())
+	if fold {
+		relName = strings.ToLower(relName)
+	}
+	for name := relName; name != "."; name = filepath.ToSlash(filepath.Dir(name)) {
+		for _, glob := range globs {
+			if fold {
+				glob = strings.ToLower(glob)
+			}
+			if matched, err := doublestar.Match(glob, name); err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isCaseInsensitiveFS returns true if the filesystem holding dir looks up names without
+// regard to case. We find the nearest path component with letters in it, and check
+// whether the same path with that component's case changed refers to the same file.
+func isCaseInsensitiveFS(dir turbopath.AbsoluteSystemPath) bool {
+	for p := dir.ToString(); ; p = filepath.Dir(p) {
+		parent, base := filepath.Split(p)
+		if base == "" || parent == p {
+			return false
+		}
+		swapped := strings.ToUpper(base)
+		if swapped == base {
+			swapped = strings.ToLower(base)
+		}
+		if swapped == base {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return false
+		}
+		other, err := os.Stat(filepath.Join(parent, swapped))
+		return err == nil && os.SameFile(info, other)
+	}
+}
+
+// isIgnoredEvent returns true if both the current and any previous path of the event are ignored
+func (fw *FileWatcher) isIgnoredEvent(ev Event) bool {
+	return fw.isIgnored(ev.Path) && (ev.OldPath == "" || fw.isIgnored(ev.OldPath))
+}
@@ -0,0 +1,88 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestSetIgnorePatterns(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	generated := repoRoot.UntypedJoin("generated")
+	err := generated.UntypedJoin("sub").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	src := repoRoot.UntypedJoin("src")
+	err = src.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	fw.SetIgnorePatterns([]string{"generated"})
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	deep := generated.UntypedJoin("sub", "foo")
+	err = deep.WriteFile([]byte("ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	// Un-ignoring a directory starts watching it, and ignoring one stops
+	fw.SetIgnorePatterns([]string{"src"})
+	err = deep.WriteFile([]byte("not ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      deep,
+		EventType: FileModified,
+	})
+	err = src.UntypedJoin("foo").WriteFile([]byte("ignored"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+	for _, dir := range fw.currentWatches() {
+		assert.Assert(t, !dir.HasPrefix(src), "still watching %v", dir)
+	}
+}
+
+func TestIgnoreCaseInsensitive(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	// Forced on, so that this runs on case-sensitive filesystems as well
+	fw := New(logger, repoRoot, backend, WithIgnoreCaseInsensitive(true))
+	fw.SetIgnorePatterns([]string{"Dist/**"})
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	backend.events <- Event{Path: repoRoot.UntypedJoin("dist", "index.js"), EventType: FileAdded}
+	src := Event{Path: repoRoot.UntypedJoin("src", "index.ts"), EventType: FileAdded}
+	backend.events <- src
+	expectFilesystemEvent(t, ch, src)
+
+	// Without it, only the exact case is ignored
+	fw = New(logger, repoRoot, newFakeBackend(), WithIgnoreCaseInsensitive(false))
+	fw.SetIgnorePatterns([]string{"Dist/**"})
+	assert.Assert(t, !fw.isIgnored(repoRoot.UntypedJoin("dist", "index.js")))
+	assert.Assert(t, fw.isIgnored(repoRoot.UntypedJoin("Dist", "index.js")))
+}
+
+func TestIsCaseInsensitiveFS(t *testing.T) {
+	dir := fs.AbsoluteSystemPathFromUpstream(t.TempDir()).UntypedJoin("Probe")
+	assert.NilError(t, dir.MkdirAll(0775), "MkdirAll")
+	_, err := os.Stat(filepath.Join(dir.ToString(), "..", "PROBE"))
+	assert.Equal(t, isCaseInsensitiveFS(dir), err == nil)
+}
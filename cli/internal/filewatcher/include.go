@@ -0,0 +1,103 @@
+package filewatcher
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/vercel/turbo/cli/internal/doublestar"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithIncludeGlobs restricts watching the repo root to the paths matching at least one of
+// the given globs, for instance "packages/**". Globs are relative to the repo root and use
+// `/` as the separator. Only directories that could contain a match are watched, and only
+// matching paths produce events. Additional roots, such as a cookie directory, are unaffected.
+func WithIncludeGlobs(globs ...string) Option {
+	return func(fw *FileWatcher) {
+		fw.include = &includeFilter{
+			root:  fw.repoRoot,
+			globs: globs,
+		}
+	}
+}
+
+// dirFilteringBackend is implemented by backends that install a watch per directory
+// and can skip directories that we are not interested in.
+type dirFilteringBackend interface {
+	setDirFilter(filter func(dir turbopath.AbsoluteSystemPath) bool)
+}
+
+// includeFilter is an allowlist of globs anchored at root
+type includeFilter struct {
+	root  turbopath.AbsoluteSystemPath
+	globs []string
+}
+
+// relativePath returns the `/`-separated path of p relative to the root, and
+// false if p is not within the root.
+func (i *includeFilter) relativePath(p turbopath.AbsoluteSystemPath) (string, bool) {
+	rel, err := (Event{Path: p}).RelativeTo(i.root)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(rel.ToString()), true
+}
+
+// matches returns true if the path is included, or is not within the root at all.
+func (i *includeFilter) matches(p turbopath.AbsoluteSystemPath) bool {
+	rel, ok := i.relativePath(p)
+	if !ok {
+		return true
+	}
+	for _, glob := range i.globs {
+		if matched, err := doublestar.Match(glob, rel); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesEvent returns true if either the current or the previous path of the event is included
+func (i *includeFilter) matchesEvent(ev Event) bool {
+	return i.matches(ev.Path) || (ev.OldPath != "" && i.matches(ev.OldPath))
+}
+
+// shouldWatchDir returns true if the directory could contain a path matching one of our globs
+func (i *includeFilter) shouldWatchDir(dir turbopath.AbsoluteSystemPath) bool {
+	rel, ok := i.relativePath(dir)
+	if !ok || rel == "." {
+		return true
+	}
+	segments := strings.Split(rel, "/")
+	for _, glob := range i.globs {
+		if couldContainMatch(segments, glob) {
+			return true
+		}
+	}
+	return false
+}
+
+// couldContainMatch returns true if a directory with the given path segments could
+// contain a path matching glob, by matching it against a prefix of the glob.
+func couldContainMatch(dirSegments []string, glob string) bool {
+	globSegments := strings.Split(glob, "/")
+	for i, dirSegment := range dirSegments {
+		if i >= len(globSegments) {
+			// The directory is deeper than anything the glob can match
+			return false
+		}
+		globSegment := globSegments[i]
+		if globSegment == "**" {
+			return true
+		}
+		if strings.Count(globSegment, "{") != strings.Count(globSegment, "}") {
+			// A brace expression spans a separator, we can't match segment-by-segment.
+			// Err on the side of watching.
+			return true
+		}
+		if matched, err := doublestar.Match(globSegment, dirSegment); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package filewatcher
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestCouldContainMatch(t *testing.T) {
+	testCases := []struct {
+		dir      []string
+		glob     string
+		expected bool
+	}{
+		{[]string{"packages"}, "packages/**", true},
+		{[]string{"packages", "ui", "src"}, "packages/**", true},
+		{[]string{"apps"}, "packages/**", false},
+		{[]string{"apps", "web"}, "{apps,packages}/*/src/**", true},
+		{[]string{"apps", "web", "dist"}, "{apps,packages}/*/src/**", false},
+		{[]string{"packages", "ui", "src"}, "packages/*", false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, couldContainMatch(tc.dir, tc.glob), tc.expected, "%v %v", tc.dir, tc.glob)
+	}
+}
+
+func TestIncludeGlobs(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("apps", "web").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = repoRoot.UntypedJoin("packages", "ui").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithIncludeGlobs("packages/**"))
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	c := &allEventsClient{
+		notify: ch,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	if runtime.GOOS != "darwin" {
+		watched := make(map[turbopath.AbsoluteSystemPath]struct{})
+		for _, dir := range fw.currentWatches() {
+			watched[dir] = struct{}{}
+		}
+		assert.DeepEqual(t, watched, map[turbopath.AbsoluteSystemPath]struct{}{
+			repoRoot:                               {},
+			repoRoot.UntypedJoin("packages"):       {},
+			repoRoot.UntypedJoin("packages", "ui"): {},
+		})
+	}
+
+	err = repoRoot.UntypedJoin("apps", "web", "index.ts").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = repoRoot.UntypedJoin("root-file").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	uiPath := repoRoot.UntypedJoin("packages", "ui", "index.ts")
+	err = uiPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      uiPath,
+		EventType: FileAdded,
+	})
+}
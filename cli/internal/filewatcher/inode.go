@@ -0,0 +1,33 @@
+package filewatcher
+
+// WithInodeLookup looks up the inode of events that the backend didn't set one for, by
+// checking the file as its event is processed. Backends that track the tree they watch
+// already know the inode of each file, so this only makes a difference with FSEvents,
+// at the cost of a filesystem call per event on the watch loop.
+func WithInodeLookup() Option {
+	return func(fw *FileWatcher) {
+		fw.lookupInodes = true
+	}
+}
+
+// tagInode sets the inode of events for paths that should still exist, if the backend
+// didn't and WithInodeLookup was given
+func (fw *FileWatcher) tagInode(ev Event) Event {
+	if !fw.lookupInodes || ev.Inode != 0 {
+		return ev
+	}
+	switch ev.EventType {
+	case FileAdded, FileModified, FileRenamed:
+	default:
+		return ev
+	}
+	info, err := ev.Path.Lstat()
+	if err != nil {
+		// It has already gone away again
+		return ev
+	}
+	if id, ok := fileIDOf(info); ok {
+		ev.Inode = id.ino
+	}
+	return ev
+}
@@ -0,0 +1,141 @@
+//go:build !windows
+// +build !windows
+
+package filewatcher
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestHardLinkSharesInode(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	original := repoRoot.UntypedJoin("original")
+	err := original.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	info, err := original.Lstat()
+	assert.NilError(t, err, "Lstat")
+	id, ok := fileIDOf(info)
+	assert.Assert(t, ok, "no inode for %v", original)
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	var opts []Option
+	if runtime.GOOS == "darwin" {
+		// FSEvents doesn't track the tree, so the inode has to be looked up
+		opts = append(opts, WithInodeLookup())
+	}
+	fw := New(logger, repoRoot, watcher, opts...)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	link := repoRoot.UntypedJoin("link")
+	err = os.Link(original.ToString(), link.ToString())
+	assert.NilError(t, err, "Link")
+	ev := nextEventFor(t, ch, link)
+	assert.Equal(t, ev.EventType, FileAdded)
+	assert.Equal(t, ev.Inode, id.ino)
+
+	// The link count of the original changes too, which may be reported as a
+	// modification, but nothing is reported as added a second time
+	deadline := time.After(500 * time.Millisecond)
+	for {
+		select {
+		case ev := <-ch:
+			if ev.EventType == FileAdded {
+				t.Fatalf("unexpected second add %v", ev)
+			}
+			if ev.Path == original {
+				assert.Equal(t, ev.Inode, id.ino)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func TestDeletionCarriesLastInode(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("FSEvents doesn't track the tree, so it can't tell us a deleted file's inode")
+	}
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	elsewhere := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	original := repoRoot.UntypedJoin("original")
+	err := original.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	info, err := original.Lstat()
+	assert.NilError(t, err, "Lstat")
+	id, ok := fileIDOf(info)
+	assert.Assert(t, ok, "no inode for %v", original)
+
+	watcher, err := GetPlatformSpecificBackend(logger, WithRenameWindow(50*time.Millisecond))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	err = fw.AddClient(&allEventsClient{notify: ch})
+	assert.NilError(t, err, "AddClient")
+
+	// Moved out of the tree, it is gone as far as we can tell, but we know what it was
+	outside := elsewhere.UntypedJoin("original")
+	err = original.Rename(outside)
+	assert.NilError(t, err, "Rename")
+	ev := nextEventFor(t, ch, original)
+	assert.Equal(t, ev.EventType, FileDeleted)
+	assert.Equal(t, ev.Inode, id.ino)
+
+	// Moved back in somewhere else, long after we gave up pairing it, a consumer can
+	// still tell that it is the same file
+	moved := repoRoot.UntypedJoin("moved")
+	err = outside.Rename(moved)
+	assert.NilError(t, err, "Rename")
+	ev = nextEventFor(t, ch, moved)
+	assert.Equal(t, ev.EventType, FileAdded)
+	assert.Equal(t, ev.Inode, id.ino)
+
+	// An outright deletion carries it too. The file's own watch may see its link count
+	// drop first, which is reported as a modification.
+	err = moved.Remove()
+	assert.NilError(t, err, "Remove")
+	ev = nextEventFor(t, ch, moved)
+	if ev.EventType == FileModified {
+		ev = nextEventFor(t, ch, moved)
+	}
+	assert.Equal(t, ev.EventType, FileDeleted)
+	assert.Equal(t, ev.Inode, id.ino)
+}
+
+func TestInodeLookupIsOptIn(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	file := repoRoot.UntypedJoin("file")
+	err := file.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	info, err := file.Lstat()
+	assert.NilError(t, err, "Lstat")
+	id, ok := fileIDOf(info)
+	assert.Assert(t, ok, "no inode for %v", file)
+
+	// Without the option, we don't touch the filesystem for events the backend didn't tag
+	fw := &FileWatcher{}
+	ev := fw.tagInode(Event{Path: file, EventType: FileModified})
+	assert.Equal(t, ev.Inode, uint64(0))
+
+	WithInodeLookup()(fw)
+	ev = fw.tagInode(Event{Path: file, EventType: FileModified})
+	assert.Equal(t, ev.Inode, id.ino)
+}
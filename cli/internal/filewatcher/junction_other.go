@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package filewatcher
+
+// isJunction returns true if the given path is an NTFS junction, which only exist on Windows
+func isJunction(path string) bool {
+	return false
+}
@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package filewatcher
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// isJunction returns true if the given path is an NTFS junction (a directory mount point)
+func isJunction(path string) bool {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	var data windows.Win32finddata
+	handle, err := windows.FindFirstFile(name, &data)
+	if err != nil {
+		return false
+	}
+	_ = windows.FindClose(handle)
+	// For reparse points, Reserved0 holds the reparse tag
+	return data.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0 &&
+		data.Reserved0 == windows.IO_REPARSE_TAG_MOUNT_POINT
+}
@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package filewatcher
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestFileWatchingThroughJunction(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	target := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := target.UntypedJoin("nested").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	link := repoRoot.UntypedJoin("link")
+	out, err := exec.Command("cmd", "/c", "mklink", "/J", link.ToString(), target.ToString()).CombinedOutput()
+	assert.NilError(t, err, "mklink: %v", string(out))
+	assert.Assert(t, isJunction(link.ToString()), "expected %v to be a junction", link)
+	// A junction back to the root must not cause an infinite walk
+	loop := target.UntypedJoin("nested", "loop")
+	out, err = exec.Command("cmd", "/c", "mklink", "/J", loop.ToString(), target.ToString()).CombinedOutput()
+	assert.NilError(t, err, "mklink: %v", string(out))
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+
+	ch := make(chan Event, 16)
+	c := &allEventsClient{
+		notify: ch,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	// Write via the target, but expect the event to be reported via the junction
+	err = target.UntypedJoin("nested", "foo").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      link.UntypedJoin("nested", "foo"),
+		EventType: FileAdded,
+	})
+}
@@ -0,0 +1,75 @@
+package filewatcher
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithMaxFileSize suppresses FileModified events for files larger than the given number of
+// bytes, for repositories with large committed assets. Other events for those files, such as
+// their creation or deletion, are still delivered, and directories are watched as usual.
+func WithMaxFileSize(bytes int64) Option {
+	return func(fw *FileWatcher) {
+		fw.maxFileSize = bytes
+	}
+}
+
+// WithIgnoreBinary suppresses FileModified events for files that look like binaries, either
+// by their extension or because their contents contain a NUL byte. Other events for those
+// files are still delivered, and directories are watched as usual.
+func WithIgnoreBinary(ignore bool) Option {
+	return func(fw *FileWatcher) {
+		fw.ignoreBinary = ignore
+	}
+}
+
+// _binaryExtensions are extensions that we treat as binary without looking at the contents
+var _binaryExtensions = map[string]struct{}{
+	".png": {}, ".jpg": {}, ".jpeg": {}, ".gif": {}, ".ico": {}, ".webp": {},
+	".mp3": {}, ".mp4": {}, ".mov": {}, ".wav": {}, ".avi": {},
+	".zip": {}, ".gz": {}, ".tgz": {}, ".tar": {}, ".7z": {},
+	".pdf": {}, ".woff": {}, ".woff2": {}, ".ttf": {}, ".otf": {},
+	".so": {}, ".dylib": {}, ".dll": {}, ".exe": {}, ".wasm": {},
+}
+
+// _binarySniffLen is how much of a file we read when sniffing for binary content.
+// It matches the amount git looks at.
+const _binarySniffLen = 8000
+
+// isSuppressedModification returns true if the event is a modification of a file that
+// is too large, or is a binary that we've been asked to ignore.
+func (fw *FileWatcher) isSuppressedModification(ev Event) bool {
+	if ev.EventType != FileModified || (fw.maxFileSize <= 0 && !fw.ignoreBinary) {
+		return false
+	}
+	info, err := ev.Path.Lstat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if fw.maxFileSize > 0 && info.Size() > fw.maxFileSize {
+		return true
+	}
+	return fw.ignoreBinary && isBinaryFile(ev.Path)
+}
+
+// isBinaryFile returns true if the file at path has a binary extension, or if its first
+// _binarySniffLen bytes contain a NUL byte.
+func isBinaryFile(path turbopath.AbsoluteSystemPath) bool {
+	if _, ok := _binaryExtensions[strings.ToLower(path.Ext())]; ok {
+		return true
+	}
+	f, err := path.Open()
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	buf := make([]byte, _binarySniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false
+	}
+	return bytes.IndexByte(buf[:n], 0) != -1
+}
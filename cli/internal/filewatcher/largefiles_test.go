@@ -0,0 +1,72 @@
+package filewatcher
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestMaxFileSize(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	largePath := repoRoot.UntypedJoin("dataset.csv")
+	smallPath := repoRoot.UntypedJoin("index.ts")
+	assert.NilError(t, largePath.WriteFile([]byte("a,b\n"), 0644), "WriteFile")
+	assert.NilError(t, smallPath.WriteFile([]byte("export {}\n"), 0644), "WriteFile")
+
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithMaxFileSize(1024))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	assert.NilError(t, largePath.WriteFile(bytes.Repeat([]byte("a,b\n"), 1024), 0644), "WriteFile")
+	backend.events <- Event{Path: largePath, EventType: FileModified}
+	assert.NilError(t, smallPath.WriteFile([]byte("export const a = 1\n"), 0644), "WriteFile")
+	smallEvent := Event{Path: smallPath, EventType: FileModified}
+	backend.events <- smallEvent
+
+	ev := <-ch
+	assert.Assert(t, ev.Equal(smallEvent), "expected only the small file's event, got %v", ev)
+}
+
+func TestIgnoreBinary(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	imagePath := repoRoot.UntypedJoin("logo.png")
+	blobPath := repoRoot.UntypedJoin("blob")
+	sourcePath := repoRoot.UntypedJoin("index.ts")
+	assert.NilError(t, imagePath.WriteFile([]byte("not really a png"), 0644), "WriteFile")
+	assert.NilError(t, blobPath.WriteFile([]byte{0x7f, 'E', 'L', 'F', 0, 0, 1}, 0644), "WriteFile")
+	assert.NilError(t, sourcePath.WriteFile([]byte("export {}\n"), 0644), "WriteFile")
+
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithIgnoreBinary(true))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	backend.events <- Event{Path: imagePath, EventType: FileModified}
+	backend.events <- Event{Path: blobPath, EventType: FileModified}
+	// Only modifications are suppressed
+	deleted := Event{Path: imagePath, EventType: FileDeleted}
+	backend.events <- deleted
+	sourceEvent := Event{Path: sourcePath, EventType: FileModified}
+	backend.events <- sourceEvent
+
+	assert.Assert(t, (<-ch).Equal(deleted))
+	assert.Assert(t, (<-ch).Equal(sourceEvent))
+}
@@ -0,0 +1,42 @@
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// WithoutInitialScan skips the recursive walk of the repo root in Start. Only the root
+// itself is watched up front, and watches for subdirectories are installed as they are
+// created. This makes Start fast for callers that only care about future changes, at the
+// cost of missing changes within directories that already existed. Backends that watch
+// hierarchies natively, such as FSEvents, are unaffected, as are later calls to AddRoot.
+func WithoutInitialScan() Option {
+	return func(fw *FileWatcher) {
+		fw.skipInitialScan = true
+	}
+}
+
+// shallowWatchingBackend is implemented by backends that have to walk a hierarchy in order
+// to watch it, and that can instead watch just the root of it.
+type shallowWatchingBackend interface {
+	addShallowRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error
+}
+
+// addInitialRoot adds the repo root to the backend, without walking it if we were asked not to
+func (fw *FileWatcher) addInitialRoot() error {
+	if fw.listed != nil {
+		return fw.addListedPaths()
+	}
+	shallow, ok := fw.backend.(shallowWatchingBackend)
+	if !fw.skipInitialScan || !ok {
+		return fw.AddRoot(fw.repoRoot, fw.excludePattern)
+	}
+	if err := shallow.addShallowRoot(fw.repoRoot, fw.excludePattern); err != nil {
+		return err
+	}
+	fw.rootsMu.Lock()
+	defer fw.rootsMu.Unlock()
+	fw.roots = append(fw.roots, watchRoot{
+		path:            fw.repoRoot,
+		excludePatterns: []string{fw.excludePattern},
+		shallow:         true,
+	})
+	return nil
+}
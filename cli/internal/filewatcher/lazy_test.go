@@ -0,0 +1,64 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithoutInitialScan(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	for i := 0; i < 50; i++ {
+		for j := 0; j < 20; j++ {
+			err := repoRoot.UntypedJoin(fmt.Sprintf("dir-%v", i), fmt.Sprintf("sub-%v", j)).MkdirAll(0775)
+			assert.NilError(t, err, "MkdirAll")
+		}
+	}
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithoutInitialScan())
+	start := time.Now()
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	assert.Assert(t, time.Since(start) < time.Second, "Start took %v", time.Since(start))
+
+	// None of the existing directories were walked
+	watches := fw.currentWatches()
+	assert.DeepEqual(t, watches, []turbopath.AbsoluteSystemPath{repoRoot})
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// A new directory is watched as soon as it is created
+	newDir := repoRoot.UntypedJoin("new")
+	err = newDir.Mkdir(0775)
+	assert.NilError(t, err, "Mkdir")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      newDir,
+		EventType: FileAdded,
+	})
+	deepDir := newDir.UntypedJoin("deep")
+	err = deepDir.Mkdir(0775)
+	assert.NilError(t, err, "Mkdir")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      deepDir,
+		EventType: FileAdded,
+	})
+	filePath := deepDir.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+}
@@ -0,0 +1,103 @@
+package filewatcher
+
+import (
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// NewForPaths returns a FileWatcher that watches only the given paths within the repo
+// root, rather than all of it, for consumers that already know which files and
+// directories they care about. Start doesn't walk the repo root. Instead, the directory
+// containing each path is watched on its own, and a path that is a directory is watched
+// along with everything beneath it. Only changes to the listed paths, and to anything
+// beneath a listed directory, produce events, including a listed path being created or
+// deleted. The directories containing the paths must exist when Start is called. With
+// backends that can't watch a directory without walking it, like FSEvents, the repo root
+// is watched as usual, and changes elsewhere are dropped.
+func NewForPaths(logger hclog.Logger, repoRoot turbopath.AbsoluteSystemPath, paths []turbopath.AbsoluteSystemPath, backend Backend, opts ...Option) *FileWatcher {
+	fw := New(logger, repoRoot, backend, opts...)
+	listed := make(map[turbopath.AbsoluteSystemPath]struct{}, len(paths))
+	for _, p := range paths {
+		listed[fw.toRealPath(normalizePath(p))] = struct{}{}
+	}
+	fw.listed = &listedFilter{
+		root:  fw.repoRoot,
+		paths: listed,
+	}
+	return fw
+}
+
+// listedFilter is an allowlist of paths beneath root, given to NewForPaths
+type listedFilter struct {
+	root  turbopath.AbsoluteSystemPath
+	paths map[turbopath.AbsoluteSystemPath]struct{}
+}
+
+// matches returns true if the path is listed, is beneath a listed directory, or is not
+// within the root at all
+func (l *listedFilter) matches(p turbopath.AbsoluteSystemPath) bool {
+	if !p.HasPrefix(l.root) {
+		return true
+	}
+	if _, ok := l.paths[p]; ok {
+		return true
+	}
+	return hasAncestorIn(p, l.paths)
+}
+
+// matchesEvent returns true if either the current or the previous path of the event is listed
+func (l *listedFilter) matchesEvent(ev Event) bool {
+	return l.matches(ev.Path) || (ev.OldPath != "" && l.matches(ev.OldPath))
+}
+
+// shouldWatchDir returns true if the directory is listed, beneath a listed directory, or
+// contains a listed path. Other directories created within the ones we watch are skipped.
+func (l *listedFilter) shouldWatchDir(dir turbopath.AbsoluteSystemPath) bool {
+	if l.matches(dir) {
+		return true
+	}
+	for p := range l.paths {
+		if p.Dir() == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// addListedPaths watches the directories containing the listed paths, and the listed
+// paths that are directories, without walking the rest of the repo root
+func (fw *FileWatcher) addListedPaths() error {
+	shallow, ok := fw.backend.(shallowWatchingBackend)
+	if !ok {
+		return fw.AddRoot(fw.repoRoot, fw.excludePattern)
+	}
+	containing := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for p := range fw.listed.paths {
+		dir := p.Dir()
+		if _, ok := containing[dir]; ok {
+			continue
+		}
+		containing[dir] = struct{}{}
+		fw.logger.Debug("watching directory containing a listed path", _logOp, "watch", _logPath, dir)
+		if err := shallow.addShallowRoot(dir, fw.excludePattern); err != nil {
+			return err
+		}
+		fw.rootsMu.Lock()
+		fw.roots = append(fw.roots, watchRoot{
+			path:            dir,
+			excludePatterns: []string{fw.excludePattern},
+			shallow:         true,
+		})
+		fw.rootsMu.Unlock()
+	}
+	for p := range fw.listed.paths {
+		if !p.DirExists() {
+			// If it is created later, it is watched then
+			continue
+		}
+		if err := fw.AddRoot(p, fw.excludePattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
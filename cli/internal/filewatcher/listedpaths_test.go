@@ -0,0 +1,62 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewForPaths(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	src := repoRoot.UntypedJoin("src")
+	docs := repoRoot.UntypedJoin("docs")
+	unrelated := repoRoot.UntypedJoin("unrelated")
+	for _, dir := range []turbopath.AbsoluteSystemPath{src, docs, unrelated} {
+		assert.NilError(t, dir.MkdirAll(0775), "MkdirAll")
+	}
+	index := src.UntypedJoin("index.ts")
+	other := src.UntypedJoin("other.ts")
+	for _, file := range []turbopath.AbsoluteSystemPath{index, other} {
+		assert.NilError(t, file.WriteFile([]byte("export {}\n"), 0644), "WriteFile")
+	}
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := NewForPaths(logger, repoRoot, []turbopath.AbsoluteSystemPath{index, docs}, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	if _, ok := watcher.(shallowWatchingBackend); ok {
+		for _, dir := range fw.currentWatches() {
+			assert.Assert(t, !dir.HasPrefix(unrelated), "watching %v", dir)
+		}
+	}
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Changes elsewhere, including next to a listed file, don't produce events
+	assert.NilError(t, unrelated.UntypedJoin("foo").WriteFile([]byte("hello"), 0644), "WriteFile")
+	assert.NilError(t, other.WriteFile([]byte("export const a = 1\n"), 0644), "WriteFile")
+	assert.NilError(t, index.WriteFile([]byte("export const a = 1\n"), 0644), "WriteFile")
+	ev := nextEvents(t, ch, 1)[0]
+	assert.Equal(t, ev.Path, index)
+
+	// Everything beneath a listed directory does
+	nested := docs.UntypedJoin("guides", "intro.md")
+	assert.NilError(t, nested.EnsureDir(), "EnsureDir")
+	assert.NilError(t, nested.WriteFile([]byte("hello"), 0644), "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: nested, EventType: FileAdded})
+	assert.NilError(t, unrelated.UntypedJoin("bar").WriteFile([]byte("hello"), 0644), "WriteFile")
+	done := docs.UntypedJoin("done")
+	assert.NilError(t, done.WriteFile([]byte("hello"), 0644), "WriteFile")
+	for ev := nextEvents(t, ch, 1)[0]; ev.Path != done; ev = nextEvents(t, ch, 1)[0] {
+		assert.Assert(t, ev.Path == index || ev.Path.HasPrefix(docs), "unexpected event %v", ev)
+	}
+}
@@ -0,0 +1,22 @@
+package filewatcher
+
+// The keys of the structured fields we log with, so that log aggregation can filter on them
+const (
+	// _logPath is the path an entry is about
+	_logPath = "path"
+	// _logEventType is the type of the event an entry is about
+	_logEventType = "event_type"
+	// _logOp is what we were doing, for instance "watch" or "dispatch"
+	_logOp = "op"
+	// _logBackend is the name of the backend
+	_logBackend = "backend"
+	// _logWatchedDirs is how many directories are being watched
+	_logWatchedDirs = "watched_dirs"
+	// _logClient is the name of the client an entry is about
+	_logClient = "client"
+)
+
+// namedBackend is implemented by backends that have a short name to identify them by
+type namedBackend interface {
+	name() string
+}
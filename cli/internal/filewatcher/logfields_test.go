@@ -0,0 +1,74 @@
+package filewatcher
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+// syncBuffer is a bytes.Buffer that can be written to by the logger while a test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLogsStructuredFields(t *testing.T) {
+	var out syncBuffer
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:  hclog.Trace,
+		Output: &out,
+	})
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	foo := repoRoot.UntypedJoin("foo")
+	err = foo.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	wantFields := []string{
+		"op=dispatch",
+		"path=" + foo.ToString(),
+		"event_type=" + FileAdded.String(),
+		"backend=" + backendName(watcher),
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, line := range strings.Split(out.String(), "\n") {
+			if !strings.Contains(line, "dispatching event") || !strings.Contains(line, "path="+foo.ToString()) {
+				continue
+			}
+			for _, field := range wantFields {
+				assert.Assert(t, strings.Contains(line, field), "missing %v in %v", field, line)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no dispatch log line for %v in:\n%v", foo, out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
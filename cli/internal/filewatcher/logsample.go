@@ -0,0 +1,57 @@
+package filewatcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// sampledLogger logs debug lines through logger, at most limit per second
+type sampledLogger struct {
+	logger hclog.Logger
+	limit  int
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	logged      int
+	suppressed  int
+}
+
+func newSampledLogger(logger hclog.Logger, limit int) *sampledLogger {
+	return &sampledLogger{
+		logger: logger,
+		limit:  limit,
+		now:    time.Now,
+	}
+}
+
+// Debug logs msg, with the given key-value pairs, if debug logging is enabled and we
+// haven't reached our limit for the current second.
+func (s *sampledLogger) Debug(msg string, args ...interface{}) {
+	if !s.logger.IsDebug() {
+		return
+	}
+	if s.limit <= 0 {
+		s.logger.Debug(msg, args...)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.windowStart) >= time.Second {
+		if s.suppressed > 0 {
+			s.logger.Debug("suppressed debug lines", "count", s.suppressed)
+		}
+		s.windowStart = now
+		s.logged = 0
+		s.suppressed = 0
+	}
+	if s.logged >= s.limit {
+		s.suppressed++
+		return
+	}
+	s.logged++
+	s.logger.Debug(msg, args...)
+}
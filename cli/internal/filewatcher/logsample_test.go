@@ -0,0 +1,46 @@
+package filewatcher
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"gotest.tools/v3/assert"
+)
+
+func TestSampledLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{
+		Output: &buf,
+		Level:  hclog.Debug,
+	})
+	now := time.Now()
+	s := newSampledLogger(logger, 5)
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		s.Debug("watching directory", "n", i)
+	}
+	assert.Equal(t, strings.Count(buf.String(), "watching directory"), 5)
+
+	// Once the next second begins, we summarize what we dropped and start logging again
+	now = now.Add(time.Second)
+	s.Debug("watching directory again")
+	assert.Assert(t, strings.Contains(buf.String(), "suppressed debug lines: count=95"), buf.String())
+	assert.Equal(t, strings.Count(buf.String(), "watching directory"), 6)
+}
+
+func TestSampledLoggerUnlimited(t *testing.T) {
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{
+		Output: &buf,
+		Level:  hclog.Debug,
+	})
+	s := newSampledLogger(logger, 0)
+	for i := 0; i < 100; i++ {
+		s.Debug("watching directory")
+	}
+	assert.Equal(t, strings.Count(buf.String(), "watching directory"), 100)
+}
@@ -0,0 +1,125 @@
+package filewatcher
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// AddWatch watches a single directory, but not its subdirectories, regardless of
+// whether our filters would have us watch it. Events for the directory's entries are
+// delivered even if the filters would otherwise drop them, and SetIgnorePatterns doesn't
+// stop watching it. A directory that we had stopped watching with ErrWatchUnstable is
+// watched as usual again. It returns ErrNotStarted if Start hasn't been called, and
+// ErrUnsupported if the backend doesn't watch individual directories, like FSEvents.
+func (fw *FileWatcher) AddWatch(dir turbopath.AbsoluteSystemPath) error {
+	if atomic.LoadInt32(&fw.started) == 0 {
+		return ErrNotStarted
+	}
+	shallow, ok := fw.backend.(shallowWatchingBackend)
+	if !ok {
+		return ErrUnsupported
+	}
+	dir = fw.toRealPath(normalizePath(dir))
+	if fw.rewatch != nil {
+		// Even if we had given up on it
+		fw.rewatch.forget(dir)
+	}
+	fw.manualMu.Lock()
+	fw.manualWatches[dir] = struct{}{}
+	fw.manualMu.Unlock()
+	if err := shallow.addShallowRoot(dir); err != nil {
+		fw.manualMu.Lock()
+		delete(fw.manualWatches, dir)
+		fw.manualMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// RemoveWatch stops watching a single directory, whether it was passed to AddWatch or is
+// watched automatically. Its subdirectories are still watched. It returns ErrNotStarted
+// if Start hasn't been called, and ErrUnsupported if the backend doesn't watch individual
+// directories, like FSEvents.
+func (fw *FileWatcher) RemoveWatch(dir turbopath.AbsoluteSystemPath) error {
+	if atomic.LoadInt32(&fw.started) == 0 {
+		return ErrNotStarted
+	}
+	rewatching, ok := fw.backend.(rewatchingBackend)
+	if !ok {
+		return ErrUnsupported
+	}
+	dir = fw.toRealPath(normalizePath(dir))
+	fw.manualMu.Lock()
+	delete(fw.manualWatches, dir)
+	fw.manualMu.Unlock()
+	return rewatching.unwatchDir(dir)
+}
+
+// StopWatching stops watching dir and every directory beneath it, and stops delivering
+// events for any of them, including for directories created beneath it later. Unlike
+// RemoveWatch, which only affects the one directory, it tears down the whole subtree,
+// including directories passed to AddWatch. It returns ErrNotStarted if Start hasn't been
+// called, and ErrUnsupported if the backend doesn't watch individual directories, like
+// FSEvents.
+func (fw *FileWatcher) StopWatching(dir turbopath.AbsoluteSystemPath) error {
+	if atomic.LoadInt32(&fw.started) == 0 {
+		return ErrNotStarted
+	}
+	rewatching, ok := fw.backend.(rewatchingBackend)
+	if !ok {
+		return ErrUnsupported
+	}
+	dir = fw.toRealPath(normalizePath(dir))
+	fw.manualMu.Lock()
+	fw.stoppedDirs[dir] = struct{}{}
+	for manual := range fw.manualWatches {
+		if manual == dir || manual.HasPrefix(dir) {
+			delete(fw.manualWatches, manual)
+		}
+	}
+	fw.manualMu.Unlock()
+	for _, watched := range fw.currentWatches() {
+		if watched != dir && !watched.HasPrefix(dir) {
+			continue
+		}
+		fw.logger.Debug("no longer watching directory", _logOp, "unwatch", _logPath, watched, "within", dir)
+		if err := rewatching.unwatchDir(watched); err != nil {
+			return errors.Wrapf(err, "failed to stop watching %v", watched)
+		}
+	}
+	return nil
+}
+
+// isStopped returns true if the path is, or is beneath, a directory passed to StopWatching
+func (fw *FileWatcher) isStopped(p turbopath.AbsoluteSystemPath) bool {
+	fw.manualMu.RLock()
+	defer fw.manualMu.RUnlock()
+	if len(fw.stoppedDirs) == 0 {
+		return false
+	}
+	if _, ok := fw.stoppedDirs[p]; ok {
+		return true
+	}
+	return hasAncestorIn(p, fw.stoppedDirs)
+}
+
+// isManualWatch returns true if the directory was passed to AddWatch
+func (fw *FileWatcher) isManualWatch(dir turbopath.AbsoluteSystemPath) bool {
+	fw.manualMu.RLock()
+	defer fw.manualMu.RUnlock()
+	_, ok := fw.manualWatches[dir]
+	return ok
+}
+
+// isInManualWatch returns true if the path is an entry of a directory passed to AddWatch
+func (fw *FileWatcher) isInManualWatch(p turbopath.AbsoluteSystemPath) bool {
+	fw.manualMu.RLock()
+	defer fw.manualMu.RUnlock()
+	if len(fw.manualWatches) == 0 {
+		return false
+	}
+	_, ok := fw.manualWatches[p.Dir()]
+	return ok
+}
@@ -0,0 +1,123 @@
+package filewatcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestAddWatchRemoveWatch(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	sub := repoRoot.UntypedJoin("generated", "sub")
+	err := sub.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	fw.SetIgnorePatterns([]string{"generated"})
+	err = fw.AddWatch(sub)
+	assert.ErrorIs(t, err, ErrNotStarted)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	err = fw.AddWatch(sub)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip("backend doesn't watch individual directories")
+	}
+	assert.NilError(t, err, "AddWatch")
+
+	// Events are delivered despite the directory being ignored
+	filePath := sub.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+
+	// Re-applying the ignore patterns doesn't remove the watch
+	fw.SetIgnorePatterns([]string{"generated"})
+	err = filePath.WriteFile([]byte("goodbye"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileModified,
+	})
+
+	// Let the backend finish reporting the modification before we stop watching
+	time.Sleep(100 * time.Millisecond)
+	for len(ch) > 0 {
+		<-ch
+	}
+	err = fw.RemoveWatch(sub)
+	assert.NilError(t, err, "RemoveWatch")
+	err = filePath.WriteFile([]byte("hello again"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+}
+
+func TestStopWatching(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	generated := repoRoot.UntypedJoin("generated")
+	deep := generated.UntypedJoin("a", "b")
+	err := deep.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.StopWatching(generated)
+	assert.ErrorIs(t, err, ErrNotStarted)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	err = fw.StopWatching(generated)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip("backend doesn't watch individual directories")
+	}
+	assert.NilError(t, err, "StopWatching")
+	for _, dir := range fw.currentWatches() {
+		assert.Assert(t, dir != generated && !dir.HasPrefix(generated), "still watching %v", dir)
+	}
+
+	// Nothing beneath it produces events, even in directories created afterwards
+	err = deep.UntypedJoin("foo").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = generated.UntypedJoin("foo").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = generated.UntypedJoin("c").Mkdir(0775)
+	assert.NilError(t, err, "Mkdir")
+	err = generated.UntypedJoin("c", "foo").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	sibling := repoRoot.UntypedJoin("foo")
+	err = sibling.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	assertEvents(t, nextEvents(t, ch, 1), Event{Path: sibling, EventType: FileAdded})
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case ev := <-ch:
+			assert.Equal(t, ev.Path, sibling, "unexpected event %v", ev)
+			continue
+		case <-timeout:
+		}
+		break
+	}
+}
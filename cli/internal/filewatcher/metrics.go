@@ -0,0 +1,43 @@
+package filewatcher
+
+import "time"
+
+// MetricsSink receives measurements from a FileWatcher as they happen, for forwarding
+// to a metrics system such as Prometheus or OpenTelemetry. Its methods may be called
+// concurrently, and should return quickly.
+type MetricsSink interface {
+	// IncEvents is called for every event dispatched to clients
+	IncEvents(eventType FileEvent)
+	// IncErrors is called for every error dispatched to clients
+	IncErrors()
+	// SetWatchedDirs is called with the number of watched directories whenever it changes
+	SetWatchedDirs(count int)
+	// ObserveDispatchLatency is called with how long it took to deliver an event to every client
+	ObserveDispatchLatency(d time.Duration)
+}
+
+// ClientMetricsSink can optionally be implemented by a MetricsSink to receive measurements
+// about individual clients, labelled by the name that AddNamedClient gave them, or that
+// was generated for them
+type ClientMetricsSink interface {
+	// IncClientPanics is called each time a client panics
+	IncClientPanics(client string)
+}
+
+// WithMetricsSink reports metrics to the given sink
+func WithMetricsSink(sink MetricsSink) Option {
+	return func(fw *FileWatcher) {
+		fw.metrics = sink
+	}
+}
+
+// nopMetricsSink is the MetricsSink used when none has been configured
+type nopMetricsSink struct{}
+
+func (nopMetricsSink) IncEvents(eventType FileEvent) {}
+
+func (nopMetricsSink) IncErrors() {}
+
+func (nopMetricsSink) SetWatchedDirs(count int) {}
+
+func (nopMetricsSink) ObserveDispatchLatency(d time.Duration) {}
@@ -0,0 +1,108 @@
+package filewatcher
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+type fakeMetricsSink struct {
+	mu          sync.Mutex
+	events      map[FileEvent]int
+	errors      int
+	watchedDirs int
+	latencies   int
+}
+
+func (f *fakeMetricsSink) IncEvents(eventType FileEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events[eventType]++
+}
+
+func (f *fakeMetricsSink) IncErrors() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors++
+}
+
+func (f *fakeMetricsSink) SetWatchedDirs(count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchedDirs = count
+}
+
+func (f *fakeMetricsSink) ObserveDispatchLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latencies++
+}
+
+func TestMetricsSink(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	sink := &fakeMetricsSink{
+		events: make(map[FileEvent]int),
+	}
+	fw := New(logger, repoRoot, backend, WithMetricsSink(sink))
+	ch := make(chan Event, 16)
+	errs := make(chan error, 16)
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+		errs:   errs,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	events := []Event{
+		{Path: repoRoot.UntypedJoin("foo"), EventType: FileAdded},
+		{Path: repoRoot.UntypedJoin("foo"), EventType: FileModified},
+		{Path: repoRoot.UntypedJoin("bar"), EventType: FileAdded},
+	}
+	for _, ev := range events {
+		backend.events <- ev
+		<-ch
+	}
+	backend.errors <- errors.New("some error")
+	<-errs
+
+	// Deliveries to clients happen before the sink hears about the latency, so close
+	// to be sure that the watch loop is done with every event
+	_ = fw.Close()
+	<-fw.done
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.DeepEqual(t, sink.events, map[FileEvent]int{FileAdded: 2, FileModified: 1})
+	assert.Equal(t, sink.errors, 1)
+	assert.Equal(t, sink.latencies, 3)
+}
+
+func TestMetricsSinkWatchedDirs(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("parent", "child").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	sink := &fakeMetricsSink{
+		events: make(map[FileEvent]int),
+	}
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithMetricsSink(sink))
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.Equal(t, sink.watchedDirs, len(fw.currentWatches()))
+	assert.Assert(t, sink.watchedDirs > 0)
+}
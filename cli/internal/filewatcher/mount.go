@@ -0,0 +1,110 @@
+package filewatcher
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _mountPollInterval is how often we check whether anything has been mounted or unmounted
+var _mountPollInterval = time.Second
+
+// watchMounts starts re-establishing our watches beneath any mount point within a root
+// that changes. When a filesystem is mounted over a directory we are watching, our watches
+// are left on the directories that are now hidden beneath it, and when it is unmounted,
+// the kernel drops the watches that were on it. Either way, nothing more is reported
+// from beneath the mount point, and nothing tells us so, so we poll for mount changes.
+func (fw *FileWatcher) watchMounts() {
+	if _, ok := fw.backend.(rewatchingBackend); !ok {
+		return
+	}
+	if _, ok := fw.backend.(rescanningBackend); !ok {
+		return
+	}
+	// Take the first look synchronously, so that we notice changes made as soon as Start returns
+	mounts, ok := mountPoints()
+	if !ok {
+		return
+	}
+	go fw.pollMounts(mounts, _mountPollInterval)
+}
+
+// pollMounts checks for mount changes every interval until the watch loop exits
+func (fw *FileWatcher) pollMounts(previous map[turbopath.AbsoluteSystemPath]string, interval time.Duration) {
+	rewatcher := fw.backend.(rewatchingBackend)
+	rescanner := fw.backend.(rescanningBackend)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-ticker.C:
+		}
+		current, ok := mountPoints()
+		if !ok {
+			continue
+		}
+		changed := changedMountPoints(previous, current)
+		previous = current
+		for _, mountPoint := range changed {
+			root, ok := fw.mountedWithin(mountPoint)
+			if !ok {
+				continue
+			}
+			fw.logger.Info("mounts changed, re-watching", _logOp, "rewatch", _logPath, mountPoint)
+			fw.reportError(errors.Wrapf(ErrMountChanged, "%v", mountPoint))
+			for _, dir := range fw.currentWatches() {
+				if dir.HasPrefix(mountPoint) {
+					// The watch may already be gone, which is fine
+					_ = rewatcher.unwatchDir(dir)
+				}
+			}
+			if !mountPoint.DirExists() {
+				continue
+			}
+			if err := rescanner.rescan(mountPoint, root.excludePatterns...); err != nil {
+				fw.reportError(errors.Wrapf(err, "failed to rescan %v", mountPoint))
+			}
+		}
+	}
+}
+
+// mountedWithin returns the innermost root that contains the given mount point, if we
+// would be watching the mount point
+func (fw *FileWatcher) mountedWithin(mountPoint turbopath.AbsoluteSystemPath) (watchRoot, bool) {
+	root, ok := fw.innermostRoot(mountPoint)
+	if !ok {
+		return watchRoot{}, false
+	}
+	return root, fw.shouldWatchDir(mountPoint)
+}
+
+// changedMountPoints returns the mount points that have been mounted, unmounted, or
+// mounted over, leaving out any that are beneath another one that changed
+func changedMountPoints(previous map[turbopath.AbsoluteSystemPath]string, current map[turbopath.AbsoluteSystemPath]string) []turbopath.AbsoluteSystemPath {
+	var changed []turbopath.AbsoluteSystemPath
+	for mountPoint, id := range current {
+		if previous[mountPoint] != id {
+			changed = append(changed, mountPoint)
+		}
+	}
+	for mountPoint := range previous {
+		if _, ok := current[mountPoint]; !ok {
+			changed = append(changed, mountPoint)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i] < changed[j]
+	})
+	var outermost []turbopath.AbsoluteSystemPath
+	for _, mountPoint := range changed {
+		if len(outermost) > 0 && mountPoint.HasPrefix(outermost[len(outermost)-1]) {
+			continue
+		}
+		outermost = append(outermost, mountPoint)
+	}
+	return outermost
+}
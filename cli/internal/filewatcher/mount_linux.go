@@ -0,0 +1,48 @@
+package filewatcher
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// mountPoints returns the ID of the mount at each mount point in our mount namespace.
+// If a mount point has been mounted over more than once, it is the ID of the topmost.
+func mountPoints() (map[turbopath.AbsoluteSystemPath]string, bool) {
+	contents, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, false
+	}
+	points := make(map[turbopath.AbsoluteSystemPath]string)
+	for _, line := range strings.Split(string(contents), "\n") {
+		// See proc(5): the first field is the mount ID, and the fifth is the mount point
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		points[turbopath.AbsoluteSystemPath(unescapeMountPoint(fields[4]))] = fields[0]
+	}
+	return points, true
+}
+
+// unescapeMountPoint decodes the octal escapes that mountinfo uses for whitespace and
+// backslashes in paths
+func unescapeMountPoint(escaped string) string {
+	if !strings.Contains(escaped, `\`) {
+		return escaped
+	}
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '\\' && i+4 <= len(escaped) {
+			if c, err := strconv.ParseUint(escaped[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(escaped[i])
+	}
+	return b.String()
+}
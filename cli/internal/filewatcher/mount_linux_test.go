@@ -0,0 +1,82 @@
+package filewatcher
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestRemountIsRewatched(t *testing.T) {
+	prevInterval := _mountPollInterval
+	_mountPollInterval = 20 * time.Millisecond
+	defer func() { _mountPollInterval = prevInterval }()
+
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	mountPoint := repoRoot.UntypedJoin("mnt")
+	hidden := mountPoint.UntypedJoin("hidden")
+	err := hidden.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	ch := make(chan Event, 16)
+	errs := make(chan error, 16)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+		errs:   errs,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	if err := syscall.Mount("tmpfs", mountPoint.ToString(), "tmpfs", 0, ""); err != nil {
+		t.Skipf("unable to mount a tmpfs: %v", err)
+	}
+	mounted := true
+	defer func() {
+		if mounted {
+			_ = syscall.Unmount(mountPoint.ToString(), 0)
+		}
+	}()
+	expectError(t, errs, ErrMountChanged)
+
+	// The new filesystem is watched
+	onTmpfs := mountPoint.UntypedJoin("dir")
+	err = onTmpfs.Mkdir(0775)
+	assert.NilError(t, err, "Mkdir")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      onTmpfs,
+		EventType: FileAdded,
+	})
+	filePath := onTmpfs.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+
+	err = syscall.Unmount(mountPoint.ToString(), 0)
+	assert.NilError(t, err, "Unmount")
+	mounted = false
+	expectError(t, errs, ErrMountChanged)
+	// What the mount was hiding reappears
+	expectFilesystemEvent(t, ch, Event{
+		Path:      hidden,
+		EventType: FileAdded,
+	})
+	filePath = hidden.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// mountPoints is only supported on Linux. FSEvents and ReadDirectoryChangesW keep
+// reporting across mounts, and kqueue's watches are on open files, which mounting over
+// doesn't affect.
+func mountPoints() (map[turbopath.AbsoluteSystemPath]string, bool) {
+	return nil, false
+}
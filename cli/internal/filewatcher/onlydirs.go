@@ -0,0 +1,73 @@
+package filewatcher
+
+import (
+	"sync"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithOnlyDirectories delivers only the events for directories, such as their creation,
+// deletion, and renaming, to consumers that track the structure of the repo rather than
+// its contents, like workspace discovery. Events for files are dropped. Whether a path
+// that no longer exists was a directory is decided by whether we have watched it or seen
+// it as one, so with backends that don't watch directories individually, like FSEvents,
+// the deletion of a directory we haven't seen an event for is dropped as well.
+func WithOnlyDirectories(enabled bool) Option {
+	return func(fw *FileWatcher) {
+		if enabled {
+			fw.dirs = &directorySet{
+				dirs: make(map[turbopath.AbsoluteSystemPath]struct{}),
+			}
+		} else {
+			fw.dirs = nil
+		}
+	}
+}
+
+// directorySet is the directories we know of, for WithOnlyDirectories. Watches are added
+// to it by the backend, as well as events by the watch loop.
+type directorySet struct {
+	mu   sync.Mutex
+	dirs map[turbopath.AbsoluteSystemPath]struct{}
+}
+
+func (d *directorySet) add(dir turbopath.AbsoluteSystemPath) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dirs[dir] = struct{}{}
+}
+
+// forget removes dir from the set, returning true if it was there
+func (d *directorySet) forget(dir turbopath.AbsoluteSystemPath) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.dirs[dir]
+	delete(d.dirs, dir)
+	return ok
+}
+
+// isDirectoryEvent returns true if the event is for a directory, keeping track of the
+// directories we know of as it goes
+func (d *directorySet) isDirectoryEvent(ev Event) bool {
+	if ev.EventType == FileDeleted {
+		return d.forget(ev.Path)
+	}
+	wasDir := false
+	if ev.EventType == FileRenamed {
+		if ev.OldPath != "" {
+			wasDir = d.forget(ev.OldPath)
+		} else {
+			wasDir = d.forget(ev.Path)
+		}
+	}
+	info, err := ev.Path.Lstat()
+	if err != nil {
+		// It is already gone, or it was renamed away
+		return wasDir || d.forget(ev.Path)
+	}
+	if !info.IsDir() {
+		return false
+	}
+	d.add(ev.Path)
+	return true
+}
@@ -0,0 +1,49 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithOnlyDirectories(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	existing := repoRoot.UntypedJoin("existing")
+	err := existing.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithOnlyDirectories(true))
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 64)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	sub := repoRoot.UntypedJoin("packages", "sub")
+	assert.NilError(t, sub.MkdirAll(0775), "MkdirAll")
+	assert.NilError(t, sub.UntypedJoin("package.json").WriteFile([]byte("{}"), 0644), "WriteFile")
+	assert.NilError(t, repoRoot.UntypedJoin("README.md").WriteFile([]byte("hello"), 0644), "WriteFile")
+	assert.NilError(t, existing.UntypedJoin("index.ts").WriteFile([]byte("hello"), 0644), "WriteFile")
+	assert.NilError(t, existing.UntypedJoin("index.ts").Remove(), "Remove")
+	assert.NilError(t, existing.Remove(), "Remove")
+	done := repoRoot.UntypedJoin("done")
+	assert.NilError(t, done.Mkdir(0775), "Mkdir")
+
+	var got []Event
+	for ev := nextEvents(t, ch, 1)[0]; ev.Path != done; ev = nextEvents(t, ch, 1)[0] {
+		got = append(got, ev)
+	}
+	assert.Assert(t, len(got) > 0, "expected directory events")
+	for _, ev := range got {
+		assert.Assert(t, ev.Path == sub.Dir() || ev.Path == sub || ev.Path == existing, "unexpected event %v", ev)
+	}
+	assert.Assert(t, got[len(got)-1].Equal(Event{Path: existing, EventType: FileDeleted}), "got %v", got)
+}
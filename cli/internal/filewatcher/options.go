@@ -0,0 +1,4 @@
+package filewatcher
+
+// Option configures optional behavior of a FileWatcher
+type Option func(fw *FileWatcher)
@@ -0,0 +1,41 @@
+package filewatcher
+
+import (
+	"path/filepath"
+
+	"github.com/vercel/turbo/cli/internal/doublestar"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// excludes returns true if p matches one of the root's exclude patterns. A pattern that
+// fails to parse is treated as matching, as the backends do.
+func (r watchRoot) excludes(p turbopath.AbsoluteSystemPath) bool {
+	for _, pattern := range r.excludePatterns {
+		if excluded, err := doublestar.Match(pattern, filepath.ToSlash(p.ToString())); err != nil || excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// coveringRoot returns an existing root whose watches already cover dir, if there is one.
+// Only a root that was walked in full covers the directories within it, and only if it
+// neither excludes dir nor filters it out.
+func (fw *FileWatcher) coveringRoot(dir turbopath.AbsoluteSystemPath) (watchRoot, bool) {
+	dir = normalizePath(dir)
+	fw.rootsMu.Lock()
+	var covering watchRoot
+	found := false
+	for _, root := range fw.roots {
+		if !root.shallow && dir.HasPrefix(normalizePath(root.path)) && !root.excludes(dir) {
+			covering = root
+			found = true
+			break
+		}
+	}
+	fw.rootsMu.Unlock()
+	if !found || !fw.shouldWatchDir(dir) {
+		return watchRoot{}, false
+	}
+	return covering, true
+}
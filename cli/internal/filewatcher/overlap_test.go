@@ -0,0 +1,71 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestOverlappingRoots(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	cookieDir := repoRoot.UntypedJoin(".turbo", "cookies")
+	err := cookieDir.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	// The repo root excludes node_modules, so a root within it is watched in its own right
+	vendored := repoRoot.UntypedJoin("node_modules", "vendored")
+	err = vendored.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddRoot(cookieDir)
+	assert.NilError(t, err, "AddRoot")
+	err = fw.AddRoot(vendored)
+	assert.NilError(t, err, "AddRoot")
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	cookie := cookieDir.UntypedJoin("1.cookie")
+	err = cookie.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	ev := nextEventFor(t, ch, cookie)
+	assert.Equal(t, ev.EventType, FileAdded)
+	assert.Equal(t, ev.Root, repoRoot)
+
+	timeout := time.After(500 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case ev := <-ch:
+			// Writing the file may also be reported as a modification, but it is only created once
+			assert.Assert(t, ev.Path != cookie || ev.EventType != FileAdded, "duplicate event %v", ev)
+		case <-timeout:
+			done = true
+		}
+	}
+	// The cookie directory was left to the repo root, rather than being watched again
+	fw.rootsMu.Lock()
+	roots := make([]turbopath.AbsoluteSystemPath, len(fw.roots))
+	for i, root := range fw.roots {
+		roots[i] = root.path
+	}
+	fw.rootsMu.Unlock()
+	assert.DeepEqual(t, roots, []turbopath.AbsoluteSystemPath{repoRoot, vendored})
+
+	inVendored := vendored.UntypedJoin("index.js")
+	err = inVendored.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	ev = nextEventFor(t, ch, inVendored)
+	assert.Equal(t, ev.Root, vendored)
+}
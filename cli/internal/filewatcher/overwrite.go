@@ -0,0 +1,136 @@
+package filewatcher
+
+import (
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _overwriteWindow is how long WithOverwriteAsModify holds a deletion, waiting for the
+// same path to be created again
+var _overwriteWindow = 100 * time.Millisecond
+
+// WithOverwriteAsModify chooses how a file that is deleted and then immediately created
+// again is reported, as some tools do instead of modifying it in place. If enabled, a
+// FileDeleted followed within a short window by a FileAdded for the same path is delivered
+// as a single FileModified, unless the path is now a directory. Every deletion is then
+// delivered that much later. If disabled, which is the default, the FileDeleted and
+// FileAdded are both delivered.
+func WithOverwriteAsModify(enabled bool) Option {
+	return func(fw *FileWatcher) {
+		if enabled {
+			fw.overwrites = newOverwriteDetector(_overwriteWindow)
+		} else {
+			fw.overwrites = nil
+		}
+	}
+}
+
+type heldDeletion struct {
+	ev       Event
+	deadline time.Time
+}
+
+// overwriteDetector holds deletions so that they can be combined with a creation of the
+// same path that follows. It is only used by the watch loop.
+type overwriteDetector struct {
+	window time.Duration
+	held   map[turbopath.AbsoluteSystemPath]heldDeletion
+	// order is the paths of the held deletions in the order they arrived, which may
+	// include paths that are no longer held
+	order []turbopath.AbsoluteSystemPath
+}
+
+func newOverwriteDetector(window time.Duration) *overwriteDetector {
+	return &overwriteDetector{
+		window: window,
+		held:   make(map[turbopath.AbsoluteSystemPath]heldDeletion),
+	}
+}
+
+// hold holds the event if it is a deletion. Otherwise, it returns the deletion of the
+// same path that was being held, if any.
+func (o *overwriteDetector) hold(ev Event, now time.Time) (held bool, released Event, ok bool) {
+	if ev.EventType == FileDeleted {
+		if _, ok := o.held[ev.Path]; !ok {
+			o.order = append(o.order, ev.Path)
+		}
+		o.held[ev.Path] = heldDeletion{
+			ev:       ev,
+			deadline: now.Add(o.window),
+		}
+		return true, Event{}, false
+	}
+	prev, ok := o.held[ev.Path]
+	if !ok {
+		return false, Event{}, false
+	}
+	delete(o.held, ev.Path)
+	return false, prev.ev, true
+}
+
+// nextDeadline returns when the oldest held deletion is due to be delivered, if there is one
+func (o *overwriteDetector) nextDeadline() (time.Time, bool) {
+	var next time.Time
+	for _, h := range o.held {
+		if next.IsZero() || h.deadline.Before(next) {
+			next = h.deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// expire returns the held deletions that are due, in the order they arrived, or all of
+// them if force is set
+func (o *overwriteDetector) expire(now time.Time, force bool) []Event {
+	var expired []Event
+	remaining := o.order[:0]
+	for _, path := range o.order {
+		h, ok := o.held[path]
+		if !ok {
+			continue
+		}
+		if force || !now.Before(h.deadline) {
+			delete(o.held, path)
+			expired = append(expired, h.ev)
+		} else {
+			remaining = append(remaining, path)
+		}
+	}
+	o.order = remaining
+	return expired
+}
+
+// holdForOverwrite returns true if the event is a deletion that has been held in case
+// the path is created again. A creation of a file that follows is returned as a
+// FileModified, and the held deletion is dropped. Any other event for the path delivers the held deletion
+// ahead of it.
+func (fw *FileWatcher) holdForOverwrite(ev Event) (Event, bool) {
+	if fw.overwrites == nil {
+		return ev, false
+	}
+	held, prev, ok := fw.overwrites.hold(ev, time.Now())
+	if held {
+		return ev, true
+	}
+	if ok {
+		// A directory replacing what was deleted isn't an overwrite
+		if ev.EventType == FileAdded && !ev.Path.DirExists() {
+			ev.EventType = FileModified
+		} else {
+			fw.forwardFinalState(prev)
+		}
+	}
+	return ev, false
+}
+
+// releaseOverwrites delivers the held deletions whose window has passed without the
+// path being created again, or all of them if force is set
+func (fw *FileWatcher) releaseOverwrites(now time.Time, force bool) {
+	if fw.overwrites == nil {
+		return
+	}
+	for _, ev := range fw.overwrites.expire(now, force) {
+		fw.forwardFinalState(ev)
+	}
+}
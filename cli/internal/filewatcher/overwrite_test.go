@@ -0,0 +1,75 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestOverwriteAsModify(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithOverwriteAsModify(true))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	overwritten := repoRoot.UntypedJoin("overwritten")
+	backend.events <- Event{Path: overwritten, EventType: FileDeleted}
+	backend.events <- Event{Path: overwritten, EventType: FileAdded}
+	ev := <-ch
+	assert.Equal(t, ev.Path, overwritten)
+	assert.Equal(t, ev.EventType, FileModified)
+
+	// A file replaced by a directory isn't an overwrite
+	replaced := repoRoot.UntypedJoin("replaced")
+	assert.NilError(t, replaced.Mkdir(0775), "Mkdir")
+	backend.events <- Event{Path: replaced, EventType: FileDeleted}
+	backend.events <- Event{Path: replaced, EventType: FileAdded}
+	ev = <-ch
+	assert.Assert(t, ev.Equal(Event{Path: replaced, EventType: FileDeleted}), "unexpected event %v", ev)
+	ev = <-ch
+	assert.Assert(t, ev.Equal(Event{Path: replaced, EventType: FileAdded}), "unexpected event %v", ev)
+
+	// A deletion that isn't followed by a creation is delivered once the window passes
+	deleted := repoRoot.UntypedJoin("deleted")
+	start := time.Now()
+	backend.events <- Event{Path: deleted, EventType: FileDeleted}
+	ev = <-ch
+	assert.Equal(t, ev.Path, deleted)
+	assert.Equal(t, ev.EventType, FileDeleted)
+	assert.Assert(t, time.Since(start) >= _overwriteWindow, "delivered after %v", time.Since(start))
+	expectNoFilesystemEvent(t, ch)
+}
+
+func TestOverwriteAsDeleteAndAdd(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithOverwriteAsModify(false))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	overwritten := repoRoot.UntypedJoin("overwritten")
+	backend.events <- Event{Path: overwritten, EventType: FileDeleted}
+	backend.events <- Event{Path: overwritten, EventType: FileAdded}
+	ev := <-ch
+	assert.Equal(t, ev.Path, overwritten)
+	assert.Equal(t, ev.EventType, FileDeleted)
+	ev = <-ch
+	assert.Equal(t, ev.Path, overwritten)
+	assert.Equal(t, ev.EventType, FileAdded)
+}
@@ -0,0 +1,25 @@
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// WithPackageResolver sets the Package of each delivered event to the name of the
+// workspace package that resolve says its path belongs to, so that consumers can map
+// changes to the packages they affect without walking the repository themselves.
+// resolve is called as each event is dispatched, from the same goroutine as every
+// client's callbacks, so it should be quick, for instance a lookup in a prebuilt map.
+func WithPackageResolver(resolve func(path turbopath.AbsoluteSystemPath) (pkgName string, ok bool)) Option {
+	return func(fw *FileWatcher) {
+		fw.resolvePackage = resolve
+	}
+}
+
+// packageOf returns the package that path belongs to, or "" if we have no resolver or it doesn't know
+func (fw *FileWatcher) packageOf(path turbopath.AbsoluteSystemPath) string {
+	if fw.resolvePackage == nil {
+		return ""
+	}
+	if pkgName, ok := fw.resolvePackage(path); ok {
+		return pkgName
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestPackageResolver(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	packages := map[turbopath.AbsoluteSystemPath]string{
+		repoRoot.UntypedJoin("apps", "web"):    "web",
+		repoRoot.UntypedJoin("packages", "ui"): "@acme/ui",
+	}
+	resolve := func(path turbopath.AbsoluteSystemPath) (string, bool) {
+		for dir, name := range packages {
+			if path.HasPrefix(dir) {
+				return name, true
+			}
+		}
+		return "", false
+	}
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend, WithPackageResolver(resolve))
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	for path, expected := range map[turbopath.AbsoluteSystemPath]string{
+		repoRoot.UntypedJoin("apps", "web", "src", "index.ts"): "web",
+		repoRoot.UntypedJoin("packages", "ui", "package.json"): "@acme/ui",
+		repoRoot.UntypedJoin("turbo.json"):                     "",
+	} {
+		backend.events <- Event{Path: path, EventType: FileModified}
+		ev := <-ch
+		assert.Equal(t, ev.Path, path)
+		assert.Equal(t, ev.Package, expected, "package of %v", path)
+	}
+}
@@ -0,0 +1,133 @@
+package filewatcher
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ErrClientPanicked is delivered to a client via OnFileWatchError when one of its callbacks
+// panics. After _maxClientPanics panics, it is delivered via OnFileWatchClosed instead, and
+// the client is removed.
+var ErrClientPanicked = errors.New("filewatching client panicked")
+
+// _maxClientPanics is how many times a client may panic before we stop delivering to it
+const _maxClientPanics = 3
+
+// clientEntry is a registered client, along with our bookkeeping for it
+type clientEntry struct {
+	client FileWatchClient
+	// id is assigned when the client is added, and reported by Clients, as is name
+	id   uint64
+	name string
+	// delivered and dropped count the events delivered to the client, and the ones it
+	// didn't receive, see ClientInfo.Dropped. queued is the number of events held for a
+	// throttled client, and handedOff the number of deliveries handed to the dispatch
+	// workers that haven't finished. All four are accessed atomically, since Clients
+	// reads them while the watch loop and the workers update them.
+	delivered uint64
+	dropped   uint64
+	queued    int64
+	handedOff int64
+	// panics counts how many times the client has panicked. It is only used by the watch loop.
+	panics int
+	// throttle is set for clients added via AddClientThrottled
+	throttle *clientThrottle
+}
+
+// callClient invokes call on the given client, converting a panic into an error
+func callClient(client FileWatchClient, call func(client FileWatchClient)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Wrapf(ErrClientPanicked, "%v", r)
+		}
+	}()
+	call(client)
+	return nil
+}
+
+// deliver invokes call for every client. A client that panics is told about it via
+// OnFileWatchError, and is removed once it has panicked too many times, so that one
+// misbehaving client doesn't stop the others from receiving events.
+func (fw *FileWatcher) deliver(call func(client FileWatchClient)) {
+	fw.clientsMu.RLock()
+	evicted := fw.deliverTo(fw.clients, call)
+	fw.clientsMu.RUnlock()
+	for _, entry := range evicted {
+		fw.evictClient(entry)
+	}
+}
+
+// deliverTo invokes call for each of the given clients, and returns the ones that should
+// be evicted. Must be called while clientsMu is held for reading.
+func (fw *FileWatcher) deliverTo(entries []*clientEntry, call func(client FileWatchClient)) []*clientEntry {
+	return fw.deliverEach(entries, func(entry *clientEntry) bool {
+		return fw.deliverToEntry(entry, call)
+	})
+}
+
+// deliverEventTo is deliverTo for an event, which each client counts as delivered, or
+// as dropped if it panicked. Must be called while clientsMu is held for reading.
+func (fw *FileWatcher) deliverEventTo(entries []*clientEntry, ev Event) []*clientEntry {
+	call := func(client FileWatchClient) {
+		client.OnFileWatchEvent(ev)
+	}
+	return fw.deliverEach(entries, func(entry *clientEntry) bool {
+		panics := entry.panics
+		evict := fw.deliverToEntry(entry, call)
+		if entry.panics == panics {
+			atomic.AddUint64(&entry.delivered, 1)
+		} else {
+			atomic.AddUint64(&entry.dropped, 1)
+		}
+		return evict
+	})
+}
+
+// deliverEach calls deliverOne for each of the given clients, and returns the ones for
+// which it returned true
+func (fw *FileWatcher) deliverEach(entries []*clientEntry, deliverOne func(entry *clientEntry) bool) []*clientEntry {
+	if fw.deliveries != nil && len(entries) > 1 {
+		return fw.deliverConcurrently(entries, deliverOne)
+	}
+	var evicted []*clientEntry
+	for _, entry := range entries {
+		if deliverOne(entry) {
+			evicted = append(evicted, entry)
+		}
+	}
+	return evicted
+}
+
+// deliverToEntry invokes call for a single client, and returns true if the client should
+// be evicted
+func (fw *FileWatcher) deliverToEntry(entry *clientEntry, call func(client FileWatchClient)) bool {
+	err := callClient(entry.client, call)
+	if err == nil {
+		return false
+	}
+	entry.panics++
+	fw.logger.Error("filewatching client panicked", _logOp, "dispatch", _logClient, entry.name, "panics", entry.panics, "allowed", _maxClientPanics, "error", err)
+	if sink, ok := fw.metrics.(ClientMetricsSink); ok {
+		sink.IncClientPanics(entry.name)
+	}
+	if entry.panics >= _maxClientPanics {
+		return true
+	}
+	_ = callClient(entry.client, func(client FileWatchClient) {
+		client.OnFileWatchError(err)
+	})
+	return false
+}
+
+// evictClient removes a client that has panicked too many times
+func (fw *FileWatcher) evictClient(entry *clientEntry) {
+	if !fw.removeClient(entry) {
+		return
+	}
+	err := errors.Wrapf(ErrClientPanicked, "removed after %v panics", entry.panics)
+	fw.logger.Warn("removed filewatching client", _logOp, "dispatch", _logClient, entry.name, "error", err)
+	_ = callClient(entry.client, func(client FileWatchClient) {
+		client.OnFileWatchClosed(err)
+	})
+}
@@ -0,0 +1,74 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+type panickingClient struct {
+	errs   chan error
+	closed chan error
+}
+
+func (c *panickingClient) OnFileWatchEvent(ev Event) {
+	panic("boom")
+}
+
+func (c *panickingClient) OnFileWatchError(err error) {
+	c.errs <- err
+}
+
+func (c *panickingClient) OnFileWatchClosed(err error) {
+	c.closed <- err
+}
+
+func TestClientPanicIsolation(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	panicky := &panickingClient{
+		errs:   make(chan error, _maxClientPanics),
+		closed: make(chan error, 1),
+	}
+	assert.NilError(t, fw.AddClient(panicky), "AddClient")
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	for i := 0; i < _maxClientPanics+1; i++ {
+		ev := Event{
+			Path:      repoRoot.UntypedJoin("foo"),
+			EventType: FileModified,
+		}
+		backend.events <- ev
+		expectFilesystemEvent(t, ch, ev)
+	}
+
+	for i := 0; i < _maxClientPanics-1; i++ {
+		select {
+		case err := <-panicky.errs:
+			assert.ErrorIs(t, err, ErrClientPanicked)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for panic to be reported")
+		}
+	}
+	select {
+	case err := <-panicky.closed:
+		assert.ErrorIs(t, err, ErrClientPanicked)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for panicking client to be removed")
+	}
+	fw.clientsMu.RLock()
+	defer fw.clientsMu.RUnlock()
+	assert.Equal(t, len(fw.clients), 1)
+}
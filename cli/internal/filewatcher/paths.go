@@ -0,0 +1,31 @@
+package filewatcher
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// normalizePath returns the canonical form of a path reported by a backend: cleaned of
+// redundant separators, `.` and `..` segments, and trailing separators, using the OS path
+// separator, and on Windows, with an upper case drive letter. This keeps consumers' lookups
+// keyed by path from missing.
+func normalizePath(p turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	if p == "" {
+		return p
+	}
+	cleaned := filepath.Clean(filepath.FromSlash(p.ToString()))
+	// Drive letters are case-insensitive. We use upper case, which is what os.Getwd reports.
+	if volume := filepath.VolumeName(cleaned); len(volume) == 2 && volume[1] == ':' {
+		cleaned = strings.ToUpper(volume) + cleaned[len(volume):]
+	}
+	return turbopath.AbsoluteSystemPath(cleaned)
+}
+
+// normalizeEvent normalizes every path in the event
+func normalizeEvent(ev Event) Event {
+	ev.Path = normalizePath(ev.Path)
+	ev.OldPath = normalizePath(ev.OldPath)
+	return ev
+}
@@ -0,0 +1,40 @@
+package filewatcher
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestEventPathsAreNormalized(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	ch := make(chan Event, 1)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: ch}), "AddClient")
+
+	expected := repoRoot.UntypedJoin("parent", "child", "foo")
+	messyPaths := []string{
+		repoRoot.ToString() + "/parent/child/foo",
+		repoRoot.ToString() + "/parent//child/./foo",
+		repoRoot.ToString() + "/parent/sibling/../child/foo",
+		repoRoot.ToString() + "/parent/child/foo/",
+	}
+	for _, messyPath := range messyPaths {
+		backend.events <- Event{
+			Path:      turbopath.AbsoluteSystemPath(messyPath),
+			EventType: FileRenamed,
+			OldPath:   turbopath.AbsoluteSystemPath(messyPath + "/../bar/"),
+		}
+		ev := <-ch
+		assert.Equal(t, ev.Path, expected, "normalizing %v", messyPath)
+		assert.Equal(t, ev.OldPath, repoRoot.UntypedJoin("parent", "child", "bar"), "normalizing %v", messyPath)
+		assert.Equal(t, ev.Path.ToString(), filepath.Clean(ev.Path.ToString()))
+	}
+}
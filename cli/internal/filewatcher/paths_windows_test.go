@@ -0,0 +1,37 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestWindowsPathsAreCanonical(t *testing.T) {
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), turbopath.AbsoluteSystemPath(`c:\repo`), backend)
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 1)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: ch}), "AddClient")
+
+	expected := turbopath.AbsoluteSystemPath(`C:\repo\parent\child\foo`)
+	messyPaths := []string{
+		`C:\repo\parent\child\foo`,
+		`c:\repo\parent\child\foo`,
+		`c:/repo/parent/child/foo`,
+		`C:\repo/parent\child/foo`,
+		`c:\repo\\parent\child\foo\`,
+	}
+	for _, messyPath := range messyPaths {
+		backend.events <- Event{
+			Path:      turbopath.AbsoluteSystemPath(messyPath),
+			EventType: FileModified,
+		}
+		ev := <-ch
+		assert.Equal(t, ev.Path, expected, "normalizing %v", messyPath)
+	}
+	assert.Equal(t, fw.repoRoot, turbopath.AbsoluteSystemPath(`C:\repo`))
+}
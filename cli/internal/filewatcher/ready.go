@@ -0,0 +1,91 @@
+package filewatcher
+
+import (
+	"context"
+	"sync"
+)
+
+// readyBackend is implemented by backends that can have requests to add watches in
+// flight after AddRoot returns, for instance for directories that were just created.
+type readyBackend interface {
+	// waitReady blocks until every request to add a watch that is in flight has been
+	// acknowledged by the OS, or ctx is done
+	waitReady(ctx context.Context) error
+}
+
+// activity tracks how much of some kind of work is in flight, and lets callers wait for
+// there to be none
+type activity struct {
+	mu    sync.Mutex
+	count int
+	// idle is closed when count drops to zero
+	idle chan struct{}
+}
+
+func (a *activity) begin() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.count == 0 {
+		a.idle = make(chan struct{})
+	}
+	a.count++
+}
+
+func (a *activity) end() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.count--
+	if a.count == 0 {
+		close(a.idle)
+	}
+}
+
+// wait blocks until nothing is in flight, or ctx is done
+func (a *activity) wait(ctx context.Context) error {
+	a.mu.Lock()
+	if a.count == 0 {
+		a.mu.Unlock()
+		return nil
+	}
+	idle := a.idle
+	a.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitForReady blocks until Start has finished and the backend has acknowledged every
+// watch we have asked it for, so that any change made afterwards is reported. Unlike
+// waiting for a cookie file, it doesn't write anything to the filesystem. It returns
+// ctx's error if ctx is done first, or ErrFilewatchingClosed if filewatching stops first.
+// With WithSettleWindow, it also waits for the settle window to pass.
+func (fw *FileWatcher) WaitForReady(ctx context.Context) error {
+	// The watch loop exits a little after Close returns
+	fw.closingMu.Lock()
+	closing := fw.closing
+	fw.closingMu.Unlock()
+	if closing {
+		return ErrFilewatchingClosed
+	}
+	select {
+	case <-fw.done:
+		return ErrFilewatchingClosed
+	default:
+	}
+	select {
+	case <-fw.ready:
+	case <-fw.done:
+		return ErrFilewatchingClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if backend, ok := fw.backend.(readyBackend); ok {
+		if err := backend.waitReady(ctx); err != nil {
+			return err
+		}
+	}
+	return fw.waitUntilLive(ctx)
+}
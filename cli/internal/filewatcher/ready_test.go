@@ -0,0 +1,72 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestWaitForReady(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("parent", "child").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = fw.WaitForReady(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ready := make(chan error, 1)
+	go func() {
+		ready <- fw.WaitForReady(context.Background())
+	}()
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	select {
+	case err := <-ready:
+		assert.NilError(t, err, "WaitForReady")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for readiness")
+	}
+	ch := make(chan Event, 16)
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	// Getting ready didn't leave anything behind, and didn't produce any events
+	entries, err := os.ReadDir(repoRoot.ToString())
+	assert.NilError(t, err, "ReadDir")
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Name(), "parent")
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected event %v", ev)
+	default:
+	}
+
+	// Changes made once we're ready are reported
+	filePath := repoRoot.UntypedJoin("parent", "child", "foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+
+	err = fw.Close()
+	assert.NilError(t, err, "Close")
+	err = fw.WaitForReady(context.Background())
+	assert.ErrorIs(t, err, ErrFilewatchingClosed)
+}
@@ -0,0 +1,66 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestDeepMkdirAllIsWatched(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 1000)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	const depth = 30
+	var levels []turbopath.AbsoluteSystemPath
+	dir := repoRoot
+	for i := 0; i < depth; i++ {
+		dir = dir.UntypedJoin(fmt.Sprintf("level-%v", i))
+		levels = append(levels, dir)
+	}
+	err = dir.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	// Write to the deepest level straight away, before we can have watched it
+	filePath := dir.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	pending := make(map[turbopath.AbsoluteSystemPath]struct{})
+	for _, level := range append(levels, filePath) {
+		pending[level] = struct{}{}
+	}
+	timeout := time.After(5 * time.Second)
+	for len(pending) > 0 {
+		select {
+		case ev := <-ch:
+			if ev.EventType == FileAdded {
+				delete(pending, ev.Path)
+			}
+		case <-timeout:
+			t.Fatalf("timed out with %v paths not reported as added", len(pending))
+		}
+	}
+
+	// The deepest level is watched
+	filePath = dir.UntypedJoin("bar")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+}
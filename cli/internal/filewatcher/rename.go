@@ -0,0 +1,536 @@
+package filewatcher
+
+import (
+	"container/heap"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _renameWindow is how long we hold the source half of a rename while waiting for a
+// creation of the same inode to show up, unless WithRenameWindow says otherwise.
+var _renameWindow = 100 * time.Millisecond
+
+// _renameBatchLimit is the longest we hold the source half of a rename while other events
+// keep arriving, see renameCorrelator.busy
+var _renameBatchLimit = time.Second
+
+// _maxPendingRenames is how many source halves of renames we hold at once. A large git
+// checkout can rename files faster than their other halves arrive, and beyond this, the
+// oldest half of the ones held are given up on, see renameCorrelator.removed.
+const _maxPendingRenames = 4096
+
+// _maxTrackedPaths is how many paths we remember the identity of, unless
+// WithRenameTrackingLimit says otherwise
+const _maxTrackedPaths = 128 * 1024
+
+// fileID identifies a file independently of its path
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+type pendingRename struct {
+	ev Event
+	id fileID
+	// deadline is when an unidentified rename is due. Identified renames are due
+	// according to when events last arrived, see renameCorrelator.dueAt.
+	deadline time.Time
+	// heldAt is when we started holding the event
+	heldAt time.Time
+	// seq is the number of events that had arrived when we started holding it
+	seq uint64
+	// index is the position of an identified rename in renameCorrelator.oldest
+	index int
+}
+
+// renameHeap orders held renames by when we started holding them, so the one held the
+// longest comes first. See renameCorrelator.dueAt for why that is also the one due first.
+type renameHeap []*pendingRename
+
+func (h renameHeap) Len() int           { return len(h) }
+func (h renameHeap) Less(i, j int) bool { return h[i].heldAt.Before(h[j].heldAt) }
+func (h renameHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *renameHeap) Push(x interface{}) {
+	p := x.(*pendingRename)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+
+func (h *renameHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return p
+}
+
+// expiry is when we stop remembering a path in renameCorrelator.moved or .created
+type expiry struct {
+	path     turbopath.AbsoluteSystemPath
+	deadline time.Time
+	// moved is set for an entry in renameCorrelator.moved, rather than .created
+	moved bool
+}
+
+// renameCorrelator pairs up the two halves of a rename for backends that report them
+// as unrelated events. It remembers the identity of every path that has been watched,
+// and when a path is renamed away it holds onto the event for a short window. If a
+// path with the same identity is created within that window, the two events are
+// replaced by a single FileRenamed carrying both paths. The window is extended for as
+// long as other events keep arriving, so that a rename is paired up across a burst of
+// unrelated changes between its two halves. fsnotify doesn't give us inotify's rename
+// cookies, so it is the identity that pairs them.
+//
+// If no creation shows up in time, the path was moved somewhere we aren't watching, and
+// as far as we can tell, it has been deleted, so that is what we report.
+//
+// Only renames are held, not deletions. A deleted file's inode can be reused by the
+// very next file that is created, which would make unrelated churn look like a rename.
+//
+// Where we don't know the identity of the path renamed away, as on Windows, which has no
+// inodes, a rename is paired with a creation in the same directory only if it is the
+// very next event. ReadDirectoryChangesW reports the two names of a rename within a
+// directory back to back. Moves between directories on Windows are reported to each
+// directory's watch as an unrelated deletion and creation, and remain so.
+type renameCorrelator struct {
+	window     time.Duration
+	batchLimit time.Duration
+	maxPending int
+	// maxPaths is the most paths in ids
+	maxPaths int
+
+	mu  sync.Mutex
+	ids map[turbopath.AbsoluteSystemPath]fileID
+	// children indexes the paths in ids by their parent directory, so that we can find
+	// what was beneath a directory that moved without going through all of them
+	children map[turbopath.AbsoluteSystemPath]map[turbopath.AbsoluteSystemPath]struct{}
+	pending  map[fileID]*pendingRename
+	// oldest holds the renames in pending, the one held the longest first
+	oldest renameHeap
+	// unidentified holds the renames whose identity we don't know. Only the most recent
+	// can still be paired, and any before it are due, so the first is always due first.
+	unidentified []*pendingRename
+	// heldPaths counts the renames held for each path, identified or not
+	heldPaths map[turbopath.AbsoluteSystemPath]int
+	// seen counts the events that have arrived, see busy
+	seen uint64
+	// lastActivity is when the most recent event arrived, see busy
+	lastActivity time.Time
+	// moved holds both paths of recently paired renames of directories. Some backends
+	// separately report a renamed directory's own watch moving, under either path, and we
+	// swallow that one echo.
+	moved map[turbopath.AbsoluteSystemPath]time.Time
+	// created holds the new paths of recent case-only renames, which were reported before
+	// their creation arrived
+	created map[turbopath.AbsoluteSystemPath]time.Time
+	// expiries holds the deadlines of the entries in moved and created, in the order they
+	// are due, since each is due a window after it was added. An entry that has since been
+	// removed or replaced is skipped when its deadline comes up.
+	expiries []expiry
+}
+
+func newRenameCorrelator(window time.Duration, maxPaths int) *renameCorrelator {
+	return &renameCorrelator{
+		window:     window,
+		batchLimit: _renameBatchLimit,
+		maxPending: _maxPendingRenames,
+		maxPaths:   maxPaths,
+		ids:        make(map[turbopath.AbsoluteSystemPath]fileID),
+		children:   make(map[turbopath.AbsoluteSystemPath]map[turbopath.AbsoluteSystemPath]struct{}),
+		pending:    make(map[fileID]*pendingRename),
+		heldPaths:  make(map[turbopath.AbsoluteSystemPath]int),
+		moved:      make(map[turbopath.AbsoluteSystemPath]time.Time),
+		created:    make(map[turbopath.AbsoluteSystemPath]time.Time),
+	}
+}
+
+// remember records the identity of the file at the given path
+func (r *renameCorrelator) remember(path turbopath.AbsoluteSystemPath, info os.FileInfo) {
+	id, ok := fileIDOf(info)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setID(path, id)
+}
+
+// hasRoom returns true if we can remember the identity of another path, so that a walk
+// only looks up identities that we will keep
+func (r *renameCorrelator) hasRoom() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ids) < r.maxPaths
+}
+
+// setID records the identity of path, unless we already remember as many paths as we
+// may. Must be called while r.mu is held.
+func (r *renameCorrelator) setID(path turbopath.AbsoluteSystemPath, id fileID) {
+	if _, ok := r.ids[path]; !ok {
+		if len(r.ids) >= r.maxPaths {
+			return
+		}
+		dir := path.Dir()
+		siblings, ok := r.children[dir]
+		if !ok {
+			siblings = make(map[turbopath.AbsoluteSystemPath]struct{})
+			r.children[dir] = siblings
+		}
+		siblings[path] = struct{}{}
+	}
+	r.ids[path] = id
+}
+
+// forgetID drops the identity of path. Must be called while r.mu is held.
+func (r *renameCorrelator) forgetID(path turbopath.AbsoluteSystemPath) {
+	if _, ok := r.ids[path]; !ok {
+		return
+	}
+	delete(r.ids, path)
+	dir := path.Dir()
+	delete(r.children[dir], path)
+	if len(r.children[dir]) == 0 {
+		delete(r.children, dir)
+	}
+}
+
+// descendants returns the paths we know of beneath dir. Must be called while r.mu is held.
+func (r *renameCorrelator) descendants(dir turbopath.AbsoluteSystemPath) []turbopath.AbsoluteSystemPath {
+	var found []turbopath.AbsoluteSystemPath
+	dirs := []turbopath.AbsoluteSystemPath{dir}
+	for len(dirs) > 0 {
+		next := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		for child := range r.children[next] {
+			found = append(found, child)
+			dirs = append(dirs, child)
+		}
+	}
+	return found
+}
+
+// forgetTree drops the identities of path and of everything we know of beneath it. Must
+// be called while r.mu is held.
+func (r *renameCorrelator) forgetTree(path turbopath.AbsoluteSystemPath) {
+	for _, child := range r.descendants(path) {
+		r.forgetID(child)
+	}
+	r.forgetID(path)
+}
+
+// lastInode returns the inode of the file last seen at path, or 0 if we don't know it
+func (r *renameCorrelator) lastInode(path turbopath.AbsoluteSystemPath) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ids[path].ino
+}
+
+// removed is called with FileDeleted and FileRenamed events. It returns true if the
+// event has been held or swallowed, and should not be delivered now. If holding it
+// takes us over maxPending, the older half of the held renames are given up on as if
+// their window had passed, and their FileDeleted events are returned. Their other
+// halves will be reported as creations.
+func (r *renameCorrelator) removed(ev Event, now time.Time) (bool, []Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ev.EventType == FileRenamed {
+		if deadline, ok := r.moved[ev.Path]; ok {
+			// The echo of a directory's move that we have already reported
+			delete(r.moved, ev.Path)
+			if now.Before(deadline) {
+				return true, nil
+			}
+		}
+		// A directory's own watch reports its move too, after its parent's
+		if r.heldPaths[ev.Path] > 0 {
+			return true, nil
+		}
+	}
+	id, ok := r.ids[ev.Path]
+	if !ok {
+		if ev.EventType != FileRenamed {
+			r.forgetTree(ev.Path)
+			return false, nil
+		}
+		// The one before, if any, wasn't followed by its creation, and won't be now
+		for i := range r.unidentified {
+			r.unidentified[i].deadline = now
+		}
+		r.unidentified = append(r.unidentified, &pendingRename{
+			ev:       ev,
+			deadline: now.Add(r.window),
+			heldAt:   now,
+			seq:      r.seen,
+		})
+		r.heldPaths[ev.Path]++
+		return true, nil
+	}
+	if ev.EventType != FileRenamed {
+		r.forgetTree(ev.Path)
+		return false, nil
+	}
+	r.forgetID(ev.Path)
+	if _, ok := r.pending[id]; ok {
+		return false, nil
+	}
+	p := &pendingRename{
+		ev:     ev,
+		id:     id,
+		heldAt: now,
+	}
+	r.pending[id] = p
+	heap.Push(&r.oldest, p)
+	r.heldPaths[ev.Path]++
+	if len(r.pending) <= r.maxPending {
+		return true, nil
+	}
+	// Giving up on half of them at once, rather than one at a time, means a long run of
+	// renames logs a warning now and then, rather than for every one of them
+	return true, r.giveUpOldest(len(r.pending) / 2)
+}
+
+// release stops holding the identified rename p. Must be called while r.mu is held.
+func (r *renameCorrelator) release(p *pendingRename) {
+	delete(r.pending, p.id)
+	heap.Remove(&r.oldest, p.index)
+	r.unholdPath(p.ev.Path)
+}
+
+// unholdPath counts one fewer rename held for path. Must be called while r.mu is held.
+func (r *renameCorrelator) unholdPath(path turbopath.AbsoluteSystemPath) {
+	if r.heldPaths[path] <= 1 {
+		delete(r.heldPaths, path)
+	} else {
+		r.heldPaths[path]--
+	}
+}
+
+// dueAt returns when the identified rename p is due. It is held for a window after it, or
+// the last event to arrive, whichever is later, but for no longer than batchLimit in all,
+// unless the window is longer than that. Since lastActivity is the same for all of them,
+// the rename held the longest is always due first. Must be called while r.mu is held.
+func (r *renameCorrelator) dueAt(p *pendingRename) time.Time {
+	deadline := p.heldAt.Add(r.window)
+	extended := r.lastActivity.Add(r.window)
+	if limit := p.heldAt.Add(r.batchLimit); extended.After(limit) {
+		extended = limit
+	}
+	if extended.After(deadline) {
+		return extended
+	}
+	return deadline
+}
+
+// giveUpOldest stops holding the n renames that have been held the longest, and returns
+// their FileDeleted events. Must be called while r.mu is held.
+func (r *renameCorrelator) giveUpOldest(n int) []Event {
+	evs := make([]Event, 0, n)
+	for i := 0; i < n; i++ {
+		evs = append(evs, r.giveUp(r.oldest[0]))
+	}
+	return evs
+}
+
+// giveUp stops holding the identified rename p, and returns its FileDeleted event. Must
+// be called while r.mu is held.
+func (r *renameCorrelator) giveUp(p *pendingRename) Event {
+	r.release(p)
+	// Whatever was beneath it went wherever it went
+	r.forgetTree(p.ev.Path)
+	return Event{
+		Path:      p.ev.Path,
+		EventType: FileDeleted,
+		Inode:     p.id.ino,
+	}
+}
+
+// busy is called as each event arrives. Held renames are kept until the events have
+// stopped arriving for the window, but for no longer than batchLimit in all, see dueAt.
+func (r *renameCorrelator) busy(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen++
+	r.lastActivity = now
+}
+
+// added is called when a path is created. If it completes a pending rename, the
+// combined FileRenamed event is returned.
+func (r *renameCorrelator) added(path turbopath.AbsoluteSystemPath, now time.Time) (Event, bool) {
+	info, err := path.Lstat()
+	if err != nil {
+		return Event{}, false
+	}
+	id, hasID := fileIDOf(info)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if hasID {
+		r.setID(path, id)
+		if p, ok := r.pending[id]; ok && p.ev.Path != path {
+			r.release(p)
+			return r.paired(p.ev.Path, path, info.IsDir(), now), true
+		}
+	}
+	if n := len(r.unidentified); n > 0 {
+		p := r.unidentified[n-1]
+		if r.seen == p.seq+1 && now.Before(p.deadline) && p.ev.Path != path && p.ev.Path.Dir() == path.Dir() {
+			r.unidentified = r.unidentified[:n-1]
+			r.unholdPath(p.ev.Path)
+			return r.paired(p.ev.Path, path, info.IsDir(), now), true
+		}
+	}
+	return Event{}, false
+}
+
+// paired returns the FileRenamed event for a rename whose halves have been paired up,
+// and if it was a directory, remembers that it moved. Must be called while r.mu is held.
+func (r *renameCorrelator) paired(oldPath turbopath.AbsoluteSystemPath, path turbopath.AbsoluteSystemPath, isDir bool, now time.Time) Event {
+	r.moveChildren(oldPath, path)
+	if isDir {
+		r.setMoved(oldPath, now)
+		r.setMoved(path, now)
+	}
+	return Event{
+		Path:      path,
+		OldPath:   oldPath,
+		EventType: FileRenamed,
+	}
+}
+
+// setMoved remembers that the directory at path moved, for a window. Must be called
+// while r.mu is held.
+func (r *renameCorrelator) setMoved(path turbopath.AbsoluteSystemPath, now time.Time) {
+	deadline := now.Add(r.window)
+	r.moved[path] = deadline
+	r.expiries = append(r.expiries, expiry{path: path, deadline: deadline, moved: true})
+}
+
+// moveChildren moves the identities of everything beneath oldPath to beneath path, since
+// if a directory moved, so did everything beneath it. Must be called while r.mu is held.
+func (r *renameCorrelator) moveChildren(oldPath turbopath.AbsoluteSystemPath, path turbopath.AbsoluteSystemPath) {
+	for _, child := range r.descendants(oldPath) {
+		childID := r.ids[child]
+		r.forgetID(child)
+		r.setID(path.UntypedJoin(child.ToString()[len(oldPath):]), childID)
+	}
+}
+
+// caseOnly is called with FileDeleted and FileRenamed events. A path that has been renamed away, but
+// still exists, was given new casing on a case-insensitive filesystem, for instance
+// README.md becoming Readme.md. Its directory tells us the new casing, so the whole
+// rename is returned from this one event, without waiting for its other half. That also
+// covers platforms without file identities to pair the halves by, such as Windows. The
+// creation of the new path that follows is swallowed, see alreadyAdded.
+func (r *renameCorrelator) caseOnly(ev Event, now time.Time) (Event, bool) {
+	if ev.EventType != FileRenamed {
+		return Event{}, false
+	}
+	info, err := ev.Path.Lstat()
+	if err != nil {
+		return Event{}, false
+	}
+	path, ok := onDiskName(ev.Path)
+	if !ok || !isCaseOnlyChange(ev.Path, path) {
+		return Event{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.ids[ev.Path]; ok {
+		r.forgetID(ev.Path)
+		r.setID(path, id)
+		if p, ok := r.pending[id]; ok {
+			r.release(p)
+		}
+	}
+	r.moveChildren(ev.Path, path)
+	if info.IsDir() {
+		r.setMoved(ev.Path, now)
+	}
+	deadline := now.Add(r.window)
+	r.created[path] = deadline
+	r.expiries = append(r.expiries, expiry{path: path, deadline: deadline})
+	return Event{
+		Path:      path,
+		OldPath:   ev.Path,
+		EventType: FileRenamed,
+		Inode:     ev.Inode,
+	}, true
+}
+
+// alreadyAdded returns true if the creation of path has already been reported, as the
+// new path of a case-only rename
+func (r *renameCorrelator) alreadyAdded(path turbopath.AbsoluteSystemPath, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deadline, ok := r.created[path]
+	if !ok {
+		return false
+	}
+	delete(r.created, path)
+	return now.Before(deadline)
+}
+
+// expire returns FileDeleted events for the held renames whose window has passed
+// without a matching creation
+func (r *renameCorrelator) expire(now time.Time) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired []Event
+	remaining := r.unidentified[:0]
+	for _, p := range r.unidentified {
+		if !now.Before(p.deadline) {
+			r.unholdPath(p.ev.Path)
+			expired = append(expired, Event{
+				Path:      p.ev.Path,
+				EventType: FileDeleted,
+			})
+		} else {
+			remaining = append(remaining, p)
+		}
+	}
+	r.unidentified = remaining
+	for len(r.oldest) > 0 && !now.Before(r.dueAt(r.oldest[0])) {
+		expired = append(expired, r.giveUp(r.oldest[0]))
+	}
+	for len(r.expiries) > 0 && !now.Before(r.expiries[0].deadline) {
+		e := r.expiries[0]
+		r.expiries = r.expiries[1:]
+		held := r.created
+		if e.moved {
+			held = r.moved
+		}
+		// Unless it has been swallowed, or remembered again since
+		if deadline, ok := held[e.path]; ok && deadline.Equal(e.deadline) {
+			delete(held, e.path)
+		}
+	}
+	return expired
+}
+
+// nextDeadline returns the time at which the next held event expires, if there is one
+func (r *renameCorrelator) nextDeadline() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var next time.Time
+	if len(r.unidentified) > 0 {
+		next = r.unidentified[0].deadline
+	}
+	if len(r.oldest) > 0 {
+		if deadline := r.dueAt(r.oldest[0]); next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	if len(r.expiries) > 0 {
+		if deadline := r.expiries[0].deadline; next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	return next, !next.IsZero()
+}
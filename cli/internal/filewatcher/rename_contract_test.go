@@ -0,0 +1,102 @@
+package filewatcher
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// TestRenameContract checks that every backend reports a rename within the tree as a
+// single FileRenamed carrying both paths, see FileRenamed
+func TestRenameContract(t *testing.T) {
+	backends := map[string]func(logger hclog.Logger) (Backend, error){
+		"native": func(logger hclog.Logger) (Backend, error) {
+			return GetPlatformSpecificBackend(logger)
+		},
+		"polling": func(logger hclog.Logger) (Backend, error) {
+			cfg := newBackendConfig([]BackendOption{WithPollInterval(10 * time.Millisecond)})
+			return newPollingBackend(logger, cfg), nil
+		},
+	}
+	cases := []struct {
+		name string
+		// betweenDirs is set if the rename moves something to another directory
+		betweenDirs bool
+		oldPath     []string
+		newPath     []string
+	}{
+		{
+			name:    "file within a directory",
+			oldPath: []string{"parent", "child", "foo"},
+			newPath: []string{"parent", "child", "bar"},
+		},
+		{
+			name:        "file between directories",
+			betweenDirs: true,
+			oldPath:     []string{"parent", "child", "foo"},
+			newPath:     []string{"parent", "sibling", "foo"},
+		},
+		{
+			name:    "directory within a directory",
+			oldPath: []string{"parent", "child"},
+			newPath: []string{"parent", "renamed"},
+		},
+		{
+			name:        "directory between directories",
+			betweenDirs: true,
+			oldPath:     []string{"parent", "child"},
+			newPath:     []string{"parent", "sibling", "child"},
+		},
+	}
+	for backendName, newBackend := range backends {
+		for _, tc := range cases {
+			backendName, newBackend, tc := backendName, newBackend, tc
+			t.Run(backendName+"/"+tc.name, func(t *testing.T) {
+				if runtime.GOOS == "windows" && (tc.betweenDirs || backendName == "polling") {
+					t.Skip("Windows has no file identities to pair renames by")
+				}
+				logger := hclog.Default()
+				repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+				for _, dir := range []turbopath.AbsoluteSystemPath{
+					repoRoot.UntypedJoin("parent", "child", "deep"),
+					repoRoot.UntypedJoin("parent", "sibling"),
+				} {
+					assert.NilError(t, dir.MkdirAll(0775), "MkdirAll")
+				}
+				for _, file := range []turbopath.AbsoluteSystemPath{
+					repoRoot.UntypedJoin("parent", "child", "foo"),
+					repoRoot.UntypedJoin("parent", "child", "deep", "baz"),
+				} {
+					assert.NilError(t, file.WriteFile([]byte("hello"), 0644), "WriteFile")
+				}
+
+				backend, err := newBackend(logger)
+				assert.NilError(t, err, "newBackend")
+				fw := New(logger, repoRoot, backend)
+				err = fw.Start()
+				assert.NilError(t, err, "fw.Start")
+				defer func() { _ = fw.Close() }()
+				ch := make(chan Event, 16)
+				err = fw.AddClient(&allEventsClient{notify: ch})
+				assert.NilError(t, err, "AddClient")
+				// Give the polling backend its first scan
+				time.Sleep(50 * time.Millisecond)
+
+				oldPath := repoRoot.UntypedJoin(tc.oldPath...)
+				newPath := repoRoot.UntypedJoin(tc.newPath...)
+				assert.NilError(t, oldPath.Rename(newPath), "Rename")
+				expectFilesystemEvent(t, ch, Event{
+					EventType: FileRenamed,
+					Path:      newPath,
+					OldPath:   oldPath,
+				})
+				expectNoFilesystemEvent(t, ch)
+			})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestRenameAcrossDirectories(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("parent", "child").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = repoRoot.UntypedJoin("parent", "sibling").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	oldPath := repoRoot.UntypedJoin("parent", "child", "foo")
+	err = oldPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	c := &allEventsClient{
+		notify: ch,
+	}
+	assert.NilError(t, fw.AddClient(c), "AddClient")
+
+	newPath := repoRoot.UntypedJoin("parent", "sibling", "foo")
+	err = oldPath.Rename(newPath)
+	assert.NilError(t, err, "Rename")
+
+	// inotify reports the two halves separately, but we should only see one event
+	expectFilesystemEvent(t, ch, Event{
+		EventType: FileRenamed,
+		Path:      newPath,
+		OldPath:   oldPath,
+	})
+	expectNoFilesystemEvent(t, ch)
+}
+
+func TestRenameInterleavedWithOtherChanges(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	oldPath := repoRoot.UntypedJoin("old")
+	err := oldPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 1024)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Other files change right before and after the rename
+	for i := 0; i < 50; i++ {
+		err = repoRoot.UntypedJoin(fmt.Sprintf("before-%v", i)).WriteFile([]byte("hello"), 0644)
+		assert.NilError(t, err, "WriteFile")
+	}
+	newPath := repoRoot.UntypedJoin("new")
+	err = oldPath.Rename(newPath)
+	assert.NilError(t, err, "Rename")
+	for i := 0; i < 50; i++ {
+		err = repoRoot.UntypedJoin(fmt.Sprintf("after-%v", i)).WriteFile([]byte("hello"), 0644)
+		assert.NilError(t, err, "WriteFile")
+	}
+
+	done := repoRoot.UntypedJoin("done")
+	err = done.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	renamed := false
+	for ev := nextEvents(t, ch, 1)[0]; ev.Path != done; ev = nextEvents(t, ch, 1)[0] {
+		if ev.Path == oldPath || ev.Path == newPath {
+			assert.Assert(t, ev.Equal(Event{Path: newPath, OldPath: oldPath, EventType: FileRenamed}), "got %v", ev)
+			renamed = true
+		}
+	}
+	assert.Assert(t, renamed, "expected the rename to be reported")
+}
+
+func TestMoveOutOfTreeIsDeletion(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	elsewhere := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	oldPath := repoRoot.UntypedJoin("parent", "leaving")
+	err := oldPath.EnsureDir()
+	assert.NilError(t, err, "EnsureDir")
+	err = oldPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	window := 50 * time.Millisecond
+	watcher, err := GetPlatformSpecificBackend(logger, WithRenameWindow(window))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	start := time.Now()
+	err = oldPath.Rename(elsewhere.UntypedJoin("leaving"))
+	assert.NilError(t, err, "Rename")
+	assertEvents(t, nextEvents(t, ch, 1), Event{Path: oldPath, EventType: FileDeleted})
+	elapsed := time.Since(start)
+	assert.Assert(t, elapsed >= window, "reported after %v, before the window passed", elapsed)
+	assert.Assert(t, elapsed < 10*window, "reported after %v, expected about %v", elapsed, window)
+	expectNoFilesystemEvent(t, ch)
+
+	// Moving a directory out stops us from watching it, wherever it went
+	dir := repoRoot.UntypedJoin("parent")
+	err = dir.Rename(elsewhere.UntypedJoin("parent"))
+	assert.NilError(t, err, "Rename")
+	assertEvents(t, nextEvents(t, ch, 1), Event{Path: dir, EventType: FileDeleted})
+	err = elsewhere.UntypedJoin("parent", "after").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+}
@@ -0,0 +1,242 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestRenameCorrelatorAcrossBurst(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	oldPath := repoRoot.UntypedJoin("old")
+	newPath := repoRoot.UntypedJoin("new")
+	err := oldPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	info, err := oldPath.Lstat()
+	assert.NilError(t, err, "Lstat")
+	if _, ok := fileIDOf(info); !ok {
+		t.Skip("platform doesn't provide file identities")
+	}
+
+	window := 100 * time.Millisecond
+	r := newRenameCorrelator(window, _maxTrackedPaths)
+	r.batchLimit = 10 * window
+	r.remember(oldPath, info)
+	assert.NilError(t, oldPath.Rename(newPath), "Rename")
+
+	start := time.Now()
+	held, _ := r.removed(Event{Path: oldPath, EventType: FileRenamed}, start)
+	assert.Assert(t, held, "expected the rename to be held")
+	// Unrelated events keep arriving for longer than the window
+	for elapsed := window / 2; elapsed <= 3*window; elapsed += window / 2 {
+		r.busy(start.Add(elapsed))
+		assert.Equal(t, len(r.expire(start.Add(elapsed))), 0)
+	}
+	ev, ok := r.added(newPath, start.Add(3*window+window/2))
+	assert.Assert(t, ok, "expected the rename to be paired")
+	assert.Assert(t, ev.Equal(Event{Path: newPath, OldPath: oldPath, EventType: FileRenamed}), "got %v", ev)
+
+	// Once events stop, or the batch limit is reached, the held half is given up on, and
+	// reported as a deletion
+	r.remember(newPath, info)
+	start = start.Add(time.Minute)
+	held, _ = r.removed(Event{Path: newPath, EventType: FileRenamed}, start)
+	assert.Assert(t, held, "expected the rename to be held")
+	for elapsed := window / 2; elapsed < r.batchLimit; elapsed += window / 2 {
+		r.busy(start.Add(elapsed))
+	}
+	expired := r.expire(start.Add(r.batchLimit))
+	assert.Equal(t, len(expired), 1)
+	assert.Assert(t, expired[0].Equal(Event{Path: newPath, EventType: FileDeleted}))
+}
+
+func TestRenameCorrelatorIsBounded(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	r := newRenameCorrelator(time.Minute, _maxTrackedPaths)
+	r.maxPending = 100
+	const count = 5000
+	var paths []turbopath.AbsoluteSystemPath
+	for i := 0; i < count; i++ {
+		path := repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i))
+		paths = append(paths, path)
+		r.setID(path, fileID{ino: uint64(i + 1)})
+	}
+
+	// Thousands of files are renamed away, none of which show up again
+	start := time.Now()
+	var unpaired []Event
+	for i, path := range paths {
+		held, evs := r.removed(Event{Path: path, EventType: FileRenamed}, start.Add(time.Duration(i)))
+		assert.Assert(t, held, "expected the rename of %v to be held", path)
+		unpaired = append(unpaired, evs...)
+		assert.Assert(t, len(r.pending) <= r.maxPending, "holding %v renames", len(r.pending))
+	}
+
+	// The oldest are given up on first, as deletions, and the rest are still held
+	assert.Assert(t, len(unpaired) > 0, "expected some renames to be given up on")
+	assert.Equal(t, len(unpaired)+len(r.pending), count)
+	for i, ev := range unpaired {
+		assert.Assert(t, ev.Equal(Event{Path: paths[i], EventType: FileDeleted}), "got %v", ev)
+	}
+	expired := r.expire(start.Add(time.Hour))
+	assert.Equal(t, len(expired), count-len(unpaired))
+	for i, ev := range expired {
+		assert.Assert(t, ev.Equal(Event{Path: paths[len(unpaired)+i], EventType: FileDeleted}), "got %v", ev)
+	}
+	assert.Equal(t, len(r.pending), 0)
+	assert.Equal(t, len(r.oldest), 0)
+	assert.Equal(t, len(r.heldPaths), 0)
+}
+
+func TestCaseOnlyRename(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	if !isCaseInsensitiveFS(repoRoot) {
+		t.Skip("filesystem is case-sensitive")
+	}
+	oldPath := repoRoot.UntypedJoin("README.md")
+	newPath := repoRoot.UntypedJoin("Readme.md")
+	err := oldPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	assert.NilError(t, oldPath.Rename(newPath), "Rename")
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-ch:
+			assert.Assert(t, ev.EventType != FileAdded && ev.EventType != FileDeleted, "unexpected event %v", ev)
+			if ev.EventType != FileRenamed {
+				continue
+			}
+			assert.Equal(t, ev.OldPath, oldPath)
+			assert.Equal(t, ev.Path, newPath)
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for the rename")
+		}
+	}
+}
+
+func TestRenameCorrelatorWithoutIdentity(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	window := 100 * time.Millisecond
+	r := newRenameCorrelator(window, _maxTrackedPaths)
+	// Nothing is remembered, as if the platform had no file identities
+	oldPath := repoRoot.UntypedJoin("old")
+	newPath := repoRoot.UntypedJoin("new")
+	assert.NilError(t, newPath.WriteFile([]byte("hello"), 0644), "WriteFile")
+
+	// The creation that immediately follows a rename in the same directory completes it
+	now := time.Now()
+	r.busy(now)
+	held, _ := r.removed(Event{Path: oldPath, EventType: FileRenamed}, now)
+	assert.Assert(t, held, "expected the rename to be held")
+	r.busy(now)
+	ev, ok := r.added(newPath, now)
+	assert.Assert(t, ok, "expected the rename to be paired")
+	assert.Assert(t, ev.Equal(Event{Path: newPath, OldPath: oldPath, EventType: FileRenamed}), "got %v", ev)
+
+	// One that doesn't immediately follow is unrelated, and the rename is a deletion
+	other := repoRoot.UntypedJoin("other")
+	assert.NilError(t, other.WriteFile([]byte("hello"), 0644), "WriteFile")
+	now = now.Add(time.Minute)
+	r.busy(now)
+	held, _ = r.removed(Event{Path: newPath, EventType: FileRenamed}, now)
+	assert.Assert(t, held, "expected the rename to be held")
+	r.busy(now)
+	r.busy(now)
+	_, ok = r.added(other, now)
+	assert.Assert(t, !ok, "expected the rename not to be paired")
+	expired := r.expire(now.Add(window))
+	assert.Equal(t, len(expired), 1)
+	assert.Assert(t, expired[0].Equal(Event{Path: newPath, EventType: FileDeleted}), "got %v", expired[0])
+
+	// So is one in another directory
+	assert.NilError(t, repoRoot.UntypedJoin("dir").MkdirAll(0775), "MkdirAll")
+	moved := repoRoot.UntypedJoin("dir", "moved")
+	assert.NilError(t, moved.WriteFile([]byte("hello"), 0644), "WriteFile")
+	now = now.Add(time.Minute)
+	r.busy(now)
+	held, _ = r.removed(Event{Path: other, EventType: FileRenamed}, now)
+	assert.Assert(t, held, "expected the rename to be held")
+	r.busy(now)
+	_, ok = r.added(moved, now)
+	assert.Assert(t, !ok, "expected the rename not to be paired")
+	assert.Equal(t, len(r.expire(now.Add(window))), 1)
+}
+
+func TestRenameCorrelatorForgetsBeyondLimit(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	r := newRenameCorrelator(time.Minute, 2)
+	r.setID(repoRoot.UntypedJoin("a"), fileID{ino: 1})
+	r.setID(repoRoot.UntypedJoin("b"), fileID{ino: 2})
+	assert.Assert(t, !r.hasRoom(), "expected no room for more")
+	r.setID(repoRoot.UntypedJoin("c"), fileID{ino: 3})
+	assert.Equal(t, r.lastInode(repoRoot.UntypedJoin("c")), uint64(0))
+	// Paths we already know are still updated
+	r.setID(repoRoot.UntypedJoin("a"), fileID{ino: 4})
+	assert.Equal(t, r.lastInode(repoRoot.UntypedJoin("a")), uint64(4))
+
+	// A deletion makes room
+	held, _ := r.removed(Event{Path: repoRoot.UntypedJoin("b"), EventType: FileDeleted}, time.Now())
+	assert.Assert(t, !held, "expected the deletion to be delivered")
+	assert.Assert(t, r.hasRoom(), "expected room once a path is deleted")
+}
+
+func TestRenameCorrelatorMovedDirectory(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	oldDir := repoRoot.UntypedJoin("old")
+	newDir := repoRoot.UntypedJoin("new")
+	oldFile := oldDir.UntypedJoin("deep", "foo")
+	err := oldFile.Dir().MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = oldFile.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	r := newRenameCorrelator(time.Minute, _maxTrackedPaths)
+	for _, path := range []turbopath.AbsoluteSystemPath{oldDir, oldDir.UntypedJoin("deep"), oldFile} {
+		info, err := path.Lstat()
+		assert.NilError(t, err, "Lstat")
+		if _, ok := fileIDOf(info); !ok {
+			t.Skip("platform doesn't provide file identities")
+		}
+		r.remember(path, info)
+	}
+	fileInode := r.lastInode(oldFile)
+	assert.NilError(t, oldDir.Rename(newDir), "Rename")
+
+	now := time.Now()
+	held, _ := r.removed(Event{Path: oldDir, EventType: FileRenamed}, now)
+	assert.Assert(t, held, "expected the rename to be held")
+	ev, ok := r.added(newDir, now)
+	assert.Assert(t, ok, "expected the rename to be paired")
+	assert.Assert(t, ev.Equal(Event{Path: newDir, OldPath: oldDir, EventType: FileRenamed}), "got %v", ev)
+	// What was beneath it moved with it
+	newFile := newDir.UntypedJoin("deep", "foo")
+	assert.Equal(t, r.lastInode(newFile), fileInode)
+	assert.Equal(t, r.lastInode(oldFile), uint64(0))
+
+	// The directory's own watch reporting its move is swallowed, once
+	held, _ = r.removed(Event{Path: oldDir, EventType: FileRenamed}, now)
+	assert.Assert(t, held, "expected the echo of the move to be swallowed")
+	// Anything else that happens to either path is not
+	held, _ = r.removed(Event{Path: newFile, EventType: FileDeleted}, now)
+	assert.Assert(t, !held, "expected the deletion to be delivered")
+	held, _ = r.removed(Event{Path: newDir, EventType: FileDeleted}, now)
+	assert.Assert(t, !held, "expected the deletion to be delivered")
+}
@@ -0,0 +1,179 @@
+package filewatcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _rewatchBackoff is how long we wait before re-watching a directory that has come back
+// for the second time in a row, doubling with each further time
+const _rewatchBackoff = 100 * time.Millisecond
+
+// _maxRewatchAttempts is how many times in a row we re-watch a directory that keeps
+// disappearing and reappearing before giving up on it
+const _maxRewatchAttempts = 8
+
+// _rewatchQuietPeriod is how long a directory has to keep its watch for its next
+// reappearance not to count as flapping
+const _rewatchQuietPeriod = time.Second
+
+// _maxRewatchRecords is how many directories that lost their watch we remember before
+// forgetting the ones that have been quiet for long enough
+const _maxRewatchRecords = 1024
+
+// rewatchGuard keeps a directory that keeps disappearing and reappearing from having us
+// re-install its watch over and over. Each time it comes back shortly after we last
+// watched it, we wait longer before watching it again, until we give up on it. Changes
+// within it while we wait aren't reported.
+type rewatchGuard struct {
+	mu          sync.Mutex
+	backoff     time.Duration
+	maxAttempts int
+	quiet       time.Duration
+	dirs        map[turbopath.AbsoluteSystemPath]*rewatchRecord
+}
+
+// rewatchRecord is what we know about a directory that has lost its watch
+type rewatchRecord struct {
+	// attempts counts the times in a row that it came back shortly after being watched
+	attempts int
+	// watchedAt is when its watch was last installed
+	watchedAt time.Time
+	// scheduled is set while a delayed re-watch is pending, and admitting while it is
+	// in progress
+	scheduled bool
+	admitting bool
+	// unstable is set once we have given up on it
+	unstable bool
+}
+
+// rewatchDecision is what to do about a directory that we are asked to watch
+type rewatchDecision int
+
+const (
+	rewatchNow rewatchDecision = iota
+	rewatchLater
+	rewatchSkip
+	rewatchGiveUp
+)
+
+func newRewatchGuard() *rewatchGuard {
+	return &rewatchGuard{
+		backoff:     _rewatchBackoff,
+		maxAttempts: _maxRewatchAttempts,
+		quiet:       _rewatchQuietPeriod,
+		dirs:        make(map[turbopath.AbsoluteSystemPath]*rewatchRecord),
+	}
+}
+
+// lost records that dir has lost its watch by being deleted
+func (g *rewatchGuard) lost(dir turbopath.AbsoluteSystemPath, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.dirs[dir]; ok {
+		return
+	}
+	if len(g.dirs) >= _maxRewatchRecords {
+		for d, r := range g.dirs {
+			if !r.scheduled && !r.unstable && now.Sub(r.watchedAt) > g.quiet {
+				delete(g.dirs, d)
+			}
+		}
+	}
+	g.dirs[dir] = &rewatchRecord{}
+}
+
+// decide returns whether dir should be watched now, and if not, for how long to wait
+// before trying again. Directories that have never lost their watch are always watched.
+func (g *rewatchGuard) decide(dir turbopath.AbsoluteSystemPath, now time.Time) (rewatchDecision, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r, ok := g.dirs[dir]
+	switch {
+	case !ok:
+		return rewatchNow, 0
+	case r.unstable:
+		return rewatchSkip, 0
+	case r.admitting:
+		r.admitting = false
+		r.watchedAt = now
+		return rewatchNow, 0
+	case r.scheduled:
+		// The pending re-watch will pick it up
+		return rewatchSkip, 0
+	}
+	if now.Sub(r.watchedAt) > g.quiet {
+		r.attempts = 0
+	}
+	r.attempts++
+	if r.attempts == 1 {
+		r.watchedAt = now
+		return rewatchNow, 0
+	}
+	if r.attempts > g.maxAttempts {
+		r.unstable = true
+		return rewatchGiveUp, 0
+	}
+	r.scheduled = true
+	return rewatchLater, g.backoff << (r.attempts - 2)
+}
+
+// admit lets the next decision about dir, made by the delayed re-watch, through
+func (g *rewatchGuard) admit(dir turbopath.AbsoluteSystemPath) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r, ok := g.dirs[dir]; ok {
+		r.admitting = true
+	}
+}
+
+// retried records that the delayed re-watch of dir has finished
+func (g *rewatchGuard) retried(dir turbopath.AbsoluteSystemPath) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r, ok := g.dirs[dir]; ok {
+		r.scheduled = false
+		r.admitting = false
+	}
+}
+
+// forget drops what we know about dir, so that it is watched as usual again
+func (g *rewatchGuard) forget(dir turbopath.AbsoluteSystemPath) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.dirs, dir)
+}
+
+// admitDir is the directory filter that we give the backend. It is shouldWatchDir, with
+// watches for flapping directories delayed, and eventually given up on.
+func (fw *FileWatcher) admitDir(dir turbopath.AbsoluteSystemPath) bool {
+	if !fw.shouldWatchDir(dir) {
+		return false
+	}
+	if fw.rewatch == nil {
+		return true
+	}
+	decision, delay := fw.rewatch.decide(dir, time.Now())
+	switch decision {
+	case rewatchLater:
+		fw.logger.Debug("directory is flapping, delaying its watch", _logOp, "watch", _logPath, dir, "delay", delay)
+		time.AfterFunc(delay, func() { fw.rewatchDir(dir) })
+	case rewatchGiveUp:
+		fw.logger.Warn("directory keeps disappearing and reappearing, no longer watching it", _logOp, "watch", _logPath, dir)
+		go fw.reportError(errors.Wrapf(ErrWatchUnstable, "%v", dir))
+	}
+	return decision == rewatchNow
+}
+
+// rewatchDir installs the watches for dir that admitDir delayed
+func (fw *FileWatcher) rewatchDir(dir turbopath.AbsoluteSystemPath) {
+	defer fw.rewatch.retried(dir)
+	fw.rewatch.admit(dir)
+	if err := fw.backend.(rewatchingBackend).watchTree(dir, fw.excludePattern); err != nil {
+		// Most likely it has disappeared again, and we'll hear about it if it comes back
+		fw.logger.Debug("failed to re-watch directory", _logOp, "watch", _logPath, dir, "error", err)
+	}
+}
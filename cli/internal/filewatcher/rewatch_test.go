@@ -0,0 +1,136 @@
+package filewatcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// flappingBackend is a fakeBackend that watches individual directories, consulting our
+// directory filter as the native backend does
+type flappingBackend struct {
+	*fakeBackend
+	filterMu sync.Mutex
+	filter   func(dir turbopath.AbsoluteSystemPath) bool
+	listener watchListener
+	// retries receives the directory of every watchTree call
+	retries chan turbopath.AbsoluteSystemPath
+}
+
+func (f *flappingBackend) setDirFilter(filter func(dir turbopath.AbsoluteSystemPath) bool) {
+	f.filterMu.Lock()
+	defer f.filterMu.Unlock()
+	f.filter = filter
+}
+
+func (f *flappingBackend) setWatchListener(l watchListener) {
+	f.filterMu.Lock()
+	defer f.filterMu.Unlock()
+	f.listener = l
+}
+
+// appear creates dir, and returns true if it was watched
+func (f *flappingBackend) appear(dir turbopath.AbsoluteSystemPath) bool {
+	f.filterMu.Lock()
+	defer f.filterMu.Unlock()
+	if err := dir.MkdirAll(0775); err != nil {
+		panic(err)
+	}
+	if !f.filter(dir) {
+		return false
+	}
+	f.listener.onWatchAdded(dir)
+	return true
+}
+
+// disappear deletes dir
+func (f *flappingBackend) disappear(dir turbopath.AbsoluteSystemPath) {
+	if err := dir.Remove(); err != nil {
+		panic(err)
+	}
+	f.listener.onWatchRemoved(dir)
+}
+
+func (f *flappingBackend) watchTree(dir turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	f.appear(dir)
+	f.retries <- dir
+	return nil
+}
+
+func (f *flappingBackend) unwatchDir(dir turbopath.AbsoluteSystemPath) error {
+	f.listener.onWatchRemoved(dir)
+	return nil
+}
+
+func (f *flappingBackend) addShallowRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	return nil
+}
+
+func TestFlappingDirectory(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := &flappingBackend{
+		fakeBackend: newFakeBackend(),
+		retries:     make(chan turbopath.AbsoluteSystemPath, 1),
+	}
+	fw := New(hclog.Default(), repoRoot, backend)
+	fw.rewatch.backoff = 20 * time.Millisecond
+	fw.rewatch.maxAttempts = 4
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	errs := make(chan error, 1)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: make(chan Event, 16), errs: errs}), "AddClient")
+
+	dir := repoRoot.UntypedJoin("flapping")
+	assert.Assert(t, backend.appear(dir), "first watch")
+	// Coming back once is usual, and is watched right away
+	backend.disappear(dir)
+	assert.Assert(t, backend.appear(dir), "watch after coming back once")
+
+	// After that, each time it is watched later, and later
+	previous := time.Duration(0)
+	for attempt := 2; attempt <= 4; attempt++ {
+		backend.disappear(dir)
+		start := time.Now()
+		assert.Assert(t, !backend.appear(dir), "watch on attempt %v should be delayed", attempt)
+		// Coming back again while the re-watch is pending doesn't change anything
+		assert.Assert(t, !backend.appear(dir), "watch on attempt %v should be delayed", attempt)
+		select {
+		case retried := <-backend.retries:
+			assert.Equal(t, retried, dir)
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %v was not retried", attempt)
+		}
+		delay := time.Since(start)
+		expected := fw.rewatch.backoff << (attempt - 2)
+		assert.Assert(t, delay >= expected, "attempt %v retried after %v, expected %v", attempt, delay, expected)
+		previous = delay
+		assert.Assert(t, fw.IsWatched(dir), "watched after attempt %v", attempt)
+	}
+
+	// Then we give up on it, and say so
+	backend.disappear(dir)
+	assert.Assert(t, !backend.appear(dir), "watched after giving up")
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrWatchUnstable)
+	case <-time.After(time.Second):
+		t.Fatal("expected ErrWatchUnstable")
+	}
+	select {
+	case <-backend.retries:
+		t.Fatal("retried after giving up")
+	case <-time.After(10 * previous):
+	}
+	assert.Assert(t, !backend.appear(dir), "watched after giving up")
+
+	// Until it is watched by hand
+	err = fw.AddWatch(dir)
+	assert.NilError(t, err, "AddWatch")
+	assert.Assert(t, backend.appear(dir), "watch after AddWatch")
+}
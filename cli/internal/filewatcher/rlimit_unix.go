@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package filewatcher
+
+import "syscall"
+
+// raiseFileLimit raises our soft limit on open files to the hard limit. It returns
+// false if the limit could not be raised.
+func raiseFileLimit() bool {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return false
+	}
+	if limit.Cur >= limit.Max {
+		return false
+	}
+	limit.Cur = limit.Max
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit) == nil
+}
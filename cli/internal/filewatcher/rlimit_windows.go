@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package filewatcher
+
+// raiseFileLimit is a no-op on Windows, where watches don't use file descriptors
+func raiseFileLimit() bool {
+	return false
+}
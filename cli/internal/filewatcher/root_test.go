@@ -0,0 +1,62 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// nextEventFor returns the next event for the given path, skipping any others
+func nextEventFor(t *testing.T, ch <-chan Event, path turbopath.AbsoluteSystemPath) Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-ch:
+			if ev.Path == path {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for an event for %v", path)
+		}
+	}
+}
+
+func TestEventRoot(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	otherRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin("parent").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = otherRoot.UntypedJoin("cookies").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddRoot(otherRoot)
+	assert.NilError(t, err, "AddRoot")
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	inRepo := repoRoot.UntypedJoin("parent", "foo")
+	err = inRepo.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	ev := nextEventFor(t, ch, inRepo)
+	assert.Equal(t, ev.Root, repoRoot)
+
+	inOther := otherRoot.UntypedJoin("cookies", "foo")
+	err = inOther.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	ev = nextEventFor(t, ch, inOther)
+	assert.Equal(t, ev.Root, otherRoot)
+}
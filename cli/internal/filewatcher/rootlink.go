@@ -0,0 +1,71 @@
+package filewatcher
+
+import (
+	"path/filepath"
+
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// resolveRoot returns the real path of the repo root, with any symlinks in it followed,
+// or the root itself if it can't be resolved. A repo root reached through a symlink, as
+// with pnpm or container mounts, is watched at its real path, since backends don't
+// follow symlinks when walking, and some report real paths whatever we asked for.
+func resolveRoot(root turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	real, err := filepath.EvalSymlinks(root.ToString())
+	if err != nil {
+		return root
+	}
+	return normalizePath(fs.AbsoluteSystemPathFromUpstream(real))
+}
+
+// reportedRoot returns the repo root as we were given it, which is what the paths we
+// report are beneath
+func (fw *FileWatcher) reportedRoot() turbopath.AbsoluteSystemPath {
+	if fw.linkedRoot != "" {
+		return fw.linkedRoot
+	}
+	return fw.repoRoot
+}
+
+// toRealPath maps a path beneath the repo root as we were given it to the same path
+// beneath its real path, which is what we watch and what backends report
+func (fw *FileWatcher) toRealPath(p turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	return rebase(p, fw.linkedRoot, fw.repoRoot)
+}
+
+// fromRealPath maps a path beneath the real path of the repo root to the same path
+// beneath the repo root as we were given it, for reporting to clients
+func (fw *FileWatcher) fromRealPath(p turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	return rebase(p, fw.repoRoot, fw.linkedRoot)
+}
+
+// fromRealEvent applies fromRealPath to every path in the event
+func (fw *FileWatcher) fromRealEvent(ev Event) Event {
+	if fw.linkedRoot == "" {
+		return ev
+	}
+	ev.Path = fw.fromRealPath(ev.Path)
+	if ev.OldPath != "" {
+		ev.OldPath = fw.fromRealPath(ev.OldPath)
+	}
+	if ev.Root != "" {
+		ev.Root = fw.fromRealPath(ev.Root)
+	}
+	return ev
+}
+
+// rebase returns p with the prefix from replaced by to, if p is within from. It returns
+// p unchanged if either is empty.
+func rebase(p turbopath.AbsoluteSystemPath, from turbopath.AbsoluteSystemPath, to turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	if from == "" || to == "" {
+		return p
+	}
+	if p == from {
+		return to
+	}
+	if !p.HasPrefix(from) {
+		return p
+	}
+	return to.UntypedJoin(p.ToString()[len(from):])
+}
@@ -0,0 +1,110 @@
+package filewatcher
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// WithScanThrottle bounds the I/O of the rescans we run to recover from an overflow, a
+// sleep, a change of mounts, or a root being recreated. At most maxConcurrency stats or
+// directory reads are in flight at once, and at most maxStatsPerSecond are started each
+// second, so that recovering on a huge repository doesn't degrade the rest of the machine.
+// Either limit may be 0 for no limit. Watching and delivering events as they happen is
+// unaffected, as are the walks that install watches on newly added roots and directories.
+func WithScanThrottle(maxConcurrency int, maxStatsPerSecond int) Option {
+	return func(fw *FileWatcher) {
+		fw.scanThrottle = newScanThrottle(maxConcurrency, maxStatsPerSecond)
+	}
+}
+
+// throttlingBackend is implemented by backends whose rescans can be throttled
+type throttlingBackend interface {
+	setScanThrottle(throttle *scanThrottle)
+}
+
+// scanFS is the filesystem as seen by a walk
+type scanFS interface {
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+}
+
+// osScanFS reads the real filesystem
+type osScanFS struct{}
+
+func (osScanFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osScanFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// scanThrottle limits how many I/O operations are in flight at once, and how often they
+// start. A nil *scanThrottle doesn't limit anything.
+type scanThrottle struct {
+	// slots has a slot per operation that may be in flight, or is nil for no limit
+	slots chan struct{}
+	// interval is the time between the starts of consecutive operations, or 0 for no limit
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is the earliest that the next operation may start
+	next time.Time
+}
+
+func newScanThrottle(maxConcurrency int, maxPerSecond int) *scanThrottle {
+	t := &scanThrottle{}
+	if maxConcurrency > 0 {
+		t.slots = make(chan struct{}, maxConcurrency)
+	}
+	if maxPerSecond > 0 {
+		t.interval = time.Second / time.Duration(maxPerSecond)
+	}
+	return t
+}
+
+// acquire blocks until another operation may start, and returns the func that must be
+// called once it has finished
+func (t *scanThrottle) acquire() func() {
+	if t == nil {
+		return func() {}
+	}
+	if t.slots != nil {
+		t.slots <- struct{}{}
+	}
+	if t.interval > 0 {
+		t.mu.Lock()
+		now := time.Now()
+		if t.next.Before(now) {
+			t.next = now
+		}
+		start := t.next
+		t.next = t.next.Add(t.interval)
+		t.mu.Unlock()
+		time.Sleep(time.Until(start))
+	}
+	return func() {
+		if t.slots != nil {
+			<-t.slots
+		}
+	}
+}
+
+// throttledFS passes operations through to fsys as the throttle allows
+type throttledFS struct {
+	fsys     scanFS
+	throttle *scanThrottle
+}
+
+func (t throttledFS) Lstat(name string) (os.FileInfo, error) {
+	release := t.throttle.acquire()
+	defer release()
+	return t.fsys.Lstat(name)
+}
+
+func (t throttledFS) ReadDir(name string) ([]os.DirEntry, error) {
+	release := t.throttle.acquire()
+	defer release()
+	return t.fsys.ReadDir(name)
+}
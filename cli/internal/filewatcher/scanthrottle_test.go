@@ -0,0 +1,179 @@
+//go:build !darwin
+// +build !darwin
+
+package filewatcher
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+// countingFS reads the real filesystem, recording when each operation starts and how
+// many are in flight at once
+type countingFS struct {
+	mu          sync.Mutex
+	starts      []time.Time
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *countingFS) begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.starts = append(c.starts, time.Now())
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+}
+
+func (c *countingFS) end() {
+	// Linger, so that any operations that could overlap do
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+}
+
+func (c *countingFS) Lstat(name string) (os.FileInfo, error) {
+	c.begin()
+	defer c.end()
+	return os.Lstat(name)
+}
+
+func (c *countingFS) ReadDir(name string) ([]os.DirEntry, error) {
+	c.begin()
+	defer c.end()
+	return os.ReadDir(name)
+}
+
+func (c *countingFS) stats() ([]time.Time, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Time{}, c.starts...), c.maxInFlight
+}
+
+func TestScanThrottle(t *testing.T) {
+	const maxConcurrency = 2
+	const maxStatsPerSecond = 20
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	for i := 0; i < 3; i++ {
+		dir := repoRoot.UntypedJoin(fmt.Sprintf("dir%v", i))
+		assert.NilError(t, dir.MkdirAll(0775), "MkdirAll")
+		for j := 0; j < 8; j++ {
+			err := dir.UntypedJoin(fmt.Sprintf("file%v", j)).WriteFile([]byte("hello"), 0644)
+			assert.NilError(t, err, "WriteFile")
+		}
+	}
+
+	backend, err := GetPlatformSpecificBackend(logger, WithWalkConcurrency(4))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	f, ok := backend.(*fsNotifyBackend)
+	assert.Assert(t, ok, "backend is %T", backend)
+	fsys := &countingFS{}
+	f.scanFS = fsys
+	fw := New(logger, repoRoot, backend, WithScanThrottle(maxConcurrency, maxStatsPerSecond))
+	ch := make(chan Event, 64)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	assert.NilError(t, fw.AddClient(&testClient{notify: ch}), "AddClient")
+
+	starts, _ := fsys.stats()
+	assert.Equal(t, len(starts), 0, "watching the repo shouldn't be throttled")
+
+	fw.reconcile()
+	starts, maxInFlight := fsys.stats()
+	assert.Assert(t, len(starts) > maxStatsPerSecond+1, "only %v operations, too few to test the rate", len(starts))
+	assert.Assert(t, maxInFlight <= maxConcurrency, "%v operations were in flight at once", maxInFlight)
+	// Allow one extra for the scheduler making an operation start later than it was allowed to
+	for i := range starts {
+		inWindow := 0
+		for _, start := range starts[i:] {
+			if start.Sub(starts[i]) < time.Second {
+				inWindow++
+			}
+		}
+		assert.Assert(t, inWindow <= maxStatsPerSecond+1, "%v operations started within a second of %v", inWindow, starts[i])
+	}
+
+	expectFilesystemEvent(t, ch, Event{
+		Path:      repoRoot.UntypedJoin("dir0"),
+		EventType: FileAdded,
+	})
+}
+
+// stallingFS reads the real filesystem, except that reading the directory stall blocks
+// until release is closed
+type stallingFS struct {
+	stall   string
+	stalled chan struct{}
+	release chan struct{}
+}
+
+func (s *stallingFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (s *stallingFS) ReadDir(name string) ([]os.DirEntry, error) {
+	if name == s.stall {
+		close(s.stalled)
+		<-s.release
+	}
+	return os.ReadDir(name)
+}
+
+func TestEventsDeliveredDuringThrottledRescan(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	slow := repoRoot.UntypedJoin("slow")
+	live := repoRoot.UntypedJoin("live")
+	for _, dir := range []string{slow.ToString(), live.ToString()} {
+		assert.NilError(t, os.MkdirAll(dir, 0775), "MkdirAll")
+	}
+
+	backend, err := GetPlatformSpecificBackend(logger, WithWalkConcurrency(1))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	f, ok := backend.(*fsNotifyBackend)
+	assert.Assert(t, ok, "backend is %T", backend)
+	fsys := &stallingFS{
+		stall:   slow.ToString(),
+		stalled: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	f.scanFS = fsys
+	fw := New(logger, repoRoot, backend, WithScanThrottle(1, 0))
+	ch := make(chan Event, 64)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	assert.NilError(t, fw.AddClient(&testClient{notify: ch}), "AddClient")
+
+	rescanned := make(chan struct{})
+	go func() {
+		defer close(rescanned)
+		fw.reconcile()
+	}()
+	<-fsys.stalled
+	var release sync.Once
+	// Unstick the rescan before closing, even if we fail
+	defer release.Do(func() { close(fsys.release) })
+
+	// The rescan is stuck partway through, but changes elsewhere are still reported
+	filePath := live.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	ev := nextEventFor(t, ch, filePath)
+	assert.Equal(t, ev.EventType, FileAdded)
+
+	release.Do(func() { close(fsys.release) })
+	<-rescanned
+}
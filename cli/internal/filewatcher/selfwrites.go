@@ -0,0 +1,36 @@
+package filewatcher
+
+import (
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// IgnorePath drops events for the given path for the next ttl, so that a client that
+// writes to a watched path can avoid being told about its own writes, and reacting to
+// them in turn. Only events for that exact path are dropped, including renames to or from
+// it. Calling it again for the same path replaces the previous ttl.
+func (fw *FileWatcher) IgnorePath(path turbopath.AbsoluteSystemPath, ttl time.Duration) {
+	fw.selfWritesMu.Lock()
+	defer fw.selfWritesMu.Unlock()
+	fw.selfWrites[fw.toRealPath(normalizePath(path))] = time.Now().Add(ttl)
+}
+
+// isSelfWrite returns true if the event is for a path passed to IgnorePath whose ttl
+// hasn't expired
+func (fw *FileWatcher) isSelfWrite(ev Event, now time.Time) bool {
+	fw.selfWritesMu.Lock()
+	defer fw.selfWritesMu.Unlock()
+	if len(fw.selfWrites) == 0 {
+		return false
+	}
+	ignored := false
+	for path, expiry := range fw.selfWrites {
+		if !now.Before(expiry) {
+			delete(fw.selfWrites, path)
+		} else if path == ev.Path || path == ev.OldPath {
+			ignored = true
+		}
+	}
+	return ignored
+}
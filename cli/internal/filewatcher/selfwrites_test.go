@@ -0,0 +1,54 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestIgnorePath(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	ignored := repoRoot.UntypedJoin("ignored")
+	ttl := 500 * time.Millisecond
+	fw.IgnorePath(ignored, ttl)
+	start := time.Now()
+	err = ignored.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	// Anything for the ignored path would have arrived before this
+	other := repoRoot.UntypedJoin("other")
+	err = other.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	timeout := time.After(time.Second)
+	for seenOther := false; !seenOther; {
+		select {
+		case ev := <-ch:
+			assert.Assert(t, ev.Path != ignored, "unexpected event %v", ev)
+			seenOther = ev.Path == other
+		case <-timeout:
+			t.Fatalf("timed out waiting for an event for %v", other)
+		}
+	}
+	assert.Assert(t, time.Since(start) < ttl, "test was too slow to be meaningful")
+
+	// Once the ttl has expired, changes are reported again
+	time.Sleep(time.Until(start.Add(ttl)))
+	err = ignored.WriteFile([]byte("goodbye"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	ev := nextEventFor(t, ch, ignored)
+	assert.Equal(t, ev.EventType, FileModified)
+}
@@ -0,0 +1,44 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestEventSeq(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+
+	const count = 20
+	first := make(chan Event, count)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: first}), "AddClient")
+	second := make(chan Event, count)
+	assert.NilError(t, fw.AddClient(&allEventsClient{notify: second}), "AddClient")
+
+	for i := 0; i < count; i++ {
+		backend.events <- Event{
+			Path:      repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)),
+			EventType: FileAdded,
+		}
+	}
+
+	var prev uint64
+	for i := 0; i < count; i++ {
+		a := <-first
+		b := <-second
+		assert.Assert(t, a.Equal(b), "clients saw %v and %v", a, b)
+		assert.Equal(t, a.Seq, b.Seq, "clients saw different sequence numbers for %v", a)
+		assert.Assert(t, a.Seq > prev, "sequence went from %v to %v", prev, a.Seq)
+		prev = a.Seq
+	}
+	assert.Equal(t, prev, uint64(count))
+}
@@ -0,0 +1,42 @@
+package filewatcher
+
+import (
+	"context"
+	"time"
+)
+
+// WithSettleWindow drops the events that arrive within window of Start installing the
+// initial watches, as describing the state that existed before we started rather than
+// live changes. FSEvents in particular can report recent history just after a stream is
+// started. WaitForReady doesn't return until the window has passed.
+func WithSettleWindow(window time.Duration) Option {
+	return func(fw *FileWatcher) {
+		fw.settleWindow = window
+	}
+}
+
+// settling returns true if the event arrived within the settle window, and should be dropped
+func (fw *FileWatcher) settling(ev Event, now time.Time) bool {
+	if fw.settleWindow <= 0 || !now.Before(fw.liveAt) {
+		return false
+	}
+	fw.logger.Debug("dropping event that arrived while settling", _logPath, ev.Path, _logEventType, ev.EventType)
+	return true
+}
+
+// waitUntilLive waits for the settle window to pass, or for ctx to be done. Start must
+// have finished.
+func (fw *FileWatcher) waitUntilLive(ctx context.Context) error {
+	wait := time.Until(fw.liveAt)
+	if fw.settleWindow <= 0 || wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
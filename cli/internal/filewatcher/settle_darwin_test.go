@@ -0,0 +1,45 @@
+package filewatcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestSettleWindowDropsFSEventsHistory(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	// Files written just before the stream starts can be reported as if they were new
+	for i := 0; i < 10; i++ {
+		err := repoRoot.UntypedJoin(fmt.Sprintf("existing-%v", i)).WriteFile([]byte("hello"), 0644)
+		assert.NilError(t, err, "WriteFile")
+	}
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithSettleWindow(500*time.Millisecond), WithBufferUntilFirstClient())
+	ch := make(chan Event, 64)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+	err = fw.WaitForReady(context.Background())
+	assert.NilError(t, err, "WaitForReady")
+	expectNoFilesystemEvent(t, ch)
+
+	filePath := repoRoot.UntypedJoin("existing-0")
+	err = filePath.WriteFile([]byte("goodbye"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileModified,
+	})
+}
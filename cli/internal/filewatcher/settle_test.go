@@ -0,0 +1,44 @@
+package filewatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithSettleWindow(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	window := 200 * time.Millisecond
+	fw := New(logger, repoRoot, backend, WithSettleWindow(window), WithBufferUntilFirstClient())
+	ch := make(chan Event, 16)
+	start := time.Now()
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	// History that the backend reports as soon as it starts is dropped
+	backend.events <- Event{
+		Path:      repoRoot.UntypedJoin("existing"),
+		EventType: FileAdded,
+	}
+	err = fw.WaitForReady(context.Background())
+	assert.NilError(t, err, "WaitForReady")
+	assert.Assert(t, time.Since(start) >= window, "ready after %v", time.Since(start))
+
+	live := Event{
+		Path:      repoRoot.UntypedJoin("live"),
+		EventType: FileAdded,
+	}
+	backend.events <- live
+	expectFilesystemEvent(t, ch, live)
+}
@@ -0,0 +1,75 @@
+package filewatcher
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPossiblyStaleAfterSleep is delivered to clients via OnFileWatchError when the watcher
+// detects that the machine has been asleep, and so may have missed filesystem events.
+// It is followed by FileAdded events for the current contents of every watched root.
+var ErrPossiblyStaleAfterSleep = errors.New("filewatching may have missed events while the machine was asleep")
+
+var (
+	_defaultSleepThreshold = 30 * time.Second
+	_sleepCheckInterval    = 5 * time.Second
+)
+
+// WithSleepThreshold sets how large of a gap between the wall clock and the monotonic clock
+// is treated as the machine having been asleep. A value of 0 disables sleep detection.
+func WithSleepThreshold(d time.Duration) Option {
+	return func(fw *FileWatcher) {
+		fw.sleep.threshold = d
+	}
+}
+
+// clock abstracts the two kinds of time we need to detect sleep, so that tests can
+// simulate a machine sleeping.
+type clock interface {
+	// wallNow returns the current wall-clock time, which advances while the machine is asleep
+	wallNow() time.Time
+	// monoNow returns the current monotonic time, which does not advance while the machine is asleep
+	monoNow() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) wallNow() time.Time {
+	// Round(0) strips the monotonic reading, so comparisons use the wall clock
+	return time.Now().Round(0)
+}
+
+func (systemClock) monoNow() time.Time {
+	return time.Now()
+}
+
+// sleepDetector notices when the wall clock has advanced significantly further than
+// the monotonic clock, which happens when the machine is suspended.
+type sleepDetector struct {
+	clock         clock
+	threshold     time.Duration
+	checkInterval time.Duration
+	lastWall      time.Time
+	lastMono      time.Time
+}
+
+func (s *sleepDetector) enabled() bool {
+	return s.threshold > 0
+}
+
+func (s *sleepDetector) reset() {
+	s.lastWall = s.clock.wallNow()
+	s.lastMono = s.clock.monoNow()
+}
+
+// check returns how long the machine appears to have been asleep since the last check,
+// and whether that exceeds our threshold.
+func (s *sleepDetector) check() (time.Duration, bool) {
+	wall := s.clock.wallNow()
+	mono := s.clock.monoNow()
+	asleep := wall.Sub(s.lastWall) - mono.Sub(s.lastMono)
+	s.lastWall = wall
+	s.lastMono = mono
+	return asleep, asleep > s.threshold
+}
@@ -0,0 +1,107 @@
+package filewatcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+type fakeClock struct {
+	mu   sync.Mutex
+	wall time.Time
+	mono time.Time
+}
+
+func newFakeClock() *fakeClock {
+	now := time.Now().Round(0)
+	return &fakeClock{
+		wall: now,
+		mono: now,
+	}
+}
+
+func (c *fakeClock) wallNow() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wall
+}
+
+func (c *fakeClock) monoNow() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mono
+}
+
+// suspend advances only the wall clock, as happens when a machine sleeps
+func (c *fakeClock) suspend(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+}
+
+func withClock(c clock, checkInterval time.Duration) Option {
+	return func(fw *FileWatcher) {
+		fw.sleep.clock = c
+		fw.sleep.checkInterval = checkInterval
+	}
+}
+
+func TestSleepDetector(t *testing.T) {
+	c := newFakeClock()
+	s := sleepDetector{
+		clock:     c,
+		threshold: time.Minute,
+	}
+	s.reset()
+	_, slept := s.check()
+	assert.Assert(t, !slept, "should not have detected sleep")
+
+	c.suspend(time.Hour)
+	asleep, slept := s.check()
+	assert.Assert(t, slept, "expected to detect sleep")
+	assert.Equal(t, asleep, time.Hour)
+
+	// A subsequent check should not report the same sleep again
+	_, slept = s.check()
+	assert.Assert(t, !slept, "should not have detected sleep")
+}
+
+func TestReconcileAfterSleep(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	existingPath := repoRoot.UntypedJoin("existing")
+	err := existingPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	c := newFakeClock()
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithSleepThreshold(time.Minute), withClock(c, 10*time.Millisecond))
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	errs := make(chan error, 1)
+	client := &allEventsClient{
+		notify: ch,
+		errs:   errs,
+	}
+	assert.NilError(t, fw.AddClient(client), "AddClient")
+
+	c.suspend(time.Hour)
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrPossiblyStaleAfterSleep)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for stale signal")
+	}
+	expectFilesystemEvent(t, ch, Event{
+		Path:      existingPath,
+		EventType: FileAdded,
+	})
+}
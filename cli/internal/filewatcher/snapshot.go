@@ -0,0 +1,88 @@
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// Snapshot is the state of every watched path at a point in time. Comparing two
+// snapshots with DiffTo yields the changes between them, for consumers that would
+// rather ask what changed since they last looked than follow a stream of events.
+type Snapshot struct {
+	files map[turbopath.AbsoluteSystemPath]fileState
+}
+
+// Snapshot captures the current state of the watched roots, honoring their exclusions
+// and any include globs. Paths that can't be read are left out of the snapshot.
+func (fw *FileWatcher) Snapshot() Snapshot {
+	fw.rootsMu.Lock()
+	roots := make([]watchRoot, len(fw.roots))
+	copy(roots, fw.roots)
+	fw.rootsMu.Unlock()
+	files := make(map[turbopath.AbsoluteSystemPath]fileState)
+	for _, root := range roots {
+		state, err := scanRoot(root, osScanFS{})
+		if err != nil {
+			fw.logger.Warn("snapshot is incomplete", _logOp, "snapshot", _logPath, root.path, "error", err)
+		}
+		for path, s := range state {
+			ev := normalizeEvent(Event{Path: path, EventType: FileAdded})
+			if _, inGitDir := fw.isGitStateEvent(ev); inGitDir || !fw.accept(ev) {
+				continue
+			}
+			files[fw.fromRealPath(ev.Path)] = s
+		}
+	}
+	return Snapshot{files: files}
+}
+
+// DiffTo returns the events that turn prev into cur. A path that disappeared while a path
+// with the same file identity appeared is reported as a single FileRenamed with OldPath
+// set, on platforms that provide file identities, as long as nothing else about the file
+// changed. Otherwise the identity is more likely to have been reused by a new file after
+// the old one was deleted. Additions, modifications and renames are reported
+// parents-first, followed by deletions, children-first.
+func (prev Snapshot) DiffTo(cur Snapshot) []Event {
+	// Index what disappeared by identity, so that we can match it up with what appeared
+	removed := make(map[fileID]turbopath.AbsoluteSystemPath)
+	for path, s := range prev.files {
+		if _, ok := cur.files[path]; !ok && s.hasID {
+			removed[s.id] = path
+		}
+	}
+	renamedFrom := make(map[turbopath.AbsoluteSystemPath]struct{})
+	var events []Event
+	for _, path := range sortedPaths(cur.files) {
+		s := cur.files[path]
+		before, existed := prev.files[path]
+		if existed {
+			if s.changedFrom(before) {
+				events = append(events, Event{Path: path, EventType: FileModified})
+			}
+			continue
+		}
+		if oldPath, ok := removed[s.id]; ok && s.hasID && s.sameFileAs(prev.files[oldPath]) {
+			delete(removed, s.id)
+			renamedFrom[oldPath] = struct{}{}
+			events = append(events, Event{Path: path, OldPath: oldPath, EventType: FileRenamed})
+			continue
+		}
+		events = append(events, Event{Path: path, EventType: FileAdded})
+	}
+	deleted := sortedPaths(prev.files)
+	for i := len(deleted) - 1; i >= 0; i-- {
+		path := deleted[i]
+		if _, ok := cur.files[path]; ok {
+			continue
+		}
+		if _, ok := renamedFrom[path]; ok {
+			continue
+		}
+		events = append(events, Event{Path: path, EventType: FileDeleted})
+	}
+	return events
+}
+
+// sameFileAs returns true if s and prev have the same identity, and s doesn't appear to
+// have changed since prev, so that s is probably prev under a new name rather than a new
+// file that was given a deleted file's identity
+func (s fileState) sameFileAs(prev fileState) bool {
+	return s.hasID && prev.hasID && s.id == prev.id && !s.changedFrom(prev)
+}
@@ -0,0 +1,110 @@
+package filewatcher
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	modifiedPath := repoRoot.UntypedJoin("parent", "modified")
+	deletedPath := repoRoot.UntypedJoin("parent", "deleted")
+	renamedPath := repoRoot.UntypedJoin("parent", "renamed")
+	unchangedPath := repoRoot.UntypedJoin("unchanged")
+	for _, path := range []string{modifiedPath.ToString(), deletedPath.ToString(), renamedPath.ToString(), unchangedPath.ToString()} {
+		p := fs.AbsoluteSystemPathFromUpstream(path)
+		assert.NilError(t, p.EnsureDir(), "EnsureDir")
+		assert.NilError(t, p.WriteFile([]byte("hello"), 0644), "WriteFile")
+	}
+	err := repoRoot.UntypedJoin("node_modules", "dep").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	fw := New(logger, repoRoot, newFakeBackend())
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	before := fw.Snapshot()
+	// Nothing has changed yet
+	assert.Equal(t, len(before.DiffTo(fw.Snapshot())), 0)
+
+	addedDir := repoRoot.UntypedJoin("added")
+	addedPath := addedDir.UntypedJoin("file")
+	assert.NilError(t, addedPath.EnsureDir(), "EnsureDir")
+	assert.NilError(t, addedPath.WriteFile([]byte("hello"), 0644), "WriteFile")
+	assert.NilError(t, modifiedPath.WriteFile([]byte("hello, world"), 0644), "WriteFile")
+	assert.NilError(t, deletedPath.Remove(), "Remove")
+	newPath := repoRoot.UntypedJoin("parent", "new-name")
+	assert.NilError(t, renamedPath.Rename(newPath), "Rename")
+	// Excluded paths don't show up
+	assert.NilError(t, repoRoot.UntypedJoin("node_modules", "dep", "index.js").WriteFile([]byte("hello"), 0644), "WriteFile")
+
+	expected := []Event{
+		{Path: addedDir, EventType: FileAdded},
+		{Path: addedPath, EventType: FileAdded},
+		{Path: modifiedPath, EventType: FileModified},
+	}
+	if runtime.GOOS == "windows" {
+		// Without file identities, a rename looks like an addition and a deletion
+		expected = append(expected,
+			Event{Path: newPath, EventType: FileAdded},
+			Event{Path: renamedPath, EventType: FileDeleted},
+			Event{Path: deletedPath, EventType: FileDeleted},
+		)
+	} else {
+		expected = append(expected,
+			Event{Path: newPath, OldPath: renamedPath, EventType: FileRenamed},
+			Event{Path: deletedPath, EventType: FileDeleted},
+		)
+	}
+	assert.DeepEqual(t, before.DiffTo(fw.Snapshot()), expected)
+}
+
+func TestSnapshotDiffReusedIdentity(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	deletedPath := repoRoot.UntypedJoin("deleted")
+	createdPath := repoRoot.UntypedJoin("created")
+	movedPath := repoRoot.UntypedJoin("moved")
+	renamedPath := repoRoot.UntypedJoin("renamed")
+	then := time.Now()
+	file := fileState{modTime: then, size: 5, mode: 0644, id: fileID{ino: 1}, hasID: true}
+	before := Snapshot{files: map[turbopath.AbsoluteSystemPath]fileState{
+		deletedPath: file,
+		movedPath:   {modTime: then, size: 5, mode: 0644, id: fileID{ino: 2}, hasID: true},
+	}}
+
+	// The deleted file's inode was reused for a file created afterwards, which shows
+	// in its size and modification time. The moved file is untouched.
+	created := file
+	created.size = 12
+	created.modTime = then.Add(time.Second)
+	after := Snapshot{files: map[turbopath.AbsoluteSystemPath]fileState{
+		createdPath: created,
+		renamedPath: before.files[movedPath],
+	}}
+	assert.DeepEqual(t, before.DiffTo(after), []Event{
+		{Path: createdPath, EventType: FileAdded},
+		{Path: renamedPath, OldPath: movedPath, EventType: FileRenamed},
+		{Path: deletedPath, EventType: FileDeleted},
+	})
+
+	// Nor is it a rename if it was reused for a directory
+	dir := file
+	dir.mode = os.ModeDir | 0755
+	after = Snapshot{files: map[turbopath.AbsoluteSystemPath]fileState{
+		createdPath: dir,
+		movedPath:   before.files[movedPath],
+	}}
+	assert.DeepEqual(t, before.DiffTo(after), []Event{
+		{Path: createdPath, EventType: FileAdded},
+		{Path: deletedPath, EventType: FileDeleted},
+	})
+}
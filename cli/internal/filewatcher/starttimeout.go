@@ -0,0 +1,46 @@
+package filewatcher
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithStartTimeout bounds how long Start waits for the initial walk of the repo root. If
+// the walk doesn't finish in time, for instance because of an unresponsive network mount,
+// Start closes the backend, which removes whatever watches were installed, and returns an
+// error wrapping ErrFailedToStart. A backend blocked in the filesystem can't be interrupted,
+// so its cleanup happens once the walk returns. The default of 0 waits indefinitely.
+func WithStartTimeout(d time.Duration) Option {
+	return func(fw *FileWatcher) {
+		fw.startTimeout = d
+	}
+}
+
+// addRepoRoot adds the repo root as our first root, honoring fw.startTimeout
+func (fw *FileWatcher) addRepoRoot() error {
+	if fw.startTimeout <= 0 {
+		return fw.addInitialRoot()
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- fw.addInitialRoot()
+	}()
+	timer := time.NewTimer(fw.startTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		fw.logger.Error("timed out walking the repo root, shutting down filewatching", _logOp, "watch", _logPath, fw.repoRoot, "timeout", fw.startTimeout)
+		fw.closingMu.Lock()
+		fw.closing = true
+		fw.closingMu.Unlock()
+		go func() {
+			// Wait for the walk to give up the backend before closing it
+			<-result
+			_ = fw.backend.Close()
+		}()
+		return errors.Wrapf(ErrFailedToStart, "timed out after %v walking %v", fw.startTimeout, fw.repoRoot)
+	}
+}
@@ -0,0 +1,52 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// stuckBackend is a fakeBackend whose walks never finish until released
+type stuckBackend struct {
+	*fakeBackend
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (s *stuckBackend) AddRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	<-s.release
+	return s.fakeBackend.AddRoot(root, excludePatterns...)
+}
+
+func (s *stuckBackend) Close() error {
+	defer close(s.closed)
+	return s.fakeBackend.Close()
+}
+
+func TestStartTimeout(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := &stuckBackend{
+		fakeBackend: newFakeBackend(),
+		release:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	fw := New(logger, repoRoot, backend, WithStartTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	err := fw.Start()
+	assert.ErrorIs(t, err, ErrFailedToStart)
+	assert.Assert(t, time.Since(start) < time.Second, "Start took %v", time.Since(start))
+
+	// Once the walk gives up, the backend is cleaned up
+	close(backend.release)
+	select {
+	case <-backend.closed:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the backend to be closed")
+	}
+}
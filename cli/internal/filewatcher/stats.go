@@ -0,0 +1,57 @@
+package filewatcher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a summary of what filewatching has done, for operators of long-running
+// processes. The counters cover the time since Start, or since the last call to
+// ResetStats.
+type Stats struct {
+	// StartedAt is when filewatching started, or the zero time if it hasn't. ResetStats
+	// leaves it alone.
+	StartedAt time.Time
+	// EventsDelivered counts the events dispatched to clients
+	EventsDelivered uint64
+	// EventsDropped counts the events discarded because they arrived faster than they
+	// could be processed, see WithChannelBuffer
+	EventsDropped uint64
+	// Errors counts the errors delivered to clients
+	Errors uint64
+}
+
+// statCounters holds the counters behind Stats. ResetStats swaps in a new set, so that
+// they are all zeroed at once.
+type statCounters struct {
+	delivered uint64
+	dropped   uint64
+	errors    uint64
+}
+
+// counters returns the current set of counters
+func (fw *FileWatcher) counters() *statCounters {
+	return fw.stats.Load().(*statCounters)
+}
+
+// Stats returns what filewatching has done since it started, or since ResetStats was
+// last called
+func (fw *FileWatcher) Stats() Stats {
+	c := fw.counters()
+	stats := Stats{
+		EventsDelivered: atomic.LoadUint64(&c.delivered),
+		EventsDropped:   atomic.LoadUint64(&c.dropped),
+		Errors:          atomic.LoadUint64(&c.errors),
+	}
+	if atomic.LoadInt32(&fw.started) == 1 {
+		stats.StartedAt = fw.startedAt
+	}
+	return stats
+}
+
+// ResetStats zeroes the counters reported by Stats, for instance once an investigation
+// is over, without restarting. StartedAt is unchanged. Counts in progress as the
+// counters are reset may be attributed to either side of the reset, but never to both.
+func (fw *FileWatcher) ResetStats() {
+	fw.stats.Store(&statCounters{})
+}
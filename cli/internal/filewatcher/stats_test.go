@@ -0,0 +1,59 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestResetStats(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(hclog.Default(), repoRoot, backend, WithChannelBuffer(2))
+	assert.Assert(t, fw.Stats().StartedAt.IsZero(), "expected no start time before Start")
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	// An unbuffered notify channel stalls the watch loop until we read from it, so some
+	// of the burst below is dropped
+	ch := make(chan Event)
+	errs := make(chan error, 16)
+	err = fw.AddClient(&allEventsClient{notify: ch, errs: errs})
+	assert.NilError(t, err, "AddClient")
+
+	const burst = 10
+	for i := 0; i < burst; i++ {
+		backend.events <- Event{Path: repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)), EventType: FileAdded}
+	}
+	received := 0
+	for {
+		select {
+		case <-ch:
+			received++
+			continue
+		case <-time.After(200 * time.Millisecond):
+		}
+		break
+	}
+	stats := fw.Stats()
+	startedAt := stats.StartedAt
+	assert.Assert(t, !startedAt.IsZero(), "expected a start time")
+	assert.Equal(t, stats.EventsDelivered, uint64(received))
+	assert.Equal(t, stats.EventsDropped, uint64(burst-received))
+	assert.Assert(t, stats.Errors > 0, "expected the overflow to be counted as an error")
+
+	fw.ResetStats()
+	assert.DeepEqual(t, fw.Stats(), Stats{StartedAt: startedAt})
+
+	// Counting carries on from zero, and so does the uptime
+	backend.events <- Event{Path: repoRoot.UntypedJoin("after"), EventType: FileAdded}
+	<-ch
+	stats = fw.Stats()
+	assert.Equal(t, stats.EventsDelivered, uint64(1))
+	assert.Equal(t, stats.StartedAt, startedAt)
+	assert.Assert(t, time.Since(stats.StartedAt) > 200*time.Millisecond, "expected the uptime to continue")
+}
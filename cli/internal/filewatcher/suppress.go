@@ -0,0 +1,90 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _suppressSyncTimeout bounds how long SuppressDuring waits for the events caused by fn
+var _suppressSyncTimeout = time.Second
+
+// SuppressDuring runs fn with event delivery paused, and then delivers the net changes
+// that happened while it ran, as Pause and Resume would. This suits a command that writes
+// to the repo itself, such as a build run in watch mode, whose intermediate writes would
+// otherwise trigger further runs. Before resuming, it waits up to a second for the events
+// caused by fn to arrive, so that they are reduced along with the rest rather than
+// delivered afterwards. To tell when they have, it writes a probe file to a temporary
+// directory of its own, outside the repo, which it watches from the first call until
+// Close. Where the backend reports all of its changes in the order they happened, as
+// inotify does, the probe's event arrives after fn's. Elsewhere the wait is best-effort.
+// It returns fn's error. It shouldn't be combined with calls to Pause and Resume.
+func (fw *FileWatcher) SuppressDuring(fn func() error) error {
+	fw.Pause()
+	defer fw.Resume()
+	err := fn()
+	if syncErr := fw.syncSuppressed(); syncErr != nil && syncErr != ErrNotStarted {
+		fw.logger.Warn("resuming without confirming that every change was seen", _logOp, "suppress", "error", syncErr)
+	}
+	return err
+}
+
+// syncSuppressed waits for the event for a probe file in the marker directory
+func (fw *FileWatcher) syncSuppressed() error {
+	dir, err := fw.ensureMarkerDir()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), _suppressSyncTimeout)
+	defer cancel()
+	return fw.awaitProbe(ctx, dir)
+}
+
+// ensureMarkerDir returns the directory that SuppressDuring writes its probe files to,
+// creating and watching it the first time
+func (fw *FileWatcher) ensureMarkerDir() (turbopath.AbsoluteSystemPath, error) {
+	fw.markerMu.Lock()
+	defer fw.markerMu.Unlock()
+	fw.probesMu.Lock()
+	dir := fw.markerDir
+	fw.probesMu.Unlock()
+	if dir != "" {
+		return dir, nil
+	}
+	fw.closingMu.Lock()
+	closing := fw.closing
+	fw.closingMu.Unlock()
+	if closing {
+		return "", ErrFilewatchingClosed
+	}
+	tmp, err := os.MkdirTemp("", "turbo-filewatch-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create a directory for SuppressDuring")
+	}
+	dir = fw.toRealPath(normalizePath(fs.AbsoluteSystemPathFromUpstream(tmp)))
+	if err := fw.backend.AddRoot(dir); err != nil {
+		_ = dir.RemoveAll()
+		return "", errors.Wrapf(err, "failed to watch %v", dir)
+	}
+	fw.probesMu.Lock()
+	fw.markerDir = dir
+	fw.probesMu.Unlock()
+	return dir, nil
+}
+
+// removeMarkerDir removes the directory created by ensureMarkerDir, if there is one
+func (fw *FileWatcher) removeMarkerDir() {
+	fw.markerMu.Lock()
+	defer fw.markerMu.Unlock()
+	fw.probesMu.Lock()
+	dir := fw.markerDir
+	fw.markerDir = ""
+	fw.probesMu.Unlock()
+	if dir != "" {
+		_ = dir.RemoveAll()
+	}
+}
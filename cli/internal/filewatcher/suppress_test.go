@@ -0,0 +1,65 @@
+package filewatcher
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestSuppressDuring(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	output := repoRoot.UntypedJoin("output")
+	scratch := repoRoot.UntypedJoin("scratch")
+	err = fw.SuppressDuring(func() error {
+		if err := scratch.WriteFile([]byte("hello"), 0644); err != nil {
+			return err
+		}
+		if err := output.WriteFile([]byte("hello"), 0644); err != nil {
+			return err
+		}
+		return scratch.Remove()
+	})
+	assert.NilError(t, err, "SuppressDuring")
+
+	// Only the file that survived is reported, and nothing arrives afterwards
+	ev := <-ch
+	assert.Equal(t, ev.Path, output)
+	assert.Equal(t, ev.EventType, FileAdded)
+	expectNoFilesystemEvent(t, ch)
+	// Nothing else was written to the repo
+	entries, err := os.ReadDir(repoRoot.ToString())
+	assert.NilError(t, err, "ReadDir")
+	assert.Equal(t, len(entries), 1, "got %v", entries)
+
+	// fn's error is returned, and delivery resumes regardless
+	errFailed := errors.New("build failed")
+	err = fw.SuppressDuring(func() error {
+		return errFailed
+	})
+	assert.Equal(t, err, errFailed)
+	live := repoRoot.UntypedJoin("live")
+	err = live.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      live,
+		EventType: FileAdded,
+	})
+}
@@ -0,0 +1,42 @@
+package filewatcher
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestSymlinkedRepoRoot(t *testing.T) {
+	logger := hclog.Default()
+	realRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir()).UntypedJoin("real")
+	nested := realRoot.UntypedJoin("packages", "a")
+	err := nested.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir()).UntypedJoin("repo")
+	if err := os.Symlink(realRoot.ToString(), repoRoot.ToString()); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Changes made through either path are reported beneath the root we were given
+	err = realRoot.UntypedJoin("packages", "a", "index.ts").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	assertEvents(t, nextEvents(t, ch, 1), Event{Path: repoRoot.UntypedJoin("packages", "a", "index.ts"), EventType: FileAdded})
+	err = repoRoot.UntypedJoin("packages", "b").Mkdir(0775)
+	assert.NilError(t, err, "Mkdir")
+	expectFilesystemEvent(t, ch, Event{Path: repoRoot.UntypedJoin("packages", "b"), EventType: FileAdded})
+}
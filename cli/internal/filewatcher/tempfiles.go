@@ -0,0 +1,38 @@
+package filewatcher
+
+import (
+	"github.com/vercel/turbo/cli/internal/doublestar"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// _defaultTempFilePatterns match the names of the swap, backup and lock files that
+// editors create alongside the files being edited. "4913" is the file Vim creates to
+// check whether it can write to a directory.
+var _defaultTempFilePatterns = []string{"*.swp", "*.swo", "*.swx", "*~", "*.tmp", "4913", ".#*", "#*#"}
+
+// WithTempFilePatterns replaces the default set of patterns for editors' temporary files.
+// Patterns are matched against the last element of a path, for instance "*.swp". Paths
+// matching one of them are neither watched nor reported. Passing no patterns disables
+// the filtering altogether.
+func WithTempFilePatterns(patterns ...string) Option {
+	return func(fw *FileWatcher) {
+		fw.tempFilePatterns = patterns
+	}
+}
+
+// ignoringBackend is implemented by backends that can avoid watching paths that we
+// would ignore anyway.
+type ignoringBackend interface {
+	setIgnoreFilter(ignore func(path turbopath.AbsoluteSystemPath) bool)
+}
+
+// isTempFile returns true if the name of the given path matches one of our temporary file patterns
+func (fw *FileWatcher) isTempFile(path turbopath.AbsoluteSystemPath) bool {
+	name := path.Base()
+	for _, pattern := range fw.tempFilePatterns {
+		if matched, err := doublestar.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
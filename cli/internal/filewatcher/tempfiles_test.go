@@ -0,0 +1,55 @@
+package filewatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestTempFilesIgnored(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	err = repoRoot.UntypedJoin(".foo.swp").WriteFile([]byte("swap"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	sourcePath := repoRoot.UntypedJoin("foo.ts")
+	err = sourcePath.WriteFile([]byte("export {}"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	// Writing the source file can produce more than one event, but they should all be for it
+	ev := <-ch
+	assert.Assert(t, ev.Equal(Event{Path: sourcePath, EventType: FileAdded}), "unexpected event %v", ev)
+	for {
+		select {
+		case ev := <-ch:
+			assert.Equal(t, ev.Path, sourcePath, "unexpected event %v", ev)
+			continue
+		case <-time.After(500 * time.Millisecond):
+		}
+		break
+	}
+}
+
+func TestTempFilePatterns(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(hclog.Default(), repoRoot, newFakeBackend(), WithTempFilePatterns("*.bak"))
+	assert.Assert(t, fw.isTempFile(repoRoot.UntypedJoin("foo.bak")))
+	assert.Assert(t, !fw.isTempFile(repoRoot.UntypedJoin(".foo.swp")), "custom patterns replace the defaults")
+
+	fw = New(hclog.Default(), repoRoot, newFakeBackend(), WithTempFilePatterns())
+	assert.Assert(t, !fw.isTempFile(repoRoot.UntypedJoin("foo~")))
+}
@@ -0,0 +1,101 @@
+package testhelper
+
+import (
+	"sync"
+
+	"github.com/vercel/turbo/cli/internal/filewatcher"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// MemoryBackend is a filewatcher.Backend that doesn't touch the filesystem. It only
+// produces the events and errors that a test gives it via Emit and EmitError.
+type MemoryBackend struct {
+	events chan filewatcher.Event
+	errors chan error
+
+	mu     sync.Mutex
+	roots  []turbopath.AbsoluteSystemPath
+	closed bool
+}
+
+var _ filewatcher.Backend = &MemoryBackend{}
+
+// NewMemoryBackend returns a new MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		events: make(chan filewatcher.Event),
+		errors: make(chan error),
+	}
+}
+
+// AddRoot implements filewatcher.Backend.AddRoot. It only records the root.
+func (m *MemoryBackend) AddRoot(root turbopath.AbsoluteSystemPath, excludePatterns ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return filewatcher.ErrFilewatchingClosed
+	}
+	m.roots = append(m.roots, root)
+	return nil
+}
+
+// Roots returns the roots that have been added
+func (m *MemoryBackend) Roots() []turbopath.AbsoluteSystemPath {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roots := make([]turbopath.AbsoluteSystemPath, len(m.roots))
+	copy(roots, m.roots)
+	return roots
+}
+
+// Events implements filewatcher.Backend.Events
+func (m *MemoryBackend) Events() <-chan filewatcher.Event {
+	return m.events
+}
+
+// Errors implements filewatcher.Backend.Errors
+func (m *MemoryBackend) Errors() <-chan error {
+	return m.errors
+}
+
+// Start implements filewatcher.Backend.Start
+func (m *MemoryBackend) Start() error {
+	return nil
+}
+
+// Close implements filewatcher.Backend.Close
+func (m *MemoryBackend) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return filewatcher.ErrFilewatchingClosed
+	}
+	m.closed = true
+	close(m.events)
+	close(m.errors)
+	return nil
+}
+
+// Emit delivers an event as if it came from the filesystem. It blocks until the event
+// has been received, and returns filewatcher.ErrFilewatchingClosed after Close.
+func (m *MemoryBackend) Emit(ev filewatcher.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return filewatcher.ErrFilewatchingClosed
+	}
+	m.events <- ev
+	return nil
+}
+
+// EmitError delivers an error as if it came from the filesystem. It blocks until the error
+// has been received, and returns filewatcher.ErrFilewatchingClosed after Close.
+func (m *MemoryBackend) EmitError(err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return filewatcher.ErrFilewatchingClosed
+	}
+	m.errors <- err
+	return nil
+}
@@ -0,0 +1,346 @@
+package testhelper
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/filewatcher"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// Clock is the source of the pauses that RunStress takes
+type Clock interface {
+	// Sleep pauses for d
+	Sleep(d time.Duration)
+	// After returns a channel that receives once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// StressConfig configures RunStress
+type StressConfig struct {
+	// Seed seeds the sequence of operations. Zero picks a seed from the current time.
+	// The seed is logged, and reported on failure, so that a failing run can be repeated.
+	Seed int64
+	// Ops is the number of operations to perform. Zero means 200.
+	Ops int
+	// MaxPause is the longest pause taken between operations. Each pause is random, and
+	// most are zero, so that operations arrive both in bursts and spread out.
+	MaxPause time.Duration
+	// Quiet is how long no events must arrive for before the event stream is considered
+	// complete. Zero means 500ms.
+	Quiet time.Duration
+	// Timeout bounds the wait for the event stream to go quiet. Zero means 10s.
+	Timeout time.Duration
+	// Clock provides the pauses and timeouts. Nil means the real clock.
+	Clock Clock
+}
+
+// entry is what the stress model knows about a path
+type entry struct {
+	dir bool
+	// version counts the writes to a file, and is also the length of its contents, so
+	// that every write changes the file's size
+	version int
+}
+
+func (e entry) String() string {
+	if e.dir {
+		return "dir"
+	}
+	return fmt.Sprintf("file v%v", e.version)
+}
+
+// tree models the contents of a root, keyed by absolute path. The root itself is not included.
+type tree map[turbopath.AbsoluteSystemPath]entry
+
+// within returns the paths of p and everything beneath it
+func (tr tree) within(p turbopath.AbsoluteSystemPath) []turbopath.AbsoluteSystemPath {
+	var paths []turbopath.AbsoluteSystemPath
+	for path := range tr {
+		if path.HasPrefix(p) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (tr tree) dirs(root turbopath.AbsoluteSystemPath) []turbopath.AbsoluteSystemPath {
+	dirs := []turbopath.AbsoluteSystemPath{root}
+	for path, e := range tr {
+		if e.dir {
+			dirs = append(dirs, path)
+		}
+	}
+	return sortPaths(dirs)
+}
+
+func (tr tree) files() []turbopath.AbsoluteSystemPath {
+	var files []turbopath.AbsoluteSystemPath
+	for path, e := range tr {
+		if !e.dir {
+			files = append(files, path)
+		}
+	}
+	return sortPaths(files)
+}
+
+func (tr tree) all() []turbopath.AbsoluteSystemPath {
+	paths := make([]turbopath.AbsoluteSystemPath, 0, len(tr))
+	for path := range tr {
+		paths = append(paths, path)
+	}
+	return sortPaths(paths)
+}
+
+func sortPaths(paths []turbopath.AbsoluteSystemPath) []turbopath.AbsoluteSystemPath {
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+// recordingClient records every event and error that it is given
+type recordingClient struct {
+	mu     sync.Mutex
+	events []filewatcher.Event
+	errs   []error
+}
+
+func (r *recordingClient) OnFileWatchEvent(ev filewatcher.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *recordingClient) OnFileWatchError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *recordingClient) OnFileWatchClosed(err error) {}
+
+func (r *recordingClient) snapshot() ([]filewatcher.Event, []error, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]filewatcher.Event{}, r.events...), append([]error{}, r.errs...), len(r.events)
+}
+
+// _names are the names that stress operations create. There are few of them, so that
+// paths are reused often.
+var _names = []string{"a", "b", "c", "d"}
+
+// RunStress drives a random sequence of creations, modifications, deletions, and renames
+// of files and directories within root, which must be empty and watched by fw, which must
+// have been started, while applying the same operations to a model. Once the operations
+// are done and events have stopped arriving, it checks that the events delivered are
+// enough to reconstruct the final state of root: starting from the initial state, every
+// path named by an event, along with everything beneath it for anything but a
+// modification, is updated from the model's final state, and the result must match the
+// model exactly. Anything that changed without an event covering it is reported, along
+// with the seed to reproduce the run, and the test fails.
+func RunStress(t testing.TB, fw *filewatcher.FileWatcher, root turbopath.AbsoluteSystemPath, cfg StressConfig) {
+	t.Helper()
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	if cfg.Ops == 0 {
+		cfg.Ops = 200
+	}
+	if cfg.Quiet == 0 {
+		cfg.Quiet = 500 * time.Millisecond
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+	t.Logf("stress seed: %v", cfg.Seed)
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	client := &recordingClient{}
+	if err := fw.AddClient(client); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+
+	model := make(tree)
+	var ops []string
+	for i := 0; i < cfg.Ops; i++ {
+		op, err := stressOp(rng, root, model)
+		if err != nil {
+			t.Fatalf("stress seed %v: operation %v (%v) failed: %v", cfg.Seed, i, op, err)
+		}
+		ops = append(ops, op)
+		if cfg.MaxPause > 0 && rng.Intn(4) == 0 {
+			cfg.Clock.Sleep(time.Duration(rng.Int63n(int64(cfg.MaxPause))))
+		}
+	}
+
+	// Wait for the event stream to go quiet
+	timeout := cfg.Clock.After(cfg.Timeout)
+	seen := -1
+	for {
+		_, _, count := client.snapshot()
+		if count == seen {
+			break
+		}
+		seen = count
+		select {
+		case <-cfg.Clock.After(cfg.Quiet):
+		case <-timeout:
+			t.Fatalf("stress seed %v: events were still arriving after %v", cfg.Seed, cfg.Timeout)
+		}
+	}
+
+	events, errs, _ := client.snapshot()
+	view := reconcile(events, model)
+	var diffs []string
+	for _, path := range sortPaths(unionPaths(view, model)) {
+		got, inView := view[path]
+		want, inModel := model[path]
+		switch {
+		case !inModel:
+			diffs = append(diffs, fmt.Sprintf("%v: events say %v, but it doesn't exist", path, got))
+		case !inView:
+			diffs = append(diffs, fmt.Sprintf("%v: exists as %v, but no event says so", path, want))
+		case got != want:
+			diffs = append(diffs, fmt.Sprintf("%v: events say %v, but it is %v", path, got, want))
+		}
+	}
+	if len(diffs) == 0 {
+		return
+	}
+	var report strings.Builder
+	fmt.Fprintf(&report, "stress seed %v: the event stream diverged from the filesystem\n", cfg.Seed)
+	for _, diff := range diffs {
+		fmt.Fprintf(&report, "  %v\n", diff)
+	}
+	for _, err := range errs {
+		fmt.Fprintf(&report, "error: %v\n", err)
+	}
+	fmt.Fprintf(&report, "operations:\n")
+	for i, op := range ops {
+		fmt.Fprintf(&report, "  %v: %v\n", i, op)
+	}
+	fmt.Fprintf(&report, "events:\n")
+	for _, ev := range events {
+		fmt.Fprintf(&report, "  %v\n", ev)
+	}
+	t.Fatal(report.String())
+}
+
+// reconcile replays events over an empty tree, refreshing the paths they name from final
+func reconcile(events []filewatcher.Event, final tree) tree {
+	view := make(tree)
+	refresh := func(p turbopath.AbsoluteSystemPath, recursive bool) {
+		if !recursive {
+			if e, ok := final[p]; ok {
+				view[p] = e
+			} else {
+				delete(view, p)
+			}
+			return
+		}
+		for _, path := range view.within(p) {
+			delete(view, path)
+		}
+		for _, path := range final.within(p) {
+			view[path] = final[path]
+		}
+	}
+	for _, ev := range events {
+		refresh(ev.Path, ev.EventType != filewatcher.FileModified)
+		if ev.OldPath != "" {
+			refresh(ev.OldPath, true)
+		}
+	}
+	return view
+}
+
+func unionPaths(a tree, b tree) []turbopath.AbsoluteSystemPath {
+	paths := a.all()
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// stressOp performs one random operation on the filesystem and the model, and describes it
+func stressOp(rng *rand.Rand, root turbopath.AbsoluteSystemPath, model tree) (string, error) {
+	dirs := model.dirs(root)
+	files := model.files()
+	newPath := func() (turbopath.AbsoluteSystemPath, bool) {
+		dir := dirs[rng.Intn(len(dirs))]
+		p := dir.UntypedJoin(_names[rng.Intn(len(_names))])
+		_, exists := model[p]
+		return p, !exists
+	}
+	switch rng.Intn(6) {
+	case 0:
+		p, ok := newPath()
+		if !ok {
+			return "skip", nil
+		}
+		model[p] = entry{dir: true}
+		return fmt.Sprintf("mkdir %v", p), p.Mkdir(0775)
+	case 1:
+		p, ok := newPath()
+		if !ok {
+			return "skip", nil
+		}
+		model[p] = entry{version: 1}
+		return fmt.Sprintf("create %v", p), p.WriteFile([]byte("x"), 0644)
+	case 2:
+		if len(files) == 0 {
+			return "skip", nil
+		}
+		p := files[rng.Intn(len(files))]
+		e := model[p]
+		e.version++
+		model[p] = e
+		return fmt.Sprintf("modify %v", p), p.WriteFile([]byte(strings.Repeat("x", e.version)), 0644)
+	case 3:
+		paths := model.all()
+		if len(paths) == 0 {
+			return "skip", nil
+		}
+		p := paths[rng.Intn(len(paths))]
+		for _, path := range model.within(p) {
+			delete(model, path)
+		}
+		return fmt.Sprintf("delete %v", p), p.RemoveAll()
+	default:
+		paths := model.all()
+		if len(paths) == 0 {
+			return "skip", nil
+		}
+		from := paths[rng.Intn(len(paths))]
+		to, ok := newPath()
+		if !ok || to.HasPrefix(from) {
+			return "skip", nil
+		}
+		for _, path := range model.within(from) {
+			model[to.UntypedJoin(path.ToString()[len(from):])] = model[path]
+			delete(model, path)
+		}
+		return fmt.Sprintf("rename %v to %v", from, to), from.Rename(to)
+	}
+}
@@ -0,0 +1,64 @@
+package testhelper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/filewatcher"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func runStress(t *testing.T, opts ...filewatcher.BackendOption) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend, err := filewatcher.GetPlatformSpecificBackend(hclog.NewNullLogger(), opts...)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := filewatcher.New(hclog.NewNullLogger(), repoRoot, backend)
+	err = fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+	RunStress(t, fw, repoRoot, StressConfig{
+		MaxPause: 20 * time.Millisecond,
+	})
+}
+
+func TestStressNative(t *testing.T) {
+	t.Setenv("TURBO_FILEWATCH_BACKEND", "native")
+	runStress(t)
+}
+
+func TestStressPolling(t *testing.T) {
+	t.Setenv("TURBO_FILEWATCH_BACKEND", "polling")
+	runStress(t, filewatcher.WithPollInterval(50*time.Millisecond))
+}
+
+func TestReconcile(t *testing.T) {
+	root := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	dir := root.UntypedJoin("a")
+	file := dir.UntypedJoin("b")
+	final := tree{
+		dir:  {dir: true},
+		file: {version: 2},
+	}
+
+	// A modification of the directory says nothing about what is inside it
+	view := reconcile([]filewatcher.Event{
+		{Path: dir, EventType: filewatcher.FileModified},
+	}, final)
+	assert.DeepEqual(t, view, tree{dir: {dir: true}}, cmp.AllowUnexported(entry{}))
+
+	// Its creation covers everything inside it
+	view = reconcile([]filewatcher.Event{
+		{Path: dir, EventType: filewatcher.FileAdded},
+	}, final)
+	assert.DeepEqual(t, view, final, cmp.AllowUnexported(entry{}))
+
+	// A rename covers both paths
+	view = reconcile([]filewatcher.Event{
+		{Path: dir, EventType: filewatcher.FileAdded},
+		{Path: root.UntypedJoin("c"), OldPath: dir, EventType: filewatcher.FileRenamed},
+	}, final)
+	assert.DeepEqual(t, view, final, cmp.AllowUnexported(entry{}))
+}
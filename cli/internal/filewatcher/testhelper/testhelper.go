@@ -0,0 +1,86 @@
+// Package testhelper provides utilities for testing code that consumes filewatcher events
+package testhelper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/filewatcher"
+)
+
+var (
+	// EventTimeout is how long ExpectEvent waits for a matching event
+	EventTimeout = 1 * time.Second
+	// NoEventWindow is how long ExpectNoEvent waits to confirm that no event arrives
+	NoEventWindow = 500 * time.Millisecond
+)
+
+// ExpectEvent reads events from ch until one with the same type, path, and old path
+// as want arrives, failing the test if none does within EventTimeout.
+func ExpectEvent(t testing.TB, ch <-chan filewatcher.Event, want filewatcher.Event) {
+	// mark this method as a helper
+	t.Helper()
+	timeout := time.After(EventTimeout)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				t.Errorf("channel closed while waiting for filesystem event at %v", want.Path)
+				return
+			}
+			t.Logf("got event %v", ev)
+			if ev.Equal(want) {
+				return
+			}
+		case <-timeout:
+			t.Errorf("Timed out waiting for filesystem event at %v", want.Path)
+			return
+		}
+	}
+}
+
+// ExpectNoEvent fails the test if any event arrives on ch, or ch is closed, within NoEventWindow
+func ExpectNoEvent(t testing.TB, ch <-chan filewatcher.Event) {
+	// mark this method as a helper
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if ok {
+			t.Errorf("got unexpected filesystem event %v", ev)
+		} else {
+			t.Error("filewatching closed unexpectedly")
+		}
+	case <-time.After(NoEventWindow):
+		return
+	}
+}
+
+// CollectEvents drains ch for the given duration, or until it is closed, and returns
+// everything that was received.
+func CollectEvents(ch <-chan filewatcher.Event, d time.Duration) []filewatcher.Event {
+	var evs []filewatcher.Event
+	timeout := time.After(d)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return evs
+			}
+			evs = append(evs, ev)
+		case <-timeout:
+			return evs
+		}
+	}
+}
+
+// CountEvents drains ch for the given duration and returns how many of the received
+// events have the given type.
+func CountEvents(ch <-chan filewatcher.Event, d time.Duration, eventType filewatcher.FileEvent) int {
+	count := 0
+	for _, ev := range CollectEvents(ch, d) {
+		if ev.EventType == eventType {
+			count++
+		}
+	}
+	return count
+}
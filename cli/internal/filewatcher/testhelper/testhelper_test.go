@@ -0,0 +1,74 @@
+package testhelper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/filewatcher"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+type channelClient struct {
+	ch chan filewatcher.Event
+}
+
+func (c *channelClient) OnFileWatchEvent(ev filewatcher.Event) {
+	c.ch <- ev
+}
+
+func (c *channelClient) OnFileWatchError(err error) {}
+
+func (c *channelClient) OnFileWatchClosed(err error) {
+	close(c.ch)
+}
+
+func setup(t *testing.T) (*MemoryBackend, *filewatcher.FileWatcher, chan filewatcher.Event) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := NewMemoryBackend()
+	fw := filewatcher.New(hclog.NewNullLogger(), repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	ch := make(chan filewatcher.Event, 16)
+	fw.AddClient(&channelClient{ch: ch})
+	assert.DeepEqual(t, backend.Roots(), []turbopath.AbsoluteSystemPath{repoRoot})
+	return backend, fw, ch
+}
+
+func TestExpectEvent(t *testing.T) {
+	backend, _, ch := setup(t)
+	foo := backend.Roots()[0].UntypedJoin("foo")
+	bar := backend.Roots()[0].UntypedJoin("bar")
+	go func() {
+		_ = backend.Emit(filewatcher.Event{Path: bar, EventType: filewatcher.FileModified})
+		_ = backend.Emit(filewatcher.Event{Path: foo, EventType: filewatcher.FileAdded})
+	}()
+	// Non-matching events are skipped
+	ExpectEvent(t, ch, filewatcher.Event{Path: foo, EventType: filewatcher.FileAdded})
+	ExpectNoEvent(t, ch)
+}
+
+func TestCollectEvents(t *testing.T) {
+	backend, fw, ch := setup(t)
+	foo := backend.Roots()[0].UntypedJoin("foo")
+	for i := 0; i < 3; i++ {
+		err := backend.Emit(filewatcher.Event{Path: foo, EventType: filewatcher.FileModified})
+		assert.NilError(t, err, "Emit")
+	}
+	err := backend.Emit(filewatcher.Event{Path: foo, EventType: filewatcher.FileDeleted})
+	assert.NilError(t, err, "Emit")
+	assert.Equal(t, CountEvents(ch, 100*time.Millisecond, filewatcher.FileModified), 3)
+
+	err = backend.Emit(filewatcher.Event{Path: foo, EventType: filewatcher.FileAdded})
+	assert.NilError(t, err, "Emit")
+	err = fw.Close()
+	assert.NilError(t, err, "Close")
+	// CollectEvents stops early when the channel closes
+	evs := CollectEvents(ch, time.Minute)
+	assert.DeepEqual(t, evs, []filewatcher.Event{{Path: foo, EventType: filewatcher.FileAdded}})
+
+	err = backend.Emit(filewatcher.Event{Path: foo, EventType: filewatcher.FileAdded})
+	assert.ErrorIs(t, err, filewatcher.ErrFilewatchingClosed)
+}
@@ -0,0 +1,147 @@
+package filewatcher
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// clientThrottle holds back events for a client that wants to hear about changes at most
+// once per interval. It is only used by the watch loop.
+type clientThrottle struct {
+	interval time.Duration
+	pending  *eventCoalescer
+	// next is the earliest time at which we may deliver to the client again
+	next time.Time
+	// grouped is set for a GroupClient, whose events are always held, and delivered in groups
+	grouped bool
+}
+
+// throttled is the ClientOption used by AddClientThrottled
+func throttled(interval time.Duration) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.throttle = interval
+	}
+}
+
+// AddClientThrottled registers a client that is delivered at most one event per
+// interval. The first change after a quiet period is delivered right away. Changes that
+// happen within the interval after a delivery are accumulated, reduced to their net
+// effect per path as they are while paused, and once the interval has passed, delivered
+// as one event. If only one path changed, that is its event. Otherwise it is a TreeDirty
+// event for the deepest directory containing every changed path, with the changes it
+// summarizes in Changes. Errors are not throttled, and other clients are unaffected. It
+// returns an error under the same conditions as AddClient.
+func (fw *FileWatcher) AddClientThrottled(client FileWatchClient, interval time.Duration) error {
+	_, err := fw.addClient(client, throttled(interval))
+	return err
+}
+
+// dispatchThrottled delivers an event to the throttled clients that are due a delivery,
+// and holds it for the rest, and for GroupClients. Must be called while clientsMu is held for reading.
+func (fw *FileWatcher) dispatchThrottled(entries []*clientEntry, ev Event, now time.Time) []*clientEntry {
+	var due []*clientEntry
+	for _, entry := range entries {
+		t := entry.throttle
+		if t.pending.len() == 0 {
+			if t.grouped {
+				// The window starts with the first event held
+				t.next = now.Add(t.interval)
+			} else if !now.Before(t.next) {
+				t.next = now.Add(t.interval)
+				due = append(due, entry)
+				continue
+			}
+		}
+		t.pending.add(ev)
+		atomic.StoreInt64(&entry.queued, int64(t.pending.len()))
+	}
+	return fw.deliverEventTo(due, ev)
+}
+
+// flushThrottled delivers the held events of every throttled client whose interval has
+// passed, as a single event. If force is set, intervals are ignored.
+func (fw *FileWatcher) flushThrottled(now time.Time, force bool) {
+	fw.clientsMu.RLock()
+	var evicted []*clientEntry
+	for _, entry := range fw.clients {
+		t := entry.throttle
+		if t == nil || t.pending.len() == 0 || (!force && now.Before(t.next)) {
+			continue
+		}
+		t.next = now.Add(t.interval)
+		target := []*clientEntry{entry}
+		pending := t.pending.flush()
+		atomic.StoreInt64(&entry.queued, 0)
+		if t.grouped {
+			if fw.deliverGroupsTo(entry, pending) {
+				evicted = append(evicted, entry)
+			}
+			continue
+		}
+		evicted = append(evicted, fw.deliverEventTo(target, summarize(pending))...)
+	}
+	fw.clientsMu.RUnlock()
+	for _, entry := range evicted {
+		fw.evictClient(entry)
+	}
+}
+
+// nextThrottleDeadline returns when the next throttled client is due a delivery of the
+// events held for it, if any are held
+func (fw *FileWatcher) nextThrottleDeadline() (time.Time, bool) {
+	fw.clientsMu.RLock()
+	defer fw.clientsMu.RUnlock()
+	var next time.Time
+	for _, entry := range fw.clients {
+		t := entry.throttle
+		if t == nil || t.pending.len() == 0 {
+			continue
+		}
+		if next.IsZero() || t.next.Before(next) {
+			next = t.next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// summarize returns the single event that stands for evs, which must not be empty
+func summarize(evs []Event) Event {
+	if len(evs) == 1 {
+		return evs[0]
+	}
+	dir := evs[0].Path.Dir()
+	for _, ev := range evs {
+		dir = commonDir(dir, ev.Path)
+		if ev.OldPath != "" {
+			dir = commonDir(dir, ev.OldPath)
+		}
+	}
+	summary := Event{
+		Path:      dir,
+		EventType: TreeDirty,
+		Seq:       evs[len(evs)-1].Seq,
+		Root:      evs[0].Root,
+		Changes:   evs,
+	}
+	for _, ev := range evs {
+		if ev.Root != summary.Root {
+			summary.Root = ""
+			break
+		}
+	}
+	return summary
+}
+
+// commonDir returns the deepest directory containing both dir and p
+func commonDir(dir turbopath.AbsoluteSystemPath, p turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	for !p.HasPrefix(dir) {
+		parent := dir.Dir()
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return dir
+}
@@ -0,0 +1,166 @@
+package filewatcher
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// timingClient records when it received each event
+type timingClient struct {
+	mu     sync.Mutex
+	events []Event
+	times  []time.Time
+}
+
+func (c *timingClient) OnFileWatchEvent(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, ev)
+	c.times = append(c.times, time.Now())
+}
+
+func (c *timingClient) OnFileWatchError(err error) {}
+
+func (c *timingClient) OnFileWatchClosed(err error) {}
+
+func (c *timingClient) received() ([]Event, []time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event{}, c.events...), append([]time.Time{}, c.times...)
+}
+
+func TestAddClientThrottled(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	fw := New(logger, repoRoot, backend)
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+
+	interval := 100 * time.Millisecond
+	throttled := &timingClient{}
+	assert.NilError(t, fw.AddClientThrottled(throttled, interval), "AddClientThrottled")
+	unthrottled := &timingClient{}
+	assert.NilError(t, fw.AddClient(unthrottled), "AddClient")
+
+	const burst = 30
+	for i := 0; i < burst; i++ {
+		backend.events <- Event{
+			Path:      repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)),
+			EventType: FileAdded,
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Every path arrives, either on its own or summarized in a TreeDirty
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		evs, _ := throttled.received()
+		if changedPaths(evs) == burst {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("throttled client got %v of %v paths", changedPaths(evs), burst)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The unthrottled client got everything as it happened
+	evs, _ := unthrottled.received()
+	assert.Equal(t, len(evs), burst)
+
+	// The burst spans several intervals, and each delivered one event
+	evs, _ = throttled.received()
+	assert.Assert(t, len(evs) > 1, "expected the burst to span several deliveries")
+	assert.Assert(t, len(evs) < burst/2, "expected events to be coalesced, got %v deliveries", len(evs))
+}
+
+// changedPaths counts the paths that evs cover
+func changedPaths(evs []Event) int {
+	n := 0
+	for _, ev := range evs {
+		if ev.EventType == TreeDirty {
+			n += len(ev.Changes)
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+func TestThrottledClientCadence(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(hclog.Default(), repoRoot, newFakeBackend())
+	ch := make(chan Event, 16)
+	interval := time.Second
+	fw.clientsMu.Lock()
+	entry := fw.addClientLocked(&allEventsClient{notify: ch}, throttled(interval))
+	fw.clientsMu.Unlock()
+	entries := []*clientEntry{entry}
+	dispatch := func(ev Event, now time.Time) {
+		fw.clientsMu.RLock()
+		defer fw.clientsMu.RUnlock()
+		fw.dispatchThrottled(entries, ev, now)
+	}
+	path := func(name string) turbopath.AbsoluteSystemPath {
+		return repoRoot.UntypedJoin("dir", name)
+	}
+	start := time.Now()
+	at := func(d time.Duration) time.Time {
+		return start.Add(d)
+	}
+
+	// Deliveries are synchronous, so whatever is in the channel is everything delivered
+	delivered := func() []Event {
+		var evs []Event
+		for {
+			select {
+			case ev := <-ch:
+				evs = append(evs, ev)
+			default:
+				return evs
+			}
+		}
+	}
+
+	// The first change after a quiet period is delivered right away
+	first := Event{Path: path("a"), EventType: FileModified}
+	dispatch(first, at(0))
+	assert.DeepEqual(t, delivered(), []Event{first})
+
+	// Changes within the interval are held, and reduced to their net effect
+	dispatch(Event{Path: path("b"), EventType: FileAdded}, at(100*time.Millisecond))
+	dispatch(Event{Path: path("c"), EventType: FileModified}, at(200*time.Millisecond))
+	dispatch(Event{Path: path("b"), EventType: FileModified}, at(300*time.Millisecond))
+	fw.flushThrottled(at(interval-time.Millisecond), false)
+	assert.Equal(t, len(delivered()), 0)
+	next, ok := fw.nextThrottleDeadline()
+	assert.Assert(t, ok, "expected held events")
+	assert.Equal(t, next, at(interval))
+
+	// and delivered as one event once the interval has passed
+	fw.flushThrottled(at(interval), false)
+	assert.DeepEqual(t, delivered(), []Event{{
+		Path:      repoRoot.UntypedJoin("dir"),
+		EventType: TreeDirty,
+		Changes: []Event{
+			{Path: path("b"), EventType: FileAdded},
+			{Path: path("c"), EventType: FileModified},
+		},
+	}})
+
+	// The next interval starts with that delivery, and a single change is delivered as is
+	third := Event{Path: path("d"), EventType: FileDeleted}
+	dispatch(third, at(interval+100*time.Millisecond))
+	assert.Equal(t, len(delivered()), 0)
+	fw.flushThrottled(at(2*interval), false)
+	assert.DeepEqual(t, delivered(), []Event{third})
+}
@@ -0,0 +1,155 @@
+//go:build !darwin
+// +build !darwin
+
+package filewatcher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// failWatchesOn makes the backend fail to watch the given paths, as if permission were denied
+func failWatchesOn(t *testing.T, backend Backend, paths ...turbopath.AbsoluteSystemPath) {
+	t.Helper()
+	f, ok := backend.(*fsNotifyBackend)
+	assert.Assert(t, ok, "backend is %T", backend)
+	add := f.addWatch
+	f.addWatch = func(name string) error {
+		for _, p := range paths {
+			if name == p.ToString() {
+				return &os.PathError{Op: "add", Path: name, Err: os.ErrPermission}
+			}
+		}
+		return add(name)
+	}
+}
+
+func TestStartToleratesUnwatchableDirectory(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	good := repoRoot.UntypedJoin("good")
+	bad := repoRoot.UntypedJoin("bad")
+	for _, dir := range []turbopath.AbsoluteSystemPath{good.UntypedJoin("child"), bad.UntypedJoin("child")} {
+		err := dir.MkdirAll(0775)
+		assert.NilError(t, err, "MkdirAll")
+	}
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	failWatchesOn(t, watcher, bad)
+	fw := New(logger, repoRoot, watcher)
+	ch := make(chan Event, 16)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	expectError(t, fw.Errors(), os.ErrPermission)
+	watches := fw.currentWatches()
+	sort.Slice(watches, func(i, j int) bool { return watches[i] < watches[j] })
+	assert.DeepEqual(t, watches, []turbopath.AbsoluteSystemPath{repoRoot, good, good.UntypedJoin("child")})
+
+	filePath := good.UntypedJoin("child", "foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{
+		Path:      filePath,
+		EventType: FileAdded,
+	})
+}
+
+// TestStartWithManyUnwatchableDirectories starts repeatedly with several directories that
+// can't be watched, whose errors come up while Start is walking and nothing is reading
+// errors yet
+func TestStartWithManyUnwatchableDirectories(t *testing.T) {
+	logger := hclog.NewNullLogger()
+	const unwatchable = 8
+	for i := 0; i < 20; i++ {
+		repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+		var bad []turbopath.AbsoluteSystemPath
+		for j := 0; j < unwatchable; j++ {
+			dir := repoRoot.UntypedJoin(fmt.Sprintf("bad-%v", j))
+			err := dir.UntypedJoin("child").MkdirAll(0775)
+			assert.NilError(t, err, "MkdirAll")
+			bad = append(bad, dir)
+		}
+
+		watcher, err := GetPlatformSpecificBackend(logger)
+		assert.NilError(t, err, "GetPlatformSpecificBackend")
+		failWatchesOn(t, watcher, bad...)
+		fw := New(logger, repoRoot, watcher)
+		started := make(chan error, 1)
+		go func() { started <- fw.Start() }()
+		select {
+		case err := <-started:
+			assert.NilError(t, err, "fw.Start")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Start didn't return")
+		}
+		for j := 0; j < unwatchable; j++ {
+			expectError(t, fw.Errors(), os.ErrPermission)
+		}
+		err = fw.Close()
+		assert.NilError(t, err, "Close")
+	}
+}
+
+// TestStartPollsWhenOutOfWatches starts repeatedly while running out of watches, whose
+// error comes up while Start is walking and nothing is reading errors yet
+func TestStartPollsWhenOutOfWatches(t *testing.T) {
+	logger := hclog.NewNullLogger()
+	for i := 0; i < 20; i++ {
+		repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+		full := repoRoot.UntypedJoin("full")
+		err := full.UntypedJoin("child").MkdirAll(0775)
+		assert.NilError(t, err, "MkdirAll")
+
+		watcher, err := GetPlatformSpecificBackend(logger)
+		assert.NilError(t, err, "GetPlatformSpecificBackend")
+		f := watcher.(*fsNotifyBackend)
+		add := f.addWatch
+		f.addWatch = func(name string) error {
+			if name == full.ToString() {
+				return syscall.ENOSPC
+			}
+			return add(name)
+		}
+		fw := New(logger, repoRoot, watcher)
+		started := make(chan error, 1)
+		go func() { started <- fw.Start() }()
+		select {
+		case err := <-started:
+			assert.NilError(t, err, "fw.Start")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Start didn't return")
+		}
+		expectError(t, fw.Errors(), ErrWatchLimitExceeded)
+		err = fw.Close()
+		assert.NilError(t, err, "Close")
+	}
+}
+
+func TestStartFailsIfRootIsUnwatchable(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	failWatchesOn(t, watcher, repoRoot)
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_ = fw.Close()
+}
@@ -0,0 +1,124 @@
+package filewatcher
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/vercel/turbo/cli/internal/turbopath"
+)
+
+// WithTreeOrdering holds each event for up to window before delivering it, and reorders
+// the events held together so that they are consistent with the shape of the tree: a
+// directory is reported as added before anything inside it, and anything inside a
+// directory is reported as deleted before the directory itself. Backends mostly report
+// events in this order already, but nothing guarantees it, for instance when a directory
+// is created and filled faster than we can watch it. Events that don't depend on each
+// other keep their relative order.
+func WithTreeOrdering(window time.Duration) Option {
+	return func(fw *FileWatcher) {
+		fw.ordering = &treeOrderer{
+			window: window,
+		}
+	}
+}
+
+// treeOrderer holds events so that they can be put in tree order. It is only used by the
+// watch loop.
+type treeOrderer struct {
+	window time.Duration
+	// first is when the oldest held event arrived
+	first time.Time
+	held  []Event
+}
+
+func (o *treeOrderer) hold(ev Event, now time.Time) {
+	if len(o.held) == 0 {
+		o.first = now
+	}
+	o.held = append(o.held, ev)
+}
+
+// releaseDeadline returns when the held events are due to be delivered, if there are any
+func (o *treeOrderer) releaseDeadline() (time.Time, bool) {
+	if len(o.held) == 0 {
+		return time.Time{}, false
+	}
+	return o.first.Add(o.window), true
+}
+
+// release returns the held events in tree order once they are due, or if force is set
+func (o *treeOrderer) release(now time.Time, force bool) []Event {
+	if len(o.held) == 0 || (!force && now.Before(o.first.Add(o.window))) {
+		return nil
+	}
+	evs := treeOrder(o.held)
+	o.held = nil
+	return evs
+}
+
+// treeOrder reorders the given events so that a FileAdded for a directory comes before
+// those for its children, and a FileDeleted for a directory comes after those for its
+// children. Otherwise, events keep their order.
+func treeOrder(evs []Event) []Event {
+	addedAt := make(map[turbopath.AbsoluteSystemPath]int)
+	deletedWithin := make(map[turbopath.AbsoluteSystemPath][]int)
+	for i, ev := range evs {
+		switch ev.EventType {
+		case FileAdded:
+			if _, ok := addedAt[ev.Path]; !ok {
+				addedAt[ev.Path] = i
+			}
+		case FileDeleted:
+			parent := parentDir(ev.Path)
+			deletedWithin[parent] = append(deletedWithin[parent], i)
+		}
+	}
+	ordered := make([]Event, 0, len(evs))
+	done := make([]bool, len(evs))
+	var place func(i int)
+	place = func(i int) {
+		if done[i] {
+			return
+		}
+		done[i] = true
+		ev := evs[i]
+		switch ev.EventType {
+		case FileAdded:
+			if parent, ok := addedAt[parentDir(ev.Path)]; ok {
+				place(parent)
+			}
+		case FileDeleted:
+			for _, child := range deletedWithin[ev.Path] {
+				place(child)
+			}
+		}
+		ordered = append(ordered, ev)
+	}
+	for i := range evs {
+		place(i)
+	}
+	return ordered
+}
+
+func parentDir(path turbopath.AbsoluteSystemPath) turbopath.AbsoluteSystemPath {
+	return turbopath.AbsoluteSystemPath(filepath.Dir(path.ToString()))
+}
+
+// holdForOrdering returns true if the event has been held to be put in tree order
+func (fw *FileWatcher) holdForOrdering(ev Event) bool {
+	if fw.ordering == nil {
+		return false
+	}
+	fw.ordering.hold(ev, time.Now())
+	return true
+}
+
+// releaseOrdered delivers the events held to be put in tree order once they are due
+func (fw *FileWatcher) releaseOrdered(now time.Time, force bool) {
+	if fw.ordering == nil {
+		return
+	}
+	for _, ev := range fw.ordering.release(now, force) {
+		fw.dispatch(ev)
+	}
+}
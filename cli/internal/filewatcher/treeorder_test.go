@@ -0,0 +1,91 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestWithTreeOrdering(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher, WithTreeOrdering(50*time.Millisecond))
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	ch := make(chan Event, 1000)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	deepest := repoRoot
+	for i := 0; i < 10; i++ {
+		deepest = deepest.UntypedJoin(fmt.Sprintf("dir-%v", i))
+	}
+	err = deepest.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	added := make(map[turbopath.AbsoluteSystemPath]struct{})
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-ch:
+			if ev.EventType != FileAdded {
+				continue
+			}
+			if parent := parentDir(ev.Path); parent != repoRoot {
+				_, ok := added[parent]
+				assert.Assert(t, ok, "%v was delivered before its parent", ev)
+			}
+			added[ev.Path] = struct{}{}
+			if ev.Path == deepest {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", deepest)
+		}
+	}
+}
+
+func TestTreeOrder(t *testing.T) {
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	parent := repoRoot.UntypedJoin("parent")
+	child := parent.UntypedJoin("child")
+	grandchild := child.UntypedJoin("grandchild")
+	other := repoRoot.UntypedJoin("other")
+
+	ordered := treeOrder([]Event{
+		{Path: grandchild, EventType: FileAdded},
+		{Path: other, EventType: FileModified},
+		{Path: parent, EventType: FileAdded},
+		{Path: child, EventType: FileAdded},
+		{Path: parent, EventType: FileModified},
+	})
+	assert.DeepEqual(t, ordered, []Event{
+		{Path: parent, EventType: FileAdded},
+		{Path: child, EventType: FileAdded},
+		{Path: grandchild, EventType: FileAdded},
+		{Path: other, EventType: FileModified},
+		{Path: parent, EventType: FileModified},
+	})
+
+	ordered = treeOrder([]Event{
+		{Path: parent, EventType: FileDeleted},
+		{Path: other, EventType: FileDeleted},
+		{Path: child, EventType: FileDeleted},
+		{Path: grandchild, EventType: FileDeleted},
+	})
+	assert.DeepEqual(t, ordered, []Event{
+		{Path: grandchild, EventType: FileDeleted},
+		{Path: child, EventType: FileDeleted},
+		{Path: parent, EventType: FileDeleted},
+		{Path: other, EventType: FileDeleted},
+	})
+}
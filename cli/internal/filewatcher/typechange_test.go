@@ -0,0 +1,199 @@
+package filewatcher
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+// nextEvents returns the next n events, failing if they don't arrive in time
+func nextEvents(t *testing.T, ch <-chan Event, n int) []Event {
+	t.Helper()
+	var events []Event
+	timeout := time.After(time.Second)
+	for len(events) < n {
+		select {
+		case ev := <-ch:
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v events, got %v", n, events)
+		}
+	}
+	return events
+}
+
+func assertEvents(t *testing.T, got []Event, expected ...Event) {
+	t.Helper()
+	assert.Equal(t, len(got), len(expected), "got %v", got)
+	for i := range expected {
+		assert.Assert(t, got[i].Equal(expected[i]), "event %v is %v, expected %v", i, got[i], expected[i])
+	}
+}
+
+func TestFileReplacedByDirectory(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	path := repoRoot.UntypedJoin("thing")
+	err := path.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	assert.NilError(t, path.Remove(), "Remove")
+	assert.NilError(t, path.Mkdir(0775), "Mkdir")
+	expectFilesystemEvent(t, ch, Event{Path: path, EventType: FileDeleted})
+	expectFilesystemEvent(t, ch, Event{Path: path, EventType: FileAdded})
+
+	// The new directory is watched
+	filePath := path.UntypedJoin("foo")
+	err = filePath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: filePath, EventType: FileAdded})
+
+	// And isn't once it's a file again
+	assert.NilError(t, path.RemoveAll(), "RemoveAll")
+	assert.NilError(t, path.WriteFile([]byte("hello"), 0644), "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: path, EventType: FileDeleted})
+	expectFilesystemEvent(t, ch, Event{Path: path, EventType: FileAdded})
+	for _, dir := range fw.currentWatches() {
+		assert.Assert(t, !dir.HasPrefix(path), "still watching %v", dir)
+	}
+}
+
+func TestDirectoryMovedAwayReplacedByFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("depends on inotify's watches following a directory that moves away")
+	}
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	outside := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	path := repoRoot.UntypedJoin("thing")
+	err := path.UntypedJoin("child").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	moved := outside.UntypedJoin("thing")
+	assert.NilError(t, path.Rename(moved), "Rename")
+	assert.NilError(t, path.WriteFile([]byte("hello"), 0644), "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: path, EventType: FileAdded})
+	for _, dir := range fw.currentWatches() {
+		assert.Assert(t, !dir.HasPrefix(path), "still watching %v", dir)
+	}
+
+	// Changes in the directory that moved away aren't reported as if it were still here
+	err = moved.UntypedJoin("child", "foo").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case ev := <-ch:
+			assert.Assert(t, !ev.Path.HasPrefix(path.UntypedJoin("child")), "unexpected event %v", ev)
+			continue
+		case <-timeout:
+		}
+		break
+	}
+}
+
+func TestPollingTypeChange(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	path := repoRoot.UntypedJoin("thing")
+	err := path.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	cfg := newBackendConfig([]BackendOption{WithPollInterval(50 * time.Millisecond)})
+	fw := New(logger, repoRoot, newPollingBackend(logger, cfg))
+	ch := make(chan Event, 16)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	err = fw.AddClient(&allEventsClient{
+		notify: ch,
+	})
+	assert.NilError(t, err, "AddClient")
+
+	// Whether or not a scan happens in the middle, the same events are reported
+	filePath := path.UntypedJoin("foo")
+	assert.NilError(t, path.Remove(), "Remove")
+	assert.NilError(t, path.Mkdir(0775), "Mkdir")
+	assert.NilError(t, filePath.WriteFile([]byte("hello"), 0644), "WriteFile")
+	assertEvents(t, nextEvents(t, ch, 3),
+		Event{Path: path, EventType: FileDeleted},
+		Event{Path: path, EventType: FileAdded},
+		Event{Path: filePath, EventType: FileAdded},
+	)
+
+	assert.NilError(t, path.RemoveAll(), "RemoveAll")
+	assert.NilError(t, path.WriteFile([]byte("hello"), 0644), "WriteFile")
+	assertEvents(t, nextEvents(t, ch, 3),
+		Event{Path: filePath, EventType: FileDeleted},
+		Event{Path: path, EventType: FileDeleted},
+		Event{Path: path, EventType: FileAdded},
+	)
+	expectNoFilesystemEvent(t, ch)
+}
+
+func TestDirectoryDeletedAndRecreated(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("depends on inotify removing the watches on deleted directories")
+	}
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	dir := repoRoot.UntypedJoin("thing")
+	sub := dir.UntypedJoin("sub")
+	err := sub.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	watches := len(fw.currentWatches())
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	assert.NilError(t, dir.RemoveAll(), "RemoveAll")
+	expectFilesystemEvent(t, ch, Event{Path: dir, EventType: FileDeleted})
+	for _, watched := range fw.currentWatches() {
+		assert.Assert(t, !watched.HasPrefix(dir), "still watching %v", watched)
+	}
+	assert.Equal(t, len(fw.currentWatches()), watches-2)
+
+	assert.NilError(t, sub.MkdirAll(0775), "MkdirAll")
+	expectFilesystemEvent(t, ch, Event{Path: sub, EventType: FileAdded})
+	assert.Equal(t, len(fw.currentWatches()), watches)
+	filePath := sub.UntypedJoin("foo")
+	assert.NilError(t, filePath.WriteFile([]byte("hello"), 0644), "WriteFile")
+	expectFilesystemEvent(t, ch, Event{Path: filePath, EventType: FileAdded})
+}
@@ -0,0 +1,67 @@
+package filewatcher
+
+import "context"
+
+// waitClient hands the first event that passes its filter to WaitForChange
+type waitClient struct {
+	filter  func(ev Event) bool
+	matched chan Event
+	closed  chan error
+}
+
+func (w *waitClient) OnFileWatchEvent(ev Event) {
+	if w.filter != nil && !w.filter(ev) {
+		return
+	}
+	select {
+	case w.matched <- ev:
+	default:
+		// We already have a match
+	}
+}
+
+func (w *waitClient) OnFileWatchError(err error) {}
+
+func (w *waitClient) FilterDescription() string {
+	if w.filter == nil {
+		return "the first event, for WaitForChange"
+	}
+	return "the first matching event, for WaitForChange"
+}
+
+func (w *waitClient) OnFileWatchClosed(err error) {
+	if err == nil {
+		err = ErrFilewatchingClosed
+	}
+	select {
+	case w.closed <- err:
+	default:
+	}
+}
+
+// WaitForChange blocks until an event for which filter returns true is delivered, and
+// returns it. A nil filter matches every event. It returns ctx's error if ctx is done
+// first, or an error if filewatching stops first: ErrFilewatchingClosed if it was shut
+// down via Close, or the reason it stopped otherwise. It returns ErrNotStarted if Start
+// hasn't been called, since nothing would ever be delivered. filter is called from the same
+// goroutine as every client's callbacks, so it should be quick.
+func (fw *FileWatcher) WaitForChange(ctx context.Context, filter func(ev Event) bool) (Event, error) {
+	w := &waitClient{
+		filter:  filter,
+		matched: make(chan Event, 1),
+		closed:  make(chan error, 1),
+	}
+	entry, err := fw.addClient(w)
+	if err != nil {
+		return Event{}, err
+	}
+	defer fw.removeClient(entry)
+	select {
+	case ev := <-w.matched:
+		return ev, nil
+	case err := <-w.closed:
+		return Event{}, err
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	}
+}
@@ -0,0 +1,93 @@
+package filewatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+func TestWaitForChange(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	matchingPath := repoRoot.UntypedJoin("matching.ts")
+	type result struct {
+		ev  Event
+		err error
+	}
+	results := make(chan result, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		ev, err := fw.WaitForChange(ctx, func(ev Event) bool {
+			return ev.Path == matchingPath
+		})
+		results <- result{ev, err}
+	}()
+
+	// Give the waiter a chance to register before writing anything
+	deadline := time.Now().Add(time.Second)
+	for {
+		fw.clientsMu.RLock()
+		registered := len(fw.clients) == 1
+		fw.clientsMu.RUnlock()
+		if registered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for WaitForChange to register")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = repoRoot.UntypedJoin("other.ts").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	err = matchingPath.WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+
+	r := <-results
+	assert.NilError(t, r.err, "WaitForChange")
+	assert.Equal(t, r.ev.Path, matchingPath)
+	assert.Equal(t, r.ev.EventType, FileAdded)
+
+	// The waiter unsubscribes itself
+	fw.clientsMu.RLock()
+	defer fw.clientsMu.RUnlock()
+	assert.Equal(t, len(fw.clients), 0)
+}
+
+func TestWaitForChangeContextDone(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(logger, repoRoot, newFakeBackend())
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = fw.WaitForChange(ctx, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWaitForChangeClosed(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	fw := New(logger, repoRoot, newFakeBackend())
+	err := fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	assert.NilError(t, fw.Close(), "Close")
+
+	_, err = fw.WaitForChange(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrFilewatchingClosed)
+}
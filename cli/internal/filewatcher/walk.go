@@ -0,0 +1,143 @@
+//go:build !darwin
+// +build !darwin
+
+package filewatcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walkConfig is how walkTree goes about a walk
+type walkConfig struct {
+	// workers is the most goroutines that the walk uses
+	workers int
+	// maxEntries is the most entries a directory can have for its files to be visited,
+	// or 0 for no limit. Its subdirectories are visited however many entries it has.
+	maxEntries int
+	// wide, if set, is called with each directory whose files weren't visited, and how
+	// many entries it has
+	wide func(dir string, entries int)
+}
+
+// walkTree visits root and everything beneath it, as read from fsys, using at most
+// cfg.workers goroutines. visit is called with the path and type bits of every entry, and
+// the walk descends into the entries for which it returns true. Nothing is followed
+// unless visit asks for it, and visit may be called concurrently when there is more than
+// one worker. Entries that disappear or can't be read mid-walk are skipped, matching
+// fs.WalkMode.
+//
+// A directory is always visited before its contents. Pending directories are kept on
+// a stack rather than a queue so that the walk proceeds depth-first, which keeps the
+// amount of pending work proportional to the depth of the tree rather than to the width
+// of its largest level.
+func walkTree(fsys scanFS, root string, cfg walkConfig, visit func(name string, mode os.FileMode) (bool, error)) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		return err
+	}
+	descend, err := visit(root, info.Mode().Type())
+	if err != nil || !descend {
+		return err
+	}
+	workers := cfg.workers
+	if workers < 1 {
+		workers = 1
+	}
+	w := &treeWalk{
+		fsys:       fsys,
+		visit:      visit,
+		maxEntries: cfg.maxEntries,
+		wide:       cfg.wide,
+		pending:    []string{root},
+	}
+	w.cond = sync.NewCond(&w.mu)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+	return w.err
+}
+
+type treeWalk struct {
+	fsys       scanFS
+	visit      func(name string, mode os.FileMode) (bool, error)
+	maxEntries int
+	wide       func(dir string, entries int)
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []string
+	active  int
+	err     error
+}
+
+func (w *treeWalk) work() {
+	for {
+		w.mu.Lock()
+		for len(w.pending) == 0 && w.active > 0 && w.err == nil {
+			w.cond.Wait()
+		}
+		if len(w.pending) == 0 || w.err != nil {
+			// Either we're done, or someone hit an error. Wake everyone else up so they exit too.
+			w.mu.Unlock()
+			w.cond.Broadcast()
+			return
+		}
+		dir := w.pending[len(w.pending)-1]
+		w.pending = w.pending[:len(w.pending)-1]
+		w.active++
+		w.mu.Unlock()
+
+		children, err := w.readDir(dir)
+
+		w.mu.Lock()
+		w.active--
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		w.pending = append(w.pending, children...)
+		w.mu.Unlock()
+		w.cond.Broadcast()
+	}
+}
+
+// readDir visits the entries of a single directory, returning the ones to descend into.
+// The regular files of a directory with more than maxEntries entries aren't visited.
+func (w *treeWalk) readDir(dir string) ([]string, error) {
+	entries, err := w.fsys.ReadDir(dir)
+	if err != nil {
+		pathErr := &os.PathError{}
+		if errors.As(err, &pathErr) {
+			// The directory went away or can't be read, skip it
+			return nil, nil
+		}
+		return nil, err
+	}
+	skipFiles := w.maxEntries > 0 && len(entries) > w.maxEntries
+	if skipFiles && w.wide != nil {
+		w.wide(dir, len(entries))
+	}
+	var children []string
+	for _, entry := range entries {
+		if skipFiles && entry.Type().IsRegular() {
+			continue
+		}
+		name := filepath.Join(dir, entry.Name())
+		descend, err := w.visit(name, entry.Type())
+		if err != nil {
+			return nil, err
+		}
+		if descend {
+			children = append(children, name)
+		}
+	}
+	return children, nil
+}
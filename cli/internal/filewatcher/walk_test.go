@@ -0,0 +1,194 @@
+//go:build !darwin
+// +build !darwin
+
+package filewatcher
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+// makeTree creates `depth` levels of directories below root, with `width` subdirectories
+// and a file at each level.
+func makeTree(t testing.TB, root turbopath.AbsoluteSystemPath, depth int, width int) {
+	t.Helper()
+	if depth == 0 {
+		return
+	}
+	err := root.UntypedJoin("file").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	for i := 0; i < width; i++ {
+		child := root.UntypedJoin(fmt.Sprintf("dir-%v", i))
+		err := child.MkdirAll(0775)
+		assert.NilError(t, err, "MkdirAll")
+		makeTree(t, child, depth-1, width)
+	}
+}
+
+func watchedSet(t testing.TB, root turbopath.AbsoluteSystemPath, workers int) map[turbopath.AbsoluteSystemPath]struct{} {
+	t.Helper()
+	backend, err := GetPlatformSpecificBackend(hclog.NewNullLogger(), WithWalkConcurrency(workers))
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	f := backend.(*fsNotifyBackend)
+	defer func() { _ = f.Close() }()
+	excludes := []string{root.UntypedJoin("dir-1", "dir-0").ToString() + "/**"}
+	err = f.AddRoot(root, excludes...)
+	assert.NilError(t, err, "AddRoot")
+	return f.watched
+}
+
+func TestConcurrentWalkMatchesSerialWalk(t *testing.T) {
+	root := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	makeTree(t, root, 3, 3)
+
+	serial := watchedSet(t, root, 1)
+	concurrent := watchedSet(t, root, 8)
+	// 1 + 3 + 9 + 27 directories, minus the 4 that are excluded
+	assert.Equal(t, len(serial), 36)
+	assert.DeepEqual(t, serial, concurrent)
+}
+
+func BenchmarkInitialWalk(b *testing.B) {
+	root := fs.AbsoluteSystemPathFromUpstream(b.TempDir())
+	makeTree(b, root, 5, 5)
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				watchedSet(b, root, workers)
+			}
+		})
+	}
+}
+
+// lstatCountingFS reads the real filesystem, counting calls to Lstat
+type lstatCountingFS struct {
+	lstats int64
+}
+
+func (c *lstatCountingFS) Lstat(name string) (os.FileInfo, error) {
+	atomic.AddInt64(&c.lstats, 1)
+	return os.Lstat(name)
+}
+
+func (c *lstatCountingFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// makeWideDir creates a directory beneath root with the given number of files, and a
+// subdirectory with a single file in it
+func makeWideDir(t testing.TB, root turbopath.AbsoluteSystemPath, files int) turbopath.AbsoluteSystemPath {
+	t.Helper()
+	wide := root.UntypedJoin("wide")
+	err := wide.UntypedJoin("sub").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = wide.UntypedJoin("sub", "file").WriteFile([]byte("hello"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	for i := 0; i < files; i++ {
+		err := wide.UntypedJoin(fmt.Sprintf("file-%v", i)).WriteFile(nil, 0644)
+		assert.NilError(t, err, "WriteFile")
+	}
+	return wide
+}
+
+// walkNewDir watches dir as if it had just been created, and returns the events
+// synthesized for it, and how many times the filesystem was asked for a file's details
+func walkNewDir(t testing.TB, dir turbopath.AbsoluteSystemPath, opts ...BackendOption) ([]Event, int64) {
+	t.Helper()
+	backend, err := GetPlatformSpecificBackend(hclog.NewNullLogger(), opts...)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	f := backend.(*fsNotifyBackend)
+	var events []Event
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ev := range f.events {
+			events = append(events, ev)
+		}
+	}()
+	fsys := &lstatCountingFS{}
+	err = f.watchRecursively(fsys, dir, []string{}, synthesizeEvents)
+	assert.NilError(t, err, "watchRecursively")
+	_, watched := f.watched[dir.UntypedJoin("sub")]
+	assert.Assert(t, watched, "expected the subdirectory to be watched")
+	_ = f.Close()
+	wg.Wait()
+	return events, atomic.LoadInt64(&fsys.lstats)
+}
+
+func TestWideDirThreshold(t *testing.T) {
+	root := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	const files = 5000
+	wide := makeWideDir(t, root, files)
+
+	// Every file is reported without a threshold
+	events, lstats := walkNewDir(t, wide)
+	assert.Equal(t, len(events), files+3)
+	assert.Assert(t, lstats > files, "expected every file to be examined, got %v", lstats)
+
+	// Above it, the files aren't even looked at, but the subdirectory is still walked
+	start := time.Now()
+	events, lstats = walkNewDir(t, wide, WithWideDirThreshold(1000))
+	elapsed := time.Since(start)
+	assert.DeepEqual(t, events, []Event{
+		{Path: wide, EventType: FileAdded},
+		{Path: wide.UntypedJoin("sub"), EventType: FileAdded},
+		{Path: wide.UntypedJoin("sub", "file"), EventType: FileAdded},
+	})
+	// The walk looks at the directory itself twice, and then at the subdirectory and its file
+	assert.Equal(t, lstats, int64(4))
+	assert.Assert(t, elapsed < time.Second, "watching the wide directory took %v", elapsed)
+}
+
+func BenchmarkWideDirectory(b *testing.B) {
+	root := fs.AbsoluteSystemPathFromUpstream(b.TempDir())
+	wide := makeWideDir(b, root, 100000)
+	for _, threshold := range []int{0, 10000} {
+		b.Run(fmt.Sprintf("threshold=%v", threshold), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				walkNewDir(b, wide, WithWideDirThreshold(threshold))
+			}
+		})
+	}
+}
+
+func TestGitDirWatchedAtTopLevelOnly(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	err := repoRoot.UntypedJoin(".git", "objects").MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+
+	ch := make(chan Event, 16)
+	assert.NilError(t, fw.AddClient(&allEventsClient{
+		notify: ch,
+	}), "AddClient")
+
+	// Directories git creates while it works aren't watched either
+	refs := repoRoot.UntypedJoin(".git", "refs", "heads")
+	err = refs.MkdirAll(0775)
+	assert.NilError(t, err, "MkdirAll")
+	err = refs.UntypedJoin("main.lock").WriteFile([]byte("abc"), 0644)
+	assert.NilError(t, err, "WriteFile")
+	expectNoFilesystemEvent(t, ch)
+
+	expectWatchedDirs(t, fw,
+		repoRoot,
+		repoRoot.UntypedJoin(".git"),
+	)
+}
@@ -0,0 +1,56 @@
+package filewatcher
+
+import "github.com/vercel/turbo/cli/internal/turbopath"
+
+// IsWatched returns true if changes to path would currently be reported: if path is a
+// directory that is being watched, or is within one. Our filters and ignore patterns are
+// taken into account, so this can answer why no event arrived for a path. Paths that
+// don't exist are considered as files.
+func (fw *FileWatcher) IsWatched(path turbopath.AbsoluteSystemPath) bool {
+	select {
+	case <-fw.ready:
+	default:
+		return false
+	}
+	select {
+	case <-fw.done:
+		return false
+	default:
+	}
+	path = fw.toRealPath(normalizePath(path))
+	if !fw.isInManualWatch(path) && fw.isFilteredOut(Event{Path: path}) {
+		return false
+	}
+	dir := path
+	if !path.DirExists() {
+		dir = path.Dir()
+	}
+	if _, ok := fw.backend.(watchTrackingBackend); ok {
+		fw.watchMu.Lock()
+		defer fw.watchMu.Unlock()
+		_, ok := fw.watchedDirs[dir]
+		return ok
+	}
+	// The backend watches whole roots, less what they exclude
+	_, ok := fw.innermostRoot(dir)
+	return ok
+}
+
+// innermostRoot returns the innermost root that contains dir, unless dir is excluded from it
+func (fw *FileWatcher) innermostRoot(dir turbopath.AbsoluteSystemPath) (watchRoot, bool) {
+	fw.rootsMu.Lock()
+	defer fw.rootsMu.Unlock()
+	var innermost watchRoot
+	for _, root := range fw.roots {
+		if dir.HasPrefix(normalizePath(root.path)) && len(root.path) > len(innermost.path) {
+			innermost = root
+		}
+	}
+	if innermost.path == "" {
+		return watchRoot{}, false
+	}
+	if innermost.excludes(dir) {
+		return watchRoot{}, false
+	}
+	return innermost, true
+}
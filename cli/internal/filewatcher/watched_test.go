@@ -0,0 +1,40 @@
+package filewatcher
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"github.com/vercel/turbo/cli/internal/turbopath"
+	"gotest.tools/v3/assert"
+)
+
+func TestIsWatched(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	src := repoRoot.UntypedJoin("src")
+	dist := repoRoot.UntypedJoin("dist")
+	nodeModules := repoRoot.UntypedJoin("node_modules")
+	for _, dir := range []turbopath.AbsoluteSystemPath{src, dist, nodeModules} {
+		err := dir.MkdirAll(0775)
+		assert.NilError(t, err, "MkdirAll")
+	}
+
+	watcher, err := GetPlatformSpecificBackend(logger)
+	assert.NilError(t, err, "GetPlatformSpecificBackend")
+	fw := New(logger, repoRoot, watcher)
+	assert.Assert(t, !fw.IsWatched(src), "nothing is watched before Start")
+
+	err = fw.Start()
+	assert.NilError(t, err, "fw.Start")
+	defer func() { _ = fw.Close() }()
+	fw.SetIgnorePatterns([]string{"dist"})
+
+	assert.Assert(t, fw.IsWatched(repoRoot))
+	assert.Assert(t, fw.IsWatched(src))
+	assert.Assert(t, fw.IsWatched(src.UntypedJoin("index.ts")), "a file is watched via its directory")
+	assert.Assert(t, !fw.IsWatched(dist), "ignored directory")
+	assert.Assert(t, !fw.IsWatched(dist.UntypedJoin("index.js")), "file in an ignored directory")
+	assert.Assert(t, !fw.IsWatched(nodeModules.UntypedJoin("pkg")), "excluded from the root")
+	assert.Assert(t, !fw.IsWatched(fs.AbsoluteSystemPathFromUpstream(t.TempDir())), "outside every root")
+}
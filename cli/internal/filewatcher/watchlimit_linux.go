@@ -0,0 +1,20 @@
+package filewatcher
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// watchLimit returns the most inotify watches that a user can have
+func watchLimit() (int, bool) {
+	contents, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package filewatcher
+
+// watchLimit returns the most watches we can have. Only inotify has a limit specific
+// to watches; elsewhere it is the limit on open files, or there is none.
+func watchLimit() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package filewatcher
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WithDispatchWorkers delivers each event to up to n clients at once, rather than to one
+// client after another, so that delivering an event takes as long as the slowest client
+// rather than as long as all of them together. An event has been delivered to every
+// client before the next one is, so every client still receives events one at a time
+// and in order, and the slowest client still sets the pace for the rest. Clients must
+// be safe to call concurrently with other clients. The n workers are started with the
+// watcher, and stop once it has closed.
+func WithDispatchWorkers(n int) Option {
+	return func(fw *FileWatcher) {
+		fw.dispatchWorkers = n
+	}
+}
+
+// deliveryBatch is a single call to deliverConcurrently, which the workers report back to
+type deliveryBatch struct {
+	deliverOne func(entry *clientEntry) bool
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	evicted    []*clientEntry
+}
+
+// deliver delivers to a single client, and records whether it should be evicted
+func (b *deliveryBatch) deliver(entry *clientEntry) {
+	defer b.wg.Done()
+	defer atomic.AddInt64(&entry.handedOff, -1)
+	if b.deliverOne(entry) {
+		b.mu.Lock()
+		b.evicted = append(b.evicted, entry)
+		b.mu.Unlock()
+	}
+}
+
+// delivery is a client that a batch is to be delivered to
+type delivery struct {
+	batch *deliveryBatch
+	entry *clientEntry
+}
+
+// startDispatchWorkers starts the pool used by deliverConcurrently, if we have dispatch
+// workers. They run until the watch loop has finished.
+func (fw *FileWatcher) startDispatchWorkers() {
+	if fw.dispatchWorkers <= 1 {
+		return
+	}
+	fw.deliveries = make(chan delivery)
+	for i := 0; i < fw.dispatchWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case d := <-fw.deliveries:
+					d.batch.deliver(d.entry)
+				case <-fw.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// deliverConcurrently is deliverEach for when we have dispatch workers. It returns once
+// deliverOne has returned for every client. Once the workers have stopped, it delivers
+// to the clients itself, one after another.
+func (fw *FileWatcher) deliverConcurrently(entries []*clientEntry, deliverOne func(entry *clientEntry) bool) []*clientEntry {
+	batch := &deliveryBatch{deliverOne: deliverOne}
+	for _, entry := range entries {
+		batch.wg.Add(1)
+		atomic.AddInt64(&entry.handedOff, 1)
+		select {
+		case fw.deliveries <- delivery{batch: batch, entry: entry}:
+		case <-fw.done:
+			batch.deliver(entry)
+		}
+	}
+	batch.wg.Wait()
+	return batch.evicted
+}
@@ -0,0 +1,126 @@
+package filewatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/vercel/turbo/cli/internal/fs"
+	"gotest.tools/v3/assert"
+)
+
+// barrierClient announces each event it is handed, doesn't return until the release for
+// that event is closed, and announces it again once it has recorded the event
+type barrierClient struct {
+	timingClient
+	arrived  chan<- struct{}
+	recorded chan<- struct{}
+	releases []chan struct{}
+	handled  int
+}
+
+func (c *barrierClient) OnFileWatchEvent(ev Event) {
+	c.arrived <- struct{}{}
+	<-c.releases[c.handled]
+	c.handled++
+	c.timingClient.OnFileWatchEvent(ev)
+	c.recorded <- struct{}{}
+}
+
+// expectQueueDepth waits for the QueueDepth of every client to add up to n
+func expectQueueDepth(t *testing.T, fw *FileWatcher, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		total := 0
+		for _, info := range fw.Clients() {
+			total += info.QueueDepth
+		}
+		if total == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("clients are awaiting %v events, expected %v", total, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWithDispatchWorkers(t *testing.T) {
+	logger := hclog.Default()
+	repoRoot := fs.AbsoluteSystemPathFromUpstream(t.TempDir())
+	backend := newFakeBackend()
+	const workers = 2
+	fw := New(logger, repoRoot, backend, WithDispatchWorkers(workers))
+	err := fw.Start()
+	assert.NilError(t, err, "Start")
+	defer func() { _ = fw.Close() }()
+
+	const clientCount = 4
+	const burst = 5
+	arrived := make(chan struct{}, clientCount)
+	recorded := make(chan struct{}, clientCount*burst)
+	releases := make([]chan struct{}, burst)
+	for i := range releases {
+		releases[i] = make(chan struct{})
+	}
+	clients := make([]*barrierClient, clientCount)
+	for i := range clients {
+		clients[i] = &barrierClient{arrived: arrived, recorded: recorded, releases: releases}
+		assert.NilError(t, fw.AddClient(clients[i]), "AddClient")
+	}
+	go func() {
+		for i := 0; i < burst; i++ {
+			backend.events <- Event{
+				Path:      repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)),
+				EventType: FileAdded,
+			}
+		}
+	}()
+
+	for i := 0; i < burst; i++ {
+		// As many clients as there are workers are called at once, and no more
+		for n := 0; n < workers; n++ {
+			select {
+			case <-arrived:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("event %v: only %v clients were called at once", i, n)
+			}
+		}
+		select {
+		case <-arrived:
+			t.Fatalf("event %v: more than %v clients were called at once", i, workers)
+		default:
+		}
+		if i == 0 {
+			// The clients being called, and the one waiting for a worker, are awaiting the event
+			expectQueueDepth(t, fw, workers+1)
+		}
+		close(releases[i])
+		for n := workers; n < clientCount; n++ {
+			select {
+			case <-arrived:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("event %v: only %v clients were called", i, n)
+			}
+		}
+	}
+
+	// Every client got every event, in order
+	for n := 0; n < clientCount*burst; n++ {
+		select {
+		case <-recorded:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v of %v deliveries were recorded", n, clientCount*burst)
+		}
+	}
+	expectQueueDepth(t, fw, 0)
+	for _, client := range clients {
+		evs, _ := client.received()
+		assert.Equal(t, len(evs), burst)
+		for i, ev := range evs {
+			assert.Equal(t, ev.Path, repoRoot.UntypedJoin(fmt.Sprintf("file-%v", i)))
+		}
+	}
+}
@@ -132,7 +132,20 @@ func (g *GlobWatcher) OnFileWatchEvent(ev filewatcher.Event) {
 	// At this point, we don't care what the Op is, any Op represents a change
 	// that should invalidate matching globs
 	g.logger.Trace(fmt.Sprintf("Got fsnotify event %v", ev))
-	absolutePath := ev.Path
+	// Changes to git's own state, like switching branches, don't change any files that
+	// globs can match. Any files the switch touched produce their own events.
+	if ev.EventType == filewatcher.GitStateChanged {
+		return
+	}
+	g.invalidatePath(ev.Path)
+	// A rename also changes whatever globs matched the previous location
+	if ev.OldPath != "" {
+		g.invalidatePath(ev.OldPath)
+	}
+}
+
+// invalidatePath invalidates every glob matching the given path
+func (g *GlobWatcher) invalidatePath(absolutePath turbopath.AbsoluteSystemPath) {
 	repoRelativePath, err := g.repoRoot.RelativePathString(absolutePath.ToStringDuringMigration())
 	if err != nil {
 		g.logger.Debug(fmt.Sprintf("could not get relative path from %v to %v: %v", g.repoRoot, absolutePath, err))
@@ -204,7 +217,11 @@ func (g *GlobWatcher) OnFileWatchError(err error) {
 }
 
 // OnFileWatchClosed implements FileWatchClient.OnFileWatchClosed
-func (g *GlobWatcher) OnFileWatchClosed() {
+func (g *GlobWatcher) OnFileWatchClosed(err error) {
 	g.setClosed()
-	g.logger.Warn("GlobWatching is closing due to file watching closing")
+	if err != nil {
+		g.logger.Warn(fmt.Sprintf("GlobWatching is closing due to file watching failing: %v", err))
+	} else {
+		g.logger.Warn("GlobWatching is closing due to file watching closing")
+	}
 }
@@ -75,7 +75,9 @@ func New(serverName string, logger hclog.Logger, repoRoot turbopath.AbsoluteSyst
 	if err != nil {
 		return nil, err
 	}
-	fileWatcher := filewatcher.New(logger.Named("FileWatcher"), repoRoot, watcher)
+	// Hold anything that happens between starting and registering our clients, rather
+	// than dropping it
+	fileWatcher := filewatcher.New(logger.Named("FileWatcher"), repoRoot, watcher, filewatcher.WithBufferUntilFirstClient())
 	globWatcher := globwatcher.New(logger.Named("GlobWatcher"), repoRoot, cookieJar)
 	server := &Server{
 		watcher:      fileWatcher,
@@ -86,12 +88,14 @@ func New(serverName string, logger hclog.Logger, repoRoot turbopath.AbsoluteSyst
 		repoRoot:     repoRoot,
 		timesSaved:   map[string]uint64{},
 	}
-	server.watcher.AddClient(cookieJar)
-	server.watcher.AddClient(globWatcher)
-	server.watcher.AddClient(server)
 	if err := server.watcher.Start(); err != nil {
 		return nil, errors.Wrapf(err, "watching %v", repoRoot)
 	}
+	// Registered together, so that every client is delivered what was held
+	if err := server.watcher.SetClients([]filewatcher.FileWatchClient{cookieJar, globWatcher, server}); err != nil {
+		_ = server.watcher.Close()
+		return nil, errors.Wrapf(err, "watching %v", repoRoot)
+	}
 	if err := server.watcher.AddRoot(cookieDir); err != nil {
 		_ = server.watcher.Close()
 		return nil, errors.Wrapf(err, "failed to watch cookie directory: %v", cookieDir)
@@ -121,7 +125,13 @@ func (s *Server) OnFileWatchEvent(ev filewatcher.Event) {
 func (s *Server) OnFileWatchError(err error) {}
 
 // OnFileWatchClosed implements filewatcher.FileWatchClient.OnFileWatchClosed
-func (s *Server) OnFileWatchClosed() {}
+// If file watching failed, our view of the filesystem can no longer be trusted,
+// so shut down the server rather than serve stale results.
+func (s *Server) OnFileWatchClosed(err error) {
+	if err != nil {
+		_ = s.tryClose()
+	}
+}
 
 // Close is used for shutting down this copy of the server
 func (s *Server) Close() error {